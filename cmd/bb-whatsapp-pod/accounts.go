@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+// defaultAccount is the account of invokes without an :account option. Its
+// client is waClient, started lazily from :db-path; the others are added
+// with add-account, each with its own session database.
+const defaultAccount = defaultSession
+
+// errUnknownAccount is returned for an :account that was never added
+var errUnknownAccount = errors.New("unknown account")
+
+var accountName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// account is a WhatsApp session added next to the default one
+type account struct {
+	dbPath string
+	client *whatsapp.WhatsAppClient
+}
+
+var accounts = struct {
+	sync.Mutex
+	byName map[string]*account
+	adding map[string]string // name to database of accounts whose client is still starting
+}{byName: map[string]*account{}, adding: map[string]string{}}
+
+// AccountInfo describes one account, as reported by list-accounts
+type AccountInfo struct {
+	Name        string `json:"name"`
+	DBPath      string `json:"db_path"`
+	Initialized bool   `json:"initialized"`
+	Status      string `json:"status,omitempty"` // the client's login status
+	JID         string `json:"jid,omitempty"`
+}
+
+// AccountsResult is returned by the account vars
+type AccountsResult struct {
+	Success  bool          `json:"success"`
+	Message  string        `json:"message,omitempty"`
+	Accounts []AccountInfo `json:"accounts"`
+}
+
+// accountClient returns the client of an account, initializing the default
+// one on first use
+func accountClient(name string) (*whatsapp.WhatsAppClient, error) {
+	if name == "" || name == defaultAccount {
+		return getWaClient()
+	}
+	accounts.Lock()
+	defer accounts.Unlock()
+	a, ok := accounts.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q; add it with add-account", errUnknownAccount, name)
+	}
+	return a.client, nil
+}
+
+// accountState returns the client and init error of an account without
+// initializing anything. Added accounts never keep a failed client.
+func accountState(name string) (*whatsapp.WhatsAppClient, error) {
+	if name == defaultAccount {
+		return clientState()
+	}
+	accounts.Lock()
+	defer accounts.Unlock()
+	if a, ok := accounts.byName[name]; ok {
+		return a.client, nil
+	}
+	return nil, nil
+}
+
+// accountNames lists the default account and the added ones, sorted
+func accountNames() []string {
+	accounts.Lock()
+	defer accounts.Unlock()
+	names := make([]string, 0, len(accounts.byName)+1)
+	for name := range accounts.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{defaultAccount}, names...)
+}
+
+// addedClients returns the clients of the added accounts
+func addedClients() []*whatsapp.WhatsAppClient {
+	accounts.Lock()
+	defer accounts.Unlock()
+	clients := make([]*whatsapp.WhatsAppClient, 0, len(accounts.byName))
+	for _, a := range accounts.byName {
+		clients = append(clients, a.client)
+	}
+	return clients
+}
+
+// accountDBPath is where an added account keeps its session unless told
+// otherwise: next to the default database, e.g. whatsapp-sales.db
+func accountDBPath(name string) string {
+	base := currentConfig().DBPath
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-" + name + ext
+}
+
+// addAccount starts a client for a new account. Its session database is
+// created on first use; log in with the login var and :account.
+func addAccount(name, dbPath string) (AccountsResult, error) {
+	if !accountName.MatchString(name) || name == defaultAccount {
+		err := fmt.Errorf("invalid account name %q: use letters, digits, - and _, and not %q", name, defaultAccount)
+		return AccountsResult{Success: false, Message: err.Error(), Accounts: []AccountInfo{}}, err
+	}
+	if dbPath == "" {
+		dbPath = accountDBPath(name)
+	}
+	cfg := currentConfig()
+
+	if err := reserveAccount(name, dbPath, cfg.DBPath); err != nil {
+		return AccountsResult{Success: false, Message: err.Error(), Accounts: []AccountInfo{}}, err
+	}
+
+	// The client starts outside the lock, so the other accounts stay usable
+	podLog.Infof("Initializing WhatsApp client for account %s (%s)...", name, dbPath)
+	client, err := whatsapp.NewClient(dbPath, cfg.Client)
+
+	accounts.Lock()
+	delete(accounts.adding, name)
+	if err != nil {
+		accounts.Unlock()
+		err = fmt.Errorf("account %q: %w", name, err)
+		return AccountsResult{Success: false, Message: err.Error(), Accounts: []AccountInfo{}}, err
+	}
+	a := &account{dbPath: dbPath, client: client}
+	accounts.byName[name] = a
+	accounts.Unlock()
+
+	attachNATS(name, client)
+	attachWebhook(name, client)
+	return AccountsResult{
+		Success:  true,
+		Message:  fmt.Sprintf("Account %s added", name),
+		Accounts: []AccountInfo{a.info(name)},
+	}, nil
+}
+
+// reserveAccount claims name and dbPath for an account being added, or
+// says which account already has them
+func reserveAccount(name, dbPath, defaultDBPath string) error {
+	accounts.Lock()
+	defer accounts.Unlock()
+	if _, exists := accounts.byName[name]; exists {
+		return fmt.Errorf("account %q already exists", name)
+	}
+	if _, adding := accounts.adding[name]; adding {
+		return fmt.Errorf("account %q is already being added", name)
+	}
+	// Two clients on one database would share, and fight over, one device
+	for other, a := range accounts.byName {
+		if sameFile(a.dbPath, dbPath) {
+			return fmt.Errorf("account %q already uses %s", other, dbPath)
+		}
+	}
+	for other, path := range accounts.adding {
+		if sameFile(path, dbPath) {
+			return fmt.Errorf("account %q already uses %s", other, dbPath)
+		}
+	}
+	if sameFile(defaultDBPath, dbPath) {
+		return fmt.Errorf("the default account uses %s", dbPath)
+	}
+	accounts.adding[name] = dbPath
+	return nil
+}
+
+// removeAccount stops forwarding an added account's events, drains its
+// in-flight sends, disconnects it and forgets it. Its session database is
+// kept, so adding the account again resumes the session.
+func removeAccount(name string) (AccountsResult, error) {
+	accounts.Lock()
+	a, ok := accounts.byName[name]
+	delete(accounts.byName, name)
+	accounts.Unlock()
+	if !ok {
+		err := fmt.Errorf("%w %q", errUnknownAccount, name)
+		if name == defaultAccount {
+			err = fmt.Errorf("the default account cannot be removed")
+		}
+		return AccountsResult{Success: false, Message: err.Error(), Accounts: []AccountInfo{}}, err
+	}
+
+	info := a.info(name)
+	detachNATS(a.client)
+	detachWebhook(a.client)
+	if !a.client.Drain(currentConfig().ShutdownGrace) {
+		podLog.Warnf("Removing account %s with operations still in flight.", name)
+	}
+	a.client.Disconnect()
+	supervisor.Lock()
+	delete(supervisor.sessions, name)
+	supervisor.Unlock()
+	podLog.Infof("Account %s removed", name)
+	return AccountsResult{Success: true, Message: fmt.Sprintf("Account %s removed", name), Accounts: []AccountInfo{info}}, nil
+}
+
+// listAccounts reports every account, the default one first
+func listAccounts() AccountsResult {
+	client, _ := clientState()
+	list := []AccountInfo{{Name: defaultAccount, DBPath: currentConfig().DBPath, Initialized: client != nil}}
+	if client != nil {
+		conn := client.Connection()
+		list[0].Status, list[0].JID = conn.Status, conn.JID
+	}
+	for _, name := range accountNames()[1:] {
+		accounts.Lock()
+		a, ok := accounts.byName[name]
+		accounts.Unlock()
+		if ok {
+			list = append(list, a.info(name))
+		}
+	}
+	return AccountsResult{Success: true, Message: fmt.Sprintf("%d account(s)", len(list)), Accounts: list}
+}
+
+func (a *account) info(name string) AccountInfo {
+	conn := a.client.Connection()
+	return AccountInfo{Name: name, DBPath: a.dbPath, Initialized: true, Status: conn.Status, JID: conn.JID}
+}
+
+// sameFile reports whether two database paths name the same file
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return absA == absB
+}
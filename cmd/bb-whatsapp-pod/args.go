@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// argKind is the expected type of one positional invoke argument
+type argKind int
+
+const (
+	argString argKind = iota
+	argJID            // string that must parse as a WhatsApp JID
+	argPhone          // phone number as a string or integer, normalized to digits
+	argPath           // string naming an existing, readable file
+	argBool
+	argInt
+	argStringList
+	argPhoneList // vector of phone numbers (or JIDs), integers rendered as digits
+	argMap
+	argMapList
+	argBytes // base64 string, or nil for the raw bytes of the invoke's data key
+)
+
+func (k argKind) String() string {
+	switch k {
+	case argString:
+		return "a string"
+	case argJID:
+		return "a JID string"
+	case argPhone:
+		return "a phone number (string or integer)"
+	case argPath:
+		return "a file path string"
+	case argBool:
+		return "a boolean"
+	case argInt:
+		return "an integer"
+	case argStringList:
+		return "a vector of strings"
+	case argPhoneList:
+		return "a vector of phone numbers (strings or integers)"
+	case argMap:
+		return "a map"
+	case argMapList:
+		return "a vector of maps"
+	case argBytes:
+		return "bytes as a base64 string"
+	}
+	return "a value"
+}
+
+// argSpec describes one positional argument of a var
+type argSpec struct {
+	Name     string
+	Kind     argKind
+	Optional bool // optional args may only be followed by other optional args
+	Secret   bool // redacted in the audit log, e.g. passphrases
+	URL      bool // recorded in the audit log without its password
+}
+
+// validateArgs checks arity and argument types for funcName before any
+// whatsmeow call is made, normalizing coercible args (phones) in place. Errors name the offending position in the args
+// vector (e.g. args[1] or args[0][3]) and the schema keyword that failed, so
+// they line up with the schemas returned by get-schemas.
+func validateArgs(funcName string, specs []argSpec, args []interface{}) error {
+	required, most := argLimits(specs)
+	if len(args) < required {
+		return fmt.Errorf("%s: args (minItems): expected %s, got %d", funcName, describeArity(specs, required), len(args))
+	}
+	if len(args) > most {
+		return fmt.Errorf("%s: args (maxItems): expected %s, got %d", funcName, describeArity(specs, required), len(args))
+	}
+
+	for i, arg := range args {
+		if err := validateArg(specs[i], fmt.Sprintf("args[%d]", i), arg); err != nil {
+			return fmt.Errorf("%s: %w", funcName, err)
+		}
+		switch specs[i].Kind {
+		case argPhone:
+			args[i] = phoneArg(arg)
+		case argPhoneList:
+			args[i] = phoneListArg(arg.([]interface{}))
+		}
+	}
+	return nil
+}
+
+// argLimits returns the fewest and the most args a var with specs takes,
+// not counting the invoke options that may follow them
+func argLimits(specs []argSpec) (required, most int) {
+	for _, spec := range specs {
+		if !spec.Optional {
+			required++
+		}
+	}
+	return required, len(specs)
+}
+
+// argCountWithOptions is the most args a var with specs takes, counting the
+// invoke options map after all of its own
+func argCountWithOptions(specs []argSpec) int {
+	_, most := argLimits(specs)
+	return most + 1
+}
+
+// invokeOptionsKey is the reserved key of the map that carries invoke
+// options after a var's args, e.g. {:pod.whatsapp/invoke {:timeout-ms 5000}}.
+// A map holding only this key is never one of the var's own args, so it can
+// follow any number of them.
+const invokeOptionsKey = "pod.whatsapp/invoke"
+
+// invokeOpts are the invoke options any var accepts after its own arguments
+type invokeOpts struct {
+	Timeout time.Duration // 0 when none was given
+	Account string        // "" for the default account
+	Bare    bool          // given as a bare map after every arg, the deprecated form
+}
+
+// invokeOption is one key of the invoke options map: its JSON schema, and
+// how it sets invokeOpts
+type invokeOption struct {
+	schema map[string]interface{}
+	set    func(opts *invokeOpts, v interface{}) error
+}
+
+// invokeOptionKeys are the invoke options invokeOptions accepts and
+// get-schemas describes
+var invokeOptionKeys = map[string]invokeOption{
+	"timeout-ms": {
+		schema: map[string]interface{}{"type": "integer", "exclusiveMinimum": 0, "description": "bounds the whole call, in milliseconds"},
+		set: func(opts *invokeOpts, v interface{}) error {
+			if !isInteger(v) || v.(float64) <= 0 {
+				return fmt.Errorf("timeout-ms must be a positive integer, got %s", describeValue(v))
+			}
+			opts.Timeout = time.Duration(v.(float64)) * time.Millisecond
+			return nil
+		},
+	},
+	"account": {
+		schema: map[string]interface{}{"type": "string", "minLength": 1, "description": "the account to run against, added with add-account"},
+		set: func(opts *invokeOpts, v interface{}) error {
+			s, ok := v.(string)
+			if !ok || s == "" {
+				return fmt.Errorf("account must be a non-empty string, got %s", describeValue(v))
+			}
+			opts.Account = s
+			return nil
+		},
+	},
+}
+
+// invokeOptions splits the invoke options any var accepts from args: a
+// trailing map under invokeOptionsKey or, in the deprecated form, a bare
+// trailing map after all of the var's args. It returns the remaining args
+// and the options.
+func invokeOptions(specs []argSpec, args []interface{}) ([]interface{}, invokeOpts, error) {
+	var opts invokeOpts
+	if len(args) == 0 {
+		return args, opts, nil
+	}
+	last, ok := args[len(args)-1].(map[string]interface{})
+	if !ok {
+		return args, opts, nil // left for validateArgs to report
+	}
+	path := fmt.Sprintf("args[%d]", len(args)-1)
+	values, reserved := last[invokeOptionsKey]
+	switch {
+	case reserved && len(last) == 1:
+		path += "." + invokeOptionsKey
+		if last, ok = values.(map[string]interface{}); !ok {
+			return nil, opts, fmt.Errorf("%s (type): invoke options must be a map, got %s", path, describeValue(values))
+		}
+	case len(args) == argCountWithOptions(specs):
+		opts.Bare = true
+	default:
+		return args, opts, nil
+	}
+	for key, v := range last {
+		option, ok := invokeOptionKeys[key]
+		if !ok {
+			return nil, opts, fmt.Errorf("%s: unknown invoke option %q", path, key)
+		}
+		if err := option.set(&opts, v); err != nil {
+			return nil, opts, fmt.Errorf("%s (type): %w", path, err)
+		}
+	}
+	return args[:len(args)-1], opts, nil
+}
+
+// validateArg checks a single argument at path against its spec
+func validateArg(spec argSpec, path string, arg interface{}) error {
+	switch spec.Kind {
+	case argString, argJID, argPath:
+		s, ok := arg.(string)
+		if !ok {
+			return fmt.Errorf("%s (type): :%s must be %s, got %s", path, spec.Name, spec.Kind, describeValue(arg))
+		}
+		if spec.Kind == argJID {
+			if _, err := types.ParseJID(s); err != nil || !strings.Contains(s, "@") {
+				return fmt.Errorf("%s (format): :%s %q is not a valid JID (expected e.g. 1234567890@s.whatsapp.net or 123-456@g.us)", path, spec.Name, s)
+			}
+		}
+		if spec.Kind == argPath {
+			info, err := os.Stat(s)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("%s (format): :%s %q does not exist", path, spec.Name, s)
+			} else if err != nil {
+				return fmt.Errorf("%s (format): :%s %q is not accessible: %v", path, spec.Name, s, err)
+			}
+			if info.IsDir() {
+				return fmt.Errorf("%s (format): :%s %q is a directory, not a file", path, spec.Name, s)
+			}
+		}
+	case argPhone:
+		if _, ok := arg.(string); !ok && !isInteger(arg) {
+			return fmt.Errorf("%s (type): :%s must be %s, got %s", path, spec.Name, spec.Kind, describeValue(arg))
+		}
+		if _, err := whatsapp.NormalizePhone(phoneArg(arg)); err != nil {
+			return fmt.Errorf("%s (format): :%s %w", path, spec.Name, err)
+		}
+	case argBool:
+		if _, ok := arg.(bool); !ok {
+			return fmt.Errorf("%s (type): :%s must be %s, got %s", path, spec.Name, spec.Kind, describeValue(arg))
+		}
+	case argInt:
+		if !isInteger(arg) {
+			return fmt.Errorf("%s (type): :%s must be %s, got %s", path, spec.Name, spec.Kind, describeValue(arg))
+		}
+	case argStringList:
+		list, ok := arg.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s (type): :%s must be %s, got %s", path, spec.Name, spec.Kind, describeValue(arg))
+		}
+		for i, item := range list {
+			if _, ok := item.(string); !ok {
+				return fmt.Errorf("%s[%d] (items/type): each item of :%s must be a string, got %s", path, i, spec.Name, describeValue(item))
+			}
+		}
+	case argPhoneList:
+		list, ok := arg.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s (type): :%s must be %s, got %s", path, spec.Name, spec.Kind, describeValue(arg))
+		}
+		for i, item := range list {
+			if _, ok := item.(string); !ok && !isInteger(item) {
+				return fmt.Errorf("%s[%d] (items/type): each item of :%s must be a phone number (string or integer), got %s", path, i, spec.Name, describeValue(item))
+			}
+		}
+	case argMap:
+		if _, ok := arg.(map[string]interface{}); !ok {
+			return fmt.Errorf("%s (type): :%s must be %s, got %s", path, spec.Name, spec.Kind, describeValue(arg))
+		}
+	case argMapList:
+		list, ok := arg.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s (type): :%s must be %s, got %s", path, spec.Name, spec.Kind, describeValue(arg))
+		}
+		for i, item := range list {
+			if _, ok := item.(map[string]interface{}); !ok {
+				return fmt.Errorf("%s[%d] (items/type): each item of :%s must be a map, got %s", path, i, spec.Name, describeValue(item))
+			}
+		}
+	case argBytes:
+		switch v := arg.(type) {
+		case []byte:
+		case string:
+			if _, err := base64.StdEncoding.DecodeString(v); err != nil {
+				return fmt.Errorf("%s (contentEncoding): :%s is not valid base64: %v", path, spec.Name, err)
+			}
+		default:
+			return fmt.Errorf("%s (type): :%s must be %s, or nil with the bytes in the invoke's data key, got %s", path, spec.Name, spec.Kind, describeValue(arg))
+		}
+	}
+	return nil
+}
+
+// rawDataArgs puts the raw bytes of an invoke's data key in place of the
+// bytes args passed as nil, so they skip base64 altogether
+func rawDataArgs(specs []argSpec, args []interface{}, data string) {
+	if data == "" {
+		return
+	}
+	for i, arg := range args {
+		if i < len(specs) && specs[i].Kind == argBytes && arg == nil {
+			args[i] = []byte(data)
+		}
+	}
+}
+
+// describeArity renders the accepted argument list for arity errors
+func describeArity(specs []argSpec, required int) string {
+	if len(specs) == 0 {
+		return "no arguments"
+	}
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.Name
+		if spec.Optional {
+			names[i] = "[" + spec.Name + "]"
+		}
+	}
+	count := fmt.Sprintf("%d", required)
+	if required != len(specs) {
+		count = fmt.Sprintf("%d to %d", required, len(specs))
+	}
+	return fmt.Sprintf("%s arguments (%s)", count, strings.Join(names, " "))
+}
+
+// describeValue names the JSON type of a decoded argument for error messages
+func describeValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	case bool:
+		return fmt.Sprintf("boolean %v", v)
+	case []interface{}:
+		return "a vector"
+	case map[string]interface{}:
+		return "a map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// isInteger reports whether a decoded JSON value is a whole number; JSON
+// numbers arrive as float64
+func isInteger(v interface{}) bool {
+	f, ok := v.(float64)
+	return ok && f == float64(int64(f))
+}
+
+// phoneArg renders a string or integer phone argument as normalized digits,
+// falling back to the raw string so validation can report it
+func phoneArg(v interface{}) string {
+	var raw string
+	switch v := v.(type) {
+	case string:
+		raw = v
+	case float64:
+		raw = strconv.FormatInt(int64(v), 10)
+	}
+	if user, err := whatsapp.NormalizePhone(raw); err == nil {
+		return user
+	}
+	return raw
+}
+
+// phoneListArg renders the integers of a phone list as digits, as
+// phoneArg does. Strings are kept as given: the vars taking a list report
+// each unparsable item, and accept JIDs, rather than failing the call.
+func phoneListArg(list []interface{}) []interface{} {
+	phones := make([]interface{}, len(list))
+	for i, item := range list {
+		if isInteger(item) {
+			item = phoneArg(item)
+		}
+		phones[i] = item
+	}
+	return phones
+}
+
+// intArg returns args[i] as an int, or def when it is absent
+func intArg(args []interface{}, i int, def int) int {
+	if i >= len(args) {
+		return def
+	}
+	f, ok := args[i].(float64)
+	if !ok {
+		return def
+	}
+	return int(f)
+}
+
+// stringArg returns args[i] as a string, or "" when it is absent
+func stringArg(args []interface{}, i int) string {
+	if i >= len(args) {
+		return ""
+	}
+	s, _ := args[i].(string)
+	return s
+}
+
+// bytesArg returns args[i], raw or base64, as bytes
+func bytesArg(args []interface{}, i int) []byte {
+	if i >= len(args) {
+		return nil
+	}
+	if data, ok := args[i].([]byte); ok {
+		return data
+	}
+	s, _ := args[i].(string)
+	data, _ := base64.StdEncoding.DecodeString(s)
+	return data
+}
+
+// stringListArg returns args[i] as a slice of strings, or nil when it is
+// absent
+func stringListArg(args []interface{}, i int) []string {
+	if i >= len(args) {
+		return nil
+	}
+	list, _ := args[i].([]interface{})
+	strs := make([]string, 0, len(list))
+	for _, v := range list {
+		s, _ := v.(string)
+		strs = append(strs, s)
+	}
+	return strs
+}
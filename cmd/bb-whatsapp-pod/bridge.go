@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+// maxHTTPMedia bounds media uploaded to /media, WhatsApp's document limit
+const maxHTTPMedia = 100 << 20
+
+// The bridge routes give the HTTP API the shape of a plain WhatsApp HTTP
+// bridge, for callers that do not want to learn the var names. They run the
+// same vars as /<var>, so results, errors and metadata are the same.
+func handleBridgeRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /send", handleBridgeSend)
+	mux.HandleFunc("GET /groups", handleBridgeGroups)
+	mux.HandleFunc("POST /media", handleBridgeMedia)
+}
+
+// bridgeSend is the body of POST /send
+type bridgeSend struct {
+	To          string `json:"to"` // phone number, or a JID such as 123-456@g.us
+	Text        string `json:"text"`
+	LinkPreview bool   `json:"link-preview"` // phone numbers only
+}
+
+// handleBridgeSend sends a text message to a phone number or a JID
+func handleBridgeSend(w http.ResponseWriter, r *http.Request) {
+	var req bridgeSend
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxHTTPBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("body must be a JSON object with to and text: %v", err))
+		return
+	}
+	if req.To == "" || req.Text == "" {
+		writeHTTPError(w, http.StatusBadRequest, "to and text are required")
+		return
+	}
+	// Phone numbers go through send-message; full JIDs (groups, lids,
+	// status@broadcast) are addressed directly, as with the send command
+	name, args := "send-message", []interface{}{req.To, req.Text, map[string]interface{}{"link-preview": req.LinkPreview}}
+	if strings.Contains(req.To, "@") {
+		name, args = "send-to-jid", []interface{}{req.To, req.Text}
+	}
+	bridgeInvoke(w, r, name, args)
+}
+
+// handleBridgeGroups lists the groups the account is in
+func handleBridgeGroups(w http.ResponseWriter, r *http.Request) {
+	bridgeInvoke(w, r, "get-groups", nil)
+}
+
+// handleBridgeMedia sends a media message. The body is either a JSON object
+// {"to", "path", "caption", "type"} naming a file on the pod's host, or a
+// multipart form with the to, caption and type fields and the file in a
+// file part. type is image, video, audio or document; without it the media
+// type of the file decides.
+func handleBridgeMedia(w http.ResponseWriter, r *http.Request) {
+	var to, path, caption, kind string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxHTTPMedia)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("file: %v", err))
+			return
+		}
+		defer file.Close()
+		path, err = saveUpload(file, header.Filename)
+		if err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, fmt.Sprintf("saving the upload: %v", err))
+			return
+		}
+		defer os.Remove(path) // a dead letter of this send cannot be retried
+		to, caption, kind = r.FormValue("to"), r.FormValue("caption"), r.FormValue("type")
+		if kind == "" {
+			kind = mediaKind(header.Header.Get("Content-Type"), header.Filename)
+		}
+	} else {
+		var req struct {
+			To      string `json:"to"`
+			Path    string `json:"path"`
+			Caption string `json:"caption"`
+			Type    string `json:"type"`
+		}
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxHTTPBody))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("body must be a multipart form or a JSON object with to and path: %v", err))
+			return
+		}
+		to, path, caption, kind = req.To, req.Path, req.Caption, req.Type
+		if kind == "" {
+			kind = mediaKind("", path)
+		}
+	}
+	if to == "" || path == "" {
+		writeHTTPError(w, http.StatusBadRequest, "to and a file are required")
+		return
+	}
+
+	recipient := to
+	if !strings.Contains(to, "@") {
+		digits, err := whatsapp.NormalizePhone(to)
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("to: %v", err))
+			return
+		}
+		recipient = digits + "@s.whatsapp.net"
+	}
+	var args []interface{}
+	switch kind {
+	case "image", "video", "document":
+		args = []interface{}{recipient, path, caption}
+	case "audio":
+		args = []interface{}{recipient, path}
+	default:
+		writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("type must be image, video, audio or document, got %q", kind))
+		return
+	}
+	bridgeInvoke(w, r, "send-"+kind, args)
+}
+
+// bridgeInvoke runs a var for a bridge route
+func bridgeInvoke(w http.ResponseWriter, r *http.Request, name string, args []interface{}) {
+	ctx, cancel, err := httpContext(r)
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer cancel()
+	result, meta, err := invokeExternal(ctx, "http", name, args)
+	writeHTTPResult(w, result, meta, err)
+}
+
+// saveUpload copies an uploaded file to a temporary file, keeping the
+// extension so the media type can still be told from the name
+func saveUpload(file io.Reader, name string) (string, error) {
+	tmp, err := os.CreateTemp("", "bb-whatsapp-upload-*"+filepath.Ext(filepath.Base(name)))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// mediaKind picks the send var for a media type, falling back to the file
+// extension when the type is missing or generic
+func mediaKind(contentType, name string) string {
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	}
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return "image"
+	case strings.HasPrefix(contentType, "video/"):
+		return "video"
+	case strings.HasPrefix(contentType, "audio/"):
+		return "audio"
+	}
+	return "document"
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errInterrupted is returned on an invoke that was stopped by the cancel var
+var errInterrupted = errors.New("interrupted")
+
+// inFlight tracks running invokes by message id so cancel can reach them
+var inFlight = struct {
+	sync.Mutex
+	invokes map[string]*runningInvoke
+}{invokes: map[string]*runningInvoke{}}
+
+type runningInvoke struct {
+	varName string
+	started time.Time
+	cancel  context.CancelFunc
+}
+
+// InvokeInfo describes one running invoke for health and cancel
+type InvokeInfo struct {
+	ID        string `json:"id"`
+	Var       string `json:"var"`
+	ElapsedMs int64  `json:"elapsed_ms"`
+}
+
+// CancelResult lists the invokes that were interrupted
+type CancelResult struct {
+	Success   bool         `json:"success"`
+	Message   string       `json:"message"`
+	Cancelled []InvokeInfo `json:"cancelled"`
+}
+
+// trackInvoke registers an invoke and returns its context, cancelled by the
+// cancel var or at shutdown, and a release func that must be called once the
+// invoke has finished
+func trackInvoke(id string, varName string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(podCtx)
+	inFlight.Lock()
+	inFlight.invokes[id] = &runningInvoke{varName: varName, started: time.Now(), cancel: cancel}
+	inFlight.Unlock()
+	return ctx, func() {
+		inFlight.Lock()
+		delete(inFlight.invokes, id)
+		inFlight.Unlock()
+		cancel()
+	}
+}
+
+// inFlightInvokes lists running invokes, oldest first
+func inFlightInvokes() []InvokeInfo {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	infos := make([]InvokeInfo, 0, len(inFlight.invokes))
+	for id, ri := range inFlight.invokes {
+		infos = append(infos, ri.info(id))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ElapsedMs > infos[j].ElapsedMs })
+	return infos
+}
+
+func (ri *runningInvoke) info(id string) InvokeInfo {
+	return InvokeInfo{ID: id, Var: ri.varName, ElapsedMs: time.Since(ri.started).Milliseconds()}
+}
+
+// cancelInvokes interrupts the invoke with the given id. A var name such as
+// pod.whatsapp/login (or just login) interrupts every running call of it.
+func cancelInvokes(target string, self string) (CancelResult, error) {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+
+	var cancelled []InvokeInfo
+	if ri, ok := inFlight.invokes[target]; ok && target != self {
+		ri.cancel()
+		cancelled = append(cancelled, ri.info(target))
+	} else {
+		for id, ri := range inFlight.invokes {
+			if id == self {
+				continue
+			}
+			if ri.varName == target || strings.TrimPrefix(ri.varName, "pod.whatsapp/") == target {
+				ri.cancel()
+				cancelled = append(cancelled, ri.info(id))
+			}
+		}
+	}
+
+	if len(cancelled) == 0 {
+		return CancelResult{Success: false, Message: "no running invoke matches " + target, Cancelled: []InvokeInfo{}},
+			fmt.Errorf("cancel: no running invoke matches %q", target)
+	}
+	return CancelResult{
+		Success:   true,
+		Message:   fmt.Sprintf("cancelled %d invoke(s)", len(cancelled)),
+		Cancelled: cancelled,
+	}, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+// cliCommand is a one-shot subcommand that runs a single operation against
+// the stored session and exits, instead of starting the pod read loop
+type cliCommand struct {
+	Usage string
+	Help  string
+	Run   func(ctx context.Context, args []string) error
+}
+
+var cliCommands = map[string]cliCommand{
+	"login": {
+		Usage: "login [--db-path PATH]",
+		Help:  "pair this device by scanning a QR code",
+		Run:   cliLogin,
+	},
+	"status": {
+		Usage: "status [--db-path PATH] [--json]",
+		Help:  "show whether the stored session can log in",
+		Run:   cliStatus,
+	},
+	"send": {
+		Usage: "send --to PHONE|JID --text TEXT [--db-path PATH] [--json]",
+		Help:  "send a text message",
+		Run:   cliSend,
+	},
+	"groups": {
+		Usage: "groups list [--db-path PATH] [--json]",
+		Help:  "list the groups this account is in",
+		Run:   cliGroups,
+	},
+}
+
+// runCLI runs a subcommand and returns the process exit code. Ctrl-C
+// cancels the running operation like the cancel var would.
+func runCLI(args []string) int {
+	cmd, ok := cliCommands[args[0]]
+	if !ok {
+		cliUsage()
+		return 2
+	}
+	if err := cmd.Run(podCtx, args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "bb-whatsapp-pod %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// cliUsage lists the subcommands on stderr
+func cliUsage() {
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "Usage: bb-whatsapp-pod [--http ADDR] [--grpc ADDR]   run as a babashka pod")
+	fmt.Fprintln(os.Stderr, "       bb-whatsapp-pod --serve ADDR [--grpc ADDR]    run as a standalone WhatsApp HTTP bridge")
+	fmt.Fprintln(os.Stderr, "       bb-whatsapp-pod <command> [flags]             run one operation and exit")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\t%s\n", cliCommands[name].Usage, cliCommands[name].Help)
+	}
+	w.Flush()
+	fmt.Fprintln(os.Stderr, "\nPod flags:")
+	flag.PrintDefaults()
+}
+
+// cliFlags holds the flags shared by every subcommand
+type cliFlags struct {
+	*flag.FlagSet
+	dbPath  *string
+	jsonOut *bool
+}
+
+func newCLIFlags(name string) cliFlags {
+	fs := flag.NewFlagSet("bb-whatsapp-pod "+name, flag.ContinueOnError)
+	return cliFlags{
+		FlagSet: fs,
+		dbPath:  fs.String("db-path", currentConfig().DBPath, "session database"),
+		jsonOut: fs.Bool("json", false, "print the raw JSON result"),
+	}
+}
+
+// parse parses args and applies --db-path before the client is created
+func (f cliFlags) parse(args []string) error {
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if f.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(f.Args(), " "))
+	}
+	_, err := applyConfig(map[string]interface{}{"db-path": *f.dbPath})
+	return err
+}
+
+// print writes result as indented JSON when --json is set, otherwise as text
+func (f cliFlags) print(result interface{}, text func()) error {
+	if !*f.jsonOut {
+		text()
+		return nil
+	}
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// cliConnect logs in with the stored session, failing instead of showing a
+// QR code when the database has none
+func cliConnect(ctx context.Context) (*whatsapp.WhatsAppClient, error) {
+	client, err := getWaClient()
+	if err != nil {
+		return nil, err
+	}
+	if !client.HasSession() {
+		return nil, fmt.Errorf("no session in %s, run `bb-whatsapp-pod login` first", currentConfig().DBPath)
+	}
+	result, _, err := invokeExternal(ctx, "cli", "login", nil)
+	if err != nil {
+		return nil, err
+	}
+	if login := result.(whatsapp.LoginResult); login.Status != "logged-in" {
+		return nil, fmt.Errorf("could not log in with the stored session (status %s)", login.Status)
+	}
+	return client, nil
+}
+
+func cliLogin(ctx context.Context, args []string) error {
+	f := newCLIFlags("login")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	client, err := getWaClient()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(currentConfig().Client.LoginTimeout)
+	shown := ""
+	for {
+		// login starts the connection, and while a QR code is pending it
+		// returns the current one; WhatsApp rotates it every ~20s
+		result, _, err := invokeExternal(ctx, "cli", "login", nil)
+		if err != nil {
+			return err
+		}
+		login := result.(whatsapp.LoginResult)
+		if login.Status == "logged-in" {
+			fmt.Printf("Logged in as %s\n", client.Client.DeviceID())
+			return nil
+		}
+		if login.QrCode != "" && login.QrCode != shown {
+			shown = login.QrCode
+			printQRCode(shown)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errInterrupted
+		case <-time.After(time.Second):
+		}
+		// Check the status before asking again: calling login right after
+		// pairing would start a second connection
+		status, _ := client.Status()
+		switch status.(whatsapp.StatusResult).Status {
+		case "logged-in":
+			fmt.Printf("Logged in as %s\n", client.Client.DeviceID())
+			return nil
+		case "login-failed":
+			return fmt.Errorf("login failed, see the log for details")
+		case "upgrade-required":
+			return fmt.Errorf("WhatsApp rejected the client version, see the log for details")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the QR code to be scanned")
+		}
+	}
+}
+
+// printQRCode renders code with qrencode when it is installed, otherwise
+// prints the raw string for another tool to render
+func printQRCode(code string) {
+	fmt.Println("Scan this QR code with WhatsApp on your phone (Settings > Linked devices):")
+	if path, err := exec.LookPath("qrencode"); err == nil {
+		cmd := exec.Command(path, "-t", "ANSIUTF8", "-o", "-", code)
+		cmd.Stdout = os.Stdout
+		if cmd.Run() == nil {
+			return
+		}
+	}
+	fmt.Println(code)
+	fmt.Println("(install qrencode to see it rendered here)")
+}
+
+func cliStatus(ctx context.Context, args []string) error {
+	f := newCLIFlags("status")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	client, err := getWaClient()
+	if err != nil {
+		return err
+	}
+	if !client.HasSession() {
+		return f.print(whatsapp.StatusResult{Status: "not-logged-in"}, func() {
+			fmt.Printf("not-logged-in (no session in %s)\n", currentConfig().DBPath)
+		})
+	}
+	if _, err := cliConnect(ctx); err != nil {
+		return err
+	}
+	result, _, err := invokeExternal(ctx, "cli", "status", nil)
+	if err != nil {
+		return err
+	}
+	status := result.(whatsapp.StatusResult)
+	return f.print(status, func() {
+		fmt.Printf("%s as %s\n", status.Status, client.Client.DeviceID())
+	})
+}
+
+func cliSend(ctx context.Context, args []string) error {
+	f := newCLIFlags("send")
+	to := f.String("to", "", "phone number, or a JID such as 123-456@g.us")
+	text := f.String("text", "", "message text")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	if *to == "" || *text == "" {
+		return fmt.Errorf("--to and --text are required")
+	}
+	// Phone numbers go through send-message; full JIDs (groups, lids,
+	// status@broadcast) are addressed directly
+	name := "send-message"
+	if strings.Contains(*to, "@") {
+		name = "send-to-jid"
+	}
+	if _, err := cliConnect(ctx); err != nil {
+		return err
+	}
+	result, _, err := invokeExternal(ctx, "cli", name, []interface{}{*to, *text})
+	if err != nil {
+		return err
+	}
+	return f.print(result, func() {
+		fmt.Println(result.(whatsapp.SendResult).Message)
+	})
+}
+
+func cliGroups(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return fmt.Errorf("unknown groups command, expected `groups list`")
+	}
+	f := newCLIFlags("groups list")
+	if err := f.parse(args[1:]); err != nil {
+		return err
+	}
+	if _, err := cliConnect(ctx); err != nil {
+		return err
+	}
+	result, _, err := invokeExternal(ctx, "cli", "get-groups", nil)
+	if err != nil {
+		return err
+	}
+	return f.print(result, func() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "JID\tNAME\tPARTICIPANTS")
+		for _, g := range result.(whatsapp.GroupResult).Groups {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", g.JID, g.Name, len(g.Participants))
+		}
+		w.Flush()
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/babashka"
+	"olympos.io/encoding/edn"
+)
+
+// codeVars are Clojure helpers babashka evaluates on its side when the pod
+// is loaded. They only call pod vars, so each one is a single form in the
+// pod namespace and works over either payload format.
+var codeVars = []babashka.Var{
+	{Name: "phone->jid", Code: `(defn phone->jid
+  "The user JID of a phone number, dropping spaces, dashes, dots,
+  parentheses and a leading +: \"+1 (234) 567-890\" gives
+  \"1234567890@s.whatsapp.net\". A JID is returned as it is."
+  [phone]
+  (let [s (clojure.string/trim (str phone))]
+    (if (clojure.string/includes? s "@")
+      s
+      (let [digits (clojure.string/replace s #"^\+|[\s().-]" "")]
+        (when-not (re-matches #"\d+" digits)
+          (throw (ex-info (str "invalid phone number " (pr-str phone)) {:phone phone})))
+        (str digits "@s.whatsapp.net")))))`},
+
+	{Name: "with-retry*", Code: `(defn with-retry*
+  "Calls f, and calls it again while it throws and retries are left,
+  waiting backoff-ms and then twice as long each time. Options:
+  :retries (default 3), :backoff-ms (default 1000) and :retry?, a
+  predicate on the exception; by default every error but invalid
+  arguments is retried."
+  [{:keys [retries backoff-ms retry?]
+    :or {retries 3
+         backoff-ms 1000
+         retry? (fn [e] (not (re-find #"args\[\d+\]" (str (ex-message e)))))}}
+   f]
+  (loop [attempt 0]
+    (let [outcome (try
+                    {:value (f)}
+                    (catch Exception e
+                      (if (and (< attempt retries) (retry? e))
+                        {:error e}
+                        (throw e))))]
+      (if (contains? outcome :value)
+        (:value outcome)
+        (do (Thread/sleep (long (* backoff-ms (bit-shift-left 1 attempt))))
+            (recur (inc attempt)))))))`},
+
+	{Name: "with-retry", Code: `(defmacro with-retry
+  "Evaluates body, retrying it as with-retry* does with opts:
+  (with-retry {:retries 5} (send-message \"1234567890\" \"Hello\"))"
+  [opts & body]
+  ` + "`" + `(pod.whatsapp/with-retry* ~opts (fn [] ~@body)))`},
+
+	{Name: "render-qr", Code: `(defn render-qr
+  "Prints the QR code of a login result, or of a raw QR string, in the
+  terminal and returns its argument. Prints nothing for a login result
+  without a QR code, e.g. when already logged in."
+  [login-result-or-code]
+  (let [x login-result-or-code
+        drawn (if (map? x)
+                (or (:qr_terminal x)
+                    (some-> (:qr_code x) (pod.whatsapp/encode-qr "terminal") :qr_terminal))
+                (:qr_terminal (pod.whatsapp/encode-qr x "terminal")))]
+    (when drawn
+      (println drawn))
+    x))`},
+}
+
+// varMeta is the EDN metadata of a pod var: its arglists, one per number
+// of optional args given plus one with the invoke options, and a docstring
+// listing what each arg takes
+func varMeta(h *handler) string {
+	var arglists, doc []string
+	var names []string
+	for _, spec := range h.Args {
+		if spec.Optional {
+			arglists = append(arglists, "["+strings.Join(names, " ")+"]")
+		}
+		names = append(names, spec.Name)
+		line := fmt.Sprintf("  %s: %s", spec.Name, spec.Kind)
+		if spec.Optional {
+			line += ", optional"
+		}
+		doc = append(doc, line)
+	}
+	arglists = append(arglists, "["+strings.Join(names, " ")+"]")
+	arglists = append(arglists, "["+strings.Join(append(names, "invoke-opts"), " ")+"]")
+
+	text := "Runs " + h.Name + " in the pod."
+	if len(doc) > 0 {
+		text += "\n\n" + strings.Join(doc, "\n")
+	}
+	if h.Async {
+		text += "\n\nAsync: call it with babashka.pods/invoke and :handlers; each value goes to :success."
+	}
+	text += "\n\ninvoke-opts, a map {:pod.whatsapp/invoke {...}} after any of the args, may set :timeout-ms and :account."
+	quoted, err := edn.Marshal(text)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("{:arglists (%s) :doc %s}", strings.Join(arglists, " "), quoted)
+}
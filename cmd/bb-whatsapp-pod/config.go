@@ -0,0 +1,688 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+// podConfig holds every runtime option of the pod. It starts with the
+// historical hardcoded values and is changed through the configure var.
+type podConfig struct {
+	DBPath        string
+	LogPath       string // file path, or "stderr" / "discard"
+	LogLevel      whatsapp.LogLevel
+	LogFormat     string // "text" or "json"
+	LogRotation   logRotation
+	MetricsAddr   string        // host:port for the Prometheus /metrics listener, "" disables
+	NATS          natsConfig    // event publishing, off while NATS.URL is ""
+	WebhookURL    string        // events are POSTed here as JSON, "" disables
+	WebhookSecret secret        // signs webhook requests with HMAC-SHA256, "" sends them unsigned
+	ShutdownGrace time.Duration // how long shutdown waits for in-flight sends
+
+	// The supervisor restarts a session disconnected for longer than
+	// SessionStuckAfter (0 disables restarts). Restarts and retried
+	// initializations wait SessionBackoff, doubled per attempt until the
+	// session is healthy again.
+	SessionStuckAfter time.Duration
+	SessionBackoff    time.Duration
+
+	Client whatsapp.Options
+}
+
+// secret is a config value kept out of logs: it prints as [redacted], or ""
+// when unset
+type secret string
+
+func (s secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+var configMutex sync.Mutex // guards config; invokes run concurrently
+
+var config = podConfig{
+	DBPath:            "whatsapp.db",
+	LogPath:           "pod.log",
+	LogLevel:          whatsapp.LevelInfo,
+	LogFormat:         logFormatText,
+	LogRotation:       logRotation{MaxSizeMB: 100, MaxBackups: 5, MaxAgeDays: 30},
+	NATS:              natsConfig{Subject: "whatsapp.events", Stream: "WHATSAPP_EVENTS"},
+	ShutdownGrace:     10 * time.Second,
+	SessionStuckAfter: 2 * time.Minute,
+	SessionBackoff:    5 * time.Second,
+	Client:            whatsapp.DefaultOptions(),
+}
+
+// currentConfig returns a snapshot of the pod configuration
+func currentConfig() podConfig {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	return config
+}
+
+// ConfigResult is returned by configure and shows the effective settings
+type ConfigResult struct {
+	Success             bool   `json:"success"`
+	Message             string `json:"message,omitempty"`
+	DBPath              string `json:"db-path"`
+	DBJournalMode       string `json:"db-journal-mode"`
+	DBBusyTimeoutMs     int64  `json:"db-busy-timeout-ms"`
+	DBSynchronous       string `json:"db-synchronous"`
+	LogPath             string `json:"log-path"`
+	LogLevel            string `json:"log-level"`
+	LogFormat           string `json:"log-format"`
+	LogMaxSizeMB        int    `json:"log-max-size-mb"`
+	LogMaxBackups       int    `json:"log-max-backups"`
+	LogMaxAgeDays       int    `json:"log-max-age-days"`
+	MetricsAddr         string `json:"metrics-addr"`
+	NATSURL             string `json:"nats-url"` // passwords redacted, as for proxy
+	NATSSubject         string `json:"nats-subject"`
+	NATSJetStream       bool   `json:"nats-jetstream"`
+	NATSStream          string `json:"nats-stream"`
+	WebhookURL          string `json:"webhook-url"`
+	WebhookSecret       string `json:"webhook-secret,omitempty"` // only tells whether one is set
+	Proxy               string `json:"proxy"`
+	DryRun              bool   `json:"dry-run"`
+	UploadCacheTTLHours int    `json:"upload-cache-ttl-hours"`
+	AutoUpdateVersion   bool   `json:"auto-update-version"`
+	DownloadDir         string `json:"download-dir"`
+	DownloadPerChat     bool   `json:"download-per-chat"`
+	DownloadMaxAgeDays  int    `json:"download-max-age-days"`
+	DownloadMaxSizeMB   int64  `json:"download-max-size-mb"`
+	HistoryMaxAgeDays   int    `json:"history-max-age-days"`
+	HistoryMaxRows      int64  `json:"history-max-rows"`
+	LoginTimeoutMs      int64  `json:"login-timeout-ms"`
+	AutoReconnect       bool   `json:"auto-reconnect"`
+	ReconnectBackoffMs  int64  `json:"reconnect-backoff-ms"`
+	SendIntervalMs      int64  `json:"send-interval-ms"`
+	ThrottleRetries     int    `json:"throttle-retries"`
+	ThrottleBackoffMs   int64  `json:"throttle-backoff-ms"`
+	UploadConcurrency   int    `json:"upload-concurrency"`
+	MaxUploadSizeMB     int64  `json:"max-upload-size-mb"`
+	CallTimeoutMs       int64  `json:"call-timeout-ms"`
+	GroupConcurrency    int    `json:"group-concurrency"`
+	AckTimeoutMs        int64  `json:"ack-timeout-ms"`
+	AckRetries          int    `json:"ack-retries"`
+	MessageQueueSize    int    `json:"message-queue-size"`
+	DeviceName          string `json:"device-name"`
+	HistorySync         bool   `json:"history-sync"`
+	ShutdownGraceMs     int64  `json:"shutdown-grace-ms"`
+	SessionStuckMs      int64  `json:"session-stuck-ms"`
+	SessionBackoffMs    int64  `json:"session-backoff-ms"`
+}
+
+func (c podConfig) result() ConfigResult {
+	return ConfigResult{
+		Success:             true,
+		DBPath:              c.DBPath,
+		DBJournalMode:       c.Client.DBJournalMode,
+		DBBusyTimeoutMs:     c.Client.DBBusyTimeout.Milliseconds(),
+		DBSynchronous:       c.Client.DBSynchronous,
+		LogPath:             c.LogPath,
+		LogLevel:            c.LogLevel.String(),
+		LogFormat:           c.LogFormat,
+		LogMaxSizeMB:        c.LogRotation.MaxSizeMB,
+		LogMaxBackups:       c.LogRotation.MaxBackups,
+		LogMaxAgeDays:       c.LogRotation.MaxAgeDays,
+		MetricsAddr:         c.MetricsAddr,
+		NATSURL:             whatsapp.RedactURL(c.NATS.URL),
+		NATSSubject:         c.NATS.Subject,
+		NATSJetStream:       c.NATS.JetStream,
+		NATSStream:          c.NATS.Stream,
+		WebhookURL:          whatsapp.RedactURL(c.WebhookURL),
+		WebhookSecret:       c.WebhookSecret.String(),
+		Proxy:               whatsapp.RedactURL(c.Client.Proxy),
+		DryRun:              c.Client.DryRun,
+		UploadCacheTTLHours: int(c.Client.UploadCacheTTL / time.Hour),
+		AutoUpdateVersion:   c.Client.AutoUpdateVersion,
+		DownloadDir:         c.Client.DownloadDir,
+		DownloadPerChat:     c.Client.DownloadPerChat,
+		DownloadMaxAgeDays:  int(c.Client.DownloadMaxAge / (24 * time.Hour)),
+		DownloadMaxSizeMB:   c.Client.DownloadMaxBytes >> 20,
+		HistoryMaxAgeDays:   int(c.Client.HistoryMaxAge / (24 * time.Hour)),
+		HistoryMaxRows:      c.Client.HistoryMaxRows,
+		LoginTimeoutMs:      c.Client.LoginTimeout.Milliseconds(),
+		AutoReconnect:       c.Client.AutoReconnect,
+		ReconnectBackoffMs:  c.Client.ReconnectBackoff.Milliseconds(),
+		SendIntervalMs:      c.Client.SendInterval.Milliseconds(),
+		ThrottleRetries:     c.Client.ThrottleRetries,
+		ThrottleBackoffMs:   c.Client.ThrottleBackoff.Milliseconds(),
+		UploadConcurrency:   c.Client.UploadConcurrency,
+		MaxUploadSizeMB:     c.Client.MaxUploadSize >> 20,
+		CallTimeoutMs:       c.Client.CallTimeout.Milliseconds(),
+		GroupConcurrency:    c.Client.GroupConcurrency,
+		AckTimeoutMs:        c.Client.AckTimeout.Milliseconds(),
+		AckRetries:          c.Client.AckRetries,
+		MessageQueueSize:    c.Client.MessageQueueSize,
+		DeviceName:          c.Client.DeviceName,
+		HistorySync:         c.Client.HistorySync,
+		ShutdownGraceMs:     c.ShutdownGrace.Milliseconds(),
+		SessionStuckMs:      c.SessionStuckAfter.Milliseconds(),
+		SessionBackoffMs:    c.SessionBackoff.Milliseconds(),
+	}
+}
+
+// configOption applies one key of the configure map to a config copy
+type configOption func(c *podConfig, value interface{}) error
+
+var configOptions = map[string]configOption{
+	"db-path": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return fmt.Errorf("must be a non-empty string")
+		}
+		c.DBPath = s
+		return nil
+	},
+	"db-journal-mode": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok || !slices.Contains([]string{"wal", "delete", "truncate", "persist", "memory", "off"}, strings.ToLower(s)) {
+			return fmt.Errorf("must be one of \"wal\", \"delete\", \"truncate\", \"persist\", \"memory\" or \"off\"")
+		}
+		c.Client.DBJournalMode = strings.ToLower(s)
+		return nil
+	},
+	"db-busy-timeout-ms": func(c *podConfig, v interface{}) error {
+		d, err := durationMs(v)
+		c.Client.DBBusyTimeout = d
+		return err
+	},
+	"db-synchronous": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok || !slices.Contains([]string{"off", "normal", "full", "extra"}, strings.ToLower(s)) {
+			return fmt.Errorf("must be one of \"off\", \"normal\", \"full\" or \"extra\"")
+		}
+		c.Client.DBSynchronous = strings.ToLower(s)
+		return nil
+	},
+	"log-path": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return fmt.Errorf("must be a non-empty string")
+		}
+		c.LogPath = s
+		return nil
+	},
+	"log-level": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be one of debug, info, warn or error")
+		}
+		level, err := whatsapp.ParseLogLevel(s)
+		if err != nil {
+			return err
+		}
+		c.LogLevel = level
+		return nil
+	},
+	"log-format": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok || (s != logFormatText && s != logFormatJSON) {
+			return fmt.Errorf("must be \"text\" or \"json\"")
+		}
+		c.LogFormat = s
+		return nil
+	},
+	"log-max-size-mb": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.LogRotation.MaxSizeMB = n
+		return err
+	},
+	"log-max-backups": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.LogRotation.MaxBackups = n
+		return err
+	},
+	"log-max-age-days": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.LogRotation.MaxAgeDays = n
+		return err
+	},
+	"metrics-addr": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be a host:port string, or \"\" to disable")
+		}
+		c.MetricsAddr = s
+		return nil
+	},
+	"nats-url": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be a NATS URL string such as nats://localhost:4222, or \"\" to disable")
+		}
+		c.NATS.URL = s
+		return nil
+	},
+	"nats-subject": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok || s == "" || strings.ContainsAny(s, "*> \t") || strings.HasSuffix(s, ".") {
+			return fmt.Errorf("must be a subject prefix without wildcards, e.g. whatsapp.events")
+		}
+		c.NATS.Subject = s
+		return nil
+	},
+	"nats-jetstream": func(c *podConfig, v interface{}) error {
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+		c.NATS.JetStream = b
+		return nil
+	},
+	"nats-stream": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok || s == "" || strings.ContainsAny(s, ".*> \t") {
+			return fmt.Errorf("must be a stream name without dots, wildcards or spaces")
+		}
+		c.NATS.Stream = s
+		return nil
+	},
+	"webhook-url": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be an http(s) URL string, or \"\" to disable")
+		}
+		if s != "" {
+			if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("must be an http(s) URL string, or \"\" to disable")
+			}
+		}
+		c.WebhookURL = s
+		return nil
+	},
+	"webhook-secret": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be a string, or \"\" to send unsigned requests")
+		}
+		c.WebhookSecret = secret(s)
+		return nil
+	},
+	"proxy": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be a proxy URL string, or \"\" for a direct connection")
+		}
+		if s != "" {
+			u, err := url.Parse(s)
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+				return fmt.Errorf("must be an http://, https:// or socks5:// URL, or \"\" for a direct connection")
+			}
+		}
+		c.Client.Proxy = s
+		return nil
+	},
+	"login-timeout-ms": func(c *podConfig, v interface{}) error {
+		d, err := durationMs(v)
+		if err != nil {
+			return err
+		}
+		c.Client.LoginTimeout = d
+		return nil
+	},
+	"auto-reconnect": func(c *podConfig, v interface{}) error {
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+		c.Client.AutoReconnect = b
+		return nil
+	},
+	"reconnect-backoff-ms": func(c *podConfig, v interface{}) error {
+		d, err := durationMs(v)
+		if err != nil {
+			return err
+		}
+		if d == 0 {
+			return fmt.Errorf("must be positive")
+		}
+		c.Client.ReconnectBackoff = d
+		return nil
+	},
+	"send-interval-ms": func(c *podConfig, v interface{}) error {
+		d, err := durationMs(v)
+		if err != nil {
+			return err
+		}
+		c.Client.SendInterval = d
+		return nil
+	},
+	"throttle-retries": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.Client.ThrottleRetries = n
+		return err
+	},
+	"throttle-backoff-ms": func(c *podConfig, v interface{}) error {
+		d, err := durationMs(v)
+		if err != nil {
+			return err
+		}
+		if d == 0 {
+			return fmt.Errorf("must be positive")
+		}
+		c.Client.ThrottleBackoff = d
+		return nil
+	},
+	"call-timeout-ms": func(c *podConfig, v interface{}) error {
+		d, err := durationMs(v)
+		if err != nil {
+			return err
+		}
+		c.Client.CallTimeout = d
+		return nil
+	},
+	"dry-run": func(c *podConfig, v interface{}) error {
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+		c.Client.DryRun = b
+		return nil
+	},
+	"auto-update-version": func(c *podConfig, v interface{}) error {
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+		c.Client.AutoUpdateVersion = b
+		return nil
+	},
+	"upload-concurrency": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		if err == nil && n == 0 {
+			err = fmt.Errorf("must be a positive integer")
+		}
+		c.Client.UploadConcurrency = n
+		return err
+	},
+	"max-upload-size-mb": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.Client.MaxUploadSize = int64(n) << 20
+		return err
+	},
+	"group-concurrency": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		if err == nil && n == 0 {
+			err = fmt.Errorf("must be a positive integer")
+		}
+		c.Client.GroupConcurrency = n
+		return err
+	},
+	"ack-timeout-ms": func(c *podConfig, v interface{}) error {
+		d, err := durationMs(v)
+		if err != nil {
+			return err
+		}
+		c.Client.AckTimeout = d
+		return nil
+	},
+	"ack-retries": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.Client.AckRetries = n
+		return err
+	},
+	"device-name": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		c.Client.DeviceName = s
+		return nil
+	},
+	"history-sync": func(c *podConfig, v interface{}) error {
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+		c.Client.HistorySync = b
+		return nil
+	},
+	"message-queue-size": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.Client.MessageQueueSize = n
+		return err
+	},
+	"upload-cache-ttl-hours": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.Client.UploadCacheTTL = time.Duration(n) * time.Hour
+		return err
+	},
+	"download-dir": func(c *podConfig, v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be a directory path string, or \"\" to disable auto-download")
+		}
+		c.Client.DownloadDir = s
+		return nil
+	},
+	"download-per-chat": func(c *podConfig, v interface{}) error {
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+		c.Client.DownloadPerChat = b
+		return nil
+	},
+	"download-max-age-days": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.Client.DownloadMaxAge = time.Duration(n) * 24 * time.Hour
+		return err
+	},
+	"download-max-size-mb": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.Client.DownloadMaxBytes = int64(n) << 20
+		return err
+	},
+	"history-max-age-days": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.Client.HistoryMaxAge = time.Duration(n) * 24 * time.Hour
+		return err
+	},
+	"history-max-rows": func(c *podConfig, v interface{}) error {
+		n, err := nonNegativeInt(v)
+		c.Client.HistoryMaxRows = int64(n)
+		return err
+	},
+	"shutdown-grace-ms": func(c *podConfig, v interface{}) error {
+		d, err := durationMs(v)
+		if err != nil {
+			return err
+		}
+		c.ShutdownGrace = d
+		return nil
+	},
+	"session-stuck-ms": func(c *podConfig, v interface{}) error {
+		d, err := durationMs(v)
+		c.SessionStuckAfter = d
+		return err
+	},
+	"session-backoff-ms": func(c *podConfig, v interface{}) error {
+		d, err := durationMs(v)
+		if err == nil && d == 0 {
+			err = fmt.Errorf("must be a positive number of milliseconds")
+		}
+		c.SessionBackoff = d
+		return err
+	},
+}
+
+// durationMs converts a JSON number of milliseconds to a duration
+func durationMs(v interface{}) (time.Duration, error) {
+	f, ok := v.(float64)
+	if !ok || f < 0 {
+		return 0, fmt.Errorf("must be a non-negative number of milliseconds")
+	}
+	return time.Duration(f) * time.Millisecond, nil
+}
+
+// nonNegativeInt converts a JSON number to a non-negative int
+func nonNegativeInt(v interface{}) (int, error) {
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != float64(int(f)) {
+		return 0, fmt.Errorf("must be a non-negative integer")
+	}
+	return int(f), nil
+}
+
+// set applies values in key order, returning the offending key (or "" for
+// an unknown one) on the first error
+func (c *podConfig) set(values map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		apply, ok := configOptions[key]
+		if !ok {
+			return "", fmt.Errorf("unknown option :%s", key)
+		}
+		if err := apply(c, values[key]); err != nil {
+			return key, err
+		}
+	}
+	return "", nil
+}
+
+// applyStartupConfig commits values from a config file or the environment.
+// It runs before logging and the listeners start, so they come up with the
+// final settings instead of being restarted. source names where a bad key
+// ("" for an unknown one) came from.
+func applyStartupConfig(values map[string]interface{}, source func(key string) string) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	next := config
+	if key, err := next.set(values); err != nil {
+		return fmt.Errorf("%s: %w", source(key), err)
+	}
+	config = next
+	return nil
+}
+
+// initPod applies values like configure, then starts the WhatsApp client
+// with them, so a script learns about a bad db-path up front rather than on
+// its first call
+func initPod(values map[string]interface{}) (ConfigResult, error) {
+	result, err := applyConfig(values)
+	if err != nil {
+		return result, err
+	}
+	if _, err := getWaClient(); err != nil {
+		return ConfigResult{Success: false, Message: err.Error()}, fmt.Errorf("init: failed to initialize WhatsApp client: %w", err)
+	}
+	return result, nil
+}
+
+// applyConfig validates the whole map first and only then commits it, so a
+// bad key never leaves the pod half configured
+func applyConfig(values map[string]interface{}) (ConfigResult, error) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	client, _ := clientState()
+	next := config
+	if key, err := next.set(values); err != nil {
+		if key == "" {
+			return ConfigResult{Success: false, Message: err.Error()}, fmt.Errorf("configure: %w", err)
+		}
+		return ConfigResult{Success: false, Message: err.Error()}, fmt.Errorf("configure: :%s %w", key, err)
+	}
+
+	if client != nil && next.DBPath != config.DBPath {
+		return ConfigResult{Success: false, Message: "db-path cannot change after the WhatsApp client is initialized"},
+			fmt.Errorf("configure: :db-path cannot change after the WhatsApp client is initialized")
+	}
+	if client != nil && (next.Client.DBJournalMode != config.Client.DBJournalMode ||
+		next.Client.DBBusyTimeout != config.Client.DBBusyTimeout || next.Client.DBSynchronous != config.Client.DBSynchronous) {
+		return ConfigResult{Success: false, Message: "database pragmas cannot change after the WhatsApp client is initialized"},
+			fmt.Errorf("configure: :db-journal-mode, :db-busy-timeout-ms and :db-synchronous cannot change after the WhatsApp client is initialized")
+	}
+
+	// Open the log file, listener and NATS connection first, and switch
+	// over to them only once all of them succeeded
+	var changes []pendingChange
+	fail := func(err error) (ConfigResult, error) {
+		for _, c := range changes {
+			c.discard()
+		}
+		return ConfigResult{Success: false, Message: err.Error()}, fmt.Errorf("configure: %w", err)
+	}
+	if next.LogPath != config.LogPath || next.LogFormat != config.LogFormat || next.LogRotation != config.LogRotation {
+		c, err := prepareLogOutput(next)
+		if err != nil {
+			return fail(fmt.Errorf(":log-path %w", err))
+		}
+		changes = append(changes, c)
+	}
+	if next.MetricsAddr != config.MetricsAddr {
+		c, err := prepareMetricsAddr(next.MetricsAddr)
+		if err != nil {
+			return fail(fmt.Errorf(":metrics-addr %w", err))
+		}
+		changes = append(changes, c)
+	}
+	if next.NATS != config.NATS {
+		c, err := prepareNATS(next.NATS)
+		if err != nil {
+			return fail(fmt.Errorf(":nats-url %w", err))
+		}
+		changes = append(changes, c)
+	}
+	for _, c := range changes {
+		c.apply()
+	}
+	if next.WebhookURL != config.WebhookURL || next.WebhookSecret != config.WebhookSecret {
+		setWebhook(next.WebhookURL, string(next.WebhookSecret))
+	}
+
+	config = next
+	whatsapp.SetLogLevel(config.LogLevel)
+	if client != nil {
+		client.SetOptions(config.Client)
+	}
+	for _, added := range addedClients() {
+		added.SetOptions(config.Client)
+	}
+	logged := config
+	logged.Client.Proxy = whatsapp.RedactURL(config.Client.Proxy)
+	logged.NATS.URL = whatsapp.RedactURL(config.NATS.URL)
+	logged.WebhookURL = whatsapp.RedactURL(config.WebhookURL)
+	podLog.Infof("Configuration updated: %+v", logged)
+	return config.result(), nil
+}
+
+// pendingChange is a setting whose resources configure has opened but not
+// yet switched to: apply puts it in effect, discard releases it
+type pendingChange struct {
+	apply   func()
+	discard func()
+}
+
+// historyLimits reads the :max-age-days and :max-rows overrides of
+// prune-history, keeping the configured limit for a key that is absent
+func historyLimits(limits map[string]interface{}, maxAge time.Duration, maxRows int64) (time.Duration, int64, error) {
+	for key, v := range limits {
+		n, err := nonNegativeInt(v)
+		if err != nil {
+			return 0, 0, fmt.Errorf("prune-history: :%s %w", key, err)
+		}
+		switch key {
+		case "max-age-days":
+			maxAge = time.Duration(n) * 24 * time.Hour
+		case "max-rows":
+			maxRows = int64(n)
+		default:
+			return 0, 0, fmt.Errorf("prune-history: unknown limit :%s, expected :max-age-days or :max-rows", key)
+		}
+	}
+	return maxAge, maxRows, nil
+}
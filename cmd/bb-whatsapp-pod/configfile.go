@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"olympos.io/encoding/edn"
+)
+
+// loadConfigFile reads the configure options from an EDN (.edn) or YAML
+// (.yaml, .yml) file. The file holds one map with the same keys configure
+// takes, e.g. {:db-path "/data/whatsapp.db" :log-level "debug"}.
+func loadConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".edn":
+		err = edn.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q, expected .edn, .yaml or .yml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return map[string]interface{}{}, nil // empty file
+	}
+
+	value, err := configValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a map of options at the top level, got %s", describeValue(value))
+	}
+	return values, nil
+}
+
+// configValue converts decoded EDN or YAML into the JSON shapes configure
+// options expect: string keys, float64 numbers, keywords as strings
+func configValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			key, err := configKey(k)
+			if err != nil {
+				return nil, err
+			}
+			if m[key], err = configValue(item); err != nil {
+				return nil, fmt.Errorf(":%s %w", key, err)
+			}
+		}
+		return m, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			var err error
+			if m[key], err = configValue(item); err != nil {
+				return nil, fmt.Errorf(":%s %w", key, err)
+			}
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = configValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case edn.Keyword:
+		return string(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case nil, bool, float64, string:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v (%T)", v, v)
+}
+
+// configKey accepts :keyword, "string" and symbol keys
+func configKey(k interface{}) (string, error) {
+	switch k := k.(type) {
+	case edn.Keyword:
+		return string(k), nil
+	case edn.Symbol:
+		return string(k), nil
+	case string:
+		return strings.TrimPrefix(k, ":"), nil
+	}
+	return "", fmt.Errorf("option keys must be keywords or strings, got %v (%T)", k, k)
+}
+
+// applyConfigFile loads path into the startup config
+func applyConfigFile(path string) error {
+	values, err := loadConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return applyStartupConfig(values, func(key string) string {
+		if key == "" {
+			return "config file " + path
+		}
+		return fmt.Sprintf("config file %s: :%s", path, key)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import "fmt"
+
+// deprecation marks a var, or one positional-arg form of it, as superseded.
+// Matching invokes still run, but the caller gets a warning on *err*.
+type deprecation struct {
+	Var         string
+	Arity       int                                    // number of args the old form takes; -1 matches any arity
+	Matches     func(args []interface{}) bool          // tells the old form from a current one of the same arity; nil matches any
+	Upgrade     func(args []interface{}) []interface{} // rewrites the old form's args into the current form; nil keeps them
+	Replacement string
+}
+
+// deprecations lists every superseded var and arg form. Add an entry here
+// instead of removing a var outright so existing scripts keep working.
+var deprecations = []deprecation{
+	{
+		Var:   "send-message",
+		Arity: 3,
+		Matches: func(args []interface{}) bool {
+			_, ok := args[2].(bool)
+			return ok
+		},
+		Upgrade: func(args []interface{}) []interface{} {
+			return []interface{}{args[0], args[1], map[string]interface{}{"link-preview": args[2]}}
+		},
+		Replacement: "pass {:link-preview true} as the options instead",
+	},
+	{
+		Var:   "send-message",
+		Arity: 4,
+		Upgrade: func(args []interface{}) []interface{} {
+			return []interface{}{args[0], args[1], map[string]interface{}{"link-preview": args[2], "mentions": args[3]}}
+		},
+		Replacement: "pass {:link-preview ... :mentions [...]} as the options instead",
+	},
+	{
+		Var:   "send-group-message",
+		Arity: 3,
+		Matches: func(args []interface{}) bool {
+			_, ok := args[2].([]interface{})
+			return ok
+		},
+		Upgrade: func(args []interface{}) []interface{} {
+			return []interface{}{args[0], args[1], map[string]interface{}{"mentions": args[2]}}
+		},
+		Replacement: "pass {:mentions [...]} as the options instead",
+	},
+}
+
+// checkDeprecations returns warnings for an invoke of funcName with args,
+// and the args rewritten into the current form of the var
+func checkDeprecations(funcName string, args []interface{}) ([]interface{}, []string) {
+	var warnings []string
+	for _, d := range deprecations {
+		if d.Var != funcName || (d.Arity >= 0 && d.Arity != len(args)) {
+			continue
+		}
+		if d.Matches != nil && !d.Matches(args) {
+			continue
+		}
+		if d.Arity >= 0 {
+			warnings = append(warnings, fmt.Sprintf("pod.whatsapp/%s with %d args is deprecated, %s", d.Var, d.Arity, d.Replacement))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("pod.whatsapp/%s is deprecated, %s", d.Var, d.Replacement))
+		}
+		if d.Upgrade != nil {
+			args = d.Upgrade(args)
+		}
+	}
+	return args, warnings
+}
+
+// bareOptionsDeprecation warns about invoke options passed as a bare map
+// after all of a var's args. It only works once every optional arg is
+// given, and cannot be told apart from a var's own trailing map.
+func bareOptionsDeprecation(funcName string) string {
+	return fmt.Sprintf("a bare invoke options map after the args of pod.whatsapp/%s is deprecated, pass {:%s {...}} instead", funcName, invokeOptionsKey)
+}
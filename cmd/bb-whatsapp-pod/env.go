@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// envPrefix prefixes every environment variable the pod reads. Each
+// configure option has one: :db-path is BB_WHATSAPP_DB_PATH, :webhook-url
+// is BB_WHATSAPP_WEBHOOK_URL, and so on.
+const envPrefix = "BB_WHATSAPP_"
+
+// envName returns the environment variable for a configure option
+func envName(option string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// envConfig collects the configure options set in the environment. Values
+// are strings there, so each is offered to its option as a string first and
+// then as a number or boolean.
+func envConfig() (map[string]interface{}, error) {
+	names := make([]string, 0, len(configOptions))
+	for option := range configOptions {
+		names = append(names, option)
+	}
+	sort.Strings(names)
+
+	values := map[string]interface{}{}
+	for _, option := range names {
+		raw, ok := os.LookupEnv(envName(option))
+		if !ok {
+			continue
+		}
+		value, err := envValue(option, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", envName(option), err)
+		}
+		values[option] = value
+	}
+	return values, nil
+}
+
+// envValue converts raw to the first type the option accepts
+func envValue(option, raw string) (interface{}, error) {
+	candidates := []interface{}{raw}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil {
+		candidates = append(candidates, f)
+	}
+	if b, err := strconv.ParseBool(raw); err == nil {
+		candidates = append(candidates, b)
+	}
+	var firstErr error
+	for _, v := range candidates {
+		var scratch podConfig
+		err := configOptions[option](&scratch, v)
+		if err == nil {
+			return v, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// applyEnvConfig loads the environment into the startup config
+func applyEnvConfig() error {
+	values, err := envConfig()
+	if err != nil {
+		return err
+	}
+	return applyStartupConfig(values, envName)
+}
+
+// envListenAddr returns the address for --http or --grpc when the flag is
+// unset: BB_WHATSAPP_<NAME>_ADDR, or :PORT for BB_WHATSAPP_<NAME>_PORT
+func envListenAddr(name string) (string, error) {
+	if addr := os.Getenv(envPrefix + name + "_ADDR"); addr != "" {
+		return addr, nil
+	}
+	port := os.Getenv(envPrefix + name + "_PORT")
+	if port == "" {
+		return "", nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("%s%s_PORT: %q is not a port number", envPrefix, name, port)
+	}
+	return ":" + port, nil
+}
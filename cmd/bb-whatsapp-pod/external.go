@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/babashka"
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+// externalErrorKind classifies failures of invokes that arrive over HTTP or
+// gRPC, so each transport can map them to its own status codes
+type externalErrorKind int
+
+const (
+	errUnknownVar externalErrorKind = iota
+	errBadArgs
+	errUnavailable // shutting down
+	errCancelled
+	errTimeout // the invoke ran past its deadline
+	errFailed  // the var itself returned an error or panicked
+)
+
+// externalError is returned by invokeExternal
+type externalError struct {
+	Kind    externalErrorKind
+	Message string
+}
+
+func (e *externalError) Error() string { return e.Message }
+
+// invokeExternal runs a var for a transport other than the pod protocol.
+// It goes through the same validation, tracking and handler code as a
+// babashka invoke; cancelling ctx cancels the invoke like the cancel var.
+func invokeExternal(ctx context.Context, source string, name string, args []interface{}) (result interface{}, meta *InvokeMetadata, err error) {
+	h, ok := handlers[name]
+	if !ok {
+		return nil, nil, &externalError{errUnknownVar, fmt.Sprintf("Unknown function: %s", name)}
+	}
+	if !beginInvoke() {
+		return nil, nil, &externalError{errUnavailable, whatsapp.ErrShuttingDown.Error()}
+	}
+	defer invokesRunning.Done()
+	// Validate up front so argument errors can be told apart from failures
+	plain, _, err := invokeOptions(h.Args, args)
+	if err != nil {
+		return nil, nil, &externalError{errBadArgs, fmt.Sprintf("%s: %v", name, err)}
+	}
+	if err := validateArgs(name, h.Args, plain); err != nil {
+		return nil, nil, &externalError{errBadArgs, err.Error()}
+	}
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return nil, nil, &externalError{errBadArgs, err.Error()}
+	}
+
+	msg := babashka.Message{Op: "invoke", Id: source + "-" + newRequestID(), Var: "pod.whatsapp/" + name, Args: string(argsJSON)}
+	ilog := newInvokeLogger(&msg)
+	ilog.Infof("Handling %s invoke of %s", source, name)
+	defer func() {
+		if p := recover(); p != nil {
+			ilog.Errorf("PANIC while invoking %s over %s: %v", msg.Var, source, p)
+			recordError(msg.Var, fmt.Sprintf("panic: %v", p))
+			result, meta, err = nil, nil, &externalError{errFailed, fmt.Sprintf("internal error in %s: %v", msg.Var, p)}
+		}
+	}()
+
+	// The caller going away cancels the invoke
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancelInvokes(msg.Id, "")
+		case <-done:
+		}
+	}()
+
+	metricInvokes.Inc()
+	result, meta, invokeErr := handleInvoke(msg, ilog, nil)
+	if invokeErr != nil {
+		metricInvokeErrors.Inc()
+		errMsg := invokeErr.Error()
+		recordError(msg.Var, errMsg)
+		switch {
+		case errors.Is(invokeErr, whatsapp.ErrTimeout):
+			return nil, nil, &externalError{errTimeout, errMsg}
+		case errors.Is(ctx.Err(), context.DeadlineExceeded): // the caller's deadline, e.g. a gRPC one
+			return nil, nil, &externalError{errTimeout, fmt.Sprintf("%s: %v", name, whatsapp.ErrTimeout)}
+		case ctx.Err() != nil:
+			return nil, nil, &externalError{errCancelled, errMsg}
+		}
+		return nil, nil, &externalError{errFailed, errMsg}
+	}
+	return result, meta, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/podpb"
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var grpcLog = whatsapp.NewLogger("gRPC") // logs of the gRPC API
+
+// grpcServer implements proto/whatsapp.proto on top of the var registry, so
+// gRPC calls share validation, tracking and the client with pod invokes
+type grpcServer struct {
+	podpb.UnimplementedWhatsAppServer
+}
+
+// startGRPCServer serves the WhatsApp gRPC service on addr
+func startGRPCServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	podpb.RegisterWhatsAppServer(server, grpcServer{})
+	go func() {
+		defer whatsapp.LogPanic(grpcLog, "the gRPC server")
+		if err := server.Serve(listener); err != nil {
+			grpcLog.Errorf("Server on %s stopped: %v", addr, err)
+		}
+	}()
+	grpcLog.Infof("Serving WhatsApp service on %s", listener.Addr())
+	return nil
+}
+
+// grpcCall invokes a var and decodes its JSON result into out. Result keys
+// are the proto field names, unknown ones (e.g. metadata) are dropped.
+func grpcCall(ctx context.Context, name string, args []interface{}, out proto.Message) error {
+	result, _, err := invokeExternal(ctx, "grpc", name, args)
+	if err != nil {
+		return grpcStatus(err)
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return status.Errorf(codes.Internal, "error marshaling result to JSON: %v", err)
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, out); err != nil {
+		return status.Errorf(codes.Internal, "error converting %s result: %v", name, err)
+	}
+	return nil
+}
+
+// grpcStatus maps an invokeExternal error to a gRPC status
+func grpcStatus(err error) error {
+	e, ok := err.(*externalError)
+	if !ok {
+		return status.Error(codes.Unknown, err.Error())
+	}
+	switch e.Kind {
+	case errUnknownVar:
+		return status.Error(codes.NotFound, e.Message)
+	case errBadArgs:
+		return status.Error(codes.InvalidArgument, e.Message)
+	case errUnavailable:
+		return status.Error(codes.Unavailable, e.Message)
+	case errCancelled:
+		return status.Error(codes.Canceled, e.Message)
+	case errTimeout:
+		return status.Error(codes.DeadlineExceeded, e.Message)
+	}
+	return status.Error(codes.Unknown, e.Message)
+}
+
+func (grpcServer) Login(ctx context.Context, _ *podpb.LoginRequest) (*podpb.LoginResponse, error) {
+	out := &podpb.LoginResponse{}
+	return out, grpcCall(ctx, "login", nil, out)
+}
+
+func (grpcServer) Status(ctx context.Context, _ *podpb.StatusRequest) (*podpb.StatusResponse, error) {
+	out := &podpb.StatusResponse{}
+	return out, grpcCall(ctx, "status", nil, out)
+}
+
+func (grpcServer) SendMessage(ctx context.Context, in *podpb.SendMessageRequest) (*podpb.SendResponse, error) {
+	out := &podpb.SendResponse{}
+	return out, grpcCall(ctx, "send-message", []interface{}{in.GetPhone(), in.GetMessage()}, out)
+}
+
+func (grpcServer) SendGroupMessage(ctx context.Context, in *podpb.SendGroupMessageRequest) (*podpb.SendResponse, error) {
+	out := &podpb.SendResponse{}
+	return out, grpcCall(ctx, "send-group-message", []interface{}{in.GetGroupJid(), in.GetMessage()}, out)
+}
+
+func (grpcServer) SendImage(ctx context.Context, in *podpb.SendImageRequest) (*podpb.SendResponse, error) {
+	out := &podpb.SendResponse{}
+	return out, grpcCall(ctx, "send-image", []interface{}{in.GetRecipient(), in.GetPath(), in.GetCaption()}, out)
+}
+
+func (grpcServer) GetGroups(ctx context.Context, _ *podpb.GetGroupsRequest) (*podpb.GetGroupsResponse, error) {
+	out := &podpb.GetGroupsResponse{}
+	return out, grpcCall(ctx, "get-groups", nil, out)
+}
+
+func (grpcServer) Invoke(ctx context.Context, in *podpb.InvokeRequest) (*podpb.InvokeResponse, error) {
+	var args []interface{}
+	if in.GetArgsJson() != "" {
+		if err := json.Unmarshal([]byte(in.GetArgsJson()), &args); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "args_json must be a JSON array: %v", err)
+		}
+	}
+	result, meta, err := invokeExternal(ctx, "grpc", in.GetVar(), args)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	if _, ok := result.(*whatsapp.BinaryResult); ok {
+		return nil, status.Errorf(codes.Unimplemented, "%s returns binary data, which Invoke does not carry", in.GetVar())
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error marshaling result to JSON: %v", err)
+	}
+	return &podpb.InvokeResponse{ValueJson: string(withMetadata(b, meta))}, nil
+}
+
+func (grpcServer) Events(in *podpb.EventsRequest, stream grpc.ServerStreamingServer[podpb.Event]) error {
+	client, err := getWaClient()
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "Failed to initialize WhatsApp client: %v", err)
+	}
+	filter := eventSubscription{Types: in.GetTypes(), Chats: in.GetChats()}
+	events, unsubscribe := client.Subscribe(256)
+	defer unsubscribe()
+	grpcLog.Infof("Event stream opened (types=%v chats=%v)", filter.Types, filter.Chats)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			grpcLog.Infof("Event stream closed by client")
+			return nil
+		case evt, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if !filter.matches(evt) {
+				continue
+			}
+			out, err := grpcEvent(evt)
+			if err != nil {
+				grpcLog.Errorf("Converting %s event: %v", evt.Type, err)
+				continue
+			}
+			if err := stream.Send(out); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcEvent converts a client event to its proto form
+func grpcEvent(evt whatsapp.Event) (*podpb.Event, error) {
+	out := &podpb.Event{Type: evt.Type, Timestamp: evt.Timestamp}
+	if evt.Data != nil {
+		data, err := json.Marshal(evt.Data)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling event data: %w", err)
+		}
+		out.DataJson = string(data)
+	}
+	if m, ok := evt.Data.(*whatsapp.MessageInfo); ok {
+		out.Message = &podpb.Message{
+			Id:          m.ID,
+			ChatId:      m.ChatID,
+			Content:     m.Content,
+			Sender:      m.Sender,
+			IsFromMe:    m.IsFromMe,
+			MessageType: m.MessageType,
+			Timestamp:   m.Timestamp,
+		}
+	}
+	return out, nil
+}
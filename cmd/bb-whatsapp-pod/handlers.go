@@ -0,0 +1,1299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/babashka"
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+// invocation carries everything a handler needs for one invoke
+type invocation struct {
+	Msg    *babashka.Message
+	Args   []interface{}
+	Client *whatsapp.WhatsAppClient // nil for handlers with NoClient set
+	Log    whatsapp.Logger
+	Ctx    context.Context // cancelled by the cancel var, bounded by a timeout-ms invoke option
+
+	Started  time.Time
+	Retries  int      // attempts beyond the first, reported in metadata
+	Warnings []string // non-fatal problems, reported in metadata
+}
+
+// handler is one pod.whatsapp var. Registering it once is enough for it to
+// be described, validated and dispatched.
+type handler struct {
+	Name     string
+	Args     []argSpec
+	NoClient bool // answer without initializing the WhatsApp client
+	Async    bool // streams several values (e.g. binary results)
+	Audit    bool // an administrative action, recorded in the audit log
+	ReadOnly bool // has no side effects, so the HTTP API also serves it on GET
+	Fn       func(inv *invocation) (interface{}, error)
+}
+
+var (
+	handlers     = map[string]*handler{}
+	handlerOrder []string // registration order, used for describe
+)
+
+// register adds a var to the registry; registering a name twice is a bug
+func register(h handler) {
+	if _, exists := handlers[h.Name]; exists {
+		panic("duplicate handler registration: " + h.Name)
+	}
+	handlers[h.Name] = &h
+	handlerOrder = append(handlerOrder, h.Name)
+}
+
+// describeVars generates the describe var list from the registry
+func describeVars() []babashka.Var {
+	vars := make([]babashka.Var, 0, len(handlerOrder)+len(codeVars))
+	for _, name := range handlerOrder {
+		v := babashka.Var{Name: name, Meta: varMeta(handlers[name])}
+		if handlers[name].Async {
+			v.Async = "true"
+		}
+		vars = append(vars, v)
+	}
+	// After the pod vars, since the helpers call them
+	return append(vars, codeVars...)
+}
+
+func init() {
+	// Pod-level vars
+	register(handler{
+		Name:     "version",
+		ReadOnly: true,
+		NoClient: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return getVersionInfo(), nil
+		},
+	})
+	register(handler{
+		Name:     "health",
+		ReadOnly: true,
+		NoClient: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return getHealth(), nil
+		},
+	})
+	register(handler{
+		Name:     "get-stats",
+		ReadOnly: true,
+		NoClient: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return getStats(), nil
+		},
+	})
+	register(handler{
+		Name:     "configure",
+		Args:     []argSpec{{Name: "options", Kind: argMap}},
+		NoClient: true,
+		Audit:    true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return applyConfig(inv.Args[0].(map[string]interface{}))
+		},
+	})
+	register(handler{
+		Name:     "init",
+		Args:     []argSpec{{Name: "options", Kind: argMap, Optional: true}},
+		NoClient: true,
+		Audit:    true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			values := map[string]interface{}{}
+			if len(inv.Args) > 0 {
+				values = inv.Args[0].(map[string]interface{})
+			}
+			return initPod(values)
+		},
+	})
+	register(handler{
+		Name:     "set-webhook",
+		Args:     []argSpec{{Name: "url", Kind: argString, URL: true}, {Name: "options", Kind: argMap, Optional: true}},
+		NoClient: true,
+		Audit:    true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			var opts webhookOptions
+			if len(inv.Args) > 1 {
+				if err := decodeMapArg(inv.Args[1].(map[string]interface{}), &opts); err != nil {
+					err = fmt.Errorf("args[1]: invalid options: %w", err)
+					return WebhookResult{Success: false, Message: err.Error()}, err
+				}
+			}
+			return configureWebhook(stringArg(inv.Args, 0), opts)
+		},
+	})
+	register(handler{
+		Name:     "get-webhook",
+		ReadOnly: true,
+		NoClient: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return webhookStatus(), nil
+		},
+	})
+	register(handler{
+		Name:     "get-schemas",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "vars", Kind: argStringList, Optional: true}},
+		NoClient: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return getSchemas(stringListArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:     "cancel",
+		Args:     []argSpec{{Name: "invoke-id", Kind: argString}},
+		NoClient: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return cancelInvokes(stringArg(inv.Args, 0), inv.Msg.Id)
+		},
+	})
+
+	register(handler{
+		Name:     "add-account",
+		Args:     []argSpec{{Name: "name", Kind: argString}, {Name: "db-path", Kind: argString, Optional: true}},
+		NoClient: true,
+		Audit:    true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return addAccount(stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:     "remove-account",
+		Args:     []argSpec{{Name: "name", Kind: argString}},
+		NoClient: true,
+		Audit:    true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return removeAccount(stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:     "list-accounts",
+		ReadOnly: true,
+		NoClient: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return listAccounts(), nil
+		},
+	})
+	register(handler{
+		Name:     "get-sessions",
+		ReadOnly: true,
+		NoClient: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return getSessions(), nil
+		},
+	})
+
+	// Session
+	register(handler{
+		Name: "login",
+		Args: []argSpec{{Name: "options", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var opts whatsapp.LoginOptions
+			if len(inv.Args) > 0 {
+				if err := decodeMapArg(inv.Args[0].(map[string]interface{}), &opts); err != nil {
+					return nil, fmt.Errorf("args[0]: invalid options: %w", err)
+				}
+			}
+			return inv.Client.Login(inv.Ctx, opts)
+		},
+	})
+	register(handler{
+		Name:     "encode-qr",
+		Args:     []argSpec{{Name: "code", Kind: argString}, {Name: "format", Kind: argString, Optional: true}},
+		NoClient: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return whatsapp.EncodeQR(stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name: "pair-phone",
+		Args: []argSpec{{Name: "phone", Kind: argPhone}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.PairPhone(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:  "logout",
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.Logout(inv.Ctx)
+		},
+	})
+	register(handler{
+		Name:     "status",
+		ReadOnly: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.Status()
+		},
+	})
+	register(handler{
+		Name:  "backup-session",
+		Args:  []argSpec{{Name: "path", Kind: argString}, {Name: "passphrase", Kind: argString, Optional: true, Secret: true}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.BackupSession(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:     "restore-session",
+		Args:     []argSpec{{Name: "path", Kind: argPath}, {Name: "passphrase", Kind: argString, Optional: true, Secret: true}},
+		NoClient: true,
+		Audit:    true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return restoreSession(stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:     "check-wa-version",
+		ReadOnly: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.CheckVersion(inv.Ctx)
+		},
+	})
+	register(handler{
+		Name:  "set-wa-version",
+		Args:  []argSpec{{Name: "version", Kind: argString}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SetVersion(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:  "prune-history",
+		Args:  []argSpec{{Name: "limits", Kind: argMap, Optional: true}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			opts := inv.Client.Options()
+			maxAge, maxRows := opts.HistoryMaxAge, opts.HistoryMaxRows
+			if len(inv.Args) > 0 {
+				var err error
+				if maxAge, maxRows, err = historyLimits(inv.Args[0].(map[string]interface{}), maxAge, maxRows); err != nil {
+					return whatsapp.PruneResult{Success: false, Message: err.Error()}, err
+				}
+			}
+			return inv.Client.PruneHistory(maxAge, maxRows)
+		},
+	})
+	register(handler{
+		Name:  "export-audit-log",
+		Args:  []argSpec{{Name: "path", Kind: argString}, {Name: "options", Kind: argMap, Optional: true}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			var opts whatsapp.AuditExportOptions
+			if len(inv.Args) > 1 {
+				if err := decodeMapArg(inv.Args[1].(map[string]interface{}), &opts); err != nil {
+					err = fmt.Errorf("args[1]: invalid options: %w", err)
+					return whatsapp.AuditExportResult{Success: false, Message: err.Error()}, err
+				}
+			}
+			return inv.Client.ExportAuditLog(inv.Ctx, stringArg(inv.Args, 0), opts)
+		},
+	})
+	register(handler{
+		Name:  "db-maintenance",
+		Args:  []argSpec{{Name: "vacuum", Kind: argBool, Optional: true}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			vacuum := len(inv.Args) == 0 || inv.Args[0].(bool)
+			return inv.Client.Maintenance(inv.Ctx, vacuum)
+		},
+	})
+
+	// Event stream
+	register(handler{
+		Name:  "listen",
+		Args:  []argSpec{{Name: "filter", Kind: argMap, Optional: true}},
+		Async: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			var filter eventSubscription
+			if len(inv.Args) > 0 {
+				if err := decodeMapArg(inv.Args[0].(map[string]interface{}), &filter); err != nil {
+					return nil, fmt.Errorf("args[0]: invalid filter: %w", err)
+				}
+			}
+			return listen(inv, filter)
+		},
+	})
+
+	register(handler{
+		Name: "poll-messages",
+		Args: []argSpec{{Name: "max", Kind: argInt, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.PollMessages(intArg(inv.Args, 0, 0)), nil
+		},
+	})
+
+	// Messaging
+	register(handler{
+		Name: "send-message",
+		Args: []argSpec{
+			{Name: "phone", Kind: argPhone},
+			{Name: "message", Kind: argString},
+			{Name: "options", Kind: argMap, Optional: true},
+		},
+		Fn: func(inv *invocation) (interface{}, error) {
+			opts, err := textOptions(inv, 2)
+			if err != nil {
+				return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.SendMessage(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), opts)
+		},
+	})
+	register(handler{
+		Name: "send-to-jid",
+		Args: []argSpec{{Name: "jid", Kind: argJID}, {Name: "message", Kind: argString}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SendToJID(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name: "send-batch",
+		Args: []argSpec{{Name: "messages", Kind: argMapList}, {Name: "options", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			items := inv.Args[0].([]interface{})
+			messages := make([]whatsapp.BatchMessage, len(items))
+			for i, item := range items {
+				if err := decodeMapArg(item.(map[string]interface{}), &messages[i]); err != nil {
+					return nil, fmt.Errorf("args[0][%d]: invalid message: %w", i, err)
+				}
+			}
+			var opts whatsapp.BatchOptions
+			if len(inv.Args) > 1 {
+				if err := decodeMapArg(inv.Args[1].(map[string]interface{}), &opts); err != nil {
+					return nil, fmt.Errorf("args[1]: invalid options: %w", err)
+				}
+			}
+			return inv.Client.SendBatch(inv.Ctx, messages, opts)
+		},
+	})
+	register(handler{
+		Name: "send-broadcast",
+		Args: []argSpec{{Name: "recipients", Kind: argPhoneList}, {Name: "message", Kind: argString}, {Name: "options", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var opts whatsapp.BatchOptions
+			if len(inv.Args) > 2 {
+				if err := decodeMapArg(inv.Args[2].(map[string]interface{}), &opts); err != nil {
+					return nil, fmt.Errorf("args[2]: invalid options: %w", err)
+				}
+			}
+			return inv.Client.SendBroadcast(inv.Ctx, stringListArg(inv.Args, 0), stringArg(inv.Args, 1), opts)
+		},
+	})
+	register(handler{
+		Name: "send-status-update",
+		Args: []argSpec{{Name: "status", Kind: argMap}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var status whatsapp.StatusUpdate
+			if err := decodeMapArg(inv.Args[0].(map[string]interface{}), &status); err != nil {
+				err = fmt.Errorf("args[0]: invalid status: %w", err)
+				return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.SendStatusUpdate(inv.Ctx, status)
+		},
+	})
+	register(handler{
+		Name: "post-status-image",
+		Args: []argSpec{{Name: "path", Kind: argPath}, {Name: "options", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			opts, err := statusMediaOptions(inv)
+			if err != nil {
+				return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.PostStatusImage(inv.Ctx, stringArg(inv.Args, 0), opts)
+		},
+	})
+	register(handler{
+		Name: "post-status-video",
+		Args: []argSpec{{Name: "path", Kind: argPath}, {Name: "options", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			opts, err := statusMediaOptions(inv)
+			if err != nil {
+				return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.PostStatusVideo(inv.Ctx, stringArg(inv.Args, 0), opts)
+		},
+	})
+	register(handler{
+		Name: "reply-message",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "quoted", Kind: argMap}, {Name: "text", Kind: argString}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var quoted whatsapp.QuotedMessage
+			if err := decodeMapArg(inv.Args[1].(map[string]interface{}), &quoted); err != nil {
+				return nil, fmt.Errorf("args[1]: invalid quoted message: %w", err)
+			}
+			return inv.Client.ReplyMessage(inv.Ctx, stringArg(inv.Args, 0), quoted, stringArg(inv.Args, 2))
+		},
+	})
+	register(handler{
+		Name: "send-reaction",
+		Args: []argSpec{
+			{Name: "chat-jid", Kind: argJID},
+			{Name: "message-id", Kind: argString},
+			{Name: "emoji", Kind: argString},
+			{Name: "sender", Kind: argJID, Optional: true},
+		},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SendReaction(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), stringArg(inv.Args, 2), stringArg(inv.Args, 3))
+		},
+	})
+	register(handler{
+		Name: "send-group-message",
+		Args: []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "message", Kind: argString}, {Name: "options", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			opts, err := textOptions(inv, 2)
+			if err != nil {
+				return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.SendGroupMessage(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), opts)
+		},
+	})
+	register(handler{
+		Name:     "get-chat-history",
+		ReadOnly: true,
+		Args: []argSpec{
+			{Name: "jid", Kind: argJID},
+			{Name: "limit", Kind: argInt, Optional: true},
+			{Name: "options", Kind: argMap, Optional: true},
+		},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var q whatsapp.HistoryQuery
+			if len(inv.Args) > 2 {
+				if err := decodeMapArg(inv.Args[2].(map[string]interface{}), &q); err != nil {
+					return nil, fmt.Errorf("args[2]: invalid options: %w", err)
+				}
+			}
+			q.Limit = intArg(inv.Args, 1, 0)
+			return inv.Client.GetChatHistory(inv.Ctx, stringArg(inv.Args, 0), q)
+		},
+	})
+	register(handler{
+		Name:     "get-archived-chats",
+		ReadOnly: true,
+		Args: []argSpec{
+			{Name: "limit", Kind: argInt, Optional: true},
+			{Name: "options", Kind: argMap, Optional: true},
+		},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var q whatsapp.ArchivedChatsQuery
+			if len(inv.Args) > 1 {
+				if err := decodeMapArg(inv.Args[1].(map[string]interface{}), &q); err != nil {
+					return nil, fmt.Errorf("args[1]: invalid options: %w", err)
+				}
+			}
+			q.Limit = intArg(inv.Args, 0, 0)
+			return inv.Client.GetArchivedChats(inv.Ctx, q)
+		},
+	})
+	register(handler{
+		Name:     "get-archived-messages",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "query", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var q whatsapp.ArchiveQuery
+			if len(inv.Args) > 0 {
+				if err := decodeMapArg(inv.Args[0].(map[string]interface{}), &q); err != nil {
+					return nil, fmt.Errorf("args[0]: invalid query: %w", err)
+				}
+			}
+			return inv.Client.GetArchivedMessages(inv.Ctx, q)
+		},
+	})
+	register(handler{
+		Name:     "get-unread-messages",
+		ReadOnly: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetUnreadMessages()
+		},
+	})
+	register(handler{
+		Name: "mark-message-as-read",
+		Args: []argSpec{{Name: "message-id", Kind: argString}, {Name: "chat-jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.MarkMessageAsRead(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:     "get-message-receipts",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "message-id", Kind: argString}, {Name: "chat-jid", Kind: argJID, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetMessageReceipts(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name: "send-buttons",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "buttons", Kind: argMap}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var buttons whatsapp.InteractiveButtons
+			if err := decodeMapArg(inv.Args[1].(map[string]interface{}), &buttons); err != nil {
+				return nil, fmt.Errorf("args[1]: invalid buttons: %w", err)
+			}
+			return inv.Client.SendButtons(inv.Ctx, stringArg(inv.Args, 0), buttons)
+		},
+	})
+	register(handler{
+		Name: "send-list",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "list", Kind: argMap}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var list whatsapp.InteractiveList
+			if err := decodeMapArg(inv.Args[1].(map[string]interface{}), &list); err != nil {
+				return nil, fmt.Errorf("args[1]: invalid list: %w", err)
+			}
+			return inv.Client.SendList(inv.Ctx, stringArg(inv.Args, 0), list)
+		},
+	})
+	register(handler{
+		Name: "create-poll",
+		Args: []argSpec{
+			{Name: "chat-jid", Kind: argJID},
+			{Name: "question", Kind: argString},
+			{Name: "options", Kind: argStringList},
+			{Name: "selectable", Kind: argInt, Optional: true},
+		},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.CreatePoll(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), stringListArg(inv.Args, 2), intArg(inv.Args, 3, 0))
+		},
+	})
+	register(handler{
+		Name:     "get-poll-results",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "poll-id", Kind: argString}, {Name: "chat-jid", Kind: argJID, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetPollResults(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name: "edit-message",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "message-id", Kind: argString}, {Name: "text", Kind: argString}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.EditMessage(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), stringArg(inv.Args, 2))
+		},
+	})
+	register(handler{
+		Name: "delete-message",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "message-id", Kind: argString}, {Name: "sender", Kind: argJID, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.DeleteMessage(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), stringArg(inv.Args, 2))
+		},
+	})
+	register(handler{
+		Name: "forward-message",
+		Args: []argSpec{{Name: "message-id", Kind: argString}, {Name: "to-jid", Kind: argJID}, {Name: "options", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var opts whatsapp.ForwardOptions
+			if len(inv.Args) > 2 {
+				if err := decodeMapArg(inv.Args[2].(map[string]interface{}), &opts); err != nil {
+					err = fmt.Errorf("args[2]: invalid options: %w", err)
+					return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+				}
+			}
+			return inv.Client.ForwardMessage(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), opts)
+		},
+	})
+
+	register(handler{
+		Name:     "get-dead-letters",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "id", Kind: argInt, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetDeadLetters(int64(intArg(inv.Args, 0, 0)))
+		},
+	})
+	register(handler{
+		Name:  "retry-dead-letter",
+		Args:  []argSpec{{Name: "id", Kind: argInt}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.RetryDeadLetter(inv.Ctx, int64(intArg(inv.Args, 0, 0)))
+		},
+	})
+	register(handler{
+		Name:  "discard-dead-letter",
+		Args:  []argSpec{{Name: "id", Kind: argInt}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.DiscardDeadLetter(int64(intArg(inv.Args, 0, 0)))
+		},
+	})
+
+	// Contacts, status and presence
+	register(handler{
+		Name:     "get-contacts",
+		ReadOnly: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetContacts(inv.Ctx)
+		},
+	})
+	register(handler{
+		Name:     "find-contact",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "query", Kind: argString}, {Name: "limit", Kind: argInt, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.FindContact(inv.Ctx, stringArg(inv.Args, 0), intArg(inv.Args, 1, 0))
+		},
+	})
+	register(handler{
+		Name:     "check-numbers",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "phones", Kind: argPhoneList}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.CheckNumbers(inv.Ctx, stringListArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:     "get-contact-info",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetContactInfo(stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:     "get-profile-picture",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetProfilePicture(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name: "download-profile-picture",
+		Args: []argSpec{{Name: "jid", Kind: argJID}, {Name: "path", Kind: argString}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.DownloadProfilePicture(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:  "download-profile-picture-data",
+		Args:  []argSpec{{Name: "jid", Kind: argJID}},
+		Async: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.DownloadProfilePicture(inv.Ctx, stringArg(inv.Args, 0), "")
+		},
+	})
+	register(handler{
+		Name:  "set-profile-picture",
+		Args:  []argSpec{{Name: "path", Kind: argPath, Optional: true}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SetProfilePicture(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name: "set-status",
+		Args: []argSpec{{Name: "text", Kind: argString}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SetStatus(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:     "get-status",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetStatus(stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name: "set-presence",
+		Args: []argSpec{{Name: "online", Kind: argBool}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SetPresence(inv.Ctx, inv.Args[0].(bool))
+		},
+	})
+	register(handler{
+		Name: "subscribe-presence",
+		Args: []argSpec{{Name: "jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SubscribePresence(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:     "get-presence",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetPresence(stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name: "send-chat-presence",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "state", Kind: argString}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SendChatPresence(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+
+	// Identity verification
+	register(handler{
+		Name:     "get-security-code",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetSecurityCode(stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:  "verify-identity",
+		Args:  []argSpec{{Name: "jid", Kind: argJID}, {Name: "expected-code", Kind: argString}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.VerifyIdentity(stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+
+	// App state
+	register(handler{
+		Name:  "send-app-state-patch",
+		Args:  []argSpec{{Name: "patch", Kind: argMap}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			var patch whatsapp.AppStatePatch
+			if err := decodeMapArg(inv.Args[0].(map[string]interface{}), &patch); err != nil {
+				err = fmt.Errorf("args[0]: invalid patch: %w", err)
+				return whatsapp.AppStatePatchResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.SendAppStatePatch(inv.Ctx, patch)
+		},
+	})
+	register(handler{
+		Name: "get-app-state",
+		Args: []argSpec{{Name: "name", Kind: argString, Optional: true}, {Name: "resync", Kind: argBool, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			resync := len(inv.Args) > 1 && inv.Args[1].(bool)
+			return inv.Client.GetAppState(inv.Ctx, stringArg(inv.Args, 0), resync)
+		},
+	})
+
+	// Chat settings
+	register(handler{
+		Name: "mute-chat",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "hours", Kind: argInt, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			hours := intArg(inv.Args, 1, 0)
+			return inv.Client.MuteChat(inv.Ctx, stringArg(inv.Args, 0), time.Duration(hours)*time.Hour)
+		},
+	})
+	register(handler{
+		Name: "unmute-chat",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.UnmuteChat(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name: "archive-chat",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "archive", Kind: argBool, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			archive := len(inv.Args) < 2 || inv.Args[1].(bool)
+			return inv.Client.ArchiveChat(inv.Ctx, stringArg(inv.Args, 0), archive)
+		},
+	})
+	register(handler{
+		Name: "pin-chat",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "pin", Kind: argBool, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			pin := len(inv.Args) < 2 || inv.Args[1].(bool)
+			return inv.Client.PinChat(inv.Ctx, stringArg(inv.Args, 0), pin)
+		},
+	})
+	register(handler{
+		Name: "mark-chat-unread",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "unread", Kind: argBool, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			unread := len(inv.Args) < 2 || inv.Args[1].(bool)
+			return inv.Client.MarkChatUnread(inv.Ctx, stringArg(inv.Args, 0), unread)
+		},
+	})
+	register(handler{
+		Name: "set-disappearing-timer",
+		Args: []argSpec{{Name: "chat-jid", Kind: argJID}, {Name: "timer", Kind: argString}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SetDisappearingTimer(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+
+	// Groups
+	register(handler{
+		Name:     "get-groups",
+		ReadOnly: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetGroups(inv.Ctx)
+		},
+	})
+	register(handler{
+		Name:     "get-group-details",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "group-jids", Kind: argStringList, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			result, err := inv.Client.GetGroupDetails(inv.Ctx, stringListArg(inv.Args, 0))
+			if details, ok := result.(whatsapp.GroupDetailsResult); ok && err == nil && len(details.Failed) > 0 {
+				inv.Warn(fmt.Sprintf("%d group(s) could not be fetched", len(details.Failed)))
+			}
+			return result, err
+		},
+	})
+	register(handler{
+		Name:     "get-group-info",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "group-jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetGroupInfo(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:     "get-group-info-from-link",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "link", Kind: argString}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetGroupInfoFromLink(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:  "create-group",
+		Args:  []argSpec{{Name: "group-info", Kind: argMap}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			var info whatsapp.GroupCreateInfo
+			if err := decodeMapArg(inv.Args[0].(map[string]interface{}), &info); err != nil {
+				err = fmt.Errorf("args[0]: invalid group info: %w", err)
+				return whatsapp.GroupCreateResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.CreateGroup(inv.Ctx, &info)
+		},
+	})
+	register(handler{
+		Name:  "leave-group",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.LeaveGroup(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name: "get-group-invite-link",
+		Args: []argSpec{{Name: "group-jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetGroupInviteLink(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:  "revoke-group-invite-link",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.RevokeGroupInviteLink(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:  "join-group-with-link",
+		Args:  []argSpec{{Name: "link", Kind: argString}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.JoinGroupWithLink(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:  "set-group-name",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "name", Kind: argString}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SetGroupName(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:  "set-group-topic",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "topic", Kind: argString}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SetGroupTopic(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:  "set-group-photo",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "path", Kind: argPath, Optional: true}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SetGroupPhoto(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:  "set-group-announce",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "announce", Kind: argBool}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SetGroupAnnounce(inv.Ctx, stringArg(inv.Args, 0), inv.Args[1].(bool))
+		},
+	})
+	register(handler{
+		Name:  "set-group-locked",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "locked", Kind: argBool}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SetGroupLocked(inv.Ctx, stringArg(inv.Args, 0), inv.Args[1].(bool))
+		},
+	})
+	register(handler{
+		Name:  "add-group-participants",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "participants", Kind: argStringList}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.AddGroupParticipants(inv.Ctx, stringArg(inv.Args, 0), stringListArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:  "remove-group-participants",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "participants", Kind: argStringList}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.RemoveGroupParticipants(inv.Ctx, stringArg(inv.Args, 0), stringListArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:  "promote-group-participants",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "participants", Kind: argStringList}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.PromoteGroupParticipants(inv.Ctx, stringArg(inv.Args, 0), stringListArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:  "demote-group-participants",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "participants", Kind: argStringList}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.DemoteGroupParticipants(inv.Ctx, stringArg(inv.Args, 0), stringListArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:     "get-group-join-requests",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "group-jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetGroupJoinRequests(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:  "approve-group-join-requests",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "participants", Kind: argStringList}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.ApproveGroupJoinRequests(inv.Ctx, stringArg(inv.Args, 0), stringListArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:  "reject-group-join-requests",
+		Args:  []argSpec{{Name: "group-jid", Kind: argJID}, {Name: "participants", Kind: argStringList}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.RejectGroupJoinRequests(inv.Ctx, stringArg(inv.Args, 0), stringListArg(inv.Args, 1))
+		},
+	})
+
+	// Communities
+	register(handler{
+		Name:     "get-communities",
+		ReadOnly: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			result, err := inv.Client.GetCommunities(inv.Ctx)
+			if communities, ok := result.(whatsapp.CommunitiesResult); ok && err == nil && len(communities.Failed) > 0 {
+				inv.Warn(fmt.Sprintf("the subgroups of %d community(ies) could not be fetched", len(communities.Failed)))
+			}
+			return result, err
+		},
+	})
+	register(handler{
+		Name:     "get-community-subgroups",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "community-jid", Kind: argJID}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetCommunitySubgroups(inv.Ctx, stringArg(inv.Args, 0))
+		},
+	})
+	register(handler{
+		Name:  "create-community",
+		Args:  []argSpec{{Name: "community-info", Kind: argMap}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			var info whatsapp.CommunityCreateInfo
+			if err := decodeMapArg(inv.Args[0].(map[string]interface{}), &info); err != nil {
+				err = fmt.Errorf("args[0]: invalid community info: %w", err)
+				return whatsapp.CommunityResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.CreateCommunity(inv.Ctx, &info)
+		},
+	})
+	register(handler{
+		Name:  "link-group-to-community",
+		Args:  []argSpec{{Name: "community-jid", Kind: argJID}, {Name: "group-jid", Kind: argJID}},
+		Audit: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.LinkGroupToCommunity(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+
+	// Media
+	register(handler{
+		Name: "upload",
+		Args: []argSpec{{Name: "path", Kind: argPath}, {Name: "mime-type", Kind: argString}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.Upload(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name: "upload-data",
+		Args: []argSpec{{Name: "data", Kind: argBytes}, {Name: "mime-type", Kind: argString}, {Name: "file-name", Kind: argString, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.UploadData(inv.Ctx, bytesArg(inv.Args, 0), stringArg(inv.Args, 1), stringArg(inv.Args, 2))
+		},
+	})
+	register(handler{
+		Name: "send-uploaded-image",
+		Args: []argSpec{{Name: "recipient", Kind: argJID}, {Name: "media", Kind: argMap}, {Name: "caption", Kind: argString, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			media, err := uploadedMedia(inv)
+			if err != nil {
+				return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.SendUploadedImage(inv.Ctx, stringArg(inv.Args, 0), media, stringArg(inv.Args, 2))
+		},
+	})
+	register(handler{
+		Name: "send-uploaded-video",
+		Args: []argSpec{{Name: "recipient", Kind: argJID}, {Name: "media", Kind: argMap}, {Name: "caption", Kind: argString, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			media, err := uploadedMedia(inv)
+			if err != nil {
+				return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.SendUploadedVideo(inv.Ctx, stringArg(inv.Args, 0), media, stringArg(inv.Args, 2))
+		},
+	})
+	register(handler{
+		Name: "send-uploaded-document",
+		Args: []argSpec{{Name: "recipient", Kind: argJID}, {Name: "media", Kind: argMap}, {Name: "caption", Kind: argString, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			media, err := uploadedMedia(inv)
+			if err != nil {
+				return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.SendUploadedDocument(inv.Ctx, stringArg(inv.Args, 0), media, stringArg(inv.Args, 2))
+		},
+	})
+	register(handler{
+		Name: "send-image",
+		Args: []argSpec{{Name: "recipient", Kind: argJID}, {Name: "path", Kind: argPath}, {Name: "caption", Kind: argString}, {Name: "options", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			opts, err := mediaSendOptions(inv)
+			if err != nil {
+				return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.SendImage(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), stringArg(inv.Args, 2), opts)
+		},
+	})
+	register(handler{
+		Name: "send-document",
+		Args: []argSpec{{Name: "recipient", Kind: argJID}, {Name: "path", Kind: argPath}, {Name: "caption", Kind: argString, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SendDocument(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), stringArg(inv.Args, 2))
+		},
+	})
+	register(handler{
+		Name: "send-video",
+		Args: []argSpec{{Name: "recipient", Kind: argJID}, {Name: "path", Kind: argPath}, {Name: "caption", Kind: argString, Optional: true}, {Name: "options", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			opts, err := mediaSendOptions(inv)
+			if err != nil {
+				return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+			}
+			return inv.Client.SendVideo(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), stringArg(inv.Args, 2), opts)
+		},
+	})
+	register(handler{
+		Name: "send-audio",
+		Args: []argSpec{{Name: "recipient", Kind: argJID}, {Name: "path", Kind: argPath}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.SendAudio(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name: "send-voice-note",
+		Args: []argSpec{{Name: "recipient", Kind: argJID}, {Name: "path", Kind: argPath}, {Name: "options", Kind: argMap, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var opts whatsapp.VoiceNoteOptions
+			if len(inv.Args) > 2 {
+				if err := decodeMapArg(inv.Args[2].(map[string]interface{}), &opts); err != nil {
+					err = fmt.Errorf("args[2]: invalid options: %w", err)
+					return whatsapp.SendResult{Success: false, Message: err.Error()}, err
+				}
+			}
+			return inv.Client.SendVoiceNote(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), opts)
+		},
+	})
+	register(handler{
+		Name: "download-media",
+		Args: []argSpec{
+			{Name: "message-id", Kind: argString},
+			{Name: "path", Kind: argString},
+			{Name: "chat-jid", Kind: argJID, Optional: true},
+		},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.DownloadMedia(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 2), stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name:  "download-media-data",
+		Args:  []argSpec{{Name: "message-id", Kind: argString}, {Name: "chat-jid", Kind: argJID, Optional: true}},
+		Async: true,
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.DownloadMedia(inv.Ctx, stringArg(inv.Args, 0), stringArg(inv.Args, 1), "")
+		},
+	})
+	register(handler{
+		Name: "download-media-keys",
+		Args: []argSpec{{Name: "keys", Kind: argMap}, {Name: "path", Kind: argString}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			var keys whatsapp.MediaKeys
+			if err := decodeMapArg(inv.Args[0].(map[string]interface{}), &keys); err != nil {
+				return nil, fmt.Errorf("args[0]: invalid media keys: %w", err)
+			}
+			return inv.Client.DownloadMediaKeys(inv.Ctx, keys, stringArg(inv.Args, 1))
+		},
+	})
+	register(handler{
+		Name: "purge-downloads",
+		Args: []argSpec{{Name: "older-than-hours", Kind: argInt, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.PurgeDownloads(time.Duration(intArg(inv.Args, 0, 0)) * time.Hour)
+		},
+	})
+	register(handler{
+		Name:     "get-transfer-status",
+		ReadOnly: true,
+		Args:     []argSpec{{Name: "message-id", Kind: argString, Optional: true}},
+		Fn: func(inv *invocation) (interface{}, error) {
+			return inv.Client.GetTransferStatus(stringArg(inv.Args, 0))
+		},
+	})
+}
+
+// decodeMapArg decodes a map argument into the struct v, rejecting keys it
+// does not know so a typo is not silently dropped
+func decodeMapArg(m map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// textOptions decodes the optional options of send-message and
+// send-group-message, at args[i]
+func textOptions(inv *invocation, i int) (whatsapp.TextOptions, error) {
+	var opts whatsapp.TextOptions
+	if len(inv.Args) > i {
+		if err := decodeMapArg(inv.Args[i].(map[string]interface{}), &opts); err != nil {
+			return opts, fmt.Errorf("args[%d]: invalid options: %w", i, err)
+		}
+	}
+	return opts, nil
+}
+
+// statusMediaOptions decodes the optional options of post-status-image and
+// post-status-video
+func statusMediaOptions(inv *invocation) (whatsapp.StatusMediaOptions, error) {
+	var opts whatsapp.StatusMediaOptions
+	if len(inv.Args) > 1 {
+		if err := decodeMapArg(inv.Args[1].(map[string]interface{}), &opts); err != nil {
+			return opts, fmt.Errorf("args[1]: invalid options: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// mediaSendOptions decodes the optional options of send-image and
+// send-video, after the caption
+func mediaSendOptions(inv *invocation) (whatsapp.MediaSendOptions, error) {
+	var opts whatsapp.MediaSendOptions
+	if len(inv.Args) > 3 {
+		if err := decodeMapArg(inv.Args[3].(map[string]interface{}), &opts); err != nil {
+			return opts, fmt.Errorf("args[3]: invalid options: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// uploadedMedia decodes the media map of the send-uploaded vars, the
+// :media of an upload result
+func uploadedMedia(inv *invocation) (whatsapp.MediaInfo, error) {
+	var media whatsapp.MediaInfo
+	if err := decodeMapArg(inv.Args[1].(map[string]interface{}), &media); err != nil {
+		return media, fmt.Errorf("args[1]: invalid media: %w", err)
+	}
+	return media, nil
+}
+
+// recordAction adds an audited var to the audit log of the client, when one
+// is running, with its secret arguments redacted
+func recordAction(h *handler, inv *invocation, err error) {
+	client := inv.Client
+	if client == nil {
+		client, _ = clientState()
+	}
+	if client == nil {
+		return
+	}
+	args := make([]interface{}, len(inv.Args))
+	for i, arg := range inv.Args {
+		if h.Args[i].Secret {
+			arg = "[redacted]"
+		} else if s, ok := arg.(string); ok && h.Args[i].URL {
+			arg = whatsapp.RedactURL(s)
+		} else if m, ok := arg.(map[string]interface{}); ok {
+			arg = redactOptions(m)
+		}
+		args[i] = arg
+	}
+	client.RecordAction(h.Name, args, err)
+}
+
+// secretOptions are the option map keys whose values are never recorded
+var secretOptions = map[string]bool{"webhook-secret": true, "secret": true, "hash-key": true, "hash-salt": true}
+
+// urlOptions are the option map keys whose URLs are recorded without their
+// passwords
+var urlOptions = map[string]bool{"proxy": true, "nats-url": true, "webhook-url": true}
+
+// redactOptions returns m with its secret options redacted, copying it only
+// when it holds one
+func redactOptions(m map[string]interface{}) map[string]interface{} {
+	var redacted map[string]interface{}
+	for key, v := range m {
+		if !secretOptions[key] && !urlOptions[key] {
+			continue
+		}
+		if redacted == nil {
+			redacted = make(map[string]interface{}, len(m))
+			for k, v := range m {
+				redacted[k] = v
+			}
+		}
+		if s, ok := v.(string); ok && urlOptions[key] {
+			redacted[key] = whatsapp.RedactURL(s)
+		} else {
+			redacted[key] = "[redacted]"
+		}
+	}
+	if redacted == nil {
+		return m
+	}
+	return redacted
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+var startTime = time.Now()
+
+// lastError remembers the most recent invoke failure for the health var
+var lastError struct {
+	sync.Mutex
+	message string
+	varName string
+	at      time.Time
+}
+
+// HealthInfo is a cheap snapshot of the pod process itself. It never touches
+// the WhatsApp client or its database, so it answers even while those block.
+type HealthInfo struct {
+	Status            string         `json:"status"`
+	UptimeSeconds     int64          `json:"uptime_seconds"`
+	Goroutines        int            `json:"goroutines"`
+	ClientInitialized bool           `json:"client_initialized"`
+	InitError         string         `json:"init_error,omitempty"`
+	Queues            map[string]int `json:"queues"`
+	InFlight          []InvokeInfo   `json:"in_flight"`
+	LastError         string         `json:"last_error,omitempty"`
+	LastErrorVar      string         `json:"last_error_var,omitempty"`
+	LastErrorAt       int64          `json:"last_error_at,omitempty"`
+}
+
+// recordError stores an invoke failure so health can report it later
+func recordError(varName string, errMsg string) {
+	lastError.Lock()
+	defer lastError.Unlock()
+	lastError.message = errMsg
+	lastError.varName = varName
+	lastError.at = time.Now()
+}
+
+// getHealth builds the health snapshot
+func getHealth() HealthInfo {
+	client, clientErr := clientState()
+	health := HealthInfo{
+		Status:            "ok",
+		UptimeSeconds:     int64(time.Since(startTime).Seconds()),
+		Goroutines:        runtime.NumGoroutine(),
+		ClientInitialized: client != nil,
+		Queues:            map[string]int{},
+		InFlight:          inFlightInvokes(),
+	}
+	if clientErr != nil {
+		health.Status = "degraded"
+		health.InitError = clientErr.Error()
+	}
+	if client != nil {
+		health.Queues = client.QueueDepths()
+	}
+
+	lastError.Lock()
+	defer lastError.Unlock()
+	if lastError.message != "" {
+		health.LastError = lastError.message
+		health.LastErrorVar = lastError.varName
+		health.LastErrorAt = lastError.at.Unix()
+	}
+	return health
+}
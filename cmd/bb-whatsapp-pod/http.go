@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+var httpLog = whatsapp.NewLogger("HTTP") // logs of the HTTP API
+
+// maxHTTPBody bounds request bodies; media goes by path, not inline
+const maxHTTPBody = 1 << 20
+
+// apiToken is the bearer token every HTTP request must carry. The API can
+// send messages, read local files and log the session out, so it is never
+// served without one.
+var apiToken string
+
+// startHTTPServer serves every registered var as a JSON REST endpoint, plus
+// the bridge routes and the event streams, next to the pod protocol on
+// stdin/stdout (or instead of it, with --serve). Both share the same
+// WhatsAppClient and session. An address without a host, such as :8080,
+// listens on 127.0.0.1 only.
+func startHTTPServer(addr string) error {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", handleHTTPEvents)
+	mux.HandleFunc("GET /ws", handleWebSocketEvents)
+	handleBridgeRoutes(mux)
+	mux.HandleFunc("/{name}", handleHTTPInvoke)
+	server := &http.Server{Handler: requireToken(mux), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		defer whatsapp.LogPanic(httpLog, "the REST API server")
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			httpLog.Errorf("Server on %s stopped: %v", addr, err)
+		}
+	}()
+	httpLog.Infof("Serving REST API on %s", listener.Addr())
+	return nil
+}
+
+// requireToken rejects requests that do not carry apiToken as
+// "Authorization: Bearer <token>"
+func requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(apiToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bb-whatsapp-pod"`)
+			writeHTTPError(w, http.StatusUnauthorized, "missing or invalid API token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestToken is the bearer token of r. Browsers cannot set headers on an
+// EventSource or a WebSocket, so /events and /ws also take it as an
+// access_token query parameter.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if r.URL.Path == "/events" || r.URL.Path == "/ws" {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// handleHTTPInvoke runs /<var>. GET calls a read-only var without
+// arguments; POST takes either a JSON array of positional args or an object
+// keyed by arg name, e.g. {"phone": "1234567890", "message": "hi"} for
+// /send-message. A timeout-ms query parameter bounds the call.
+func handleHTTPInvoke(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	h, ok := handlers[name]
+	if !ok {
+		writeHTTPError(w, http.StatusNotFound, fmt.Sprintf("Unknown function: %s", name))
+		return
+	}
+	// A var with side effects only answers POST, which a cross-site link or
+	// image cannot send
+	if r.Method == http.MethodGet && !h.ReadOnly {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s has side effects: use POST", name))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeHTTPError(w, http.StatusMethodNotAllowed, "use GET or POST")
+		return
+	}
+
+	var args []interface{}
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxHTTPBody))
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		args, err = httpArgs(h, body)
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	ctx, cancel, err := httpContext(r)
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer cancel()
+	result, meta, err := invokeExternal(ctx, "http", name, args)
+	writeHTTPResult(w, result, meta, err)
+}
+
+// httpContext is the request context, bounded by the timeout-ms query
+// parameter when it is set
+func httpContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	q := r.URL.Query().Get("timeout-ms")
+	if q == "" {
+		return r.Context(), func() {}, nil
+	}
+	ms, err := strconv.Atoi(q)
+	if err != nil || ms <= 0 {
+		return nil, nil, fmt.Errorf("timeout-ms must be a positive integer")
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(ms)*time.Millisecond)
+	return ctx, cancel, nil
+}
+
+// writeHTTPResult writes what invokeExternal returned: the result JSON with
+// its metadata, a binary result as it is, or the error
+func writeHTTPResult(w http.ResponseWriter, result interface{}, meta *InvokeMetadata, err error) {
+	if err != nil {
+		writeHTTPError(w, httpStatus(err), err.Error())
+		return
+	}
+	if bin, ok := result.(*whatsapp.BinaryResult); ok {
+		w.Header().Set("Content-Type", bin.Mimetype)
+		if bin.FileName != "" {
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bin.FileName))
+		}
+		w.Write(bin.Data)
+		return
+	}
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, fmt.Sprintf("error marshaling result to JSON: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(withMetadata(resultBytes, meta))
+}
+
+// httpArgs turns a request body into positional args
+func httpArgs(h *handler, body []byte) ([]interface{}, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, nil
+	}
+	if body[0] == '[' {
+		var args []interface{}
+		if err := json.Unmarshal(body, &args); err != nil {
+			return nil, fmt.Errorf("invalid JSON args: %v", err)
+		}
+		return args, nil
+	}
+
+	var named map[string]interface{}
+	if err := json.Unmarshal(body, &named); err != nil {
+		return nil, fmt.Errorf("body must be a JSON array or object: %v", err)
+	}
+	var args []interface{}
+	for _, spec := range h.Args {
+		v, ok := named[spec.Name]
+		if !ok {
+			if spec.Optional {
+				break
+			}
+			return nil, fmt.Errorf("%s: missing %q", h.Name, spec.Name)
+		}
+		args = append(args, v)
+		delete(named, spec.Name)
+	}
+	for key := range named {
+		return nil, fmt.Errorf("%s: unknown or out-of-order argument %q", h.Name, key)
+	}
+	return args, nil
+}
+
+// handleHTTPEvents streams client events as server-sent events. The
+// optional types and chats query parameters filter them, e.g.
+// /events?types=message,receipt&chats=123@s.whatsapp.net, and account
+// picks an account other than the default one.
+func handleHTTPEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHTTPError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	client, err := accountClient(r.URL.Query().Get("account"))
+	if errors.Is(err, errUnknownAccount) {
+		writeHTTPError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeHTTPError(w, http.StatusServiceUnavailable, fmt.Sprintf("Failed to initialize WhatsApp client: %v", err))
+		return
+	}
+	filter := eventSubscription{
+		Types: splitList(r.URL.Query().Get("types")),
+		Chats: splitList(r.URL.Query().Get("chats")),
+	}
+
+	events, unsubscribe := client.Subscribe(256)
+	defer unsubscribe()
+	httpLog.Infof("Event stream opened by %s", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			httpLog.Infof("Event stream closed by %s", r.RemoteAddr)
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case evt, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.matches(evt) {
+				continue
+			}
+			data, err := json.Marshal(evt)
+			if err != nil {
+				httpLog.Errorf("Marshaling %s event: %v", evt.Type, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// httpStatus maps an invokeExternal error to a status code
+func httpStatus(err error) int {
+	e, ok := err.(*externalError)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	switch e.Kind {
+	case errUnknownVar:
+		return http.StatusNotFound
+	case errBadArgs:
+		return http.StatusBadRequest
+	case errUnavailable:
+		return http.StatusServiceUnavailable
+	case errCancelled:
+		return 499 // client closed request
+	case errTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// writeHTTPError writes the same shape the vars use for failures
+func writeHTTPError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": message})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/babashka"
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard) // invokes log every step
+	os.Exit(m.Run())
+}
+
+// fakeClient makes a client around a connected Fake the client of the
+// default account, for the length of the test
+func fakeClient(t *testing.T) *whatsapp.Fake {
+	t.Helper()
+	fake := whatsapp.NewFake(types.NewJID("15550001111", types.DefaultUserServer))
+	client := whatsapp.NewClientWithMessenger(fake, whatsapp.Options{})
+	if err := fake.Connect(); err != nil {
+		t.Fatalf("connecting the fake: %v", err)
+	}
+	clientMutex.Lock()
+	prevClient, prevErr := waClient, initErr
+	waClient, initErr = client, nil
+	clientMutex.Unlock()
+	t.Cleanup(func() {
+		clientMutex.Lock()
+		waClient, initErr = prevClient, prevErr
+		clientMutex.Unlock()
+	})
+	return fake
+}
+
+// invoke runs a var through handleInvoke as the pod protocol would, and
+// returns the warnings it sent
+func invoke(name, args, binary string) (interface{}, []string, error) {
+	msg := babashka.Message{Op: "invoke", Id: "test-" + newRequestID(), Var: "pod.whatsapp/" + name, Args: args, Binary: binary}
+	var warnings []string
+	result, _, err := handleInvoke(msg, newInvokeLogger(&msg), func(w []string) { warnings = append(warnings, w...) })
+	return result, warnings, err
+}
+
+func TestHandleInvoke(t *testing.T) {
+	tests := []struct {
+		name         string
+		fn           string
+		args         string
+		binary       string
+		setup        func(f *whatsapp.Fake)
+		wantErr      string // a substring of the error, "" for success
+		wantWarnings []string
+		wantSent     string // the text of the one message sent, "" for none
+		wantMentions int
+	}{
+		{
+			name:    "unknown var",
+			fn:      "no-such-var",
+			args:    "[]",
+			wantErr: "Unknown function: no-such-var",
+		},
+		{
+			name:    "missing arg",
+			fn:      "send-message",
+			args:    `["15550002222"]`,
+			wantErr: "send-message",
+		},
+		{
+			name:    "wrong arg type",
+			fn:      "send-message",
+			args:    `["15550002222", 42]`,
+			wantErr: "args[1] (type)",
+		},
+		{
+			name:    "too many args",
+			fn:      "send-message",
+			args:    `["15550002222", "hi", {}, "extra", "more"]`,
+			wantErr: "send-message",
+		},
+		{
+			name:    "unknown option",
+			fn:      "send-message",
+			args:    `["15550002222", "hi", {"bogus": true}]`,
+			wantErr: "bogus",
+		},
+		{
+			name:    "invalid binary mode",
+			fn:      "send-message",
+			args:    `["15550002222", "hi"]`,
+			binary:  "hex",
+			wantErr: "binary must be raw or base64",
+		},
+		{
+			name:     "send",
+			fn:       "send-message",
+			args:     `["15550002222", "hi"]`,
+			wantSent: "hi",
+		},
+		{
+			name:         "send with options",
+			fn:           "send-message",
+			args:         `["15550002222", "hi @15550003333", {"mentions": ["15550003333"]}]`,
+			wantSent:     "hi @15550003333",
+			wantMentions: 1,
+		},
+		{
+			name:    "send error",
+			fn:      "send-message",
+			args:    `["15550002222", "hi"]`,
+			setup:   func(f *whatsapp.Fake) { f.SendErr = errors.New("server said no") },
+			wantErr: "server said no",
+		},
+		{
+			name:         "deprecated link preview flag",
+			fn:           "send-message",
+			args:         `["15550002222", "hi", false]`,
+			wantWarnings: []string{"send-message with 3 args is deprecated"},
+			wantSent:     "hi",
+		},
+		{
+			name:         "deprecated mentions",
+			fn:           "send-message",
+			args:         `["15550002222", "hi @15550003333", false, ["15550003333"]]`,
+			wantWarnings: []string{"send-message with 4 args is deprecated"},
+			wantSent:     "hi @15550003333",
+			wantMentions: 1,
+		},
+		{
+			name:         "deprecated group mentions",
+			fn:           "send-group-message",
+			args:         `["123456789-987654@g.us", "hi @15550003333", ["15550003333"]]`,
+			wantWarnings: []string{"send-group-message with 3 args is deprecated"},
+			wantSent:     "hi @15550003333",
+			wantMentions: 1,
+		},
+		{
+			name:     "broadcast to an integer phone",
+			fn:       "send-broadcast",
+			args:     `[[15550002222], "hi"]`,
+			wantSent: "hi",
+		},
+		{
+			name:    "wrong phone list item",
+			fn:      "check-numbers",
+			args:    `[["15550002222", true]]`,
+			wantErr: "args[0][1] (items/type)",
+		},
+		{
+			name:    "unknown account",
+			fn:      "send-message",
+			args:    `["15550002222", "hi", {"pod.whatsapp/invoke": {"account": "nope"}}]`,
+			wantErr: `unknown account "nope"`,
+		},
+		{
+			name:    "invalid invoke option",
+			fn:      "send-message",
+			args:    `["15550002222", "hi", {"pod.whatsapp/invoke": {"timeout-ms": -1}}]`,
+			wantErr: "timeout-ms must be a positive integer",
+		},
+		{
+			name:    "unknown invoke option",
+			fn:      "send-message",
+			args:    `["15550002222", "hi", {"pod.whatsapp/invoke": {"retries": 3}}]`,
+			wantErr: `unknown invoke option "retries"`,
+		},
+		{
+			name:     "invoke options after the optional args",
+			fn:       "send-message",
+			args:     `["15550002222", "hi", {"link-preview": false}, {"pod.whatsapp/invoke": {"timeout-ms": 5000}}]`,
+			wantSent: "hi",
+		},
+		{
+			name:     "invoke options without the optional args",
+			fn:       "send-message",
+			args:     `["15550002222", "hi", {"pod.whatsapp/invoke": {"timeout-ms": 5000}}]`,
+			wantSent: "hi",
+		},
+		{
+			name:         "bare invoke options",
+			fn:           "send-message",
+			args:         `["15550002222", "hi", {}, {"timeout-ms": 5000}]`,
+			wantWarnings: []string{"bare invoke options map"},
+			wantSent:     "hi",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := fakeClient(t)
+			if tt.setup != nil {
+				tt.setup(fake)
+			}
+			result, warnings, err := invoke(tt.fn, tt.args, tt.binary)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if sent, ok := result.(whatsapp.SendResult); ok && !sent.Success {
+				t.Errorf("result = %+v, want success", sent)
+			}
+
+			if len(warnings) != len(tt.wantWarnings) {
+				t.Fatalf("warnings = %q, want %d", warnings, len(tt.wantWarnings))
+			}
+			for i, want := range tt.wantWarnings {
+				if !strings.Contains(warnings[i], want) {
+					t.Errorf("warning %d = %q, want one containing %q", i, warnings[i], want)
+				}
+			}
+
+			sent := fake.Sent()
+			if tt.wantSent == "" {
+				if len(sent) != 0 {
+					t.Errorf("sent %d messages, want none", len(sent))
+				}
+				return
+			}
+			if len(sent) != 1 {
+				t.Fatalf("sent %d messages, want 1", len(sent))
+			}
+			msg := sent[0].Message
+			text := msg.GetConversation()
+			if ext := msg.GetExtendedTextMessage(); ext != nil {
+				text = ext.GetText()
+			}
+			if text != tt.wantSent {
+				t.Errorf("sent text %q, want %q", text, tt.wantSent)
+			}
+			if got := len(msg.GetExtendedTextMessage().GetContextInfo().GetMentionedJID()); got != tt.wantMentions {
+				t.Errorf("sent %d mentions, want %d", got, tt.wantMentions)
+			}
+		})
+	}
+}
+
+// TestHandleInvokeBinary downloads media as a binary result, which the pod
+// protocol streams in chunks instead of returning as one value
+func TestHandleInvokeBinary(t *testing.T) {
+	fake := fakeClient(t)
+	data := []byte("\x89PNG not really an image")
+	up, err := fake.Upload(context.Background(), data, whatsmeow.MediaImage)
+	if err != nil {
+		t.Fatalf("uploading: %v", err)
+	}
+	keys, err := json.Marshal(whatsapp.MediaKeys{
+		MediaType:     "image",
+		DirectPath:    up.DirectPath,
+		MediaKey:      up.MediaKey,
+		FileEncSHA256: up.FileEncSHA256,
+		FileSHA256:    up.FileSHA256,
+		FileLength:    up.FileLength,
+		MimeType:      "image/png",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, binary := range []string{"", "raw", "base64"} {
+		result, _, err := invoke("download-media-keys", `[`+string(keys)+`, ""]`, binary)
+		if err != nil {
+			t.Fatalf("binary %q: %v", binary, err)
+		}
+		bin, ok := result.(*whatsapp.BinaryResult)
+		if !ok {
+			t.Fatalf("binary %q: result is %T, want *whatsapp.BinaryResult", binary, result)
+		}
+		if string(bin.Data) != string(data) || bin.Mimetype != "image/png" {
+			t.Errorf("binary %q: got %q (%s), want %q (image/png)", binary, bin.Data, bin.Mimetype, data)
+		}
+	}
+
+	if _, _, err := invoke("download-media-keys", `[{"media-type": "image"}, ""]`, ""); err == nil {
+		t.Error("download with incomplete keys succeeded")
+	}
+}
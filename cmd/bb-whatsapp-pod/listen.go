@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/babashka"
+)
+
+// streamDone ends an async invoke with a done message and no further value.
+// Cancelling a stream is how it is closed, so it is not reported as
+// interrupted.
+type streamDone struct{}
+
+// listen pushes client events to the babashka client as async values until
+// the invoke is cancelled (cancel "listen") or the pod shuts down. Without
+// a filter only incoming and sent messages are pushed.
+func listen(inv *invocation, filter eventSubscription) (interface{}, error) {
+	if len(filter.Types) == 0 {
+		filter.Types = []string{"message"}
+	}
+	events, unsubscribe := inv.Client.Subscribe(256)
+	defer unsubscribe()
+	inv.Log.Infof("Listening for types=%v chats=%v", filter.Types, filter.Chats)
+
+	for {
+		select {
+		case <-inv.Ctx.Done():
+			inv.Log.Infof("Listener stopped.")
+			return streamDone{}, nil
+		case evt, ok := <-events:
+			if !ok {
+				return streamDone{}, nil
+			}
+			if !filter.matches(evt) {
+				continue
+			}
+			value, err := json.Marshal(evt)
+			if err != nil {
+				inv.Log.Errorf("Marshaling %s event: %v", evt.Type, err)
+				continue
+			}
+			if err := babashka.WriteChunkResponse(inv.Msg, string(value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/babashka"
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+// newRequestID returns a short random id that stays unique even if babashka
+// reuses message ids across pod restarts
+func newRequestID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(b)
+}
+
+// podLog logs what is not part of a more specific component
+var podLog = whatsapp.NewLogger("pod")
+
+// newInvokeLogger returns a logger that tags every line with the babashka
+// message id and a fresh request id, so interleaved lines in pod.log can be
+// grouped per invoke (grep for "req=<id>")
+func newInvokeLogger(msg *babashka.Message) whatsapp.Logger {
+	return podLog.WithPrefix(fmt.Sprintf("[id=%s req=%s] ", msg.Id, newRequestID()))
+}
+
+// Log formats accepted by the log-format option
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logFlags returns the standard log flags for a format. JSON lines carry
+// their own timestamp, so only the caller is kept in the text prefix.
+func logFlags(format string) int {
+	if format == logFormatJSON {
+		return log.Lshortfile
+	}
+	return log.LstdFlags | log.Lshortfile
+}
+
+// logEntry is one JSON log line
+type logEntry struct {
+	Time      string            `json:"time"`
+	Level     string            `json:"level"`
+	Component string            `json:"component"`
+	InvokeID  string            `json:"invoke_id,omitempty"`
+	Message   string            `json:"msg"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+var (
+	logCallerRe    = regexp.MustCompile(`^([\w.-]+\.go:\d+): `)
+	logInvokeRe    = regexp.MustCompile(`^\[id=(\S*) req=(\S*)\] `)
+	logComponentRe = regexp.MustCompile(`^\[([\w /-]+)\] `)
+	logMarkerRe    = regexp.MustCompile(`^(DEBUG|INFO|WARN|ERROR): `)
+)
+
+// jsonLogWriter turns each standard log line into a JSON object, picking
+// the caller, invoke tags, [Component] prefix and level marker out of the
+// text
+type jsonLogWriter struct {
+	out io.Writer
+}
+
+func (w jsonLogWriter) Write(p []byte) (int, error) {
+	line := bytes.TrimRight(p, "\n")
+	entry := logEntry{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     whatsapp.LineLevel(line).String(),
+		Component: "pod",
+		Fields:    map[string]string{},
+	}
+	if m := logCallerRe.FindSubmatch(line); m != nil {
+		entry.Fields["caller"] = string(m[1])
+		line = line[len(m[0]):]
+	}
+	if m := logInvokeRe.FindSubmatch(line); m != nil {
+		entry.InvokeID = string(m[1])
+		entry.Fields["req"] = string(m[2])
+		line = line[len(m[0]):]
+	}
+	if m := logComponentRe.FindSubmatch(line); m != nil {
+		entry.Component = string(m[1])
+		line = line[len(m[0]):]
+	}
+	if m := logMarkerRe.Find(line); m != nil {
+		line = line[len(m):] // already in Level
+	}
+	entry.Message = string(line)
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(b, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
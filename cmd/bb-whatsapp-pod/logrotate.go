@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logRotation limits how much disk the log file may use. Zero values
+// disable the corresponding limit.
+type logRotation struct {
+	MaxSizeMB  int // rotate once the file would grow past this size
+	MaxBackups int // rotated files to keep
+	MaxAgeDays int // delete rotated files older than this
+}
+
+// backupTimeFormat sorts lexically in time order
+const backupTimeFormat = "20060102-150405.000"
+
+// rotatingFile is an append-only log file that moves itself aside to
+// <path>.<timestamp> when it reaches MaxSizeMB, then prunes old backups
+type rotatingFile struct {
+	mu     sync.Mutex
+	path   string
+	limits logRotation
+	file   *os.File
+	size   int64
+}
+
+// openRotatingFile opens path for appending with the given limits
+func openRotatingFile(path string, limits logRotation) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, limits: limits}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	maxSize := int64(r.limits.MaxSizeMB) * 1024 * 1024
+	if maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > maxSize {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and starts a new one
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	backup := r.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(r.path, backup); err != nil {
+		// Reopen the original so writes keep working
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.prune()
+	return nil
+}
+
+// prune deletes backups beyond MaxBackups or older than MaxAgeDays
+func (r *rotatingFile) prune() {
+	backups, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return
+	}
+	prefix := r.path + "."
+	kept := backups[:0]
+	for _, b := range backups {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(b, prefix)); err == nil {
+			kept = append(kept, b)
+		}
+	}
+	backups = kept
+	sort.Sort(sort.Reverse(sort.StringSlice(backups))) // newest first
+
+	cutoff := time.Now().AddDate(0, 0, -r.limits.MaxAgeDays)
+	for i, b := range backups {
+		tooMany := r.limits.MaxBackups > 0 && i >= r.limits.MaxBackups
+		tooOld := false
+		if r.limits.MaxAgeDays > 0 {
+			if info, err := os.Stat(b); err == nil && info.ModTime().Before(cutoff) {
+				tooOld = true
+			}
+		}
+		if tooMany || tooOld {
+			os.Remove(b)
+		}
+	}
+}
+
+// Close closes the current file
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"runtime/debug"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kbosompem/bb-whatsapp-pod/pkg/babashka" // Import the helper package
 	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
@@ -14,137 +20,394 @@ import (
 
 var waClient *whatsapp.WhatsAppClient // Initialize lazily
 var initErr error                     // Store potential init error
+var clientInit chan struct{}          // Closed when the running initialization ends; nil when none runs
+var clientMutex sync.Mutex            // Guards waClient, initErr and clientInit
 
-// setupLogging redirects standard log output to a file
+var logFile *rotatingFile // Current log file, replaced by configure; nil for stderr/discard
+
+// setupLogging redirects standard log output to the configured destination
 func setupLogging() {
-	logFile, err := os.OpenFile("pod.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	cfg := currentConfig()
+	whatsapp.SetLogLevel(cfg.LogLevel)
+	err := openLogOutput(cfg)
 	if err != nil {
 		// If we can't open the log file, log to stderr (which babashka might ignore or handle differently)
-		log.SetOutput(os.Stderr)
-		log.Printf("Error opening log file pod.log: %v", err)
-		log.Println("Logging to stderr instead.")
+		log.SetFlags(logFlags(logFormatText))
+		log.SetOutput(whatsapp.LevelFilter(os.Stderr))
+		podLog.Errorf("Opening log file %s: %v", cfg.LogPath, err)
+		podLog.Infof("Logging to stderr instead.")
 		return
 	}
-	log.SetOutput(logFile)
-	log.SetFlags(log.LstdFlags | log.Lshortfile) // Keep existing log format
-	log.Println("--- Pod Started ---")
+	podLog.Infof("--- Pod Started ---")
+}
+
+// Special log-path values that don't name a file
+const (
+	logToStderr  = "stderr"
+	logToDiscard = "discard"
+)
+
+// openLogOutput switches standard log output to cfg.LogPath (or to stderr
+// or nowhere for the special values) in cfg.LogFormat, closing the previous
+// file
+func openLogOutput(cfg podConfig) error {
+	change, err := prepareLogOutput(cfg)
+	if err != nil {
+		return err
+	}
+	change.apply()
+	return nil
+}
+
+// prepareLogOutput opens the log file of cfg; nothing is logged there until
+// the change is applied
+func prepareLogOutput(cfg podConfig) (pendingChange, error) {
+	var out io.Writer
+	var f *rotatingFile
+	switch cfg.LogPath {
+	case logToStderr:
+		out = os.Stderr
+	case logToDiscard:
+		out = io.Discard
+	default:
+		var err error
+		f, err = openRotatingFile(cfg.LogPath, cfg.LogRotation)
+		if err != nil {
+			return pendingChange{}, err
+		}
+		out = f
+	}
+	if cfg.LogFormat == logFormatJSON {
+		out = jsonLogWriter{out: out}
+	}
+	return pendingChange{
+		apply: func() {
+			log.SetFlags(logFlags(cfg.LogFormat))
+			log.SetOutput(whatsapp.LevelFilter(out))
+			if logFile != nil {
+				logFile.Close()
+			}
+			logFile = f
+		},
+		discard: func() {
+			if f != nil {
+				f.Close()
+			}
+		},
+	}, nil
+}
+
+// startConfigured starts the listeners and forwarders the startup config
+// asks for; configure starts them later on when settings change
+func startConfigured() error {
+	cfg := currentConfig()
+	if err := setMetricsAddr(cfg.MetricsAddr); err != nil {
+		return fmt.Errorf("metrics-addr %s: %w", cfg.MetricsAddr, err)
+	}
+	if err := setNATS(cfg.NATS); err != nil {
+		return fmt.Errorf("nats-url: %w", err)
+	}
+	setWebhook(cfg.WebhookURL, string(cfg.WebhookSecret))
+	return nil
+}
+
+// opHandlers is the central table of pod protocol ops this build supports.
+// The read loop dispatches through it and describe advertises its keys.
+var opHandlers map[string]func(msg *babashka.Message)
+
+func init() {
+	opHandlers = map[string]func(msg *babashka.Message){
+		"describe": handleDescribeOp,
+		"invoke":   handleInvokeOp,
+		"shutdown": handleShutdownOp,
+	}
 }
 
 func main() {
+	httpAddr := flag.String("http", "", "also serve the vars as a JSON REST API on this address, e.g. :8080 (127.0.0.1 unless a host is given)")
+	serveAddr := flag.String("serve", "", "run as a standalone WhatsApp HTTP bridge on this address, without reading the pod protocol from stdin, e.g. :8080")
+	token := flag.String("api-token", os.Getenv(envPrefix+"API_TOKEN"), "bearer token the HTTP API requires (env: BB_WHATSAPP_API_TOKEN)")
+	origins := flag.String("ws-origins", os.Getenv(envPrefix+"WS_ORIGINS"), "comma-separated origins of other sites whose pages may open the /ws event stream (env: BB_WHATSAPP_WS_ORIGINS)")
+	grpcAddr := flag.String("grpc", "", "also serve the WhatsApp gRPC service on this address, e.g. :9090")
+	defaultFormat := podFormat
+	if f := os.Getenv(envPrefix + "FORMAT"); f != "" {
+		defaultFormat = f
+	}
+	format := flag.String("format", defaultFormat, "payload format announced to babashka: json or edn (env: BB_WHATSAPP_FORMAT)")
+	configPath := flag.String("config", os.Getenv(envPrefix+"CONFIG"), "load options from this EDN or YAML file (env: BB_WHATSAPP_CONFIG)")
+	flag.Usage = cliUsage
+	flag.Parse()
+
+	// Config file, then environment, then configure calls: later sources win
+	var envErr error
+	if *configPath != "" {
+		envErr = applyConfigFile(*configPath)
+	}
+	if envErr == nil {
+		envErr = applyEnvConfig()
+	}
 	setupLogging()
+	if envErr == nil {
+		envErr = startConfigured()
+	}
+	if envErr == nil {
+		var f babashka.Format
+		if f, envErr = babashka.FormatByName(*format); envErr == nil {
+			babashka.SetFormat(f)
+		}
+	}
+	if envErr == nil && *httpAddr == "" {
+		*httpAddr, envErr = envListenAddr("HTTP")
+	}
+	if envErr == nil && *serveAddr == "" {
+		*serveAddr, envErr = envListenAddr("SERVE")
+	}
+	if envErr == nil && *grpcAddr == "" {
+		*grpcAddr, envErr = envListenAddr("GRPC")
+	}
+	if envErr == nil && (*httpAddr != "" || *serveAddr != "") && *token == "" {
+		envErr = errors.New("the HTTP API needs a bearer token: set --api-token or BB_WHATSAPP_API_TOKEN")
+	}
+	apiToken = *token
+	wsOrigins = splitList(*origins)
+	if envErr != nil {
+		podLog.Errorf("In startup configuration: %v", envErr)
+		fmt.Fprintf(os.Stderr, "bb-whatsapp-pod: %v\n", envErr)
+		os.Exit(1)
+	}
+
+	if flag.NArg() > 0 {
+		// One-shot subcommand against the stored session; a signal cancels
+		// the running operation, which then fails and sets the exit code
+		watchSignals(func(os.Signal) { stopPod() })
+		shutdown(runCLI(flag.Args()))
+	}
+	watchSignals(func(os.Signal) { shutdown(0) })
+	go supervise(podCtx)
 
-	log.Println("Pod started. WhatsApp client will be initialized on first invoke.")
+	podLog.Infof("Pod started. WhatsApp client will be initialized on first invoke.")
 
-	log.Println("Starting read loop...")
+	if *httpAddr != "" {
+		if err := startHTTPServer(*httpAddr); err != nil {
+			podLog.Errorf("Starting HTTP server on %s: %v", *httpAddr, err)
+			fmt.Fprintf(os.Stderr, "bb-whatsapp-pod: cannot listen on %s: %v\n", *httpAddr, err)
+			os.Exit(1)
+		}
+	}
+	if *serveAddr != "" {
+		if err := startHTTPServer(*serveAddr); err != nil {
+			podLog.Errorf("Starting HTTP bridge on %s: %v", *serveAddr, err)
+			fmt.Fprintf(os.Stderr, "bb-whatsapp-pod: cannot listen on %s: %v\n", *serveAddr, err)
+			os.Exit(1)
+		}
+	}
+	if *grpcAddr != "" {
+		if err := startGRPCServer(*grpcAddr); err != nil {
+			podLog.Errorf("Starting gRPC server on %s: %v", *grpcAddr, err)
+			fmt.Fprintf(os.Stderr, "bb-whatsapp-pod: cannot listen on %s: %v\n", *grpcAddr, err)
+			os.Exit(1)
+		}
+	}
+
+	if *serveAddr != "" {
+		// A standalone bridge: stdin is not the pod protocol, so it is not read
+		podLog.Infof("Serving the HTTP bridge until interrupted.")
+		<-podCtx.Done()
+		shutdown(0)
+	}
+
+	podLog.Infof("Starting read loop...")
 	for {
 		msg, err := babashka.ReadMessage()
 		if err != nil {
 			if err == io.EOF {
-				log.Println("Received EOF from stdin, exiting.")
-				if waClient != nil {
-					waClient.Disconnect()
+				if *httpAddr != "" || *grpcAddr != "" {
+					// Running standalone for the APIs; stay up until signalled
+					podLog.Infof("Received EOF from stdin, serving APIs until interrupted.")
+					<-podCtx.Done()
+					shutdown(0)
 				}
-				os.Exit(0)
+				podLog.Infof("Received EOF from stdin, exiting.")
+				shutdown(0)
 			}
 			// Log error, but difficult to report back to Babashka if ReadMessage failed
-			log.Printf("ERROR reading message: %v", err)
+			podLog.Errorf("Reading message: %v", err)
 			os.Exit(1) // Exit if we can't read messages
 		}
 
-		log.Printf("Received message. Op: %s, ID: %s, Var: %s", msg.Op, msg.Id, msg.Var)
+		podLog.Debugf("Received message. Op: %s, ID: %s, Var: %s", msg.Op, msg.Id, msg.Var)
 
-		switch msg.Op {
-		case "describe":
-			log.Println("Handling describe op...")
-			describeResp := handleDescribe()
-			err = babashka.WriteDescribeResponse(describeResp)
-			if err != nil {
-				log.Printf("ERROR writing describe response: %v", err)
-			}
-		case "invoke":
-			log.Println("Handling invoke op...")
-			value, invokeErrMsg := handleInvoke(*msg) // Pass msg by value if needed or keep pointer
-			if invokeErrMsg != "" {
-				log.Printf("Invoke error: %s", invokeErrMsg)
-				err = babashka.WriteErrorResponse(msg, fmt.Errorf(invokeErrMsg)) // Pass original msg and error
-				if err != nil {
-					log.Printf("ERROR writing error response: %v", err)
-				}
-			} else {
-				log.Printf("Invoke success. Value: %s", value)
-				err = babashka.WriteInvokeResponse(msg, value)
-				if err != nil {
-					log.Printf("ERROR writing invoke response: %v", err)
-				}
-			}
-		case "shutdown":
-			log.Println("Received shutdown op. Cleaning up and exiting...")
-			if waClient != nil {
-				waClient.Disconnect()
-			}
-			// Pod protocol doesn't require a response for shutdown, just exit cleanly.
-			os.Exit(0)
-		default:
+		handler, ok := opHandlers[msg.Op]
+		if !ok {
 			errMsg := fmt.Sprintf("Unknown operation: %s", msg.Op)
-			log.Printf("Unknown op received: %s", msg.Op)
-			err = babashka.WriteErrorResponse(msg, fmt.Errorf(errMsg))
+			podLog.Infof("Unknown op received: %s", msg.Op)
+			err = babashka.WriteErrorResponse(msg, errors.New(errMsg))
 			if err != nil {
-				log.Printf("ERROR writing unknown op error response: %v", err)
+				podLog.Errorf("Writing unknown op error response: %v", err)
 			}
+			continue
 		}
+		handler(msg)
+	}
+}
+
+// handleDescribeOp answers the describe op
+func handleDescribeOp(msg *babashka.Message) {
+	podLog.Infof("Handling describe op...")
+	describeResp := handleDescribe()
+	err := babashka.WriteDescribeResponse(describeResp)
+	if err != nil {
+		podLog.Errorf("Writing describe response: %v", err)
+	}
+}
+
+// handleInvokeOp runs each invoke on its own goroutine so a long call (a
+// login waiting for a QR scan, a big upload) can be cancelled by a later one
+func handleInvokeOp(msg *babashka.Message) {
+	go runInvoke(msg)
+}
+
+// runInvoke runs a var and writes its result or error
+func runInvoke(msg *babashka.Message) {
+	ilog := newInvokeLogger(msg)
+	ilog.Infof("Handling invoke op...")
+	if !beginInvoke() {
+		ilog.Infof("Rejecting invoke, pod is shutting down.")
+		err := babashka.WriteErrorResponse(msg, whatsapp.ErrShuttingDown)
+		if err != nil {
+			ilog.Errorf("Writing error response: %v", err)
+		}
+		return
+	}
+	defer invokesRunning.Done()
+	defer recoverInvoke(msg, ilog)
+	metricInvokes.Inc()
+	// handleInvoke takes JSON args, like the other APIs
+	args, argsErr := babashka.CurrentFormat().ArgsToJSON(msg.Args)
+	if argsErr != nil {
+		metricInvokeErrors.Inc()
+		ilog.Errorf("Invoke error: decoding %s args: %v", babashka.CurrentFormat().Name(), argsErr)
+		if err := babashka.WriteErrorResponse(msg, fmt.Errorf("invalid %s args: %w", babashka.CurrentFormat().Name(), argsErr)); err != nil {
+			ilog.Errorf("Writing error response: %v", err)
+		}
+		return
+	}
+	msg.Args = args
+	result, meta, invokeErr := handleInvoke(*msg, ilog, func(warnings []string) {
+		if err := babashka.WriteWarnings(msg, warnings); err != nil {
+			ilog.Errorf("Writing deprecation warnings: %v", err)
+		}
+	})
+	if invokeErr != nil {
+		metricInvokeErrors.Inc()
+		ilog.Errorf("Invoke error: %v", invokeErr)
+		recordError(msg.Var, invokeErr.Error())
+		var err error
+		if errors.Is(invokeErr, whatsapp.ErrTimeout) {
+			// Tagged so scripts can tell a timeout from a failure
+			exData, _ := json.Marshal(map[string]interface{}{"type": "timeout", "var": msg.Var})
+			err = babashka.WriteErrorResponseWithData(msg, invokeErr, string(exData))
+		} else {
+			err = babashka.WriteErrorResponse(msg, invokeErr)
+		}
+		if err != nil {
+			ilog.Errorf("Writing error response: %v", err)
+		}
+		return
+	}
+	err := writeInvokeResult(msg, result, meta, ilog)
+	if err != nil {
+		ilog.Errorf("Writing invoke response: %v", err)
+	}
+}
+
+// recoverInvoke turns a panic inside an invoke into an error response, so a
+// bug in one handler fails that call instead of killing the pod
+func recoverInvoke(msg *babashka.Message, ilog whatsapp.Logger) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := string(debug.Stack())
+	ilog.Errorf("PANIC while invoking %s: %v\n%s", msg.Var, r, stack)
+	recordError(msg.Var, fmt.Sprintf("panic: %v", r))
+
+	exData, err := json.Marshal(map[string]interface{}{
+		"type":  "panic",
+		"var":   msg.Var,
+		"panic": fmt.Sprint(r),
+		"stack": stack,
+	})
+	if err != nil {
+		exData = nil
+	}
+	err = babashka.WriteErrorResponseWithData(msg, fmt.Errorf("internal error in %s: %v", msg.Var, r), string(exData))
+	if err != nil {
+		ilog.Errorf("Writing panic error response: %v", err)
 	}
 }
 
+// handleShutdownOp disconnects the client and exits
+func handleShutdownOp(msg *babashka.Message) {
+	podLog.Infof("Received shutdown op. Cleaning up and exiting...")
+	// Pod protocol doesn't require a response for shutdown, just exit cleanly.
+	shutdown(0)
+}
+
 // handleDescribe now returns *babashka.DescribeResponse
 func handleDescribe() *babashka.DescribeResponse {
+	// Advertise every op in the dispatch table so babashka knows it may send
+	// shutdown, and clients can feature-detect this build
+	ops := make(map[string]babashka.OpInfo, len(opHandlers))
+	for op := range opHandlers {
+		ops[op] = babashka.OpInfo{}
+	}
+
 	return &babashka.DescribeResponse{
-		Format: "json", // Values passed in invoke args/results are JSON
+		Format: babashka.CurrentFormat().Name(), // invoke args and results, set by --format
+		Ops:    ops,
+		Metadata: map[string]string{
+			"version":        version,
+			"async-support":  "true", // async vars stream chunked values
+			"client-ready":   fmt.Sprint(clientReady()),
+			"client-on-load": "lazy", // the WhatsApp client starts on first invoke
+		},
 		Namespaces: []babashka.Namespace{
 			{
 				Name: "pod.whatsapp",
-				Vars: []babashka.Var{
-					{Name: "login"}, // ArgLists not directly supported by babashka helper struct
-					{Name: "logout"},
-					{Name: "status"},
-					{Name: "send-message"},
-					{Name: "get-groups"},
-					{Name: "send-group-message"},
-					{Name: "upload"},
-					{Name: "send-image"},
-				},
+				Vars: describeVars(),
 			},
 		},
 	}
 }
 
-// handleInvoke takes babashka.Message, returns JSON string value and error message
-func handleInvoke(msg babashka.Message) (value string, errMsg string) {
-	log.Printf("Handling invoke for var: %s", msg.Var)
+// handleInvoke takes babashka.Message, returns the function result, its
+// timing metadata and error. An invoke that runs past its deadline fails
+// with an error wrapping whatsapp.ErrTimeout. Deprecation warnings are always part of
+// the metadata; onWarnings, if set, also reports them before the call runs.
+func handleInvoke(msg babashka.Message, ilog whatsapp.Logger, onWarnings func([]string)) (value interface{}, meta *InvokeMetadata, err error) {
+	var errMsg string
+	started := time.Now()
+	ilog.Infof("Handling invoke for var: %s", msg.Var)
 	parts := strings.SplitN(msg.Var, "/", 2)
 	if len(parts) != 2 {
 		errMsg = fmt.Sprintf("Invalid var format: %s", msg.Var)
-		log.Printf("Error in handleInvoke: %s", errMsg)
-		return "", errMsg
+		ilog.Errorf("Error in handleInvoke: %s", errMsg)
+		return nil, nil, errors.New(errMsg)
 	}
 	// namespace := parts[0] // Assuming single namespace
 	funcName := parts[1]
 
-	log.Printf("Parsed function name: %s", funcName)
+	ilog.Debugf("Parsed function name: %s", funcName)
 
-	// Get the client instance (initializes on first call)
-	client, clientErr := getWaClient()
-	if clientErr != nil {
-		errMsg = fmt.Sprintf("Failed to initialize WhatsApp client: %v", clientErr)
-		log.Printf("Error in handleInvoke (getClient): %s", errMsg)
-		return "", errMsg
-	}
-	if client == nil {
-		errMsg = "WhatsApp client is not available after initialization attempt."
-		log.Printf("Error in handleInvoke: %s", errMsg)
-		return "", errMsg
+	h, ok := handlers[funcName]
+	if !ok {
+		errMsg = fmt.Sprintf("Unknown function: %s", funcName)
+		ilog.Errorf("Error in handleInvoke: %s", errMsg)
+		return nil, nil, errors.New(errMsg)
 	}
 
-	log.Printf("Raw args string (should be JSON): %s", msg.Args)
+	ilog.Debugf("Raw args string (should be JSON): %s", msg.Args)
 
 	// Parse arguments JSON string from msg.Args into a slice of interface{}
 	var args []interface{}
@@ -152,121 +415,256 @@ func handleInvoke(msg babashka.Message) (value string, errMsg string) {
 		errUnmarshal := json.Unmarshal([]byte(msg.Args), &args)
 		if errUnmarshal != nil {
 			errMsg = fmt.Sprintf("Error unmarshaling invoke args JSON: %v", errUnmarshal)
-			log.Printf("Error in handleInvoke: %s", errMsg)
-			return "", errMsg
+			ilog.Errorf("Error in handleInvoke: %s", errMsg)
+			return nil, nil, errors.New(errMsg)
 		}
-		log.Printf("Parsed JSON args: %+v", args)
+		ilog.Debugf("Parsed JSON args: %+v", args)
 	} else {
-		log.Println("No arguments provided.")
-	}
-
-	var result interface{}
-	var invokeErr error
-
-	switch funcName {
-	case "login":
-		log.Println("Calling client.Login()...")
-		result, invokeErr = client.Login()
-	case "logout":
-		log.Println("Calling client.Logout()...")
-		result, invokeErr = client.Logout()
-	case "status":
-		log.Println("Calling client.Status()...")
-		result, invokeErr = client.Status()
-	case "send-message":
-		log.Println("Handling send-message...")
-		if len(args) != 2 {
-			invokeErr = fmt.Errorf("send-message expects 2 arguments (phone-number, message), got %d", len(args))
-		} else {
-			phone, okPhone := args[0].(string)
-			message, okMsg := args[1].(string)
-			if !okPhone || !okMsg {
-				invokeErr = fmt.Errorf("send-message arguments must be strings")
-			} else {
-				log.Printf("Calling client.SendMessage(%s, ...)", phone)
-				result, invokeErr = client.SendMessage(phone, message)
-			}
+		ilog.Infof("No arguments provided.")
+	}
+
+	args, opts, err := invokeOptions(h.Args, args)
+	if err != nil {
+		errMsg = fmt.Sprintf("%s: %v", funcName, err)
+		ilog.Errorf("Error in handleInvoke (invokeOptions): %s", errMsg)
+		return nil, nil, errors.New(errMsg)
+	}
+	if msg.Binary != "" && msg.Binary != binaryRaw && msg.Binary != "base64" {
+		return nil, nil, fmt.Errorf("%s: binary must be raw or base64, got %q", funcName, msg.Binary)
+	}
+	rawDataArgs(h.Args, args, msg.Data)
+
+	args, warnings := checkDeprecations(funcName, args)
+	if opts.Bare {
+		warnings = append(warnings, bareOptionsDeprecation(funcName))
+	}
+	if len(warnings) > 0 {
+		for _, w := range warnings {
+			ilog.Warnf("DEPRECATED: %s", w)
 		}
-	case "get-groups":
-		log.Println("Calling client.GetGroups()...")
-		result, invokeErr = client.GetGroups()
-	case "send-group-message":
-		log.Println("Handling send-group-message...")
-		if len(args) != 2 {
-			invokeErr = fmt.Errorf("send-group-message expects 2 arguments (group-jid, message), got %d", len(args))
-		} else {
-			groupJID, okJID := args[0].(string)
-			message, okMsg := args[1].(string)
-			if !okJID || !okMsg {
-				invokeErr = fmt.Errorf("send-group-message arguments must be strings")
-			} else {
-				log.Printf("Calling client.SendGroupMessage(%s, ...)", groupJID)
-				result, invokeErr = client.SendGroupMessage(groupJID, message)
-			}
+		if onWarnings != nil {
+			onWarnings(warnings)
 		}
-	case "upload":
-		if len(args) != 2 {
-			invokeErr = fmt.Errorf("upload requires 2 arguments: file-path and mime-type")
-		} else {
-			filePath, ok1 := args[0].(string)
-			mimeType, ok2 := args[1].(string)
-			if !ok1 || !ok2 {
-				invokeErr = fmt.Errorf("upload arguments must be strings")
-			} else {
-				log.Printf("Calling client.Upload(%s, %s)", filePath, mimeType)
-				result, invokeErr = client.Upload(filePath, mimeType)
-			}
+	}
+
+	// Reject bad arguments before touching the client or whatsmeow
+	if err := validateArgs(funcName, h.Args, args); err != nil {
+		errMsg = err.Error()
+		ilog.Errorf("Error in handleInvoke (validateArgs): %s", errMsg)
+		return nil, nil, errors.New(errMsg)
+	}
+
+	ctx, release := trackInvoke(msg.Id, msg.Var)
+	defer release()
+	ctx = whatsapp.WithLogPrefix(ctx, ilog.Prefix()) // the client logs this invoke's uploads and retries with its id
+	timeout := opts.Timeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	inv := &invocation{Msg: &msg, Args: args, Log: ilog, Ctx: ctx, Started: started, Warnings: warnings}
+	if !h.NoClient {
+		// Get the account's client (the default one initializes on first call)
+		client, clientErr := accountClient(opts.Account)
+		if errors.Is(clientErr, errUnknownAccount) {
+			ilog.Errorf("Error in handleInvoke (getClient): %v", clientErr)
+			return nil, nil, clientErr
 		}
-	case "send-image":
-		if len(args) != 3 {
-			invokeErr = fmt.Errorf("send-image requires 3 arguments: recipient, file-path, and caption")
-		} else {
-			recipient, ok1 := args[0].(string)
-			filePath, ok2 := args[1].(string)
-			caption, ok3 := args[2].(string)
-			if !ok1 || !ok2 || !ok3 {
-				invokeErr = fmt.Errorf("send-image arguments must be strings")
-			} else {
-				log.Printf("Calling client.SendImage(%s, %s, %s)", recipient, filePath, caption)
-				result, invokeErr = client.SendImage(recipient, filePath, caption)
-			}
+		if clientErr != nil {
+			errMsg = fmt.Sprintf("Failed to initialize WhatsApp client: %v", clientErr)
+			ilog.Errorf("Error in handleInvoke (getClient): %s", errMsg)
+			return nil, nil, errors.New(errMsg)
 		}
-	default:
-		invokeErr = fmt.Errorf("Unknown function: %s", funcName)
+		if client == nil {
+			errMsg = "WhatsApp client is not available after initialization attempt."
+			ilog.Errorf("Error in handleInvoke: %s", errMsg)
+			return nil, nil, errors.New(errMsg)
+		}
+		inv.Client = client
 	}
 
+	ilog.Infof("Calling handler %s...", funcName)
+	result, invokeErr := h.Fn(inv)
+	if h.Audit {
+		recordAction(h, inv, invokeErr)
+	}
+	if _, done := result.(streamDone); done && invokeErr == nil {
+		ilog.Infof("Stream '%s' ended.", funcName)
+		return result, inv.metadata(), nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		ilog.Warnf("Function '%s' timed out after %v.", funcName, timeout)
+		return nil, nil, fmt.Errorf("%s: %w after %v", funcName, whatsapp.ErrTimeout, timeout)
+	}
+	if ctx.Err() != nil {
+		ilog.Infof("Function '%s' was cancelled.", funcName)
+		return nil, nil, fmt.Errorf("%s: %w", funcName, errInterrupted)
+	}
+	if errors.Is(invokeErr, whatsapp.ErrTimeout) {
+		ilog.Warnf("Function '%s' timed out: %v", funcName, invokeErr)
+		return nil, nil, fmt.Errorf("%s: %w", funcName, invokeErr)
+	}
 	if invokeErr != nil {
 		errMsg = invokeErr.Error()
-		log.Printf("Error invoking function '%s': %s", funcName, errMsg)
-		return "", errMsg
+		ilog.Errorf("Error invoking function '%s': %s", funcName, errMsg)
+		return nil, nil, errors.New(errMsg)
+	}
+
+	if sent, ok := result.(whatsapp.SendResult); ok {
+		inv.Retries += sent.Retries + sent.AckRetries
 	}
+	meta = inv.metadata()
+	ilog.Infof("Function '%s' executed successfully in %dms.", funcName, meta.DurationMs)
+	return result, meta, nil
+}
+
+// binaryChunkSize bounds each streamed value so large media never has to be
+// bencoded as a single string
+const binaryChunkSize = 256 * 1024
 
-	log.Printf("Function '%s' executed successfully. Result: %+v", funcName, result)
+// binaryRaw is the "binary" key of an invoke that wants raw chunks
+const binaryRaw = "raw"
+
+// writeInvokeResult writes the invoke response for a successful call. Binary
+// results are streamed as a header value, one value per chunk, and a final
+// done message; everything else is written as a single JSON value.
+func writeInvokeResult(msg *babashka.Message, result interface{}, meta *InvokeMetadata, ilog whatsapp.Logger) error {
+	if bin, ok := result.(*whatsapp.BinaryResult); ok {
+		return writeBinaryResult(msg, bin, meta, ilog)
+	}
+	if _, ok := result.(streamDone); ok {
+		return babashka.WriteDoneResponse(msg)
+	}
 
 	// Marshal the result back to a JSON string for the 'Value' field in the invoke response
 	resultBytes, marshalErr := json.Marshal(result)
 	if marshalErr != nil {
-		errMsg = fmt.Sprintf("Error marshaling result to JSON: %v", marshalErr)
-		log.Printf("Error in handleInvoke after execution: %s", errMsg)
-		return "", errMsg
+		ilog.Errorf("Error marshaling result to JSON: %v", marshalErr)
+		return babashka.WriteErrorResponse(msg, fmt.Errorf("error marshaling result to JSON: %w", marshalErr))
 	}
 
-	log.Printf("Successfully marshaled result for '%s'.", funcName)
-	return string(resultBytes), ""
+	resultBytes = withMetadata(resultBytes, meta)
+	ilog.Debugf("Invoke success. Value: %s", resultBytes)
+	return babashka.WriteInvokeResponse(msg, string(resultBytes))
 }
 
-// getWaClient remains the same
+// writeBinaryResult streams a BinaryResult in bounded chunks: as base64 in
+// the pod's format for babashka, or as bare byte strings for a client that
+// sent "binary" "raw" with the invoke
+func writeBinaryResult(msg *babashka.Message, bin *whatsapp.BinaryResult, meta *InvokeMetadata, ilog whatsapp.Logger) error {
+	chunks := bin.Chunks(binaryChunkSize)
+	raw := msg.Binary == binaryRaw
+	encoding := "base64"
+	if raw {
+		encoding = binaryRaw
+	}
+	header, err := json.Marshal(whatsapp.BinaryHeader{
+		Mimetype:  bin.Mimetype,
+		FileName:  bin.FileName,
+		Size:      len(bin.Data),
+		ChunkSize: binaryChunkSize,
+		Chunks:    len(chunks),
+		Encoding:  encoding,
+	})
+	if err != nil {
+		return babashka.WriteErrorResponse(msg, fmt.Errorf("error marshaling binary header: %w", err))
+	}
+	ilog.Infof("Streaming binary result: %d bytes in %d %s chunks", len(bin.Data), len(chunks), encoding)
+	header = withMetadata(header, meta)
+	if err := babashka.WriteChunkResponse(msg, string(header)); err != nil {
+		return err
+	}
+
+	for i, chunk := range chunks {
+		if raw {
+			if err := babashka.WriteRawChunkResponse(msg, chunk); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := json.Marshal(whatsapp.BinaryChunk{Index: i, Data: chunk})
+		if err != nil {
+			return babashka.WriteErrorResponse(msg, fmt.Errorf("error marshaling chunk %d: %w", i, err))
+		}
+		if err := babashka.WriteChunkResponse(msg, string(value)); err != nil {
+			return err
+		}
+	}
+
+	return babashka.WriteDoneResponse(msg)
+}
+
+// getWaClient initializes the client on first use; safe for concurrent invokes.
+// The session database is opened without holding clientMutex, so health and
+// status keep answering while it runs; other callers wait for it to end.
 func getWaClient() (*whatsapp.WhatsAppClient, error) {
-	if waClient == nil && initErr == nil { // Only initialize if nil and no previous error
-		log.Println("Initializing WhatsApp client for the first time...")
-		dbPath := "whatsapp.db"
-		waClient, initErr = whatsapp.NewClient(dbPath)
+	clientMutex.Lock()
+	defer clientMutex.Unlock()
+	for waClient == nil && initErr == nil { // Only initialize if nil and no previous error
+		if done := clientInit; done != nil {
+			clientMutex.Unlock()
+			<-done
+			clientMutex.Lock()
+			continue
+		}
+		done := make(chan struct{})
+		clientInit = done
+		clientMutex.Unlock()
+
+		podLog.Infof("Initializing WhatsApp client for the first time...")
+		cfg := currentConfig()
+		client, err := whatsapp.NewClient(cfg.DBPath, cfg.Client)
+
+		clientMutex.Lock()
+		waClient, initErr, clientInit = client, err, nil
+		close(done)
 		if initErr != nil {
-			log.Printf("FATAL: Error initializing WhatsApp client: %v", initErr)
-			// Keep initErr set so we don't retry
+			podLog.Errorf("Error initializing WhatsApp client: %v", initErr)
+			// Keep initErr set; the supervisor retries with backoff
 		} else {
-			log.Println("WhatsApp client initialized successfully.")
+			podLog.Infof("WhatsApp client initialized successfully.")
+			attachNATS(defaultAccount, waClient)
+			attachWebhook(defaultAccount, waClient)
 		}
 	}
 	return waClient, initErr
 }
+
+// retryClientInit clears a failed initialization and tries again
+func retryClientInit() (*whatsapp.WhatsAppClient, error) {
+	clientMutex.Lock()
+	if waClient == nil {
+		initErr = nil
+	}
+	clientMutex.Unlock()
+	return getWaClient()
+}
+
+// clientState returns the client and init error without initializing anything
+func clientState() (*whatsapp.WhatsAppClient, error) {
+	clientMutex.Lock()
+	defer clientMutex.Unlock()
+	return waClient, initErr
+}
+
+// clientReady reports whether the client has been initialized successfully
+func clientReady() bool {
+	client, err := clientState()
+	return client != nil && err == nil
+}
+
+// restoreSession replaces the session database with a backup. The client
+// holds the database open once initialized, so like :db-path this only works
+// before the first var that needs it.
+func restoreSession(backupPath, passphrase string) (whatsapp.BackupResult, error) {
+	dbPath := currentConfig().DBPath // before locking, applyConfig reads the client under configMutex
+	clientMutex.Lock()
+	defer clientMutex.Unlock()
+	if waClient != nil || clientInit != nil {
+		err := fmt.Errorf("restore-session must run before the WhatsApp client is initialized; restart the pod and restore first")
+		return whatsapp.BackupResult{Success: false, Message: err.Error()}, err
+	}
+	return whatsapp.RestoreSession(dbPath, backupPath, passphrase)
+}
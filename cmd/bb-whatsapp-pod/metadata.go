@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// InvokeMetadata is attached under "metadata" to every map result, so
+// scripts can spot slow or retried calls without reading pod.log
+type InvokeMetadata struct {
+	DurationMs int64    `json:"duration_ms"`
+	Retries    int      `json:"retries"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// Warn records a non-fatal problem to report in the result metadata
+func (inv *invocation) Warn(warning string) {
+	inv.Log.Warnf("%s", warning)
+	inv.Warnings = append(inv.Warnings, warning)
+}
+
+// metadata summarizes the invocation so far
+func (inv *invocation) metadata() *InvokeMetadata {
+	return &InvokeMetadata{
+		DurationMs: time.Since(inv.Started).Milliseconds(),
+		Retries:    inv.Retries,
+		Warnings:   inv.Warnings,
+	}
+}
+
+// withMetadata adds meta to a JSON object result. Other JSON values are
+// returned unchanged since they have nowhere to carry it.
+func withMetadata(result []byte, meta *InvokeMetadata) []byte {
+	if meta == nil || !bytes.HasPrefix(bytes.TrimSpace(result), []byte("{")) {
+		return result
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(result, &fields); err != nil {
+		return result
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return result
+	}
+	fields["metadata"] = metaBytes
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return result
+	}
+	return merged
+}
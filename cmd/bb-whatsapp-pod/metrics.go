@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/metrics"
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+var metricsLog = whatsapp.NewLogger("Metrics") // logs of the metrics listener
+
+// Pod metrics; the WhatsApp client registers its own in pkg/whatsapp
+var (
+	metricInvokes      = metrics.NewCounter("pod_invokes_total", "Invokes handled by the pod.")
+	metricInvokeErrors = metrics.NewCounter("pod_invoke_errors_total", "Invokes that returned an error.")
+	_                  = metrics.NewGaugeFunc("whatsapp_event_queue_depth", "Items waiting in the client's internal queues.", "queue", queueDepths)
+)
+
+// queueDepths reads the client's queues for the gauge without initializing it
+func queueDepths() map[string]float64 {
+	depths := map[string]float64{}
+	if client, _ := clientState(); client != nil {
+		for name, n := range client.QueueDepths() {
+			depths[name] = float64(n)
+		}
+	}
+	return depths
+}
+
+// metricsServer is the optional /metrics listener, restarted by configure
+var metricsServer struct {
+	sync.Mutex
+	addr   string
+	server *http.Server
+}
+
+// setMetricsAddr starts, moves or stops the /metrics listener. An empty
+// addr disables it.
+func setMetricsAddr(addr string) error {
+	metricsServer.Lock()
+	running := metricsServer.addr
+	metricsServer.Unlock()
+	if addr == running {
+		return nil
+	}
+	change, err := prepareMetricsAddr(addr)
+	if err != nil {
+		return err
+	}
+	change.apply()
+	return nil
+}
+
+// prepareMetricsAddr listens on addr without touching the running listener.
+// The listen happens synchronously so configure can report a port that is
+// already taken before it changes anything else.
+func prepareMetricsAddr(addr string) (pendingChange, error) {
+	var listener net.Listener
+	if addr != "" {
+		var err error
+		listener, err = net.Listen("tcp", addr)
+		if err != nil {
+			return pendingChange{}, err
+		}
+	}
+	return pendingChange{
+		apply: func() { serveMetrics(addr, listener) },
+		discard: func() {
+			if listener != nil {
+				listener.Close()
+			}
+		},
+	}, nil
+}
+
+// serveMetrics stops the running listener and serves /metrics on listener,
+// if any
+func serveMetrics(addr string, listener net.Listener) {
+	metricsServer.Lock()
+	defer metricsServer.Unlock()
+	if metricsServer.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		metricsServer.server.Shutdown(ctx)
+		cancel()
+		metricsLog.Infof("Stopped listener on %s", metricsServer.addr)
+		metricsServer.server = nil
+	}
+	metricsServer.addr = addr
+	if listener == nil {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	metricsServer.server = server
+	go func() {
+		defer whatsapp.LogPanic(metricsLog, "the metrics server")
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			metricsLog.Errorf("Listener on %s stopped: %v", addr, err)
+		}
+	}()
+	metricsLog.Infof("Serving /metrics on %s", listener.Addr())
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/metrics"
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var natsLog = whatsapp.NewLogger("NATS") // logs of NATS publishing
+
+var (
+	metricNATSPublished     = metrics.NewCounter("pod_nats_published_total", "Events published to NATS.")
+	metricNATSPublishErrors = metrics.NewCounter("pod_nats_publish_errors_total", "Events that could not be published to NATS.")
+)
+
+// natsAccountHeader names the account each event came from
+const natsAccountHeader = "WhatsApp-Account"
+
+// natsConfig selects where events are published. Each event goes to
+// <Subject>.<type>, e.g. whatsapp.events.message.
+type natsConfig struct {
+	URL       string // "" disables publishing
+	Subject   string
+	JetStream bool   // publish with acks into a stream instead of core NATS
+	Stream    string // stream created for <Subject>.> when missing
+}
+
+// natsPublisher forwards the event streams of the clients to NATS
+type natsPublisher struct {
+	cfg  natsConfig
+	conn *nats.Conn
+	js   jetstream.JetStream // nil for core NATS
+
+	mu    sync.Mutex
+	stops map[*whatsapp.WhatsAppClient]func() // ends each client subscription
+}
+
+// natsState holds the running publisher, replaced by configure, and the
+// client of every account it forwards
+var natsState struct {
+	sync.Mutex
+	cfg     natsConfig
+	pub     *natsPublisher
+	clients map[*whatsapp.WhatsAppClient]string // to account name
+}
+
+// setNATS connects to, reconnects to or disconnects from NATS
+func setNATS(cfg natsConfig) error {
+	natsState.Lock()
+	same := cfg == natsState.cfg
+	natsState.Unlock()
+	if same {
+		return nil
+	}
+	change, err := prepareNATS(cfg)
+	if err != nil {
+		return err
+	}
+	change.apply()
+	return nil
+}
+
+// prepareNATS connects a publisher for cfg without touching the running
+// one. Connecting happens synchronously so configure can report a bad URL
+// or stream before it changes anything else.
+func prepareNATS(cfg natsConfig) (pendingChange, error) {
+	var pub *natsPublisher
+	if cfg.URL != "" {
+		var err error
+		pub, err = newNATSPublisher(cfg)
+		if err != nil {
+			return pendingChange{}, err
+		}
+	}
+	return pendingChange{
+		apply: func() { switchNATS(cfg, pub) },
+		discard: func() {
+			if pub != nil {
+				pub.Close()
+			}
+		},
+	}, nil
+}
+
+// switchNATS closes the running publisher and forwards the events of
+// every account to pub, if any
+func switchNATS(cfg natsConfig, pub *natsPublisher) {
+	natsState.Lock()
+	defer natsState.Unlock()
+	if natsState.pub != nil {
+		natsState.pub.Close()
+	}
+	natsState.cfg = cfg
+	natsState.pub = pub
+	if pub != nil {
+		for client, account := range natsState.clients {
+			pub.attach(account, client)
+		}
+	}
+}
+
+// attachNATS starts forwarding the events of an account's freshly
+// initialized client
+func attachNATS(account string, client *whatsapp.WhatsAppClient) {
+	natsState.Lock()
+	defer natsState.Unlock()
+	if natsState.clients == nil {
+		natsState.clients = map[*whatsapp.WhatsAppClient]string{}
+	}
+	natsState.clients[client] = account
+	if natsState.pub != nil {
+		natsState.pub.attach(account, client)
+	}
+}
+
+// detachNATS stops forwarding the events of a removed account's client
+func detachNATS(client *whatsapp.WhatsAppClient) {
+	natsState.Lock()
+	defer natsState.Unlock()
+	delete(natsState.clients, client)
+	if natsState.pub != nil {
+		natsState.pub.detach(client)
+	}
+}
+
+func newNATSPublisher(cfg natsConfig) (*natsPublisher, error) {
+	conn, err := nats.Connect(cfg.URL,
+		nats.Name("bb-whatsapp-pod"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			natsLog.Warnf("Disconnected: %v", err)
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			natsLog.Infof("Reconnected to %s", c.ConnectedUrlRedacted())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", whatsapp.RedactURL(cfg.URL), err)
+	}
+	pub := &natsPublisher{cfg: cfg, conn: conn, stops: map[*whatsapp.WhatsAppClient]func(){}}
+
+	if cfg.JetStream {
+		js, err := jetstream.New(conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("enabling JetStream: %w", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:     cfg.Stream,
+			Subjects: []string{cfg.Subject + ".>"},
+		})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("creating stream %s: %w", cfg.Stream, err)
+		}
+		pub.js = js
+	}
+	natsLog.Infof("Publishing events to %s.> on %s (jetstream=%v)", cfg.Subject, whatsapp.RedactURL(cfg.URL), cfg.JetStream)
+	return pub, nil
+}
+
+// attach subscribes to the events of an account's client, once
+func (p *natsPublisher) attach(account string, client *whatsapp.WhatsAppClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.stops[client]; ok {
+		return
+	}
+	events, stop := client.Subscribe(1024)
+	p.stops[client] = stop
+	go p.forward(account, events)
+}
+
+// detach ends the subscription to a client's events
+func (p *natsPublisher) detach(client *whatsapp.WhatsAppClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if stop, ok := p.stops[client]; ok {
+		stop()
+		delete(p.stops, client)
+	}
+}
+
+// forward publishes events until the subscription is closed
+func (p *natsPublisher) forward(account string, events <-chan whatsapp.Event) {
+	for evt := range events {
+		p.publish(account, evt)
+	}
+}
+
+// publish sends one event to its subject
+func (p *natsPublisher) publish(account string, evt whatsapp.Event) {
+	defer whatsapp.LogPanic(natsLog, "publishing a "+evt.Type+" event")
+	data, err := json.Marshal(evt)
+	if err != nil {
+		natsLog.Errorf("Marshaling %s event: %v", evt.Type, err)
+		metricNATSPublishErrors.Inc()
+		return
+	}
+	subject := p.cfg.Subject + "." + evt.Type
+	msg := &nats.Msg{Subject: subject, Data: data, Header: nats.Header{natsAccountHeader: []string{account}}}
+	if p.js != nil {
+		// Async publish keeps a slow ack from stalling the event stream;
+		// failures surface on the returned future
+		future, err := p.js.PublishMsgAsync(msg)
+		if err != nil {
+			natsLog.Errorf("Publishing to %s: %v", subject, err)
+			metricNATSPublishErrors.Inc()
+			return
+		}
+		go p.awaitAck(subject, future)
+		return
+	}
+	if err := p.conn.PublishMsg(msg); err != nil {
+		natsLog.Errorf("Publishing to %s: %v", subject, err)
+		metricNATSPublishErrors.Inc()
+		return
+	}
+	metricNATSPublished.Inc()
+}
+
+// awaitAck counts a JetStream publish once the server acknowledges it
+func (p *natsPublisher) awaitAck(subject string, future jetstream.PubAckFuture) {
+	defer whatsapp.LogPanic(natsLog, "awaiting the ack of "+subject)
+	select {
+	case <-future.Ok():
+		metricNATSPublished.Inc()
+	case err := <-future.Err():
+		natsLog.Errorf("Publishing to %s: %v", subject, err)
+		metricNATSPublishErrors.Inc()
+	}
+}
+
+// Close stops forwarding and flushes pending messages before disconnecting
+func (p *natsPublisher) Close() {
+	p.mu.Lock()
+	for client, stop := range p.stops {
+		stop()
+		delete(p.stops, client)
+	}
+	p.mu.Unlock()
+	if p.js != nil {
+		select {
+		case <-p.js.PublishAsyncComplete():
+		case <-time.After(5 * time.Second):
+			natsLog.Warnf("Closing with JetStream publishes still unacknowledged.")
+		}
+	}
+	if err := p.conn.FlushTimeout(5 * time.Second); err != nil {
+		natsLog.Warnf("Flushing before close: %v", err)
+	}
+	p.conn.Close()
+	natsLog.Infof("Stopped publishing to %s", whatsapp.RedactURL(p.cfg.URL))
+}
+
+// closeNATS flushes and disconnects the publisher during shutdown
+func closeNATS() {
+	natsState.Lock()
+	defer natsState.Unlock()
+	if natsState.pub != nil {
+		natsState.pub.Close()
+		natsState.pub = nil
+	}
+}
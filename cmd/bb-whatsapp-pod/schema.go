@@ -0,0 +1,109 @@
+package main
+
+import "fmt"
+
+// jsonSchemaDialect is the draft the generated schemas follow
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schema returns the JSON schema of one argument kind
+func (k argKind) schema() map[string]interface{} {
+	switch k {
+	case argString:
+		return map[string]interface{}{"type": "string"}
+	case argJID:
+		return map[string]interface{}{"type": "string", "format": "whatsapp-jid", "pattern": "^[^@]*@.+$"}
+	case argPhone:
+		return map[string]interface{}{"type": []string{"string", "integer"}, "format": "phone"}
+	case argPath:
+		return map[string]interface{}{"type": "string", "format": "file-path"}
+	case argBool:
+		return map[string]interface{}{"type": "boolean"}
+	case argInt:
+		return map[string]interface{}{"type": "integer"}
+	case argStringList:
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+	case argPhoneList:
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": []string{"string", "integer"}, "format": "phone"}}
+	case argMap:
+		return map[string]interface{}{"type": "object"}
+	case argMapList:
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}}
+	case argBytes:
+		return map[string]interface{}{"type": []string{"string", "null"}, "contentEncoding": "base64"}
+	}
+	return map[string]interface{}{}
+}
+
+// invokeOptionsSchema describes the map of invoke options that may follow
+// a var's args, generated from the keys invokeOptions accepts
+func invokeOptionsSchema() map[string]interface{} {
+	properties := make(map[string]interface{}, len(invokeOptionKeys))
+	for key, option := range invokeOptionKeys {
+		properties[key] = option.schema
+	}
+	return map[string]interface{}{
+		"title":                "invoke-opts",
+		"type":                 "object",
+		"required":             []string{invokeOptionsKey},
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			invokeOptionsKey: map[string]interface{}{"type": "object", "additionalProperties": false, "properties": properties},
+		},
+	}
+}
+
+// schema describes the positional argument vector of a var as a JSON schema
+// tuple. It is generated from the same argSpecs and limits validateArgs and
+// invokeOptions check against, so they cannot drift apart. The invoke
+// options map may take the place of any optional arg, as the last item.
+func (h *handler) schema() map[string]interface{} {
+	opts := invokeOptionsSchema()
+	items := make([]interface{}, len(h.Args))
+	for i, spec := range h.Args {
+		item := spec.Kind.schema()
+		item["title"] = spec.Name
+		if spec.Optional {
+			items[i] = map[string]interface{}{"anyOf": []interface{}{item, opts}}
+		} else {
+			items[i] = item
+		}
+	}
+	required, _ := argLimits(h.Args)
+	s := map[string]interface{}{
+		"$schema":  jsonSchemaDialect,
+		"$id":      "pod.whatsapp/" + h.Name,
+		"type":     "array",
+		"minItems": required,
+		"maxItems": argCountWithOptions(h.Args),
+		"items":    opts,
+	}
+	if len(items) > 0 {
+		s["prefixItems"] = items
+	}
+	return s
+}
+
+// SchemasResult is returned by get-schemas
+type SchemasResult struct {
+	Success bool                              `json:"success"`
+	Message string                            `json:"message,omitempty"`
+	Schemas map[string]map[string]interface{} `json:"schemas"`
+}
+
+// getSchemas returns the argument schemas of the named vars, or of every var
+// when names is empty
+func getSchemas(names []string) (SchemasResult, error) {
+	if len(names) == 0 {
+		names = handlerOrder
+	}
+	schemas := make(map[string]map[string]interface{}, len(names))
+	for _, name := range names {
+		h, ok := handlers[name]
+		if !ok {
+			return SchemasResult{Success: false, Message: "unknown var " + name, Schemas: map[string]map[string]interface{}{}},
+				fmt.Errorf("get-schemas: unknown var %q", name)
+		}
+		schemas[name] = h.schema()
+	}
+	return SchemasResult{Success: true, Schemas: schemas}, nil
+}
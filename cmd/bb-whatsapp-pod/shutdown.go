@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// answerGrace bounds how long shutdown waits for cancelled invokes to write
+// their interrupted responses before the process exits
+const answerGrace = time.Second
+
+// shuttingDown is set once shutdown starts; new invokes are refused after that
+var shuttingDown atomic.Bool
+
+// podCtx is the process-level shutdown context. Every invoke, login wait
+// and long operation derives from it, and shutdown cancels it exactly once,
+// after in-flight sends have had their grace period.
+var podCtx, stopPod = context.WithCancel(context.Background())
+
+var (
+	shutdownOnce   sync.Once
+	invokesRunning sync.WaitGroup // invokes that still owe a response
+	invokeGate     sync.Mutex     // orders invokesRunning.Add before the Wait of shutdown
+)
+
+// beginInvoke counts an invoke in invokesRunning, unless shutdown has
+// started. An invoke it admits must call invokesRunning.Done.
+func beginInvoke() bool {
+	invokeGate.Lock()
+	defer invokeGate.Unlock()
+	if shuttingDown.Load() {
+		return false
+	}
+	invokesRunning.Add(1)
+	return true
+}
+
+// stopInvokes refuses every later invoke. Once it returns invokesRunning
+// only goes down, so waiting on it is safe.
+func stopInvokes() {
+	invokeGate.Lock()
+	defer invokeGate.Unlock()
+	shuttingDown.Store(true)
+}
+
+// shutdown drains in-flight sends and uploads for up to the configured grace
+// period, cancels whatever is still running, disconnects the clients of
+// every account and closes their databases, then exits. Later calls block until the first exits.
+func shutdown(exitCode int) {
+	shutdownOnce.Do(func() {
+		stopInvokes()
+		clients := addedClients()
+		if client, _ := clientState(); client != nil {
+			clients = append(clients, client)
+		}
+		if len(clients) > 0 {
+			grace := currentConfig().ShutdownGrace
+			podLog.Infof("Draining in-flight work (grace period %v)...", grace)
+			var drained sync.WaitGroup
+			for _, client := range clients {
+				drained.Add(1)
+				go func() {
+					defer drained.Done()
+					if !client.Drain(grace) {
+						podLog.Warnf("Shutting down with operations still in flight.")
+					}
+				}()
+			}
+			drained.Wait()
+		}
+
+		stopPod()
+		answered := make(chan struct{})
+		go func() {
+			invokesRunning.Wait()
+			close(answered)
+		}()
+		select {
+		case <-answered:
+		case <-time.After(answerGrace):
+			podLog.Warnf("Exiting before every cancelled invoke was answered.")
+		}
+
+		for _, client := range clients {
+			client.Disconnect()
+		}
+		closeNATS()
+		podLog.Infof("--- Pod Stopped ---")
+		os.Exit(exitCode)
+	})
+}
+
+// watchSignals calls onSignal for the first SIGINT or SIGTERM. It is the only
+// place the pod subscribes to signals; a second signal exits immediately.
+func watchSignals(onSignal func(os.Signal)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		podLog.Infof("Received %v, shutting down.", sig)
+		go onSignal(sig)
+		sig = <-signals
+		podLog.Infof("Received %v again, exiting immediately.", sig)
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+// StatsInfo is a self-report of the pod for scripts without a metrics stack
+type StatsInfo struct {
+	Success       bool            `json:"success"`
+	UptimeSeconds int64           `json:"uptime_seconds"`
+	Totals        whatsapp.Totals `json:"totals"`
+	Invokes       uint64          `json:"invokes"`
+	InvokeErrors  uint64          `json:"invoke_errors"`
+	Queues        map[string]int  `json:"queues"`
+	DBSizeBytes   int64           `json:"db_size_bytes"`
+	Memory        MemoryStats     `json:"memory"`
+}
+
+// MemoryStats is the subset of runtime.MemStats worth reporting
+type MemoryStats struct {
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+	Goroutines     int    `json:"goroutines"`
+}
+
+// getStats collects the stats without initializing the client
+func getStats() StatsInfo {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := StatsInfo{
+		Success:       true,
+		UptimeSeconds: int64(time.Since(startTime).Seconds()),
+		Totals:        whatsapp.CurrentTotals(),
+		Invokes:       metricInvokes.Value(),
+		InvokeErrors:  metricInvokeErrors.Value(),
+		Queues:        map[string]int{},
+		DBSizeBytes:   dbSize(currentConfig().DBPath),
+		Memory: MemoryStats{
+			HeapAllocBytes: mem.HeapAlloc,
+			SysBytes:       mem.Sys,
+			NumGC:          mem.NumGC,
+			Goroutines:     runtime.NumGoroutine(),
+		},
+	}
+	if client, _ := clientState(); client != nil {
+		stats.Queues = client.QueueDepths()
+	}
+	return stats
+}
+
+// dbSize adds up the SQLite database and its WAL/journal side files
+func dbSize(path string) int64 {
+	var total int64
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if info, err := os.Stat(path + suffix); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+// defaultSession names the pod's default WhatsApp session. Accounts added
+// with add-account are supervised under their own names.
+const defaultSession = "default"
+
+const (
+	// superviseInterval is how often the supervisor checks each session
+	superviseInterval = 5 * time.Second
+	// maxSessionBackoff caps the doubling wait between restarts
+	maxSessionBackoff = 5 * time.Minute
+)
+
+// SessionInfo is the health of one session, as reported by get-sessions
+type SessionInfo struct {
+	Name           string `json:"name"`
+	State          string `json:"state"`            // not-initialized, init-failed, idle, connected, disconnected
+	Status         string `json:"status,omitempty"` // the client's login status
+	JID            string `json:"jid,omitempty"`
+	UptimeMs       int64  `json:"uptime_ms"`                 // time connected, 0 while disconnected
+	DisconnectedMs int64  `json:"disconnected_ms,omitempty"` // time since the connection dropped
+	LastError      string `json:"last_error,omitempty"`
+	LastErrorAt    int64  `json:"last_error_at,omitempty"`
+	Restarts       int    `json:"restarts"`
+	Attempts       int    `json:"attempts"` // restarts or initializations since the session was last healthy
+	NextRestartAt  int64  `json:"next_restart_at,omitempty"`
+}
+
+// SessionsResult is returned by get-sessions
+type SessionsResult struct {
+	Success  bool          `json:"success"`
+	Message  string        `json:"message,omitempty"`
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// supervised is the supervisor's bookkeeping for one session
+type supervised struct {
+	attempts    int // restarts or initializations since the session was last healthy
+	nextAttempt time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+var supervisor = struct {
+	sync.Mutex
+	sessions map[string]*supervised
+}{sessions: map[string]*supervised{}}
+
+// supervisedSession returns the bookkeeping of a session; the caller holds
+// the lock
+func supervisedSession(name string) *supervised {
+	s, ok := supervisor.sessions[name]
+	if !ok {
+		s = &supervised{}
+		supervisor.sessions[name] = s
+	}
+	return s
+}
+
+// supervise checks the sessions every superviseInterval until ctx ends. A
+// client that failed to initialize is initialized again, and one that has
+// been disconnected longer than SessionStuckAfter is restarted, each with a
+// per-session backoff that doubles on every failure.
+func supervise(ctx context.Context) {
+	ticker := time.NewTicker(superviseInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, name := range accountNames() {
+				superviseSession(name)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func superviseSession(name string) {
+	defer whatsapp.LogPanic(podLog, "the supervisor of session "+name)
+	cfg := currentConfig()
+	client, initErr := accountState(name)
+	if shuttingDown.Load() {
+		return
+	}
+
+	supervisor.Lock()
+	s := supervisedSession(name)
+	due := !time.Now().Before(s.nextAttempt)
+	attempts := s.attempts
+	supervisor.Unlock()
+
+	switch {
+	case initErr != nil:
+		if !due {
+			return
+		}
+		podLog.Infof("Supervisor: initializing session %s again after: %v", name, initErr)
+		if _, err := retryClientInit(); err != nil {
+			s.backOff(name, err, cfg.SessionBackoff)
+		} else {
+			s.reset()
+		}
+
+	case client != nil:
+		conn := client.Connection()
+		if conn.Connected {
+			if attempts > 0 && time.Since(conn.ChangedAt) > cfg.SessionBackoff {
+				s.reset() // stable again
+			}
+			return
+		}
+		stuck := cfg.SessionStuckAfter > 0 && conn.EverConnected && time.Since(conn.ChangedAt) > cfg.SessionStuckAfter
+		if !stuck || !due || conn.Status == "logged-out" || conn.Status == "upgrade-required" {
+			return
+		}
+		podLog.Infof("Supervisor: session %s disconnected for %v, restarting", name, time.Since(conn.ChangedAt).Round(time.Second))
+		// Even a restart that connects counts until the connection proves stable
+		s.backOff(name, client.Restart(), cfg.SessionBackoff)
+	}
+}
+
+// backOff counts an attempt and schedules the next one after a wait that
+// doubles with every attempt since the session was last healthy
+func (s *supervised) backOff(name string, err error, backoff time.Duration) {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	s.attempts++
+	if err != nil {
+		s.lastError = err.Error()
+		s.lastErrorAt = time.Now()
+	}
+	wait := backoff << min(s.attempts-1, 16)
+	if wait > maxSessionBackoff || wait <= 0 {
+		wait = maxSessionBackoff
+	}
+	s.nextAttempt = time.Now().Add(wait)
+	if err != nil {
+		podLog.Infof("Supervisor: session %s attempt %d failed, next in %v: %v", name, s.attempts, wait, err)
+	}
+}
+
+// reset clears the backoff once a session is healthy
+func (s *supervised) reset() {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	s.attempts = 0
+	s.nextAttempt = time.Time{}
+}
+
+// getSessions reports the health of every session
+func getSessions() SessionsResult {
+	names := accountNames()
+	sessions := make([]SessionInfo, 0, len(names))
+	for _, name := range names {
+		sessions = append(sessions, sessionInfo(name))
+	}
+	return SessionsResult{
+		Success:  true,
+		Message:  fmt.Sprintf("%d session(s)", len(sessions)),
+		Sessions: sessions,
+	}
+}
+
+// sessionInfo reports the health of one session
+func sessionInfo(name string) SessionInfo {
+	client, initErr := accountState(name)
+
+	supervisor.Lock()
+	s := *supervisedSession(name)
+	supervisor.Unlock()
+
+	info := SessionInfo{Name: name, State: "not-initialized", Attempts: s.attempts}
+	if !s.nextAttempt.IsZero() {
+		info.NextRestartAt = s.nextAttempt.Unix()
+	}
+	if s.lastError != "" {
+		info.LastError, info.LastErrorAt = s.lastError, s.lastErrorAt.Unix()
+	}
+	switch {
+	case initErr != nil:
+		info.State = "init-failed"
+		info.LastError = initErr.Error()
+	case client != nil:
+		conn := client.Connection()
+		info.Status, info.JID, info.Restarts = conn.Status, conn.JID, conn.Restarts
+		switch {
+		case conn.Connected:
+			info.State = "connected"
+			info.UptimeMs = time.Since(conn.ChangedAt).Milliseconds()
+		case conn.EverConnected:
+			info.State = "disconnected"
+			info.DisconnectedMs = time.Since(conn.ChangedAt).Milliseconds()
+		default:
+			info.State = "idle" // initialized, not logged in yet
+		}
+		if conn.LastErrorAt.After(s.lastErrorAt) {
+			info.LastError, info.LastErrorAt = conn.LastError, conn.LastErrorAt.Unix()
+		}
+	}
+	return info
+}
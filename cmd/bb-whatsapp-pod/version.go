@@ -0,0 +1,62 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"go.mau.fi/whatsmeow/store"
+)
+
+// version is stamped at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// podFormat is the default payload format of the pod protocol, overridden
+// by --format; builds for EDN-first users can stamp it with
+// -ldflags "-X main.podFormat=edn"
+var podFormat = "json"
+
+// VersionInfo describes the exact build of the running pod
+type VersionInfo struct {
+	Version          string `json:"version"`
+	GitSHA           string `json:"git_sha,omitempty"`
+	GitDirty         bool   `json:"git_dirty,omitempty"`
+	BuildTime        string `json:"build_time,omitempty"`
+	GoVersion        string `json:"go_version"`
+	Platform         string `json:"platform"`
+	WhatsmeowVersion string `json:"whatsmeow_version"`
+	WAWebVersion     string `json:"wa_web_version"`
+}
+
+// getVersionInfo collects version details from the binary's embedded build info
+func getVersionInfo() VersionInfo {
+	info := VersionInfo{
+		Version:      version,
+		GoVersion:    runtime.Version(),
+		Platform:     runtime.GOOS + "/" + runtime.GOARCH,
+		WAWebVersion: store.GetWAVersion().String(),
+	}
+
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, dep := range buildInfo.Deps {
+		if dep.Path == "go.mau.fi/whatsmeow" {
+			info.WhatsmeowVersion = dep.Version
+			if dep.Replace != nil {
+				info.WhatsmeowVersion = dep.Replace.Version
+			}
+		}
+	}
+	for _, setting := range buildInfo.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.GitSHA = setting.Value
+		case "vcs.time":
+			info.BuildTime = setting.Value
+		case "vcs.modified":
+			info.GitDirty = setting.Value == "true"
+		}
+	}
+	return info
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+var webhookLog = whatsapp.NewLogger("Webhook") // logs of the webhook forwarder
+
+// webhookBackoff is the wait before the first retry, doubled for each next
+// one. Tests shorten it.
+var webhookBackoff = time.Second
+
+const (
+	webhookAttempts = 5   // per event, before it becomes a dead letter
+	webhookRetries  = 256 // events waiting to be retried, beyond which they become dead letters
+
+	// webhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of
+	// the body under the webhook secret, as GitHub signs its webhooks
+	webhookSignatureHeader = "X-Hub-Signature-256"
+
+	// webhookTimestampHeader carries the Unix time of the attempt, and
+	// webhookTimestampSignatureHeader signs it with the body, as
+	// "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>", so a
+	// receiver can reject replayed requests
+	webhookTimestampHeader          = "X-Webhook-Timestamp"
+	webhookTimestampSignatureHeader = "X-Webhook-Signature-256"
+
+	// webhookAccountHeader names the account the event came from
+	webhookAccountHeader = "X-Webhook-Account"
+)
+
+// webhookState is the running webhook forwarder, replaced by configure and
+// set-webhook
+var webhookState struct {
+	sync.Mutex
+	url       string
+	secret    string
+	forwarded map[*whatsapp.WhatsAppClient]*webhookForwarder // every attached client
+
+	delivered    int64
+	retried      int64
+	deadLettered int64
+	lastError    string
+	lastErrorAt  time.Time
+}
+
+// webhookForwarder is the forwarding of one account's events
+type webhookForwarder struct {
+	account string
+	stop    func() // ends the client subscription, nil while the webhook is disabled
+}
+
+// webhookTarget is where, and for which account, an event is delivered
+type webhookTarget struct {
+	account string
+	url     string
+	secret  string
+}
+
+var webhookHTTP = &http.Client{Timeout: 10 * time.Second}
+
+// WebhookResult is returned by set-webhook and get-webhook
+type WebhookResult struct {
+	Success      bool   `json:"success"`
+	Message      string `json:"message,omitempty"`
+	URL          string `json:"url"`    // "" while disabled
+	Signed       bool   `json:"signed"` // a secret is set
+	Delivered    int64  `json:"delivered"`
+	Retried      int64  `json:"retried"`       // retries, not events
+	DeadLettered int64  `json:"dead_lettered"` // events kept as dead letters after the last attempt
+	LastError    string `json:"last_error,omitempty"`
+	LastErrorAt  int64  `json:"last_error_at,omitempty"`
+}
+
+// WebhookDelivery is the result of replaying a webhook dead letter
+type WebhookDelivery struct {
+	Success  bool   `json:"success"`
+	Message  string `json:"message,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+// webhookOptions are the options of set-webhook
+type webhookOptions struct {
+	Secret string `json:"secret"` // "" sends unsigned requests
+}
+
+// configureWebhook sets the webhook-url and webhook-secret options
+// together, so configure reports them like any option
+func configureWebhook(url string, opts webhookOptions) (interface{}, error) {
+	if _, err := applyConfig(map[string]interface{}{"webhook-url": url, "webhook-secret": opts.Secret}); err != nil {
+		return WebhookResult{Success: false, Message: err.Error()}, err
+	}
+	return webhookStatus(), nil
+}
+
+// webhookStatus reports the forwarder and its counters since the pod started
+func webhookStatus() WebhookResult {
+	webhookState.Lock()
+	defer webhookState.Unlock()
+	r := WebhookResult{
+		Success:      true,
+		URL:          whatsapp.RedactURL(webhookState.url),
+		Signed:       webhookState.secret != "",
+		Delivered:    webhookState.delivered,
+		Retried:      webhookState.retried,
+		DeadLettered: webhookState.deadLettered,
+		LastError:    webhookState.lastError,
+	}
+	if !webhookState.lastErrorAt.IsZero() {
+		r.LastErrorAt = webhookState.lastErrorAt.Unix()
+	}
+	switch {
+	case r.URL == "":
+		r.Message = "Webhook disabled"
+	case r.Signed:
+		r.Message = "Forwarding events to " + r.URL + ", signed"
+	default:
+		r.Message = "Forwarding events to " + r.URL
+	}
+	return r
+}
+
+// setWebhook starts, retargets or stops (url "") event forwarding for
+// every account
+func setWebhook(url, secret string) {
+	webhookState.Lock()
+	defer webhookState.Unlock()
+	if url == webhookState.url && secret == webhookState.secret {
+		return
+	}
+	stopped := false
+	for _, f := range webhookState.forwarded {
+		if f.stop != nil {
+			f.stop()
+			f.stop = nil
+			stopped = true
+		}
+	}
+	if stopped {
+		webhookLog.Infof("Stopped forwarding to %s", whatsapp.RedactURL(webhookState.url))
+	}
+	webhookState.url, webhookState.secret = url, secret
+	for client, f := range webhookState.forwarded {
+		startWebhookLocked(client, f)
+	}
+}
+
+// attachWebhook starts forwarding the events of an account's freshly
+// initialized client. Dead letters of webhook deliveries are replayable
+// even while the webhook is disabled.
+func attachWebhook(account string, client *whatsapp.WhatsAppClient) {
+	client.HandleReplay("webhook", func(ctx context.Context, args []string) (interface{}, error) {
+		return replayWebhook(ctx, client, account, args)
+	})
+	webhookState.Lock()
+	defer webhookState.Unlock()
+	if webhookState.forwarded == nil {
+		webhookState.forwarded = map[*whatsapp.WhatsAppClient]*webhookForwarder{}
+	}
+	if _, ok := webhookState.forwarded[client]; ok {
+		return
+	}
+	f := &webhookForwarder{account: account}
+	webhookState.forwarded[client] = f
+	startWebhookLocked(client, f)
+}
+
+// detachWebhook stops forwarding the events of a removed account's client
+func detachWebhook(client *whatsapp.WhatsAppClient) {
+	webhookState.Lock()
+	defer webhookState.Unlock()
+	if f, ok := webhookState.forwarded[client]; ok {
+		if f.stop != nil {
+			f.stop()
+		}
+		delete(webhookState.forwarded, client)
+	}
+}
+
+func startWebhookLocked(client *whatsapp.WhatsAppClient, f *webhookForwarder) {
+	if webhookState.url == "" || f.stop != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, unsubscribe := client.Subscribe(1024)
+	f.stop = func() {
+		unsubscribe()
+		cancel() // ends the retries of the event being delivered
+	}
+	t := webhookTarget{account: f.account, url: webhookState.url, secret: webhookState.secret}
+	go forwardWebhook(ctx, client, t, events)
+	webhookLog.Infof("Forwarding events of account %s to %s", f.account, whatsapp.RedactURL(t.url))
+}
+
+// webhookEvent is an event waiting to be retried
+type webhookEvent struct {
+	Type     string
+	Body     []byte
+	Attempts int   // already made, 0 when it queued behind other retries
+	Err      error // of the last attempt
+}
+
+// forwardWebhook POSTs each event as JSON until the subscription is closed.
+// Each event gets one attempt here; one that is worth retrying goes to
+// retryWebhook, so a failing endpoint never holds the subscription back.
+// While retries are pending, later events queue behind them to keep their
+// order, and an event that finds the queue full is kept as a dead letter
+// at once. Dead letters are replayed with retry-dead-letter.
+func forwardWebhook(ctx context.Context, client *whatsapp.WhatsAppClient, t webhookTarget, events <-chan whatsapp.Event) {
+	retries := make(chan webhookEvent, webhookRetries)
+	var pending atomic.Int32 // queued or being retried
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for evt := range retries {
+			retryWebhook(ctx, client, t, evt, &pending)
+		}
+	}()
+	defer func() {
+		close(retries)
+		<-done
+	}()
+
+	for evt := range events {
+		if ctx.Err() != nil {
+			return
+		}
+		forwardWebhookEvent(ctx, client, t, evt, retries, &pending)
+	}
+}
+
+// forwardWebhookEvent makes the first attempt at one event, or queues it
+// for retryWebhook
+func forwardWebhookEvent(ctx context.Context, client *whatsapp.WhatsAppClient, t webhookTarget, evt whatsapp.Event, retries chan<- webhookEvent, pending *atomic.Int32) {
+	defer whatsapp.LogPanic(webhookLog, "forwarding a "+evt.Type+" event")
+	body, err := json.Marshal(evt)
+	if err != nil {
+		webhookLog.Errorf("Marshaling %s event: %v", evt.Type, err)
+		return
+	}
+	queued := webhookEvent{Type: evt.Type, Body: body}
+	if pending.Load() == 0 {
+		retry, err := postWebhook(ctx, t, evt.Type, body)
+		if err == nil {
+			noteWebhookDelivery()
+			return
+		}
+		noteWebhookError(err)
+		if !retry {
+			deadLetterWebhook(client, err, 1, t, evt.Type, body)
+			return
+		}
+		queued.Attempts, queued.Err = 1, err
+	}
+	pending.Add(1)
+	select {
+	case retries <- queued:
+	default:
+		pending.Add(-1)
+		err := fmt.Errorf("%d events are already waiting to be retried", webhookRetries)
+		deadLetterWebhook(client, err, queued.Attempts, t, evt.Type, body)
+	}
+}
+
+// retryWebhook delivers an event forwardWebhook could not, with the usual
+// retries. Events still queued when ctx ends are kept as dead letters
+// without another attempt.
+func retryWebhook(ctx context.Context, client *whatsapp.WhatsAppClient, t webhookTarget, evt webhookEvent, pending *atomic.Int32) {
+	defer pending.Add(-1)
+	defer whatsapp.LogPanic(webhookLog, "retrying a "+evt.Type+" event")
+	if ctx.Err() != nil {
+		deadLetterWebhook(client, ctx.Err(), evt.Attempts, t, evt.Type, evt.Body)
+		return
+	}
+	attempts, err := deliverWebhook(ctx, t, evt.Type, evt.Body, evt.Attempts, evt.Err)
+	if err != nil {
+		deadLetterWebhook(client, err, attempts, t, evt.Type, evt.Body)
+	}
+}
+
+// deadLetterWebhook keeps an event that could not be delivered
+func deadLetterWebhook(client *whatsapp.WhatsAppClient, err error, attempts int, t webhookTarget, eventType string, body []byte) {
+	webhookLog.Errorf("Delivering %s event after %d attempt(s): %v", eventType, attempts, err)
+	noteWebhookDeadLetter()
+	client.AddDeadLetter(context.Background(), err, attempts, "webhook", t.url, eventType, string(body))
+}
+
+// replayWebhook delivers a dead letter of forwardWebhook again, signed with
+// the current secret. args are the URL, the event type and the body.
+func replayWebhook(ctx context.Context, client *whatsapp.WhatsAppClient, account string, args []string) (interface{}, error) {
+	if len(args) != 3 {
+		err := fmt.Errorf("webhook dead letter has %d args, want 3", len(args))
+		return WebhookDelivery{Success: false, Message: err.Error()}, err
+	}
+	webhookState.Lock()
+	t := webhookTarget{account: account, url: args[0], secret: webhookState.secret}
+	webhookState.Unlock()
+	attempts, err := deliverWebhook(ctx, t, args[1], []byte(args[2]), 0, nil)
+	if err != nil {
+		return WebhookDelivery{Success: false, Message: err.Error(), Attempts: attempts},
+			client.AddDeadLetter(ctx, err, attempts, "webhook", args...)
+	}
+	return WebhookDelivery{Success: true, Message: "Delivered " + args[1] + " event to " + whatsapp.RedactURL(args[0]), Attempts: attempts}, nil
+}
+
+// deliverWebhook POSTs body, retrying with backoff on network errors,
+// 429 and 5xx responses. made attempts already failed with lastErr, so the
+// first one here waits its backoff. It returns the attempts made in all and
+// the last error.
+func deliverWebhook(ctx context.Context, t webhookTarget, eventType string, body []byte, made int, lastErr error) (int, error) {
+	for attempt := made + 1; ; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(webhookBackoff << (attempt - 2)):
+			case <-ctx.Done():
+				return attempt - 1, lastErr
+			}
+			webhookState.Lock()
+			webhookState.retried++
+			webhookState.Unlock()
+		}
+		retry, err := postWebhook(ctx, t, eventType, body)
+		if err == nil {
+			noteWebhookDelivery()
+			return attempt, nil
+		}
+		noteWebhookError(err)
+		if !retry || attempt >= webhookAttempts {
+			return attempt, err
+		}
+		lastErr = err
+	}
+}
+
+// postWebhook makes one delivery attempt, reporting whether a failure is
+// worth retrying
+func postWebhook(ctx context.Context, t webhookTarget, eventType string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bb-whatsapp-pod")
+	req.Header.Set("X-Webhook-Event", eventType)
+	req.Header.Set(webhookAccountHeader, t.account)
+	if t.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhookSignatureHeader, signWebhook(t.secret, body))
+		req.Header.Set(webhookTimestampHeader, timestamp)
+		req.Header.Set(webhookTimestampSignatureHeader, signWebhook(t.secret, []byte(timestamp+"."+string(body))))
+	}
+	resp, err := webhookHTTP.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("rejected with status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("rejected with status %s", resp.Status)
+	}
+}
+
+// signWebhook returns the signature header value of body
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func noteWebhookDelivery() {
+	webhookState.Lock()
+	defer webhookState.Unlock()
+	webhookState.delivered++
+}
+
+func noteWebhookError(err error) {
+	webhookState.Lock()
+	defer webhookState.Unlock()
+	webhookState.lastError, webhookState.lastErrorAt = err.Error(), time.Now()
+}
+
+func noteWebhookDeadLetter() {
+	webhookState.Lock()
+	defer webhookState.Unlock()
+	webhookState.deadLettered++
+}
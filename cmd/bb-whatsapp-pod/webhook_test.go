@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		signed string // what the receiver HMACs
+		want   string
+	}{
+		{
+			// The example in GitHub's webhook documentation
+			name:   "body",
+			secret: "It's a Secret to Everybody",
+			signed: "Hello, World!",
+			want:   "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+		},
+		{
+			name:   "event body",
+			secret: "s3cret",
+			signed: `{"type":"message"}`,
+			want:   "sha256=bc38a917440101a99edb3fa173a5c1262cd8d8e58e0d7cd26fdf5c3fc5bc4383",
+		},
+		{
+			name:   "timestamp and body",
+			secret: "s3cret",
+			signed: `1718000000.{"type":"message"}`,
+			want:   "sha256=ba11bfef31fe9e98f13e0688efc26090ff655be00f29b0ecbf6ac2db8fd2506f",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signWebhook(tt.secret, []byte(tt.signed)); got != tt.want {
+				t.Errorf("signWebhook = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// webhookReceiver answers with statuses in turn, repeating the last one,
+// and checks the headers of every request it gets
+type webhookReceiver struct {
+	t        *testing.T
+	secret   string
+	statuses []int
+
+	mu       sync.Mutex
+	requests int
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	if got := req.Header.Get("X-Webhook-Event"); got != "message" {
+		r.t.Errorf("X-Webhook-Event = %q, want message", got)
+	}
+	if got := req.Header.Get(webhookAccountHeader); got != "sales" {
+		r.t.Errorf("%s = %q, want sales", webhookAccountHeader, got)
+	}
+	mac := hmac.New(sha256.New, []byte(r.secret))
+	mac.Write([]byte(req.Header.Get(webhookTimestampHeader) + "." + string(body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.Header.Get(webhookTimestampSignatureHeader) != want {
+		r.t.Errorf("%s = %q, want %q", webhookTimestampSignatureHeader, req.Header.Get(webhookTimestampSignatureHeader), want)
+	}
+
+	r.mu.Lock()
+	status := r.statuses[min(r.requests, len(r.statuses)-1)]
+	r.requests++
+	r.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+// TestForwardWebhook delivers one event through forwardWebhook, retrying
+// and dead-lettering it as the receiver's answers call for
+func TestForwardWebhook(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	tests := []struct {
+		name             string
+		statuses         []int
+		wantRequests     int
+		wantDelivered    int64
+		wantRetried      int64
+		wantDeadLettered int64
+	}{
+		{name: "delivered", statuses: []int{http.StatusNoContent}, wantRequests: 1, wantDelivered: 1},
+		{name: "retried after 503", statuses: []int{503, 200}, wantRequests: 2, wantDelivered: 1, wantRetried: 1},
+		{name: "retried after 429", statuses: []int{429, 429, 200}, wantRequests: 3, wantDelivered: 1, wantRetried: 2},
+		{name: "dead letter after the last attempt", statuses: []int{500}, wantRequests: webhookAttempts, wantRetried: webhookAttempts - 1, wantDeadLettered: 1},
+		{name: "dead letter without retries on 400", statuses: []int{400}, wantRequests: 1, wantDeadLettered: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &webhookReceiver{t: t, secret: "s3cret", statuses: tt.statuses}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+			fake := whatsapp.NewFake(types.NewJID("15550001111", types.DefaultUserServer))
+			client := whatsapp.NewClientWithMessenger(fake, whatsapp.Options{})
+
+			before := webhookStatus()
+			events := make(chan whatsapp.Event, 1)
+			events <- whatsapp.Event{Type: "message", Timestamp: 1718000000, Data: map[string]string{"text": "hi"}}
+			close(events)
+			// Returns once the event is delivered or kept as a dead letter
+			forwardWebhook(context.Background(), client, webhookTarget{account: "sales", url: server.URL, secret: "s3cret"}, events)
+			after := webhookStatus()
+
+			if receiver.requests != tt.wantRequests {
+				t.Errorf("receiver got %d requests, want %d", receiver.requests, tt.wantRequests)
+			}
+			if got := after.Delivered - before.Delivered; got != tt.wantDelivered {
+				t.Errorf("delivered %d, want %d", got, tt.wantDelivered)
+			}
+			if got := after.Retried - before.Retried; got != tt.wantRetried {
+				t.Errorf("retried %d times, want %d", got, tt.wantRetried)
+			}
+			if got := after.DeadLettered - before.DeadLettered; got != tt.wantDeadLettered {
+				t.Errorf("dead-lettered %d, want %d", got, tt.wantDeadLettered)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/whatsapp"
+)
+
+var wsLog = whatsapp.NewLogger("WebSocket") // logs of the WebSocket event stream
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     checkWebSocketOrigin,
+}
+
+// wsOrigins are the origins, besides the pod's own, whose pages may open
+// /ws, e.g. https://dashboard.example.com
+var wsOrigins []string
+
+// checkWebSocketOrigin lets a browser page open /ws only when it is served
+// by the pod itself or by one of wsOrigins. Any page the user visits could
+// otherwise read their messages through a socket to localhost. Clients
+// that are not browsers send no Origin and are let through.
+func checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range wsOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// eventSubscription is what a WebSocket client asked to receive. Empty
+// lists match everything.
+type eventSubscription struct {
+	Types []string `json:"types"`
+	Chats []string `json:"chats"`
+}
+
+// matches reports whether evt passes the filter
+func (s eventSubscription) matches(evt whatsapp.Event) bool {
+	if len(s.Types) > 0 && !containsString(s.Types, evt.Type) {
+		return false
+	}
+	if len(s.Chats) > 0 {
+		chat := eventChat(evt)
+		if chat == "" || !containsString(s.Chats, chat) {
+			return false
+		}
+	}
+	return true
+}
+
+// eventChat returns the chat an event belongs to, if any
+func eventChat(evt whatsapp.Event) string {
+	switch data := evt.Data.(type) {
+	case *whatsapp.MessageInfo:
+		return data.ChatID
+	case whatsapp.ReceiptInfo:
+		return data.ChatID
+	case whatsapp.ChatPresenceInfo:
+		return data.ChatID
+	case whatsapp.PresenceEventInfo:
+		return data.JID
+	}
+	return ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList parses a comma-separated query parameter
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// handleWebSocketEvents streams client events as JSON text frames. The
+// initial filter comes from the types and chats query parameters (account
+// picks an account other than the default one); the
+// client can replace it at any time by sending
+// {"types": ["message"], "chats": ["123@s.whatsapp.net"]}.
+func handleWebSocketEvents(w http.ResponseWriter, r *http.Request) {
+	client, err := accountClient(r.URL.Query().Get("account"))
+	if errors.Is(err, errUnknownAccount) {
+		writeHTTPError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeHTTPError(w, http.StatusServiceUnavailable, "Failed to initialize WhatsApp client: "+err.Error())
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		wsLog.Errorf("Upgrading connection from %s: %v", r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+	wsLog.Infof("Event stream opened by %s", r.RemoteAddr)
+
+	var filterMutex sync.Mutex
+	filter := eventSubscription{
+		Types: splitList(r.URL.Query().Get("types")),
+		Chats: splitList(r.URL.Query().Get("chats")),
+	}
+
+	// Reader: applies filter updates and notices when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		defer whatsapp.LogPanic(wsLog, "reading from "+r.RemoteAddr)
+		for {
+			var next eventSubscription
+			if err := conn.ReadJSON(&next); err != nil {
+				if _, isClose := err.(*websocket.CloseError); !isClose {
+					wsLog.Infof("Read from %s ended: %v", r.RemoteAddr, err)
+				}
+				return
+			}
+			filterMutex.Lock()
+			filter = next
+			filterMutex.Unlock()
+			wsLog.Infof("%s subscribed to types=%v chats=%v", r.RemoteAddr, next.Types, next.Chats)
+		}
+	}()
+
+	events, unsubscribe := client.Subscribe(256)
+	defer unsubscribe()
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			wsLog.Infof("Event stream closed by %s", r.RemoteAddr)
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case evt, ok := <-events:
+			if !ok {
+				return
+			}
+			filterMutex.Lock()
+			match := filter.matches(evt)
+			filterMutex.Unlock()
+			if !match {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(evt); err != nil {
+				wsLog.Errorf("Writing to %s: %v", r.RemoteAddr, err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package babashka
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"olympos.io/encoding/edn"
+)
+
+// Format is the payload format announced in describe, in which babashka
+// encodes invoke args and decodes values and ex-data. The pod works in
+// JSON either way: a Format translates args from the wire to JSON and
+// values from JSON to the wire.
+type Format interface {
+	Name() string                              // the describe "format"
+	ArgsToJSON(payload string) (string, error) // invoke args, a vector, as a JSON array
+	ValueFromJSON(value string) (string, error)
+}
+
+// JSON passes payloads through as they are. Babashka keywordizes map keys
+// but turns keywords and sets in args into strings and lists.
+var JSON Format = jsonFormat{}
+
+// EDN keeps keywords (as map keys and values of args) and big integers,
+// and accepts sets, at the cost of a slower encoding
+var EDN Format = ednFormat{}
+
+// FormatByName returns the Format for a describe format name
+func FormatByName(name string) (Format, error) {
+	switch name {
+	case "json":
+		return JSON, nil
+	case "edn":
+		return EDN, nil
+	}
+	return nil, fmt.Errorf("unsupported pod format %q, expected json or edn", name)
+}
+
+var formatMutex sync.Mutex
+var currentFormat = JSON
+
+// SetFormat picks the payload format. It must be set before the describe
+// response is written, since babashka keeps the format it was told.
+func SetFormat(f Format) {
+	formatMutex.Lock()
+	defer formatMutex.Unlock()
+	currentFormat = f
+}
+
+// CurrentFormat returns the payload format in use
+func CurrentFormat() Format {
+	formatMutex.Lock()
+	defer formatMutex.Unlock()
+	return currentFormat
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) Name() string                               { return "json" }
+func (jsonFormat) ArgsToJSON(payload string) (string, error)  { return payload, nil }
+func (jsonFormat) ValueFromJSON(value string) (string, error) { return value, nil }
+
+type ednFormat struct{}
+
+func (ednFormat) Name() string { return "edn" }
+
+// ArgsToJSON turns keywords and symbols into their names, sets into lists
+// and tagged values (#inst, #uuid) into strings, as the JSON format does
+func (ednFormat) ArgsToJSON(payload string) (string, error) {
+	if strings.TrimSpace(payload) == "" {
+		return payload, nil
+	}
+	var raw interface{}
+	if err := edn.UnmarshalString(payload, &raw); err != nil {
+		return "", err
+	}
+	value, err := ednToJSON(raw)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// ValueFromJSON keywordizes map keys that read back as keywords, as the
+// JSON format does on the babashka side, and keeps integers exact
+func (ednFormat) ValueFromJSON(value string) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(value)))
+	dec.UseNumber()
+	var raw interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return "", err
+	}
+	data, err := edn.Marshal(jsonToEDN(raw))
+	return string(data), err
+}
+
+func ednToJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			key, err := ednToJSON(k)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := key.(string)
+			if !ok {
+				s = fmt.Sprint(key)
+			}
+			if m[s], err = ednToJSON(item); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case map[interface{}]bool: // a set
+		list := make([]interface{}, 0, len(v))
+		for item := range v {
+			converted, err := ednToJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, converted)
+		}
+		sort.Slice(list, func(i, j int) bool { return fmt.Sprint(list[i]) < fmt.Sprint(list[j]) })
+		return list, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = ednToJSON(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case edn.Keyword:
+		return string(v), nil
+	case edn.Symbol:
+		return string(v), nil
+	case edn.Rune:
+		return string(rune(v)), nil
+	case rune: // characters decode as runes into interface{}
+		return string(v), nil
+	case edn.Tag:
+		return ednToJSON(v.Value)
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case big.Int:
+		return json.Number(v.String()), nil
+	case *big.Int:
+		return json.Number(v.String()), nil
+	case big.Float:
+		return json.Number(v.Text('g', -1)), nil
+	case *big.Float:
+		return json.Number(v.Text('g', -1)), nil
+	case int64:
+		return json.Number(fmt.Sprint(v)), nil
+	case nil, bool, float64, string:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported EDN value %v (%T)", v, v)
+}
+
+// ednKeyword matches the keys that read back as keywords
+var ednKeyword = regexp.MustCompile(`^[A-Za-z*+!_?<>=.-][A-Za-z0-9*+!_?<>=.'-]*(/[A-Za-z*+!_?<>=.-][A-Za-z0-9*+!_?<>=.'-]*)?$`)
+
+func jsonToEDN(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, item := range v {
+			if ednKeyword.MatchString(k) {
+				m[edn.Keyword(k)] = jsonToEDN(item)
+			} else {
+				m[k] = jsonToEDN(item)
+			}
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = jsonToEDN(item)
+		}
+		return list
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if n, ok := new(big.Int).SetString(string(v), 10); ok {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
@@ -0,0 +1,89 @@
+package babashka
+
+import "testing"
+
+func TestEDNValueFromJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string // JSON, as a handler result
+		want  string // EDN, as babashka reads it
+	}{
+		{name: "nil", value: `null`, want: `nil`},
+		{name: "string", value: `"hi \"there\""`, want: `"hi \"there\""`},
+		{name: "keyword key", value: `{"success":true}`, want: `{:success true}`},
+		{name: "namespaced keyword key", value: `{"pod.whatsapp/invoke":1}`, want: `{:pod.whatsapp/invoke 1}`},
+		{name: "string key", value: `{"1 2":null}`, want: `{"1 2"nil}`},
+		{name: "nested map", value: `{"a":{"b":[{"c":"d"},null]}}`, want: `{:a{:b[{:c"d"}nil]}}`},
+		{name: "big integer", value: `12345678901234567890`, want: `12345678901234567890N`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EDN.ValueFromJSON(tt.value)
+			if err != nil {
+				t.Fatalf("ValueFromJSON(%s): %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("ValueFromJSON(%s) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEDNArgsToJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string // EDN, as babashka writes invoke args
+		want    string // JSON, as handlers read them
+		wantErr bool
+	}{
+		{name: "empty", args: ``, want: ``},
+		{name: "nil", args: `[nil]`, want: `[null]`},
+		{name: "string", args: `["hi \"there\""]`, want: `["hi \"there\""]`},
+		{name: "keyword", args: `[:image :pod.whatsapp/invoke]`, want: `["image","pod.whatsapp/invoke"]`},
+		{name: "nested map", args: `[{:a {:b [{:c "d"} nil]}}]`, want: `[{"a":{"b":[{"c":"d"},null]}}]`},
+		{name: "set", args: `[#{"b" "a"}]`, want: `[["a","b"]]`},
+		{name: "big integer", args: `[12345678901234567890N]`, want: `[12345678901234567890]`},
+		{name: "invalid", args: `[{:a}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EDN.ArgsToJSON(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ArgsToJSON(%s) = %s, want an error", tt.args, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ArgsToJSON(%s): %v", tt.args, err)
+			}
+			if got != tt.want {
+				t.Errorf("ArgsToJSON(%s) = %s, want %s", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEDNRoundTrip sends results back through the args encoding, as a
+// script passing one var's result to another does
+func TestEDNRoundTrip(t *testing.T) {
+	for _, value := range []string{
+		`null`,
+		`"hi"`,
+		`{"status":"connected"}`,
+		`{"a":{"b":[{"c":"d"},null],"e":"f"}}`,
+		`{"1 2":[true,false]}`,
+	} {
+		edn, err := EDN.ValueFromJSON(value)
+		if err != nil {
+			t.Fatalf("ValueFromJSON(%s): %v", value, err)
+		}
+		got, err := EDN.ArgsToJSON("[" + edn + "]")
+		if err != nil {
+			t.Fatalf("ArgsToJSON([%s]): %v", edn, err)
+		}
+		if want := "[" + value + "]"; got != want {
+			t.Errorf("%s came back as %s, want %s", value, got, want)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package babashka
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/jackpal/bencode-go"
 )
@@ -17,105 +20,178 @@ type Message struct {
 	Id   string
 	Args string
 	Var  string
+
+	// Binary and Data are not sent by babashka, which decodes every value in
+	// the pod's format, but let other protocol clients move bytes as raw
+	// bencode byte strings instead of base64
+	Binary string // "raw" streams binary results as bare byte strings
+	Data   string // the bytes of a bytes arg passed as nil
 }
 
 type Namespace struct {
-	Name string "name"
-	Vars []Var  "vars"
+	Name string `bencode:"name"`
+	Vars []Var  `bencode:"vars"`
 }
 
 type Var struct {
-	Name string "name"
-	Code string `bencode:"code,omitempty"`
+	Name  string `bencode:"name"`
+	Code  string `bencode:"code,omitempty"`  // Clojure source babashka evaluates instead of invoking the pod
+	Async string `bencode:"async,omitempty"` // "true" for vars that stream multiple values
+	Meta  string `bencode:"meta,omitempty"`  // an EDN map merged into the var's metadata, e.g. {:doc "..."}
 }
 
+// OpInfo is the (currently empty) per-op entry of the describe "ops" map
+type OpInfo struct{}
+
 type DescribeResponse struct {
-	Format     string      "format"
-	Namespaces []Namespace "namespaces"
-}
-
-// Add new operations for group functionality
-var whatsappNamespace = Namespace{
-	Name: "pod.whatsapp",
-	Vars: []Var{
-		{Name: "login", Code: "Login"},
-		{Name: "logout", Code: "Logout"},
-		{Name: "status", Code: "Status"},
-		{Name: "send-message", Code: "SendMessage"},
-		{Name: "get-groups", Code: "GetGroups"},
-		{Name: "send-group-message", Code: "SendGroupMessage"},
-		{Name: "upload", Code: "Upload"},
-		{Name: "send-image", Code: "SendImage"},
-		{Name: "send-document", Code: "SendDocument"},
-		{Name: "send-video", Code: "SendVideo"},
-		{Name: "send-audio", Code: "SendAudio"},
-		{Name: "get-contact-info", Code: "GetContactInfo"},
-		{Name: "get-profile-picture", Code: "GetProfilePicture"},
-		{Name: "set-status", Code: "SetStatus"},
-		{Name: "get-status", Code: "GetStatus"},
-		{Name: "set-presence", Code: "SetPresence"},
-		{Name: "subscribe-presence", Code: "SubscribePresence"},
-		{Name: "get-chat-history", Code: "GetChatHistory"},
-		{Name: "get-unread-messages", Code: "GetUnreadMessages"},
-		{Name: "mark-message-as-read", Code: "MarkMessageAsRead"},
-		{Name: "delete-message", Code: "DeleteMessage"},
-		{Name: "create-group", Code: "CreateGroup"},
-		{Name: "leave-group", Code: "LeaveGroup"},
-		{Name: "get-group-invite-link", Code: "GetGroupInviteLink"},
-		{Name: "join-group-with-link", Code: "JoinGroupWithLink"},
-		{Name: "set-group-name", Code: "SetGroupName"},
-		{Name: "set-group-topic", Code: "SetGroupTopic"},
-		{Name: "add-group-participants", Code: "AddGroupParticipants"},
-		{Name: "remove-group-participants", Code: "RemoveGroupParticipants"},
-		{Name: "promote-group-participants", Code: "PromoteGroupParticipants"},
-		{Name: "demote-group-participants", Code: "DemoteGroupParticipants"},
-	},
+	Format     string            `bencode:"format"`
+	Namespaces []Namespace       `bencode:"namespaces"`
+	Ops        map[string]OpInfo `bencode:"ops,omitempty"`
+	Metadata   map[string]string `bencode:"metadata,omitempty"` // pod-level capability flags
 }
 
 type InvokeResponse struct {
-	Id     string   "id"
-	Value  string   "value" // stringified json response
-	Status []string "status"
+	Id     string   `bencode:"id"`
+	Value  string   `bencode:"value"` // the result, in the pod's Format
+	Status []string `bencode:"status,omitempty"`
 }
 
 type ErrorResponse struct {
-	Id        string   "id"
-	Status    []string "status"
-	ExMessage string   "ex-message"
-	ExData    string   "ex-data,omitempty"
+	Id        string   `bencode:"id"`
+	Status    []string `bencode:"status"`
+	ExMessage string   `bencode:"ex-message"`
+	ExData    string   `bencode:"ex-data,omitempty"`
 }
 
-func ReadMessage() (*Message, error) {
-	reader := bufio.NewReader(os.Stdin)
+// MessageReader decodes consecutive bencode messages from one stream. It
+// keeps a single buffered reader for its whole lifetime, because bytes that
+// a discarded bufio.Reader had already buffered (the start of the next
+// pipelined message) would otherwise be lost.
+type MessageReader struct {
+	reader *bufio.Reader
+}
+
+// NewMessageReader wraps r for reading pod protocol messages
+func NewMessageReader(r io.Reader) *MessageReader {
+	return &MessageReader{reader: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// ReadMessage decodes the next message from the stream
+func (mr *MessageReader) ReadMessage() (*Message, error) {
 	message := &Message{}
-	if err := bencode.Unmarshal(reader, &message); err != nil {
+	if err := bencode.Unmarshal(mr.reader, &message); err != nil {
 		return nil, err
 	}
 
 	return message, nil
 }
 
+// stdinReader is shared by every ReadMessage call for the life of the process
+var stdinReader = NewMessageReader(os.Stdin)
+
+func ReadMessage() (*Message, error) {
+	return stdinReader.ReadMessage()
+}
+
 func WriteDescribeResponse(describeResponse *DescribeResponse) error {
 	return writeResponse(*describeResponse)
 }
 
+// WriteInvokeResponse writes the JSON value of an invoke, converted to the
+// pod's Format. A value that cannot be converted fails the invoke instead.
 func WriteInvokeResponse(inputMessage *Message, value string) error {
+	value, err := CurrentFormat().ValueFromJSON(value)
+	if err != nil {
+		return WriteErrorResponse(inputMessage, fmt.Errorf("encoding the result as %s: %w", CurrentFormat().Name(), err))
+	}
 	response := InvokeResponse{Id: inputMessage.Id, Status: []string{"done"}, Value: value}
 
 	return writeResponse(response)
 }
 
+// WriteChunkResponse writes an intermediate value for an async invoke.
+// The babashka client hands it to the var's :success callback and keeps
+// waiting, since the response carries no "done" status.
+func WriteChunkResponse(inputMessage *Message, value string) error {
+	value, err := CurrentFormat().ValueFromJSON(value)
+	if err != nil {
+		return err
+	}
+	response := InvokeResponse{Id: inputMessage.Id, Value: value}
+
+	return writeResponse(response)
+}
+
+// WriteRawChunkResponse writes an intermediate value for an async invoke
+// as a bare bencode byte string, without converting it to the pod's Format
+func WriteRawChunkResponse(inputMessage *Message, data []byte) error {
+	response := struct {
+		Id    string `bencode:"id"`
+		Value []byte `bencode:"value"`
+	}{Id: inputMessage.Id, Value: data}
+
+	return writeResponse(response)
+}
+
+// WriteDoneResponse terminates an async invoke without sending a value.
+func WriteDoneResponse(inputMessage *Message) error {
+	response := struct {
+		Id     string   `bencode:"id"`
+		Status []string `bencode:"status"`
+	}{Id: inputMessage.Id, Status: []string{"done"}}
+
+	return writeResponse(response)
+}
+
+// WriteWarnings sends non-fatal warnings for an invoke. The text goes in the
+// "err" key, which the babashka client prints to *err*, and the raw list is
+// kept under "warnings" for tooling. No value or status is sent, so the
+// invoke's real response still follows.
+func WriteWarnings(inputMessage *Message, warnings []string) error {
+	var text strings.Builder
+	for _, w := range warnings {
+		text.WriteString("WARNING: ")
+		text.WriteString(w)
+		text.WriteString("\n")
+	}
+	response := struct {
+		Id       string   `bencode:"id"`
+		Err      string   `bencode:"err"`
+		Warnings []string `bencode:"warnings"`
+	}{Id: inputMessage.Id, Err: text.String(), Warnings: warnings}
+
+	return writeResponse(response)
+}
+
 func WriteErrorResponse(inputMessage *Message, err error) error {
+	return WriteErrorResponseWithData(inputMessage, err, "")
+}
+
+// WriteErrorResponseWithData is WriteErrorResponse with a JSON ex-data
+// payload, converted to the pod's Format
+func WriteErrorResponseWithData(inputMessage *Message, err error, exData string) error {
+	if exData != "" {
+		var convErr error
+		if exData, convErr = CurrentFormat().ValueFromJSON(exData); convErr != nil {
+			exData = ""
+		}
+	}
 	errorMessage := string(err.Error())
 	errorResponse := ErrorResponse{
 		Id:        inputMessage.Id,
 		Status:    []string{"done", "error"},
 		ExMessage: errorMessage,
+		ExData:    exData,
 	}
 	return writeResponse(errorResponse)
 }
 
+// writeMutex keeps responses from concurrent invokes from interleaving on stdout
+var writeMutex sync.Mutex
+
 func writeResponse(response interface{}) error {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
 	writer := bufio.NewWriter(os.Stdout)
 	if err := bencode.Marshal(writer, response); err != nil {
 		return err
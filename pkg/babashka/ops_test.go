@@ -0,0 +1,116 @@
+package babashka
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jackpal/bencode-go"
+)
+
+// encode bencodes each message back to back into one buffer, as babashka
+// writes pipelined requests
+func encode(t *testing.T, messages ...map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, m := range messages {
+		if err := bencode.Marshal(&buf, m); err != nil {
+			t.Fatalf("encoding %v: %v", m, err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestReadMessage(t *testing.T) {
+	large := `["` + strings.Repeat("x", 200*1024) + `"]`
+	tests := []struct {
+		name     string
+		input    []byte
+		want     []Message
+		wantTail error // what reading past the last message returns
+	}{
+		{
+			name: "pipelined",
+			input: encode(t,
+				map[string]string{"op": "describe"},
+				map[string]string{"op": "invoke", "id": "1", "var": "pod.whatsapp/status", "args": "[]"},
+				map[string]string{"op": "invoke", "id": "2", "var": "pod.whatsapp/send-message", "args": `["123","hi"]`},
+				map[string]string{"op": "shutdown"},
+			),
+			want: []Message{
+				{Op: "describe"},
+				{Op: "invoke", Id: "1", Var: "pod.whatsapp/status", Args: "[]"},
+				{Op: "invoke", Id: "2", Var: "pod.whatsapp/send-message", Args: `["123","hi"]`},
+				{Op: "shutdown"},
+			},
+			wantTail: io.EOF,
+		},
+		{
+			name: "larger than the read buffer",
+			input: encode(t,
+				map[string]string{"op": "invoke", "id": "1", "var": "pod.whatsapp/send-message", "args": large},
+				map[string]string{"op": "invoke", "id": "2", "var": "pod.whatsapp/status", "args": "[]"},
+			),
+			want: []Message{
+				{Op: "invoke", Id: "1", Var: "pod.whatsapp/send-message", Args: large},
+				{Op: "invoke", Id: "2", Var: "pod.whatsapp/status", Args: "[]"},
+			},
+			wantTail: io.EOF,
+		},
+		{
+			name: "truncated",
+			input: func() []byte {
+				data := encode(t,
+					map[string]string{"op": "describe"},
+					map[string]string{"op": "invoke", "id": "1", "var": "pod.whatsapp/status", "args": "[]"},
+				)
+				return data[:len(data)-5]
+			}(),
+			want:     []Message{{Op: "describe"}},
+			wantTail: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Deliver the input a few bytes at a time, so messages straddle reads
+			mr := NewMessageReader(&chunkedReader{data: tt.input, chunk: 7})
+			for i, want := range tt.want {
+				got, err := mr.ReadMessage()
+				if err != nil {
+					t.Fatalf("message %d: %v", i, err)
+				}
+				if *got != want {
+					t.Errorf("message %d = %+v, want %+v", i, truncated(*got), truncated(want))
+				}
+			}
+			if _, err := mr.ReadMessage(); !errors.Is(err, tt.wantTail) {
+				t.Errorf("reading past the last message: got error %v, want %v", err, tt.wantTail)
+			}
+		})
+	}
+}
+
+// chunkedReader returns at most chunk bytes per Read
+type chunkedReader struct {
+	data  []byte
+	chunk int
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), r.chunk)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// truncated shortens the args of m for test failure messages
+func truncated(m Message) Message {
+	if len(m.Args) > 40 {
+		m.Args = m.Args[:40] + "..."
+	}
+	return m
+}
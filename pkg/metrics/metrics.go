@@ -0,0 +1,164 @@
+// Package metrics is a minimal Prometheus text-format registry. It covers the
+// handful of counters, histograms and gauges the pod exports without pulling
+// in the full client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is anything that can render itself in the text exposition format
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      []metric
+	names         = map[string]bool{}
+)
+
+func register(name string, m metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if names[name] {
+		panic("duplicate metric registration: " + name)
+	}
+	names[name] = true
+	registry = append(registry, m)
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	name, help string
+	value      atomic.Uint64
+}
+
+// NewCounter registers a counter
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	register(name, c)
+	return c
+}
+
+// Inc adds one
+func (c *Counter) Inc() { c.value.Add(1) }
+
+// Add adds n
+func (c *Counter) Add(n uint64) { c.value.Add(n) }
+
+// Value returns the current count
+func (c *Counter) Value() uint64 { return c.value.Load() }
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value.Load())
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	name, help string
+	mu         sync.Mutex
+	buckets    []float64 // upper bounds, ascending
+	counts     []uint64
+	sum        float64
+	count      uint64
+}
+
+// DefBuckets suit latencies measured in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogram registers a histogram with the given bucket upper bounds
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &Histogram{name: name, help: help, buckets: b, counts: make([]uint64, len(b))}
+	register(name, h)
+	return h
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, formatFloat(h.sum), h.name, h.count)
+}
+
+// GaugeFunc reports values computed at scrape time, one series per label value
+type GaugeFunc struct {
+	name, help, label string
+	fn                func() map[string]float64
+}
+
+// NewGaugeFunc registers a gauge whose series are read from fn on every
+// scrape. Each key of the returned map becomes the value of label.
+func NewGaugeFunc(name, help, label string, fn func() map[string]float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, label: label, fn: fn}
+	register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	values := g.fn()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", g.name, g.label, escapeLabel(k), formatFloat(values[k]))
+	}
+}
+
+// Write renders every registered metric in the Prometheus text format
+func Write(w io.Writer) {
+	registryMutex.Lock()
+	metrics := append([]metric(nil), registry...)
+	registryMutex.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the registry, typically on /metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", f)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
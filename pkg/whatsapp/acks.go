@@ -0,0 +1,71 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// sendAcked hands a message to whatsmeow, which returns once the server acks
+// it. When no ack arrives within Options.AckTimeout, e.g. because the
+// connection died silently, the message is sent again with the same ID, up
+// to Options.AckRetries times. WhatsApp drops a second copy of an ID it
+// already has, so a resend cannot deliver the message twice.
+func (wac *WhatsAppClient) sendAcked(ctx context.Context, to types.JID, msg *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	opts := wac.Options()
+	var req whatsmeow.SendRequestExtra
+	if len(extra) > 0 {
+		req = extra[0]
+	}
+	if req.ID == "" {
+		req.ID = wac.Client.GenerateMessageID()
+	}
+	if req.Timeout == 0 {
+		req.Timeout = opts.AckTimeout
+	}
+
+	stats := retryStatsFrom(ctx)
+	for {
+		var resp whatsmeow.SendResponse
+		err := wac.withThrottleRetry(ctx, "send to "+to.String(), func() error {
+			start := time.Now()
+			callCtx, cancel := wac.withCallTimeout(ctx)
+			defer cancel()
+			var err error
+			resp, err = wac.Client.SendMessage(callCtx, to, msg, req)
+			metricSendLatency.Observe(time.Since(start).Seconds())
+			return err
+		})
+		if !errors.Is(err, whatsmeow.ErrMessageTimedOut) || stats.AckRetries >= opts.AckRetries {
+			return resp, err
+		}
+		stats.AckRetries++
+		metricAckResends.Inc()
+		logger.For(ctx).Warnf("No server ack for %s to %s, resending (%d/%d)", req.ID, to, stats.AckRetries, opts.AckRetries)
+		if err := wac.waitConnected(ctx, req.Timeout); err != nil {
+			return resp, err
+		}
+	}
+}
+
+// waitConnected gives a dropped connection up to max to come back before a
+// resend. It only fails when ctx ends; a resend on a connection still down
+// fails on its own.
+func (wac *WhatsAppClient) waitConnected(ctx context.Context, max time.Duration) error {
+	if max <= 0 {
+		max = time.Minute
+	}
+	deadline := time.Now().Add(max)
+	for !wac.Client.IsLoggedIn() && time.Now().Before(deadline) {
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
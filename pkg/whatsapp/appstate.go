@@ -0,0 +1,378 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/proto/waSyncAction"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxPatchMutations bounds the mutations of one raw patch
+const maxPatchMutations = 100
+
+// AppStateMutation is one mutation of a raw app state patch. Index is the
+// thing mutated followed by its parameters, e.g. ["mute", "123@s.whatsapp.net"];
+// Value is a SyncActionValue in protobuf JSON, e.g. {"muteAction": {"muted": true}}.
+type AppStateMutation struct {
+	Index   []string        `json:"index"`
+	Version int32           `json:"version,omitempty"` // may be left out for indexes with a known version
+	Value   json.RawMessage `json:"value"`
+}
+
+// AppStatePatch is a raw patch for SendAppStatePatch. All its mutations
+// must belong to the app state type named by Type.
+type AppStatePatch struct {
+	Type      string             `json:"type"` // critical_block, critical_unblock_low, regular, regular_low or regular_high
+	Mutations []AppStateMutation `json:"mutations"`
+	Force     bool               `json:"force,omitempty"` // allow indexes the pod does not know
+}
+
+// AppStatePatchResult is returned by SendAppStatePatch
+type AppStatePatchResult struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Mutations int    `json:"mutations,omitempty"`
+	Version   uint64 `json:"version,omitempty"` // the app state version after the patch
+}
+
+// AppStateEntry is the latest value synced for one index
+type AppStateEntry struct {
+	Index     []string        `json:"index"`
+	Value     json.RawMessage `json:"value"`
+	UpdatedAt int64           `json:"updated_at"`
+}
+
+// AppStateInfo is the synced state of one app state type
+type AppStateInfo struct {
+	Name    string          `json:"name"`
+	Version uint64          `json:"version"` // 0 until the type was synced
+	Entries []AppStateEntry `json:"entries"`
+}
+
+// AppStateResult is returned by GetAppState
+type AppStateResult struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message,omitempty"`
+	States  []AppStateInfo `json:"states"`
+}
+
+// appStateIndex is what the pod knows about one kind of index
+type appStateIndex struct {
+	patch   appstate.WAPatchName
+	version int32 // 0 when the caller must give it
+	target  int   // position of the target JID in the index, 0 for none
+	action  func(*waSyncAction.SyncActionValue) bool
+}
+
+// appStateIndexes are the indexes whatsmeow knows, with the app state type
+// they belong to and, where whatsmeow builds them, their version
+var appStateIndexes = map[string]appStateIndex{
+	appstate.IndexMute: {appstate.WAPatchRegularHigh, 2, 1,
+		func(v *waSyncAction.SyncActionValue) bool { return v.MuteAction != nil }},
+	appstate.IndexPin: {appstate.WAPatchRegularLow, 5, 1,
+		func(v *waSyncAction.SyncActionValue) bool { return v.PinAction != nil }},
+	appstate.IndexArchive: {appstate.WAPatchRegularLow, 3, 1,
+		func(v *waSyncAction.SyncActionValue) bool { return v.ArchiveChatAction != nil }},
+	appstate.IndexContact: {appstate.WAPatchCriticalUnblockLow, 0, 1,
+		func(v *waSyncAction.SyncActionValue) bool { return v.ContactAction != nil }},
+	appstate.IndexClearChat: {appstate.WAPatchRegularHigh, 0, 1,
+		func(v *waSyncAction.SyncActionValue) bool { return v.ClearChatAction != nil }},
+	appstate.IndexDeleteChat: {appstate.WAPatchRegularHigh, 0, 1,
+		func(v *waSyncAction.SyncActionValue) bool { return v.DeleteChatAction != nil }},
+	appstate.IndexStar: {appstate.WAPatchRegularHigh, 2, 1,
+		func(v *waSyncAction.SyncActionValue) bool { return v.StarAction != nil }},
+	appstate.IndexDeleteMessageForMe: {appstate.WAPatchRegularHigh, 0, 1,
+		func(v *waSyncAction.SyncActionValue) bool { return v.DeleteMessageForMeAction != nil }},
+	appstate.IndexMarkChatAsRead: {appstate.WAPatchRegularLow, 0, 1,
+		func(v *waSyncAction.SyncActionValue) bool { return v.MarkChatAsReadAction != nil }},
+	appstate.IndexSettingPushName: {appstate.WAPatchCriticalBlock, 1, 0,
+		func(v *waSyncAction.SyncActionValue) bool { return v.PushNameSetting != nil }},
+	appstate.IndexSettingUnarchiveChats: {appstate.WAPatchRegularLow, 0, 0,
+		func(v *waSyncAction.SyncActionValue) bool { return v.UnarchiveChatsSetting != nil }},
+	appstate.IndexUserStatusMute: {appstate.WAPatchRegularHigh, 0, 1,
+		func(v *waSyncAction.SyncActionValue) bool { return v.UserStatusMuteAction != nil }},
+	appstate.IndexLabelEdit: {appstate.WAPatchRegular, 3, 0,
+		func(v *waSyncAction.SyncActionValue) bool { return v.LabelEditAction != nil }},
+	appstate.IndexLabelAssociationChat: {appstate.WAPatchRegular, 3, 2,
+		func(v *waSyncAction.SyncActionValue) bool { return v.LabelAssociationAction != nil }},
+	appstate.IndexLabelAssociationMessage: {appstate.WAPatchRegular, 3, 2,
+		func(v *waSyncAction.SyncActionValue) bool { return v.LabelAssociationAction != nil }},
+}
+
+// patchName checks an app state type name
+func patchName(name string) (appstate.WAPatchName, error) {
+	for _, known := range appstate.AllPatchNames {
+		if string(known) == name {
+			return known, nil
+		}
+	}
+	var names []string
+	for _, known := range appstate.AllPatchNames {
+		names = append(names, string(known))
+	}
+	return "", fmt.Errorf("unknown app state type %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// buildPatch validates a raw patch and converts it for whatsmeow. A mutation
+// of a known index must be in that index's app state type, target a valid
+// JID and set the matching action; unknown indexes need Force. Errors name
+// the offending mutation.
+func buildPatch(raw AppStatePatch, now time.Time) (appstate.PatchInfo, error) {
+	name, err := patchName(raw.Type)
+	if err != nil {
+		return appstate.PatchInfo{}, err
+	}
+	if len(raw.Mutations) == 0 {
+		return appstate.PatchInfo{}, fmt.Errorf("patch has no mutations")
+	}
+	if len(raw.Mutations) > maxPatchMutations {
+		return appstate.PatchInfo{}, fmt.Errorf("patch has %d mutations, at most %d are allowed", len(raw.Mutations), maxPatchMutations)
+	}
+
+	patch := appstate.PatchInfo{Type: name, Timestamp: now}
+	for i, m := range raw.Mutations {
+		mutation, err := buildMutation(name, m, raw.Force, now)
+		if err != nil {
+			return appstate.PatchInfo{}, fmt.Errorf("mutations[%d]: %w", i, err)
+		}
+		patch.Mutations = append(patch.Mutations, mutation)
+	}
+	return patch, nil
+}
+
+func buildMutation(name appstate.WAPatchName, m AppStateMutation, force bool, now time.Time) (appstate.MutationInfo, error) {
+	if len(m.Index) == 0 || m.Index[0] == "" {
+		return appstate.MutationInfo{}, fmt.Errorf("index is empty")
+	}
+	for _, part := range m.Index[1:] {
+		if strings.Contains(part, "@") {
+			if _, err := types.ParseJID(part); err != nil {
+				return appstate.MutationInfo{}, fmt.Errorf("index: %w", err)
+			}
+		}
+	}
+	if len(m.Value) == 0 {
+		return appstate.MutationInfo{}, fmt.Errorf("value is missing")
+	}
+	value := &waSyncAction.SyncActionValue{}
+	if err := protojson.Unmarshal(m.Value, value); err != nil {
+		return appstate.MutationInfo{}, fmt.Errorf("value: %w", err)
+	}
+
+	known, ok := appStateIndexes[m.Index[0]]
+	switch {
+	case !ok && !force:
+		return appstate.MutationInfo{}, fmt.Errorf("unknown index %q; set force to send it anyway", m.Index[0])
+	case ok:
+		if known.patch != name {
+			return appstate.MutationInfo{}, fmt.Errorf("%s belongs to app state %s, not %s", m.Index[0], known.patch, name)
+		}
+		if known.target > 0 {
+			if len(m.Index) <= known.target {
+				return appstate.MutationInfo{}, fmt.Errorf("%s needs a target JID at index[%d]", m.Index[0], known.target)
+			}
+			if _, err := types.ParseJID(m.Index[known.target]); err != nil || !strings.Contains(m.Index[known.target], "@") {
+				return appstate.MutationInfo{}, fmt.Errorf("index[%d]: %q is not a JID", known.target, m.Index[known.target])
+			}
+		}
+		if !known.action(value) {
+			return appstate.MutationInfo{}, fmt.Errorf("value does not set the action of %s", m.Index[0])
+		}
+		if m.Version == 0 {
+			m.Version = known.version
+		} else if known.version != 0 && m.Version != known.version {
+			return appstate.MutationInfo{}, fmt.Errorf("%s has version %d, not %d", m.Index[0], known.version, m.Version)
+		}
+	}
+	if m.Version <= 0 {
+		return appstate.MutationInfo{}, fmt.Errorf("version is required for %s", m.Index[0])
+	}
+	if value.Timestamp == nil {
+		value.Timestamp = proto.Int64(now.UnixMilli())
+	}
+	return appstate.MutationInfo{Index: m.Index, Version: m.Version, Value: value}, nil
+}
+
+// SendAppStatePatch validates and sends a raw app state patch. A
+// malformed patch could leave the app state of the other devices out of
+// sync, so nothing is sent unless every mutation passes buildPatch.
+func (wac *WhatsAppClient) SendAppStatePatch(ctx context.Context, raw AppStatePatch) (interface{}, error) {
+	if err := wac.sendReady(); err != nil {
+		return AppStatePatchResult{Success: false, Message: "Not logged in"}, err
+	}
+	patch, err := buildPatch(raw, time.Now())
+	if err != nil {
+		return AppStatePatchResult{Success: false, Message: err.Error(), Type: raw.Type}, err
+	}
+	if wac.Options().DryRun {
+		logger.For(ctx).Infof("DRY RUN: would send %s app state patch with %d mutation(s)", patch.Type, len(patch.Mutations))
+		return AppStatePatchResult{Success: true, Message: "Dry run, patch not sent", Type: raw.Type, Mutations: len(patch.Mutations)}, nil
+	}
+
+	err = callContextErr(wac, ctx, "sending app state patch", func() error {
+		return wac.Client.SendAppState(patch)
+	})
+	if err != nil {
+		logger.For(ctx).Errorf("Error sending %s app state patch: %v", patch.Type, err)
+		return AppStatePatchResult{Success: false, Message: err.Error(), Type: raw.Type}, err
+	}
+	version, err := wac.Client.AppStateVersion(patch.Type)
+	if err != nil {
+		logger.For(ctx).Warnf("Reading the %s app state version: %v", patch.Type, err)
+	}
+	logger.For(ctx).Infof("Sent %s app state patch with %d mutation(s)", patch.Type, len(patch.Mutations))
+	return AppStatePatchResult{
+		Success:   true,
+		Message:   "App state patch sent",
+		Type:      raw.Type,
+		Mutations: len(patch.Mutations),
+		Version:   version,
+	}, nil
+}
+
+// appStateStore keeps the latest value synced for each app state index.
+// whatsmeow only keeps the hashes of app state, so this is what get-app-state
+// reads.
+type appStateStore struct {
+	db *sql.DB
+}
+
+func newAppStateStore(db *sql.DB) (*appStateStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS pod_app_state (
+		idx        TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		value      TEXT NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &appStateStore{db: db}, nil
+}
+
+func (s *appStateStore) put(name appstate.WAPatchName, index []string, value string, at time.Time) error {
+	idx, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO pod_app_state (idx, name, value, updated_at) VALUES (?, ?, ?, ?)`,
+		string(idx), string(name), value, at.Unix())
+	return err
+}
+
+// list returns the entries of one app state type, ordered by index
+func (s *appStateStore) list(name appstate.WAPatchName) ([]AppStateEntry, error) {
+	rows, err := s.db.Query(`SELECT idx, value, updated_at FROM pod_app_state WHERE name = ? ORDER BY idx`, string(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AppStateEntry{}
+	for rows.Next() {
+		var e AppStateEntry
+		var idx, value string
+		if err := rows.Scan(&idx, &value, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(idx), &e.Index); err != nil {
+			return nil, fmt.Errorf("app state index %s: %w", idx, err)
+		}
+		e.Value = json.RawMessage(value)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// clear removes the entries of one app state type before a full resync
+func (s *appStateStore) clear(name appstate.WAPatchName) error {
+	_, err := s.db.Exec(`DELETE FROM pod_app_state WHERE name = ?`, string(name))
+	return err
+}
+
+// recordAppState stores a synced mutation, and applies chat setting changes
+// to the chat list. Indexes the pod does not know are kept under the empty
+// type name, which get-app-state does not list.
+func (wac *WhatsAppClient) recordAppState(evt *events.AppState) {
+	if len(evt.Index) == 0 || evt.SyncActionValue == nil {
+		return
+	}
+	if wac.messages != nil {
+		wac.messages.chatAction(evt.Index, evt.SyncActionValue)
+	}
+	if wac.appState == nil {
+		return
+	}
+	value, err := protojson.Marshal(evt.SyncActionValue)
+	if err != nil {
+		logger.Warnf("Encoding app state %v: %v", evt.Index, err)
+		return
+	}
+	name := appStateIndexes[evt.Index[0]].patch
+	if err := wac.appState.put(name, evt.Index, string(value), time.Now()); err != nil {
+		logger.Warnf("Recording app state %v: %v", evt.Index, err)
+	}
+}
+
+// GetAppState returns the synced app state of one type, or of every type
+// when name is empty. With resync the types are first synced again in
+// full from the server, replacing what was recorded.
+func (wac *WhatsAppClient) GetAppState(ctx context.Context, name string, resync bool) (interface{}, error) {
+	if wac.appState == nil {
+		return AppStateResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	names := appstate.AllPatchNames[:]
+	if name != "" {
+		known, err := patchName(name)
+		if err != nil {
+			return AppStateResult{Success: false, Message: err.Error()}, err
+		}
+		names = []appstate.WAPatchName{known}
+	}
+
+	if resync {
+		if !wac.Client.IsLoggedIn() {
+			return AppStateResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+		}
+		for _, n := range names {
+			if err := wac.appState.clear(n); err != nil {
+				return AppStateResult{Success: false, Message: err.Error()}, err
+			}
+			err := callContextErr(wac, ctx, "syncing app state "+string(n), func() error {
+				return wac.Client.FetchAppState(n, true, false)
+			})
+			if err != nil {
+				err = fmt.Errorf("syncing %s: %w", n, err)
+				return AppStateResult{Success: false, Message: err.Error()}, err
+			}
+		}
+	}
+
+	states := make([]AppStateInfo, 0, len(names))
+	for _, n := range names {
+		version, err := wac.Client.AppStateVersion(n)
+		if err != nil {
+			return AppStateResult{Success: false, Message: err.Error()}, err
+		}
+		entries, err := wac.appState.list(n)
+		if err != nil {
+			return AppStateResult{Success: false, Message: err.Error()}, err
+		}
+		states = append(states, AppStateInfo{Name: string(n), Version: version, Entries: entries})
+	}
+	return AppStateResult{
+		Success: true,
+		Message: fmt.Sprintf("%d app state type(s)", len(states)),
+		States:  states,
+	}, nil
+}
@@ -0,0 +1,203 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	waHistorySync "go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// The archive is the message history (pod_messages) plus what history syncs
+// tell about each chat (pod_chats). Right after pairing, and from time to
+// time later, the phone sends past conversations; they are stored like live
+// messages, so get-chat-history sees them too.
+
+// ArchivedChat is one chat of the archive
+type ArchivedChat struct {
+	JID               string `json:"jid"`
+	Name              string `json:"name,omitempty"`
+	LastMessageAt     int64  `json:"last_message_at,omitempty"` // Unix seconds
+	UnreadCount       int    `json:"unread_count,omitempty"`
+	Archived          bool   `json:"archived,omitempty"`
+	Pinned            bool   `json:"pinned,omitempty"`
+	MutedUntil        int64  `json:"muted_until,omitempty"`        // Unix seconds, -1 for always
+	DisappearingTimer uint32 `json:"disappearing_timer,omitempty"` // seconds new messages last, 0 when off
+	Messages          int64  `json:"messages"`                     // stored messages of the chat
+}
+
+// ArchivedChatsResult is returned by GetArchivedChats
+type ArchivedChatsResult struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message,omitempty"`
+	Chats   []ArchivedChat `json:"chats,omitempty"`
+}
+
+// ArchivedChatsQuery pages through the archived chats
+type ArchivedChatsQuery struct {
+	Limit  int `json:"-"` // positional in get-archived-chats; 0 uses defaultHistoryLimit
+	Offset int `json:"offset"`
+}
+
+// ArchiveQuery pages through the archive, newest first. Since and Until are
+// Unix seconds, 0 for no bound.
+type ArchiveQuery struct {
+	Chat   string `json:"chat"` // one chat's messages; "" searches every chat
+	Text   string `json:"text"` // only messages containing this, ignoring case
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	Since  int64  `json:"since"`
+	Until  int64  `json:"until"`
+}
+
+// HistorySyncInfo is the data of a history-sync event, published once a
+// sync's conversations are stored
+type HistorySyncInfo struct {
+	Type     string `json:"type"` // initial_bootstrap, recent, full, on_demand, ...
+	Chats    int    `json:"chats"`
+	Messages int    `json:"messages"`
+	Progress int    `json:"progress,omitempty"` // percent, for the initial syncs
+}
+
+// saveChat records what a history sync tells about a chat; fields it leaves
+// empty keep the stored value
+func (s *messageStore) saveChat(conv *waHistorySync.Conversation, chat types.JID) {
+	muted := int64(conv.GetMuteEndTime())
+	if muted > 0 {
+		muted /= 1000 // sent in milliseconds; all bits set (-1) mutes for always
+	}
+	name := conv.GetName()
+	if name == "" {
+		name = conv.GetDisplayName()
+	}
+	_, err := s.db.Exec(`INSERT INTO pod_chats (jid, name, last_message_at, unread_count, archived, pinned, muted_until, disappearing_timer)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET
+			name = CASE WHEN excluded.name != '' THEN excluded.name ELSE name END,
+			last_message_at = MAX(last_message_at, excluded.last_message_at),
+			unread_count = excluded.unread_count,
+			archived = excluded.archived,
+			pinned = excluded.pinned,
+			muted_until = excluded.muted_until,
+			disappearing_timer = excluded.disappearing_timer`,
+		chat.String(), name, int64(conv.GetConversationTimestamp()), conv.GetUnreadCount(),
+		conv.GetArchived(), conv.GetPinned() > 0, muted, conv.GetEphemeralExpiration())
+	if err != nil {
+		logger.Warnf("Storing chat %s: %v", chat, err)
+	}
+}
+
+// chats lists the chats with stored messages or history sync data, the most
+// recently active first
+func (s *messageStore) chats(ctx context.Context, limit, offset int) ([]ArchivedChat, error) {
+	rows, err := s.db.QueryContext(ctx, `WITH ids AS (SELECT jid FROM pod_chats UNION SELECT chat_jid FROM pod_messages)
+		SELECT ids.jid, COALESCE(c.name, ''), COALESCE(c.unread_count, 0), COALESCE(c.archived, 0),
+			COALESCE(c.pinned, 0), COALESCE(c.muted_until, 0), COALESCE(c.disappearing_timer, 0),
+			(SELECT COUNT(*) FROM pod_messages m WHERE m.chat_jid = ids.jid),
+			MAX(COALESCE(c.last_message_at, 0),
+				COALESCE((SELECT MAX(timestamp) FROM pod_messages m WHERE m.chat_jid = ids.jid), 0)) AS last
+		FROM ids LEFT JOIN pod_chats c ON c.jid = ids.jid
+		ORDER BY last DESC, ids.jid LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chats := []ArchivedChat{}
+	for rows.Next() {
+		var c ArchivedChat
+		if err := rows.Scan(&c.JID, &c.Name, &c.UnreadCount, &c.Archived, &c.Pinned, &c.MutedUntil, &c.DisappearingTimer, &c.Messages, &c.LastMessageAt); err != nil {
+			return nil, err
+		}
+		chats = append(chats, c)
+	}
+	return chats, rows.Err()
+}
+
+// search returns the stored messages matching q, newest first
+func (s *messageStore) search(ctx context.Context, q ArchiveQuery) ([]MessageHistoryInfo, error) {
+	where := []string{"timestamp >= ?"}
+	args := []interface{}{q.Since}
+	if q.Until > 0 {
+		where = append(where, "timestamp <= ?")
+		args = append(args, q.Until)
+	}
+	if q.Chat != "" {
+		where = append(where, "chat_jid = ?")
+		args = append(args, q.Chat)
+	}
+	if q.Text != "" {
+		where = append(where, `content LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(q.Text)+"%")
+	}
+	args = append(args, q.Limit, q.Offset)
+	rows, err := s.db.QueryContext(ctx, `SELECT id, chat_jid, content, sender, is_from_me, message_type, timestamp
+		FROM pod_messages WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY timestamp DESC, rowid DESC LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs := []MessageHistoryInfo{}
+	for rows.Next() {
+		var m MessageHistoryInfo
+		if err := rows.Scan(&m.ID, &m.ChatID, &m.Content, &m.Sender, &m.IsFromMe, &m.MessageType, &m.Timestamp); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// likeEscaper escapes the LIKE wildcards of a search text
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetArchivedChats lists the archived chats, the most recently active first:
+// those the phone sent in history syncs and those with stored messages
+func (wac *WhatsAppClient) GetArchivedChats(ctx context.Context, q ArchivedChatsQuery) (interface{}, error) {
+	if wac.messages == nil {
+		return ArchivedChatsResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return ArchivedChatsResult{Success: false, Message: "Limit and offset must not be negative"}, fmt.Errorf("limit and offset must not be negative")
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultHistoryLimit
+	}
+	if q.Limit > maxHistoryLimit {
+		q.Limit = maxHistoryLimit
+	}
+	chats, err := wac.messages.chats(ctx, q.Limit, q.Offset)
+	if err != nil {
+		return ArchivedChatsResult{Success: false, Message: err.Error()}, err
+	}
+	return ArchivedChatsResult{Success: true, Message: fmt.Sprintf("%d chats", len(chats)), Chats: chats}, nil
+}
+
+// GetArchivedMessages searches the stored messages of one chat or of all
+func (wac *WhatsAppClient) GetArchivedMessages(ctx context.Context, q ArchiveQuery) (interface{}, error) {
+	if wac.messages == nil {
+		return MessageHistoryResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	if q.Limit < 0 || q.Offset < 0 || q.Since < 0 || q.Until < 0 {
+		return MessageHistoryResult{Success: false, Message: "Limit, offset, since and until must not be negative"}, fmt.Errorf("limit, offset, since and until must not be negative")
+	}
+	if q.Chat != "" {
+		chat, err := types.ParseJID(q.Chat)
+		if err != nil {
+			return MessageHistoryResult{Success: false, Message: err.Error()}, err
+		}
+		q.Chat = chat.String()
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultHistoryLimit
+	}
+	if q.Limit > maxHistoryLimit {
+		q.Limit = maxHistoryLimit
+	}
+	msgs, err := wac.messages.search(ctx, q)
+	if err != nil {
+		return MessageHistoryResult{Success: false, Message: err.Error()}, err
+	}
+	return MessageHistoryResult{Success: true, Message: fmt.Sprintf("%d messages", len(msgs)), Messages: msgs}, nil
+}
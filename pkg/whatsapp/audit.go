@@ -0,0 +1,305 @@
+package whatsapp
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// AuditRecord is one line of an exported audit log: a stored message
+// (Kind "message") or an administrative action taken through the pod
+// (Kind "action")
+type AuditRecord struct {
+	Kind      string `json:"kind"`
+	Timestamp int64  `json:"timestamp"`
+
+	ID          string  `json:"id,omitempty"`
+	ChatID      string  `json:"chat_id,omitempty"`
+	Sender      string  `json:"sender,omitempty"`
+	Direction   string  `json:"direction,omitempty"` // inbound or outbound
+	MessageType string  `json:"message_type,omitempty"`
+	Content     *string `json:"content,omitempty"` // left out with DropBodies
+
+	Action  string        `json:"action,omitempty"`
+	Args    []interface{} `json:"args,omitempty"` // left out with MetadataOnly
+	Success *bool         `json:"success,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// AuditExportOptions select and redact what ExportAuditLog writes. Since
+// and Until are Unix seconds, 0 for no bound.
+type AuditExportOptions struct {
+	HashPhones   bool   `json:"hash-phones"`   // replace the user part of JIDs and phone numbers with a hash
+	HashKey      string `json:"hash-key"`      // keys the hashes; generated per export when empty
+	HashSalt     string `json:"hash-salt"`     // Deprecated: the old name of HashKey
+	DropBodies   bool   `json:"drop-bodies"`   // leave out message content
+	MetadataOnly bool   `json:"metadata-only"` // leave out message content and action arguments
+	Since        int64  `json:"since"`
+	Until        int64  `json:"until"`
+}
+
+// AuditExportResult is returned by ExportAuditLog
+type AuditExportResult struct {
+	Success  bool   `json:"success"`
+	Message  string `json:"message,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Messages int    `json:"messages"`
+	Actions  int    `json:"actions"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// auditStore records administrative actions next to the message history
+type auditStore struct {
+	db *sql.DB
+}
+
+func newAuditStore(db *sql.DB) (*auditStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS pod_audit (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		action    TEXT NOT NULL,
+		args      TEXT NOT NULL,
+		success   BOOLEAN NOT NULL,
+		error     TEXT NOT NULL,
+		timestamp INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &auditStore{db: db}, nil
+}
+
+// RecordAction adds an administrative action and its outcome to the audit
+// log. Secrets must be removed from args by the caller.
+func (wac *WhatsAppClient) RecordAction(action string, args []interface{}, actionErr error) {
+	if wac.audit == nil {
+		return
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		logger.Warnf("Encoding the arguments of %s for the audit log: %v", action, err)
+		data = []byte("[]")
+	}
+	errMsg := ""
+	if actionErr != nil {
+		errMsg = actionErr.Error()
+	}
+	_, err = wac.audit.db.Exec(`INSERT INTO pod_audit (action, args, success, error, timestamp) VALUES (?, ?, ?, ?, ?)`,
+		action, string(data), actionErr == nil, errMsg, time.Now().Unix())
+	if err != nil {
+		logger.Warnf("Recording %s in the audit log: %v", action, err)
+	}
+}
+
+// auditRows selects messages and actions in one time-ordered stream
+const auditRows = `
+	SELECT 'message', timestamp, id, chat_jid, sender, is_from_me, message_type, content, '', '[]', 1, ''
+	FROM pod_messages WHERE timestamp >= ? AND timestamp <= ?
+	UNION ALL
+	SELECT 'action', timestamp, '', '', '', 0, '', '', action, args, success, error
+	FROM pod_audit WHERE timestamp >= ? AND timestamp <= ?
+	ORDER BY 2, 1`
+
+// ExportAuditLog writes the stored messages and the recorded administrative
+// actions to path as JSON Lines, oldest first, redacted as opts asks. The
+// file is readable by its owner only and appears once it is complete.
+func (wac *WhatsAppClient) ExportAuditLog(ctx context.Context, path string, opts AuditExportOptions) (interface{}, error) {
+	if wac.audit == nil || wac.messages == nil {
+		return AuditExportResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	if path == "" {
+		return AuditExportResult{Success: false, Message: "No path given"}, fmt.Errorf("no path given")
+	}
+	red, err := newRedactor(opts)
+	if err != nil {
+		return AuditExportResult{Success: false, Message: err.Error()}, err
+	}
+	until := opts.Until
+	if until == 0 {
+		until = 1<<63 - 1
+	}
+
+	partial := path + partialSuffix
+	file, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return AuditExportResult{Success: false, Message: err.Error()}, err
+	}
+	result, err := wac.writeAuditLog(ctx, file, opts, red, until)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partial, path)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return AuditExportResult{Success: false, Message: err.Error()}, err
+	}
+
+	logger.For(ctx).Infof("Exported %d messages and %d actions to %s", result.Messages, result.Actions, path)
+	result.Success = true
+	result.Path = path
+	result.Message = fmt.Sprintf("Exported %d messages and %d actions", result.Messages, result.Actions)
+	return result, nil
+}
+
+func (wac *WhatsAppClient) writeAuditLog(ctx context.Context, file *os.File, opts AuditExportOptions, red redactor, until int64) (AuditExportResult, error) {
+	var result AuditExportResult
+	rows, err := wac.audit.db.QueryContext(ctx, auditRows, opts.Since, until, opts.Since, until)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	out := bufio.NewWriter(file)
+	enc := json.NewEncoder(out)
+	for rows.Next() {
+		var r AuditRecord
+		var fromMe, success bool
+		var content, args string
+		if err := rows.Scan(&r.Kind, &r.Timestamp, &r.ID, &r.ChatID, &r.Sender, &fromMe, &r.MessageType, &content,
+			&r.Action, &args, &success, &r.Error); err != nil {
+			return result, err
+		}
+		if r.Kind == "message" {
+			r.Direction = "inbound"
+			if fromMe {
+				r.Direction = "outbound"
+			}
+			if !opts.DropBodies && !opts.MetadataOnly {
+				r.Content = &content
+			}
+			result.Messages++
+		} else {
+			r.Success = &success
+			if !opts.MetadataOnly {
+				if err := json.Unmarshal([]byte(args), &r.Args); err != nil {
+					return result, fmt.Errorf("audit record of %s: %w", r.Action, err)
+				}
+			}
+			result.Actions++
+		}
+		red.record(&r)
+		if err := enc.Encode(r); err != nil {
+			return result, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	if err := out.Flush(); err != nil {
+		return result, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return result, err
+	}
+	result.Bytes = stat.Size()
+	return result, nil
+}
+
+// minHashKeyLen is the shortest hash key accepted, so the hashes of a
+// known phone list cannot be matched by guessing the key
+const minHashKeyLen = 16
+
+// redactor hashes the phone numbers of audit records
+type redactor struct {
+	opts AuditExportOptions
+	key  []byte
+}
+
+// newRedactor keys the hashes with opts.HashKey, or with a random key when
+// none is given, so the hashes of one export cannot be matched against
+// another's
+func newRedactor(opts AuditExportOptions) (redactor, error) {
+	red := redactor{opts: opts}
+	if !opts.HashPhones {
+		return red, nil
+	}
+	key := opts.HashKey
+	if key == "" {
+		key = opts.HashSalt
+	}
+	if key == "" {
+		red.key = make([]byte, 32)
+		if _, err := rand.Read(red.key); err != nil {
+			return red, fmt.Errorf("generating a hash key: %w", err)
+		}
+		return red, nil
+	}
+	if len(key) < minHashKeyLen {
+		return red, fmt.Errorf("hash-key must be at least %d bytes", minHashKeyLen)
+	}
+	red.key = []byte(key)
+	return red, nil
+}
+
+func (red redactor) record(r *AuditRecord) {
+	if !red.opts.HashPhones {
+		return
+	}
+	r.ChatID = red.jid(r.ChatID)
+	r.Sender = red.jid(r.Sender)
+	for i, arg := range r.Args {
+		r.Args[i] = red.value(arg)
+	}
+}
+
+func (red redactor) hash(user string) string {
+	mac := hmac.New(sha256.New, red.key)
+	mac.Write([]byte(user))
+	return "h" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// jid hashes the user part of a JID, keeping the server so chats and
+// groups stay distinguishable
+func (red redactor) jid(s string) string {
+	if s == "" {
+		return s
+	}
+	jid, err := types.ParseJID(s)
+	if err != nil || jid.User == "" {
+		return red.hash(s)
+	}
+	return red.hash(jid.User) + "@" + jid.Server
+}
+
+// value hashes the JIDs and phone numbers in an action argument
+func (red redactor) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if strings.Contains(v, "@") {
+			return red.jid(v)
+		}
+		if digits, err := NormalizePhone(v); err == nil && len(digits) >= 6 {
+			return red.hash(digits)
+		}
+		return v
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = red.value(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = red.value(e)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,298 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+const (
+	backupMagic      = "BBWAENC1" // prefix of encrypted backups
+	backupSaltSize   = 16
+	backupIterations = 600_000
+	backupStepPages  = 256 // pages copied per step; the store is unlocked in between
+)
+
+// BackupResult is returned by BackupSession and RestoreSession
+type BackupResult struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Size       int64  `json:"size"`
+	Encrypted  bool   `json:"encrypted"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// sqliteBackup is implemented by the modernc sqlite driver connection
+type sqliteBackup interface {
+	NewBackup(dstUri string) (*sqlite.Backup, error)
+	NewRestore(srcUri string) (*sqlite.Backup, error)
+}
+
+// copyDatabase runs the sqlite online backup API on db, towards path
+// (restore false) or from it (restore true), a few pages at a time so
+// whatsmeow can keep writing between steps
+func copyDatabase(ctx context.Context, db *sql.DB, path string, restore bool) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(sqliteBackup)
+		if !ok {
+			return fmt.Errorf("database driver does not support online backup")
+		}
+		var b *sqlite.Backup
+		if restore {
+			b, err = c.NewRestore(fileURI(path, nil))
+		} else {
+			b, err = c.NewBackup(fileURI(path, nil))
+		}
+		if err != nil {
+			return err
+		}
+		for more := true; more; {
+			if more, err = b.Step(backupStepPages); err != nil {
+				b.Finish()
+				return err
+			}
+			if err = ctx.Err(); err != nil {
+				b.Finish()
+				return err
+			}
+		}
+		return b.Finish()
+	})
+}
+
+// backupKey derives the AES-256 key of an encrypted backup
+func backupKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, backupIterations, 32)
+}
+
+// encryptBackup seals data as magic | salt | nonce | AES-GCM ciphertext
+func encryptBackup(data []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := backupKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(backupMagic), salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, []byte(backupMagic)), nil
+}
+
+// decryptBackup opens a backup written by encryptBackup
+func decryptBackup(data []byte, passphrase string) ([]byte, error) {
+	data = data[len(backupMagic):]
+	if len(data) < backupSaltSize {
+		return nil, fmt.Errorf("backup is truncated")
+	}
+	key, err := backupKey(passphrase, data[:backupSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	data = data[backupSaltSize:]
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("backup is truncated")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(backupMagic))
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted backup")
+	}
+	return plain, nil
+}
+
+// BackupSession snapshots the session database to path while the client
+// keeps running. With a passphrase the snapshot is encrypted with AES-256-GCM
+// under a PBKDF2 key; without one it is a plain sqlite file.
+func (wac *WhatsAppClient) BackupSession(ctx context.Context, path, passphrase string) (interface{}, error) {
+	if wac.db == nil {
+		return BackupResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	start := time.Now()
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return BackupResult{Success: false, Message: err.Error()}, err
+	}
+
+	// Snapshot into a private directory next to the target and rename, so
+	// a failed backup never replaces a good one and the plaintext snapshot
+	// of an encrypted backup is never readable by others
+	dir, err := os.MkdirTemp(filepath.Dir(abs), ".backup-*")
+	if err != nil {
+		return BackupResult{Success: false, Message: err.Error()}, err
+	}
+	defer os.RemoveAll(dir)
+	tmp, err := privateFile(dir, "session-*.db", nil)
+	if err != nil {
+		return BackupResult{Success: false, Message: err.Error()}, err
+	}
+	if err := copyDatabase(ctx, wac.db, tmp, false); err != nil {
+		logger.For(ctx).Errorf("Backing up session database: %v", err)
+		return BackupResult{Success: false, Message: err.Error()}, fmt.Errorf("backup: %w", err)
+	}
+	if passphrase != "" {
+		data, err := os.ReadFile(tmp)
+		if err == nil {
+			data, err = encryptBackup(data, passphrase)
+		}
+		if err == nil {
+			tmp, err = privateFile(dir, "session-*.enc", data)
+		}
+		if err != nil {
+			return BackupResult{Success: false, Message: err.Error()}, fmt.Errorf("encrypting backup: %w", err)
+		}
+	}
+	if err := os.Chmod(tmp, 0o600); err != nil { // the store holds the device keys
+		return BackupResult{Success: false, Message: err.Error()}, err
+	}
+	if err := os.Rename(tmp, abs); err != nil {
+		return BackupResult{Success: false, Message: err.Error()}, err
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return BackupResult{Success: false, Message: err.Error()}, err
+	}
+	result := BackupResult{
+		Success:    true,
+		Message:    "Session database backed up",
+		Path:       abs,
+		Size:       info.Size(),
+		Encrypted:  passphrase != "",
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	logger.For(ctx).Infof("Session database backed up to %s (%d bytes, encrypted %v)", abs, result.Size, result.Encrypted)
+	return result, nil
+}
+
+// RestoreSession replaces the session database at dbPath with a backup made
+// by BackupSession. The passphrase is required for encrypted backups. It
+// must run while no client has dbPath open.
+func RestoreSession(dbPath, backupPath, passphrase string) (BackupResult, error) {
+	start := time.Now()
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		return BackupResult{Success: false, Message: err.Error()}, err
+	}
+	encrypted := bytes.HasPrefix(data, []byte(backupMagic))
+	src := backupPath
+	if encrypted {
+		if passphrase == "" {
+			err := errors.New("backup is encrypted, a passphrase is required")
+			return BackupResult{Success: false, Message: err.Error()}, err
+		}
+		plain, err := decryptBackup(data, passphrase)
+		if err != nil {
+			return BackupResult{Success: false, Message: err.Error()}, err
+		}
+		dir, err := os.MkdirTemp(filepath.Dir(dbPath), ".restore-*")
+		if err != nil {
+			return BackupResult{Success: false, Message: err.Error()}, err
+		}
+		defer os.RemoveAll(dir)
+		if src, err = privateFile(dir, "session-*.db", plain); err != nil {
+			return BackupResult{Success: false, Message: err.Error()}, err
+		}
+	}
+
+	if err := checkBackup(src); err != nil {
+		return BackupResult{Success: false, Message: err.Error()}, err
+	}
+
+	db, err := sql.Open("sqlite", fileURI(dbPath, url.Values{"_pragma": {"foreign_keys(ON)"}}))
+	if err != nil {
+		return BackupResult{Success: false, Message: err.Error()}, err
+	}
+	defer db.Close()
+	if err := copyDatabase(context.Background(), db, src, true); err != nil {
+		logger.Errorf("Restoring session database: %v", err)
+		return BackupResult{Success: false, Message: err.Error()}, fmt.Errorf("restore: %w", err)
+	}
+
+	logger.Infof("Session database %s restored from %s", dbPath, backupPath)
+	return BackupResult{
+		Success:    true,
+		Message:    "Session restored, log in to reconnect",
+		Path:       dbPath,
+		Size:       int64(len(data)),
+		Encrypted:  encrypted,
+		DurationMs: time.Since(start).Milliseconds(),
+	}, nil
+}
+
+// privateFile writes data to a new 0600 file in dir and returns its path
+func privateFile(dir, pattern string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return f.Name(), err
+}
+
+// checkBackup makes sure path is an intact session database before it
+// overwrites the live one
+func checkBackup(path string) error {
+	db, err := sql.Open("sqlite", fileURI(path, url.Values{"mode": {"ro"}}))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("not a session database backup: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup failed its integrity check: %s", result)
+	}
+	var devices int
+	if err := db.QueryRow("SELECT COUNT(*) FROM whatsmeow_device").Scan(&devices); err != nil {
+		return fmt.Errorf("not a session database backup: %w", err)
+	}
+	if devices == 0 {
+		return fmt.Errorf("backup holds no paired device")
+	}
+	return nil
+}
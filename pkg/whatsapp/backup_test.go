@@ -0,0 +1,72 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newSessionDB creates a session database at path holding one paired device
+func newSessionDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", sqliteDSN(path, Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`CREATE TABLE whatsmeow_device (jid TEXT PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO whatsmeow_device VALUES ('15550001111@s.whatsapp.net')`); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestBackupRestore(t *testing.T) {
+	for _, passphrase := range []string{"", "correct horse"} {
+		t.Run("passphrase "+passphrase, func(t *testing.T) {
+			// ? and # would end the file name of an unescaped sqlite URI
+			dir := filepath.Join(t.TempDir(), "odd?dir#1")
+			if err := os.Mkdir(dir, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			wac := &WhatsAppClient{db: newSessionDB(t, filepath.Join(dir, "live.db"))}
+			backup := filepath.Join(dir, "session.bak")
+			if _, err := wac.BackupSession(context.Background(), backup, passphrase); err != nil {
+				t.Fatalf("BackupSession: %v", err)
+			}
+
+			info, err := os.Stat(backup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != 0o600 {
+				t.Errorf("backup mode = %o, want 600", perm)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if name := e.Name(); name != "live.db" && name != "session.bak" {
+					t.Errorf("backup left %s behind", name)
+				}
+			}
+
+			restored := filepath.Join(dir, "restored.db")
+			result, err := RestoreSession(restored, backup, passphrase)
+			if err != nil {
+				t.Fatalf("RestoreSession: %v", err)
+			}
+			if result.Encrypted != (passphrase != "") {
+				t.Errorf("Encrypted = %v for passphrase %q", result.Encrypted, passphrase)
+			}
+			if err := checkBackup(restored); err != nil {
+				t.Errorf("restored database: %v", err)
+			}
+		})
+	}
+}
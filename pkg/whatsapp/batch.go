@@ -0,0 +1,156 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+const (
+	maxBatchSize       = 1000
+	defaultBatchDelay  = 2 * time.Second
+	defaultBatchJitter = time.Second
+)
+
+// BatchMessage is one message of a batch. Recipient is a phone number or a
+// chat JID.
+type BatchMessage struct {
+	Recipient string `json:"recipient"`
+	Message   string `json:"message"`
+}
+
+// BatchOptions paces a batch. The wait between two sends is DelayMs plus a
+// random part of up to JitterMs, so the sends do not look scripted.
+type BatchOptions struct {
+	DelayMs     *int64 `json:"delay-ms"`  // nil for the default of 2000
+	JitterMs    *int64 `json:"jitter-ms"` // nil for the default of 1000
+	StopOnError bool   `json:"stop-on-error"`
+}
+
+// BatchItemResult is the outcome of one message of a batch
+type BatchItemResult struct {
+	Index     int    `json:"index"`
+	Recipient string `json:"recipient"`
+	Success   bool   `json:"success"`
+	Skipped   bool   `json:"skipped,omitempty"` // not attempted, after a stop-on-error failure or a cancel
+	Message   string `json:"message,omitempty"`
+	ID        string `json:"id,omitempty"`
+}
+
+// BatchResult is returned by SendBatch. Success is only set when every
+// message was sent.
+type BatchResult struct {
+	Success bool              `json:"success"`
+	Message string            `json:"message,omitempty"`
+	Sent    int               `json:"sent"`
+	Failed  int               `json:"failed"`
+	Skipped int               `json:"skipped"`
+	DryRun  bool              `json:"dry_run,omitempty"`
+	Results []BatchItemResult `json:"results"`
+}
+
+// SendBatch sends text messages one after the other, waiting between sends
+// as opts says. A failed message does not stop the batch unless
+// opts.StopOnError is set; failures are kept as dead letters like any send.
+// The error is only set when the batch could not run at all or was
+// cancelled.
+func (wac *WhatsAppClient) SendBatch(ctx context.Context, messages []BatchMessage, opts BatchOptions) (interface{}, error) {
+	if err := wac.sendReady(); err != nil {
+		return BatchResult{Success: false, Message: "Not logged in", Results: []BatchItemResult{}}, err
+	}
+	if len(messages) == 0 || len(messages) > maxBatchSize {
+		err := fmt.Errorf("a batch holds 1 to %d messages, got %d", maxBatchSize, len(messages))
+		return BatchResult{Success: false, Message: err.Error(), Results: []BatchItemResult{}}, err
+	}
+	delay, jitter := defaultBatchDelay, defaultBatchJitter
+	if opts.DelayMs != nil {
+		delay = time.Duration(*opts.DelayMs) * time.Millisecond
+	}
+	if opts.JitterMs != nil {
+		jitter = time.Duration(*opts.JitterMs) * time.Millisecond
+	}
+	if delay < 0 || jitter < 0 {
+		err := fmt.Errorf("delay-ms and jitter-ms must not be negative")
+		return BatchResult{Success: false, Message: err.Error(), Results: []BatchItemResult{}}, err
+	}
+
+	result := BatchResult{DryRun: wac.Options().DryRun, Results: make([]BatchItemResult, len(messages))}
+	var stopped error
+	for i, m := range messages {
+		item := &result.Results[i]
+		item.Index, item.Recipient = i, m.Recipient
+		if stopped != nil {
+			item.Skipped, item.Message = true, stopped.Error()
+			result.Skipped++
+			continue
+		}
+		if i > 0 {
+			if err := sleepContext(ctx, delay+randomDuration(jitter)); err != nil {
+				stopped = err
+				item.Skipped, item.Message = true, err.Error()
+				result.Skipped++
+				continue
+			}
+		}
+
+		sent, err := wac.sendBatchMessage(ctx, m)
+		item.Success, item.Message, item.ID = sent.Success, sent.Message, sent.ID
+		if err != nil {
+			result.Failed++
+			logger.For(ctx).Warnf("Batch message %d to %s failed: %v", i, m.Recipient, err)
+			if ctx.Err() != nil {
+				stopped = ctx.Err()
+			} else if opts.StopOnError {
+				stopped = fmt.Errorf("stopped after message %d failed", i)
+			}
+			continue
+		}
+		result.Sent++
+	}
+
+	result.Success = result.Sent == len(messages)
+	result.Message = fmt.Sprintf("%d sent, %d failed, %d skipped", result.Sent, result.Failed, result.Skipped)
+	logger.For(ctx).Infof("Batch of %d messages: %s", len(messages), result.Message)
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// sendBatchMessage sends one message of a batch to a JID or phone number
+func (wac *WhatsAppClient) sendBatchMessage(ctx context.Context, m BatchMessage) (SendResult, error) {
+	var sent interface{}
+	var err error
+	if strings.Contains(m.Recipient, "@") {
+		sent, err = wac.SendToJID(ctx, m.Recipient, m.Message)
+	} else {
+		sent, err = wac.SendMessage(ctx, m.Recipient, m.Message, TextOptions{})
+	}
+	r, _ := sent.(SendResult)
+	if err != nil && r.Message == "" {
+		r.Message = err.Error()
+	}
+	return r, err
+}
+
+// randomDuration is a random duration in [0, max]
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(max) + 1))
+}
+
+// sleepContext waits for d unless ctx ends first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,263 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Broadcast lists live on the phone, and WhatsApp only lets the phone send
+// to them, so SendBroadcast does what the phone does: it sends the message
+// to each recipient in their own chat. Statuses go to status@broadcast,
+// which WhatsApp fans out to the contacts the status privacy settings allow.
+
+const (
+	// defaultStatusBackground is the background of a text status without
+	// one, WhatsApp's dark teal
+	defaultStatusBackground = 0xFF075E54
+	statusTextColor         = 0xFFFFFFFF
+)
+
+// StatusUpdate is a status (story) to post. With Image it is an image
+// status and Text its caption; otherwise a text status.
+type StatusUpdate struct {
+	Text            string `json:"text"`
+	Image           string `json:"image"`            // path of the image
+	BackgroundColor string `json:"background-color"` // #RRGGBB; of a text status, or the frame of an image
+	Font            string `json:"font"`             // of a text status: system, system-bold, fb-script, courierprime-bold, ...
+}
+
+// StatusMediaOptions are the options of PostStatusImage and PostStatusVideo
+type StatusMediaOptions struct {
+	Caption         string `json:"caption"`
+	BackgroundColor string `json:"background-color"` // images only: the #RRGGBB of a portrait frame around the image
+}
+
+// SendBroadcast sends message to each recipient (phone numbers or JIDs) in
+// their own chat, paced and reported like SendBatch
+func (wac *WhatsAppClient) SendBroadcast(ctx context.Context, recipients []string, message string, opts BatchOptions) (interface{}, error) {
+	if message == "" {
+		err := fmt.Errorf("a broadcast needs a message")
+		return BatchResult{Success: false, Message: err.Error(), Results: []BatchItemResult{}}, err
+	}
+	messages := make([]BatchMessage, len(recipients))
+	for i, r := range recipients {
+		messages[i] = BatchMessage{Recipient: r, Message: message}
+	}
+	return wac.SendBatch(ctx, messages, opts)
+}
+
+// SendStatusUpdate posts a status visible to your contacts
+func (wac *WhatsAppClient) SendStatusUpdate(ctx context.Context, status StatusUpdate) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+	spec, _ := json.Marshal(status)
+	if status.Image != "" {
+		return wac.postStatusImage(ctx, stats, status.Image, StatusMediaOptions{Caption: status.Text, BackgroundColor: status.BackgroundColor}, "send-status-update", string(spec))
+	}
+	if status.Text == "" {
+		err := fmt.Errorf("a status needs a text or an image")
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	text, err := textStatus(status)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	return wac.postStatus(ctx, stats, &waProto.Message{ExtendedTextMessage: text}, "send-status-update", string(spec))
+}
+
+// PostStatusImage posts the image at path as a status. With a
+// background color, the image is centered on a portrait 9:16 frame of that
+// color, which status viewers show full screen.
+func (wac *WhatsAppClient) PostStatusImage(ctx context.Context, path string, opts StatusMediaOptions) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+	spec, _ := json.Marshal(opts)
+	return wac.postStatusImage(ctx, stats, path, opts, "post-status-image", path, string(spec))
+}
+
+// PostStatusVideo posts the video at path as a status. Videos are
+// sent as they are, so a background color cannot be applied to them.
+func (wac *WhatsAppClient) PostStatusVideo(ctx context.Context, path string, opts StatusMediaOptions) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+	if opts.BackgroundColor != "" {
+		err := fmt.Errorf("background-color only applies to image and text statuses")
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	spec, _ := json.Marshal(opts)
+
+	header, err := readFileHeader(path)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	mime := http.DetectContentType(header)
+	if !strings.HasPrefix(mime, "video/") {
+		err := fmt.Errorf("%s is %s, not a video", path, mime)
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	uploaded, err := wac.uploadFile(ctx, path, whatsmeow.MediaVideo, types.StatusBroadcastJID.String())
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "post-status-video", path, string(spec))
+	}
+	msg := &waProto.Message{
+		VideoMessage: &waProto.VideoMessage{
+			URL:        &uploaded.URL,
+			Mimetype:   proto.String(mime),
+			Caption:    proto.String(opts.Caption),
+			FileSHA256: uploaded.FileSHA256,
+			FileLength: proto.Uint64(uploaded.FileLength),
+			MediaKey:   uploaded.MediaKey,
+			DirectPath: proto.String(uploaded.DirectPath),
+		},
+	}
+	return wac.postStatus(ctx, stats, msg, "post-status-video", path, string(spec))
+}
+
+// postStatusImage uploads and posts an image status; op and args are what a
+// dead letter replays
+func (wac *WhatsAppClient) postStatusImage(ctx context.Context, stats *RetryStats, path string, opts StatusMediaOptions, op string, args ...string) (interface{}, error) {
+	if _, err := wac.checkUploadSize(path); err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	mime := http.DetectContentType(data)
+	if !strings.HasPrefix(mime, "image/") {
+		err := fmt.Errorf("%s is %s, not an image", path, mime)
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	if opts.BackgroundColor != "" {
+		background, err := parseColor(opts.BackgroundColor)
+		if err != nil {
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+		if data, err = frameImage(data, background); err != nil {
+			err = fmt.Errorf("framing %s: %w", path, err)
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+		mime = "image/jpeg"
+	}
+
+	uploaded, err := wac.upload(ctx, data, whatsmeow.MediaImage)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, op, args...)
+	}
+	msg := &waProto.Message{
+		ImageMessage: &waProto.ImageMessage{
+			URL:        &uploaded.URL,
+			Mimetype:   proto.String(mime),
+			Caption:    proto.String(opts.Caption),
+			FileSHA256: uploaded.FileSHA256,
+			FileLength: proto.Uint64(uploaded.FileLength),
+			MediaKey:   uploaded.MediaKey,
+			DirectPath: proto.String(uploaded.DirectPath),
+		},
+	}
+	return wac.postStatus(ctx, stats, msg, op, args...)
+}
+
+// postStatus sends a status message to status@broadcast
+func (wac *WhatsAppClient) postStatus(ctx context.Context, stats *RetryStats, msg *waProto.Message, op string, args ...string) (interface{}, error) {
+	ts := time.Now()
+	resp, err := wac.sendMessage(ctx, types.StatusBroadcastJID, msg)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, op, args...)
+	}
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: fmt.Sprintf("Status posted (server timestamp: %v)", ts),
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// frameImage centers an image on a 9:16 frame of the ARGB background, as
+// large as the image needs, and encodes the result as JPEG
+func frameImage(data []byte, background uint32) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w*16 > h*9 {
+		h = (w*16 + 8) / 9
+	} else {
+		w = (h*9 + 15) / 16
+	}
+	frame := image.NewRGBA(image.Rect(0, 0, w, h))
+	fill := color.NRGBA{R: uint8(background >> 16), G: uint8(background >> 8), B: uint8(background), A: uint8(background >> 24)}
+	draw.Draw(frame, frame.Bounds(), image.NewUniform(fill), image.Point{}, draw.Src)
+	at := image.Pt((w-b.Dx())/2, (h-b.Dy())/2)
+	draw.Draw(frame, image.Rectangle{Min: at, Max: at.Add(b.Size())}, src, b.Min, draw.Over)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, frame, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// textStatus builds a text status with its colors and font
+func textStatus(status StatusUpdate) (*waProto.ExtendedTextMessage, error) {
+	background := uint32(defaultStatusBackground)
+	if status.BackgroundColor != "" {
+		var err error
+		if background, err = parseColor(status.BackgroundColor); err != nil {
+			return nil, err
+		}
+	}
+	text := &waProto.ExtendedTextMessage{
+		Text:           proto.String(status.Text),
+		BackgroundArgb: proto.Uint32(background),
+		TextArgb:       proto.Uint32(statusTextColor),
+		Font:           waProto.ExtendedTextMessage_SYSTEM.Enum(),
+	}
+	if status.Font != "" {
+		font, ok := waProto.ExtendedTextMessage_FontType_value[strings.ToUpper(strings.ReplaceAll(status.Font, "-", "_"))]
+		if !ok {
+			return nil, fmt.Errorf("unknown status font %q", status.Font)
+		}
+		text.Font = waProto.ExtendedTextMessage_FontType(font).Enum()
+	}
+	return text, nil
+}
+
+// parseColor parses a #RRGGBB color, or #AARRGGBB with its alpha, as ARGB
+func parseColor(color string) (uint32, error) {
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return 0, fmt.Errorf("invalid color %q: want #RRGGBB", color)
+	}
+	argb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid color %q: want #RRGGBB", color)
+	}
+	if len(hex) == 6 {
+		argb |= 0xFF000000
+	}
+	return uint32(argb), nil
+}
@@ -0,0 +1,285 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	waCommon "go.mau.fi/whatsmeow/proto/waCommon"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/proto/waSyncAction"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Chat settings (mute, pin, archive, unread) are app state: they are sent as
+// patches the other devices of the account sync, so the phone's chat list
+// changes too.
+
+// ChatSettingResult is returned by the chat setting calls
+type ChatSettingResult struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	Chat       string `json:"chat,omitempty"`
+	MutedUntil int64  `json:"muted_until,omitempty"` // Unix seconds, -1 for always
+	DryRun     bool   `json:"dry_run,omitempty"`
+}
+
+// DisappearingTimerResult is returned by SetDisappearingTimer
+type DisappearingTimerResult struct {
+	Success           bool   `json:"success"`
+	Message           string `json:"message,omitempty"`
+	Chat              string `json:"chat,omitempty"`
+	DisappearingTimer uint32 `json:"disappearing_timer"` // seconds, 0 when off
+	DryRun            bool   `json:"dry_run,omitempty"`
+}
+
+// MuteChat mutes a chat for duration, or for always when it is 0
+func (wac *WhatsAppClient) MuteChat(ctx context.Context, chatJID string, duration time.Duration) (interface{}, error) {
+	if duration < 0 {
+		return ChatSettingResult{Success: false, Message: "Mute duration must not be negative"}, fmt.Errorf("mute duration must not be negative")
+	}
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return ChatSettingResult{Success: false, Message: err.Error()}, err
+	}
+	result, err := wac.sendChatPatch(ctx, chat, "muting chat", appstate.BuildMute(chat, true, duration))
+	if err == nil {
+		result.MutedUntil = -1
+		if duration > 0 {
+			result.MutedUntil = time.Now().Add(duration).Unix()
+		}
+	}
+	return result, err
+}
+
+// UnmuteChat unmutes a chat
+func (wac *WhatsAppClient) UnmuteChat(ctx context.Context, chatJID string) (interface{}, error) {
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return ChatSettingResult{Success: false, Message: err.Error()}, err
+	}
+	return wac.sendChatPatch(ctx, chat, "unmuting chat", appstate.BuildMute(chat, false, 0))
+}
+
+// PinChat pins a chat to the top of the chat list, or unpins it
+func (wac *WhatsAppClient) PinChat(ctx context.Context, chatJID string, pin bool) (interface{}, error) {
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return ChatSettingResult{Success: false, Message: err.Error()}, err
+	}
+	return wac.sendChatPatch(ctx, chat, "pinning chat", appstate.BuildPin(chat, pin))
+}
+
+// ArchiveChat archives a chat, which also unpins it, or unarchives it. The
+// newest stored message of the chat tells the other devices what the
+// archive covers.
+func (wac *WhatsAppClient) ArchiveChat(ctx context.Context, chatJID string, archive bool) (interface{}, error) {
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return ChatSettingResult{Success: false, Message: err.Error()}, err
+	}
+	last, key := wac.lastMessageKey(ctx, chat)
+	return wac.sendChatPatch(ctx, chat, "archiving chat", appstate.BuildArchive(chat, archive, last, key))
+}
+
+// MarkChatUnread marks a chat as unread, or as read again
+func (wac *WhatsAppClient) MarkChatUnread(ctx context.Context, chatJID string, unread bool) (interface{}, error) {
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return ChatSettingResult{Success: false, Message: err.Error()}, err
+	}
+	last, key := wac.lastMessageKey(ctx, chat)
+	if last.IsZero() {
+		last = time.Now()
+	}
+	messageRange := &waSyncAction.SyncActionMessageRange{LastMessageTimestamp: proto.Int64(last.Unix())}
+	if key != nil {
+		messageRange.Messages = []*waSyncAction.SyncActionMessage{{Key: key, Timestamp: proto.Int64(last.Unix())}}
+	}
+	// whatsmeow has no builder for this one
+	patch := appstate.PatchInfo{
+		Type: appstate.WAPatchRegularLow,
+		Mutations: []appstate.MutationInfo{{
+			Index:   []string{appstate.IndexMarkChatAsRead, chat.String()},
+			Version: 3,
+			Value: &waSyncAction.SyncActionValue{
+				MarkChatAsReadAction: &waSyncAction.MarkChatAsReadAction{
+					Read:         proto.Bool(!unread),
+					MessageRange: messageRange,
+				},
+			},
+		}},
+	}
+	return wac.sendChatPatch(ctx, chat, "marking chat", patch)
+}
+
+// SetDisappearingTimer turns disappearing messages in a chat off, or sets
+// how long new messages last: "off", "24h", "7d" or "90d", the durations
+// WhatsApp offers. In a group only admins can change it, unless the group
+// lets every member edit its info.
+func (wac *WhatsAppClient) SetDisappearingTimer(ctx context.Context, chatJID, timer string) (interface{}, error) {
+	duration, ok := whatsmeow.ParseDisappearingTimerString(timer)
+	if !ok {
+		err := fmt.Errorf("invalid disappearing timer %q: want off, 24h, 7d or 90d", timer)
+		return DisappearingTimerResult{Success: false, Message: err.Error()}, err
+	}
+	if err := wac.sendReady(); err != nil {
+		return DisappearingTimerResult{Success: false, Message: "Not logged in"}, err
+	}
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return DisappearingTimerResult{Success: false, Message: err.Error()}, err
+	}
+	if chat.Server != types.DefaultUserServer && chat.Server != types.GroupServer {
+		err := fmt.Errorf("cannot set a disappearing timer in %s", chat)
+		return DisappearingTimerResult{Success: false, Message: err.Error()}, err
+	}
+	seconds := uint32(duration.Seconds())
+	if wac.Options().DryRun {
+		logger.For(ctx).Infof("DRY RUN: would set the disappearing timer of %s to %v", chat, duration)
+		return DisappearingTimerResult{Success: true, Message: "Dry run, timer not set", Chat: chat.String(), DisappearingTimer: seconds, DryRun: true}, nil
+	}
+
+	err = callContextErr(wac, ctx, "setting disappearing timer", func() error {
+		return wac.Client.SetDisappearingTimer(chat, duration)
+	})
+	if err != nil {
+		logger.For(ctx).Errorf("Error setting the disappearing timer of %s: %v", chat, err)
+		return DisappearingTimerResult{Success: false, Message: err.Error(), Chat: chat.String()}, err
+	}
+	if wac.messages != nil {
+		wac.messages.setDisappearingTimer(chat, seconds)
+	}
+	message := "Disappearing messages turned off"
+	switch days := seconds / 86400; {
+	case days == 1:
+		message = "New messages disappear after 24 hours"
+	case days > 1:
+		message = fmt.Sprintf("New messages disappear after %d days", days)
+	}
+	return DisappearingTimerResult{Success: true, Message: message, Chat: chat.String(), DisappearingTimer: seconds}, nil
+}
+
+// recordDisappearingTimer keeps the chat list's timer of a chat current when
+// a contact changes it, or a group's admin
+func (wac *WhatsAppClient) recordDisappearingTimer(evt interface{}) {
+	if wac.messages == nil {
+		return
+	}
+	switch v := evt.(type) {
+	case *events.Message:
+		if pm := v.Message.GetProtocolMessage(); pm.GetType() == waProto.ProtocolMessage_EPHEMERAL_SETTING && !v.Info.IsGroup {
+			wac.messages.setDisappearingTimer(v.Info.Chat, pm.GetEphemeralExpiration())
+		}
+	case *events.GroupInfo:
+		if v.Ephemeral != nil {
+			timer := v.Ephemeral.DisappearingTimer
+			if !v.Ephemeral.IsEphemeral {
+				timer = 0
+			}
+			wac.messages.setDisappearingTimer(v.JID, timer)
+		}
+	}
+}
+
+// lastMessageKey returns the time and key of the newest stored message of
+// chat, zero when none is stored
+func (wac *WhatsAppClient) lastMessageKey(ctx context.Context, chat types.JID) (time.Time, *waCommon.MessageKey) {
+	if wac.messages == nil {
+		return time.Time{}, nil
+	}
+	msgs, err := wac.messages.history(ctx, chat.String(), HistoryQuery{Limit: 1})
+	if err != nil || len(msgs) == 0 {
+		return time.Time{}, nil
+	}
+	m := msgs[0]
+	sender, err := types.ParseJID(m.Sender)
+	if err != nil {
+		return time.Unix(m.Timestamp, 0), nil
+	}
+	key := wac.Client.BuildMessageKey(chat, sender, m.ID)
+	key.FromMe = proto.Bool(m.IsFromMe)
+	return time.Unix(m.Timestamp, 0), key
+}
+
+// sendChatPatch sends an app state patch that changes one chat's settings,
+// and records the change in the chat list of the archive
+func (wac *WhatsAppClient) sendChatPatch(ctx context.Context, chat types.JID, what string, patch appstate.PatchInfo) (ChatSettingResult, error) {
+	if err := wac.sendReady(); err != nil {
+		return ChatSettingResult{Success: false, Message: "Not logged in"}, err
+	}
+	patch.Timestamp = time.Now()
+	if wac.Options().DryRun {
+		logger.For(ctx).Infof("DRY RUN: would send the %s patch %s for %s", patch.Type, patch.Mutations[0].Index[0], chat)
+		return ChatSettingResult{Success: true, Message: "Dry run, chat not changed", Chat: chat.String(), DryRun: true}, nil
+	}
+	err := callContextErr(wac, ctx, what, func() error {
+		return wac.Client.SendAppState(patch)
+	})
+	if err != nil {
+		logger.For(ctx).Errorf("Error %s %s: %v", what, chat, err)
+		return ChatSettingResult{Success: false, Message: err.Error(), Chat: chat.String()}, err
+	}
+	if wac.messages != nil {
+		for _, m := range patch.Mutations {
+			wac.messages.chatAction(m.Index, m.Value)
+		}
+	}
+	return ChatSettingResult{Success: true, Message: "Chat updated", Chat: chat.String()}, nil
+}
+
+// chatAction records a synced mute, pin, archive or read change in the chat
+// list, so get-archived-chats reflects it
+func (s *messageStore) chatAction(index []string, value *waSyncAction.SyncActionValue) {
+	if len(index) < 2 || value == nil {
+		return
+	}
+	var column string
+	var v interface{}
+	switch index[0] {
+	case appstate.IndexMute:
+		muted := int64(0)
+		if value.GetMuteAction().GetMuted() {
+			muted = -1
+			if end := value.GetMuteAction().GetMuteEndTimestamp(); end > 0 {
+				muted = end / 1000
+			}
+		}
+		column, v = "muted_until", muted
+	case appstate.IndexPin:
+		column, v = "pinned", value.GetPinAction().GetPinned()
+	case appstate.IndexArchive:
+		column, v = "archived", value.GetArchiveChatAction().GetArchived()
+	case appstate.IndexMarkChatAsRead:
+		column, v = "unread_count", 0
+	default:
+		return
+	}
+	chat, err := types.ParseJID(index[1])
+	if err != nil {
+		return
+	}
+	update := column + ` = excluded.` + column
+	if index[0] == appstate.IndexMarkChatAsRead && !value.GetMarkChatAsReadAction().GetRead() {
+		// Marked unread: -1 as WhatsApp keeps it, unless messages are unread
+		v, update = -1, `unread_count = CASE WHEN unread_count > 0 THEN unread_count ELSE -1 END`
+	}
+	_, err = s.db.Exec(`INSERT INTO pod_chats (jid, `+column+`) VALUES (?, ?)
+		ON CONFLICT (jid) DO UPDATE SET `+update, chat.String(), v)
+	if err != nil {
+		logger.Warnf("Recording %s of chat %s: %v", index[0], chat, err)
+	}
+}
+
+// setDisappearingTimer records the disappearing timer of a chat
+func (s *messageStore) setDisappearingTimer(chat types.JID, seconds uint32) {
+	_, err := s.db.Exec(`INSERT INTO pod_chats (jid, disappearing_timer) VALUES (?, ?)
+		ON CONFLICT (jid) DO UPDATE SET disappearing_timer = excluded.disappearing_timer`, chat.String(), seconds)
+	if err != nil {
+		logger.Warnf("Recording the disappearing timer of %s: %v", chat, err)
+	}
+}
@@ -0,0 +1,214 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// A community is a parent group that other groups are linked to. WhatsApp
+// creates its announcement group (the default subgroup) along with it.
+
+// CommunityInfo is one community and the groups linked to it
+type CommunityInfo struct {
+	JID               string              `json:"jid"`
+	Name              string              `json:"name"`
+	Topic             string              `json:"topic,omitempty"`
+	Owner             string              `json:"owner,omitempty"`
+	AnnouncementGroup string              `json:"announcement_group,omitempty"` // the default subgroup
+	Subgroups         []CommunitySubgroup `json:"subgroups"`
+	CreatedAt         int64               `json:"created_at,omitempty"`
+}
+
+// CommunitySubgroup is a group linked to a community
+type CommunitySubgroup struct {
+	JID     string `json:"jid"`
+	Name    string `json:"name"`
+	Default bool   `json:"default,omitempty"` // the announcement group
+}
+
+// CommunitiesResult is returned by GetCommunities. Communities whose
+// subgroups could not be fetched are listed in Failed.
+type CommunitiesResult struct {
+	Success     bool            `json:"success"`
+	Message     string          `json:"message,omitempty"`
+	Communities []CommunityInfo `json:"communities"`
+	Failed      []GroupFailure  `json:"failed,omitempty"`
+}
+
+// CommunityResult is returned by GetCommunitySubgroups and CreateCommunity
+type CommunityResult struct {
+	Success   bool           `json:"success"`
+	Message   string         `json:"message,omitempty"`
+	Community *CommunityInfo `json:"community,omitempty"`
+}
+
+// CommunityCreateInfo is what create-community takes
+type CommunityCreateInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// communityInfo converts whatsmeow's info of a community and its subgroups
+func communityInfo(group *types.GroupInfo, subgroups []*types.GroupLinkTarget) CommunityInfo {
+	info := CommunityInfo{
+		JID:       group.JID.String(),
+		Name:      group.Name,
+		Topic:     group.Topic,
+		Subgroups: make([]CommunitySubgroup, 0, len(subgroups)),
+	}
+	if !group.OwnerJID.IsEmpty() {
+		info.Owner = group.OwnerJID.String()
+	}
+	if !group.GroupCreated.IsZero() {
+		info.CreatedAt = group.GroupCreated.Unix()
+	}
+	for _, sub := range subgroups {
+		info.Subgroups = append(info.Subgroups, CommunitySubgroup{
+			JID:     sub.JID.String(),
+			Name:    sub.Name,
+			Default: sub.IsDefaultSubGroup,
+		})
+		if sub.IsDefaultSubGroup {
+			info.AnnouncementGroup = sub.JID.String()
+		}
+	}
+	return info
+}
+
+// GetCommunities lists the joined communities with their subgroups,
+// including the subgroups the account is not a member of. The call only
+// fails as a whole when the group list cannot be fetched.
+func (wac *WhatsAppClient) GetCommunities(ctx context.Context) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return CommunitiesResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	groups, err := callContext(wac, ctx, "fetching groups", wac.Client.GetJoinedGroups)
+	if err != nil {
+		return CommunitiesResult{Success: false, Message: err.Error()}, err
+	}
+
+	communities := []CommunityInfo{}
+	var failed []GroupFailure
+	for _, group := range groups {
+		if !group.IsParent {
+			continue
+		}
+		subgroups, err := callContext(wac, ctx, "fetching community subgroups", func() ([]*types.GroupLinkTarget, error) {
+			return wac.Client.GetSubGroups(group.JID)
+		})
+		if err != nil {
+			failed = append(failed, GroupFailure{JID: group.JID.String(), Error: err.Error()})
+			continue
+		}
+		communities = append(communities, communityInfo(group, subgroups))
+	}
+	if len(failed) > 0 {
+		logger.For(ctx).Warnf("Fetched the subgroups of %d of %d communities", len(communities), len(communities)+len(failed))
+		return CommunitiesResult{
+			Success:     false,
+			Message:     fmt.Sprintf("Fetched %d of %d communities", len(communities), len(communities)+len(failed)),
+			Communities: communities,
+			Failed:      failed,
+		}, nil
+	}
+	return CommunitiesResult{
+		Success:     true,
+		Message:     fmt.Sprintf("Fetched %d communities", len(communities)),
+		Communities: communities,
+	}, nil
+}
+
+// GetCommunitySubgroups fetches a community and the groups linked to it
+func (wac *WhatsAppClient) GetCommunitySubgroups(ctx context.Context, communityJID string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return CommunityResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	jid, err := parseGroupJID(communityJID)
+	if err != nil {
+		return CommunityResult{Success: false, Message: err.Error()}, err
+	}
+
+	group, err := callContext(wac, ctx, "fetching community info", func() (*types.GroupInfo, error) {
+		return wac.Client.GetGroupInfo(jid)
+	})
+	if err != nil {
+		return CommunityResult{Success: false, Message: err.Error()}, err
+	}
+	if !group.IsParent {
+		err := fmt.Errorf("%s is not a community", jid)
+		return CommunityResult{Success: false, Message: err.Error()}, err
+	}
+	subgroups, err := callContext(wac, ctx, "fetching community subgroups", func() ([]*types.GroupLinkTarget, error) {
+		return wac.Client.GetSubGroups(jid)
+	})
+	if err != nil {
+		return CommunityResult{Success: false, Message: err.Error()}, err
+	}
+	info := communityInfo(group, subgroups)
+	return CommunityResult{
+		Success:   true,
+		Message:   fmt.Sprintf("%d subgroups", len(info.Subgroups)),
+		Community: &info,
+	}, nil
+}
+
+// CreateCommunity creates a community, owned by the account, and sets
+// its description when one is given. New members ask to join and an admin
+// approves them, the WhatsApp default.
+func (wac *WhatsAppClient) CreateCommunity(ctx context.Context, info *CommunityCreateInfo) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return CommunityResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	if info.Name == "" {
+		return CommunityResult{Success: false, Message: "A community needs a name"}, fmt.Errorf("a community needs a name")
+	}
+
+	group, err := callContext(wac, ctx, "creating community", func() (*types.GroupInfo, error) {
+		return wac.Client.CreateGroup(whatsmeow.ReqCreateGroup{
+			Name:        info.Name,
+			GroupParent: types.GroupParent{IsParent: true},
+		})
+	})
+	if err != nil {
+		return CommunityResult{Success: false, Message: err.Error()}, err
+	}
+	created := communityInfo(group, nil)
+	if info.Description != "" {
+		err = callContextErr(wac, ctx, "setting community description", func() error {
+			return wac.Client.SetGroupTopic(group.JID, "", "", info.Description)
+		})
+		if err != nil {
+			// The community exists, so report it along with the error
+			return CommunityResult{Success: false, Message: "Community created, but setting its description failed: " + err.Error(), Community: &created}, err
+		}
+		created.Topic = info.Description
+	}
+	return CommunityResult{Success: true, Message: "Community created", Community: &created}, nil
+}
+
+// LinkGroupToCommunity links a group to a community; the account
+// must be an admin of both
+func (wac *WhatsAppClient) LinkGroupToCommunity(ctx context.Context, communityJID, groupJID string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	community, err := parseGroupJID(communityJID)
+	if err != nil {
+		return GroupResult{Success: false, Message: err.Error()}, err
+	}
+	group, err := parseGroupJID(groupJID)
+	if err != nil {
+		return GroupResult{Success: false, Message: err.Error()}, err
+	}
+
+	err = callContextErr(wac, ctx, "linking group", func() error {
+		return wac.Client.LinkGroup(community, group)
+	})
+	if err != nil {
+		return GroupResult{Success: false, Message: err.Error()}, err
+	}
+	return GroupResult{Success: true, Message: fmt.Sprintf("Linked %s to community %s", group, community)}, nil
+}
@@ -0,0 +1,191 @@
+package whatsapp
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ConnectionInfo is the connection health of a client, tracked from
+// whatsmeow's connection events
+type ConnectionInfo struct {
+	Status        string    // the login status, as reported by Status
+	JID           string    // "" until paired
+	Connected     bool      // between a Connected and a Disconnected event
+	EverConnected bool      // connected at least once since the client was created
+	ChangedAt     time.Time // when Connected last changed
+	LastError     string    // the latest connect failure, stream error or keepalive timeout
+	LastErrorAt   time.Time
+	Restarts      int    // successful calls of Restart
+	Disconnect    string // why the connection last dropped
+	DisconnectAt  time.Time
+}
+
+// connState is the part of ConnectionInfo set by events
+type connState struct {
+	mu          sync.Mutex
+	connected   bool
+	ever        bool
+	changedAt   time.Time
+	lastError   string
+	lastErrorAt time.Time
+	restarts    int
+	disconnect  string
+	disconnAt   time.Time
+}
+
+// sessionState is who the session is and how far its login got. The
+// whatsmeow event goroutine writes it while invokes read it.
+type sessionState struct {
+	mu     sync.Mutex
+	jid    types.JID
+	status string // "not-logged-in", "qr-pending", "logged-in", "login-failed", "connecting", "logged-out", "upgrade-required"
+	qrCode string // the latest QR code, while one is pending
+}
+
+// get returns the session's JID and login status
+func (ss *sessionState) get() (types.JID, string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.jid, ss.status
+}
+
+func (ss *sessionState) JID() types.JID {
+	jid, _ := ss.get()
+	return jid
+}
+
+func (ss *sessionState) loginStatus() string {
+	_, status := ss.get()
+	return status
+}
+
+func (ss *sessionState) setStatus(status string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.status = status
+}
+
+// setStatusIf changes the status only from one of from, and reports whether
+// it did
+func (ss *sessionState) setStatusIf(status string, from ...string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if !slices.Contains(from, ss.status) {
+		return false
+	}
+	ss.status = status
+	return true
+}
+
+// setStatusUnless changes the status unless it is already except, and
+// reports whether it did
+func (ss *sessionState) setStatusUnless(status, except string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.status == except {
+		return false
+	}
+	ss.status = status
+	return true
+}
+
+// loggedIn records a paired session
+func (ss *sessionState) loggedIn(jid types.JID) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.jid, ss.status = jid, "logged-in"
+}
+
+func (ss *sessionState) setJID(jid types.JID) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.jid = jid
+}
+
+func (ss *sessionState) qr() string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.qrCode
+}
+
+func (ss *sessionState) setQR(code string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.qrCode = code
+}
+
+func (wac *WhatsAppClient) noteConnected(connected bool) {
+	cs := &wac.conn
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if connected != cs.connected || cs.changedAt.IsZero() {
+		cs.changedAt = time.Now()
+	}
+	cs.connected = connected
+	cs.ever = cs.ever || connected
+}
+
+// noteDisconnected records that the connection dropped, and why
+func (wac *WhatsAppClient) noteDisconnected(reason string) {
+	wac.noteConnected(false)
+	cs := &wac.conn
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.disconnect = reason
+	cs.disconnAt = time.Now()
+}
+
+func (wac *WhatsAppClient) noteConnError(format string, args ...interface{}) {
+	cs := &wac.conn
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.lastError = fmt.Sprintf(format, args...)
+	cs.lastErrorAt = time.Now()
+}
+
+// Connection reports the client's connection health
+func (wac *WhatsAppClient) Connection() ConnectionInfo {
+	jid, status := wac.session.get()
+	cs := &wac.conn
+	cs.mu.Lock()
+	info := ConnectionInfo{
+		Status:        status,
+		Connected:     cs.connected,
+		EverConnected: cs.ever,
+		ChangedAt:     cs.changedAt,
+		LastError:     cs.lastError,
+		LastErrorAt:   cs.lastErrorAt,
+		Restarts:      cs.restarts,
+		Disconnect:    cs.disconnect,
+		DisconnectAt:  cs.disconnAt,
+	}
+	cs.mu.Unlock()
+	if !jid.IsEmpty() {
+		info.JID = jid.String()
+	}
+	return info
+}
+
+// Restart drops the connection and connects again with the stored session,
+// for a client that is stuck reconnecting. It fails without a paired
+// session, since connecting would only produce a QR code nobody scans.
+func (wac *WhatsAppClient) Restart() error {
+	if !wac.HasSession() {
+		return fmt.Errorf("no session to reconnect")
+	}
+	logger.Infof("Restarting the connection...")
+	wac.Client.Disconnect()
+	wac.noteDisconnected("restart")
+	if err := wac.Client.Connect(); err != nil {
+		wac.noteConnError("restart: %v", err)
+		return err
+	}
+	wac.conn.mu.Lock()
+	wac.conn.restarts++
+	wac.conn.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,279 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// defaultContactMatches is how many matches FindContact returns by default
+const defaultContactMatches = 10
+
+// ContactEntry is one contact of the contact store
+type ContactEntry struct {
+	JID          string `json:"jid"`
+	Phone        string `json:"phone,omitempty"` // for phone-number JIDs
+	Name         string `json:"name,omitempty"`  // the name saved in the address book
+	FirstName    string `json:"first_name,omitempty"`
+	PushName     string `json:"push_name,omitempty"` // the name the contact chose
+	BusinessName string `json:"business_name,omitempty"`
+	Score        int    `json:"score,omitempty"` // how well it matched, 1 to 100, for find-contact
+}
+
+// ContactsResult is returned by GetContacts and FindContact
+type ContactsResult struct {
+	Success  bool           `json:"success"`
+	Message  string         `json:"message,omitempty"`
+	Contacts []ContactEntry `json:"contacts"`
+}
+
+// GetContacts returns every contact the session knows, from the address
+// book synced from the phone and the push names of people who wrote, sorted
+// by name
+func (wac *WhatsAppClient) GetContacts(ctx context.Context) (interface{}, error) {
+	contacts, err := wac.allContacts(ctx)
+	if err != nil {
+		return ContactsResult{Success: false, Message: err.Error(), Contacts: []ContactEntry{}}, err
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		a, b := strings.ToLower(contacts[i].displayName()), strings.ToLower(contacts[j].displayName())
+		if a != b {
+			return a < b
+		}
+		return contacts[i].JID < contacts[j].JID
+	})
+	return ContactsResult{Success: true, Message: fmt.Sprintf("%d contact(s)", len(contacts)), Contacts: contacts}, nil
+}
+
+// FindContact returns the contacts whose names or number best match query,
+// best first. Names match case-insensitively by prefix, substring or with a
+// typo or two; a query of digits matches the phone number. limit <= 0
+// returns the default number of matches.
+func (wac *WhatsAppClient) FindContact(ctx context.Context, query string, limit int) (interface{}, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return ContactsResult{Success: false, Message: "The query is required", Contacts: []ContactEntry{}}, fmt.Errorf("query is required")
+	}
+	if limit <= 0 {
+		limit = defaultContactMatches
+	}
+	contacts, err := wac.allContacts(ctx)
+	if err != nil {
+		return ContactsResult{Success: false, Message: err.Error(), Contacts: []ContactEntry{}}, err
+	}
+
+	matches := []ContactEntry{}
+	for _, c := range contacts {
+		if c.Score = matchContact(c, query); c.Score > 0 {
+			matches = append(matches, c)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return strings.ToLower(matches[i].displayName()) < strings.ToLower(matches[j].displayName())
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return ContactsResult{Success: true, Message: fmt.Sprintf("%d match(es) for %q", len(matches), query), Contacts: matches}, nil
+}
+
+// allContacts reads the contact store
+func (wac *WhatsAppClient) allContacts(ctx context.Context) ([]ContactEntry, error) {
+	if !wac.Client.IsLoggedIn() {
+		return nil, fmt.Errorf("not logged in")
+	}
+	stored, err := callContext(wac, ctx, "reading contacts", func() (map[types.JID]types.ContactInfo, error) {
+		return wac.Client.GetAllContacts()
+	})
+	if err != nil {
+		return nil, err
+	}
+	contacts := make([]ContactEntry, 0, len(stored))
+	for jid, c := range stored {
+		e := ContactEntry{
+			JID:          jid.String(),
+			Name:         c.FullName,
+			FirstName:    c.FirstName,
+			PushName:     c.PushName,
+			BusinessName: c.BusinessName,
+		}
+		if jid.Server == types.DefaultUserServer {
+			e.Phone = jid.User
+		}
+		contacts = append(contacts, e)
+	}
+	return contacts, nil
+}
+
+// displayName is the name WhatsApp would show for the contact
+func (c ContactEntry) displayName() string {
+	for _, name := range []string{c.Name, c.BusinessName, c.PushName, c.Phone} {
+		if name != "" {
+			return name
+		}
+	}
+	return c.JID
+}
+
+// matchContact scores how well query matches a contact, 0 for no match
+func matchContact(c ContactEntry, query string) int {
+	best := 0
+	if digits, err := NormalizePhone(query); err == nil && len(strings.TrimLeft(digits, "0")) >= 3 && c.Phone != "" {
+		switch {
+		case c.Phone == digits:
+			best = 100
+		case strings.HasSuffix(c.Phone, strings.TrimLeft(digits, "0")):
+			best = 90 // a local number, without the country code or with a trunk 0
+		case strings.Contains(c.Phone, digits):
+			best = 60
+		}
+	}
+	q := strings.ToLower(query)
+	for _, name := range []string{c.Name, c.FirstName, c.PushName, c.BusinessName} {
+		if name != "" {
+			best = max(best, matchName(strings.ToLower(name), q))
+		}
+	}
+	return best
+}
+
+// matchName scores a lower-case name against a lower-case query
+func matchName(name, query string) int {
+	switch {
+	case name == query:
+		return 100
+	case strings.HasPrefix(name, query):
+		return 90
+	}
+	words := strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	score := 0
+	for _, w := range words {
+		switch {
+		case w == query:
+			score = max(score, 85)
+		case strings.HasPrefix(w, query):
+			score = max(score, 80)
+		case len([]rune(query)) >= 4 && editDistance(w, query) == 1:
+			score = max(score, 60) // a typo: "kofy" finds "Kofi"
+		case len([]rune(query)) >= 7 && editDistance(w, query) == 2:
+			score = max(score, 50)
+		}
+	}
+	if strings.Contains(name, query) {
+		score = max(score, 70)
+	}
+	return score
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// checkNumbersBatch is how many numbers go in one registration query
+const checkNumbersBatch = 50
+
+// NumberCheck is whether one phone number is on WhatsApp
+type NumberCheck struct {
+	Input        string `json:"input"`
+	Phone        string `json:"phone,omitempty"` // the normalized digits
+	IsOnWhatsApp bool   `json:"is_on_whatsapp"`
+	JID          string `json:"jid,omitempty"`           // the canonical JID to send to, when registered
+	BusinessName string `json:"business_name,omitempty"` // the verified name of a business account
+	Error        string `json:"error,omitempty"`         // why the number was not checked
+}
+
+// CheckNumbersResult is returned by CheckNumbers
+type CheckNumbersResult struct {
+	Success    bool          `json:"success"`
+	Message    string        `json:"message,omitempty"`
+	Registered int           `json:"registered"`
+	Numbers    []NumberCheck `json:"numbers"`
+}
+
+// CheckNumbers asks WhatsApp which phone numbers are registered, in the
+// order given. Invalid numbers are reported one by one rather than failing
+// the whole check.
+func (wac *WhatsAppClient) CheckNumbers(ctx context.Context, phones []string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return CheckNumbersResult{Success: false, Message: "Not logged in", Numbers: []NumberCheck{}}, fmt.Errorf("not logged in")
+	}
+	if len(phones) == 0 {
+		return CheckNumbersResult{Success: false, Message: "No phone numbers given", Numbers: []NumberCheck{}}, fmt.Errorf("no phone numbers given")
+	}
+
+	checks := make([]NumberCheck, len(phones))
+	var queries []string
+	seen := map[string]bool{}
+	for i, p := range phones {
+		checks[i].Input = p
+		digits, err := NormalizePhone(p)
+		if err != nil {
+			checks[i].Error = err.Error()
+			continue
+		}
+		checks[i].Phone = digits
+		if !seen[digits] {
+			seen[digits] = true
+			queries = append(queries, "+"+digits)
+		}
+	}
+
+	found := map[string]types.IsOnWhatsAppResponse{}
+	for start := 0; start < len(queries); start += checkNumbersBatch {
+		batch := queries[start:min(start+checkNumbersBatch, len(queries))]
+		resp, err := callContext(wac, ctx, "checking phone numbers", func() ([]types.IsOnWhatsAppResponse, error) {
+			return wac.Client.IsOnWhatsApp(batch)
+		})
+		if err != nil {
+			return CheckNumbersResult{Success: false, Message: err.Error(), Numbers: []NumberCheck{}}, err
+		}
+		for _, r := range resp {
+			found[strings.TrimPrefix(r.Query, "+")] = r
+		}
+	}
+
+	registered := 0
+	for i := range checks {
+		r, ok := found[checks[i].Phone]
+		if !ok || !r.IsIn {
+			continue
+		}
+		checks[i].IsOnWhatsApp = true
+		checks[i].JID = r.JID.String()
+		if r.VerifiedName != nil {
+			checks[i].BusinessName = r.VerifiedName.Details.GetVerifiedName()
+		}
+		registered++
+	}
+	return CheckNumbersResult{
+		Success:    true,
+		Message:    fmt.Sprintf("%d of %d numbers are on WhatsApp", registered, len(phones)),
+		Registered: registered,
+		Numbers:    checks,
+	}, nil
+}
@@ -0,0 +1,349 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a send or upload that failed after its retries. Op and Args
+// are the call as made, so it can be replayed with RetryDeadLetter.
+type DeadLetter struct {
+	ID       int64    `json:"id"`
+	Op       string   `json:"op"` // send-message, send-group-message, upload, send-image, webhook, ...
+	Args     []string `json:"args"`
+	Error    string   `json:"error"`
+	Attempts int      `json:"attempts"` // every try so far, including retries and replays
+	FailedAt int64    `json:"failed_at"`
+}
+
+// DeadLetterResult is returned by GetDeadLetters and DiscardDeadLetter
+type DeadLetterResult struct {
+	Success     bool         `json:"success"`
+	Message     string       `json:"message,omitempty"`
+	DeadLetters []DeadLetter `json:"dead_letters"`
+}
+
+// deadLetterStore persists failed operations in the session database, so
+// they survive a restart until they are retried or discarded
+type deadLetterStore struct {
+	db *sql.DB
+}
+
+func newDeadLetterStore(db *sql.DB) (*deadLetterStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS pod_dead_letters (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		op        TEXT NOT NULL,
+		args      TEXT NOT NULL,
+		error     TEXT NOT NULL,
+		attempts  INTEGER NOT NULL,
+		failed_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetterStore{db: db}, nil
+}
+
+func (s *deadLetterStore) add(l *DeadLetter) error {
+	args, err := json.Marshal(l.Args)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`INSERT INTO pod_dead_letters (op, args, error, attempts, failed_at) VALUES (?, ?, ?, ?, ?)`,
+		l.Op, string(args), l.Error, l.Attempts, l.FailedAt)
+	if err != nil {
+		return err
+	}
+	l.ID, err = res.LastInsertId()
+	return err
+}
+
+// failedAgain records another failed replay of an existing dead letter
+func (s *deadLetterStore) failedAgain(id int64, reason string, attempts int) error {
+	_, err := s.db.Exec(`UPDATE pod_dead_letters SET error = ?, attempts = attempts + ?, failed_at = ? WHERE id = ?`,
+		reason, attempts, time.Now().Unix(), id)
+	return err
+}
+
+// list returns the dead letters oldest first, or only the one with id
+func (s *deadLetterStore) list(id int64) ([]DeadLetter, error) {
+	query := `SELECT id, op, args, error, attempts, failed_at FROM pod_dead_letters`
+	var params []interface{}
+	if id != 0 {
+		query += ` WHERE id = ?`
+		params = append(params, id)
+	}
+	rows, err := s.db.Query(query+` ORDER BY id`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		var l DeadLetter
+		var args string
+		if err := rows.Scan(&l.ID, &l.Op, &args, &l.Error, &l.Attempts, &l.FailedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(args), &l.Args); err != nil {
+			return nil, fmt.Errorf("dead letter %d: %w", l.ID, err)
+		}
+		letters = append(letters, l)
+	}
+	return letters, rows.Err()
+}
+
+// remove deletes a dead letter, reporting whether it existed
+func (s *deadLetterStore) remove(id int64) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM pod_dead_letters WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ReplayFunc replays a dead letter of an op outside the client, such as a
+// webhook delivery of the pod. On failure it records the error again with
+// AddDeadLetter and the ctx it was given.
+type ReplayFunc func(ctx context.Context, args []string) (interface{}, error)
+
+// replayers are the ReplayFuncs registered with HandleReplay
+type replayers struct {
+	mu  sync.Mutex
+	ops map[string]ReplayFunc
+}
+
+type replayKey struct{}
+
+// HandleReplay registers how dead letters of op are retried
+func (wac *WhatsAppClient) HandleReplay(op string, fn ReplayFunc) {
+	r := &wac.replayers
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ops == nil {
+		r.ops = make(map[string]ReplayFunc)
+	}
+	r.ops[op] = fn
+}
+
+// AddDeadLetter records an operation outside the client that failed after
+// attempts tries, so it is listed and retried like a failed send. Its op
+// needs a ReplayFunc registered with HandleReplay.
+func (wac *WhatsAppClient) AddDeadLetter(ctx context.Context, err error, attempts int, op string, args ...string) error {
+	ctx, stats := withRetryStats(ctx)
+	stats.Retries = attempts - 1
+	return wac.deadLetter(ctx, err, op, args...)
+}
+
+// deadLetter records a send or upload that failed after its retries and
+// returns err unchanged. A call cancelled by its caller is not recorded, and
+// a failed replay updates its existing dead letter instead of adding one.
+func (wac *WhatsAppClient) deadLetter(ctx context.Context, err error, op string, args ...string) error {
+	if wac.deadLetters == nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	stats := retryStatsFrom(ctx)
+	attempts := 1 + stats.Retries + stats.AckRetries
+	if id, ok := ctx.Value(replayKey{}).(int64); ok {
+		if dbErr := wac.deadLetters.failedAgain(id, err.Error(), attempts); dbErr != nil {
+			logger.For(ctx).Warnf("Updating dead letter %d: %v", id, dbErr)
+		}
+		return err
+	}
+
+	l := DeadLetter{Op: op, Args: args, Error: err.Error(), Attempts: attempts, FailedAt: time.Now().Unix()}
+	if dbErr := wac.deadLetters.add(&l); dbErr != nil {
+		logger.For(ctx).Errorf("Recording failed %s as a dead letter: %v", op, dbErr)
+		return err
+	}
+	metricDeadLetters.Inc()
+	logger.For(ctx).Warnf("%s failed after %d attempt(s), kept as dead letter %d: %v", op, attempts, l.ID, err)
+	wac.publish("dead-letter", l)
+	return err
+}
+
+// GetDeadLetters lists the failed sends and uploads, or only the one with
+// id when it is not 0
+func (wac *WhatsAppClient) GetDeadLetters(id int64) (interface{}, error) {
+	if wac.deadLetters == nil {
+		return DeadLetterResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	letters, err := wac.deadLetters.list(id)
+	if err != nil {
+		return DeadLetterResult{Success: false, Message: err.Error()}, err
+	}
+	if id != 0 && len(letters) == 0 {
+		return DeadLetterResult{Success: false, Message: fmt.Sprintf("No dead letter %d", id), DeadLetters: letters},
+			fmt.Errorf("no dead letter %d", id)
+	}
+	return DeadLetterResult{
+		Success:     true,
+		Message:     fmt.Sprintf("%d dead letter(s)", len(letters)),
+		DeadLetters: letters,
+	}, nil
+}
+
+// RetryDeadLetter replays a dead letter with its original arguments and
+// returns what the call returns. It is removed once the replay succeeds;
+// otherwise it stays, with the new error and attempt count.
+func (wac *WhatsAppClient) RetryDeadLetter(ctx context.Context, id int64) (interface{}, error) {
+	if wac.deadLetters == nil {
+		return DeadLetterResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	letters, err := wac.deadLetters.list(id)
+	if err != nil {
+		return DeadLetterResult{Success: false, Message: err.Error()}, err
+	}
+	if len(letters) == 0 {
+		return DeadLetterResult{Success: false, Message: fmt.Sprintf("No dead letter %d", id)}, fmt.Errorf("no dead letter %d", id)
+	}
+
+	l := letters[0]
+	logger.For(ctx).Infof("Retrying dead letter %d (%s)", l.ID, l.Op)
+	result, err := wac.replay(context.WithValue(ctx, replayKey{}, l.ID), l.Op, l.Args)
+	if err != nil {
+		return result, err
+	}
+	if _, err := wac.deadLetters.remove(l.ID); err != nil {
+		logger.For(ctx).Warnf("Removing dead letter %d after a successful retry: %v", l.ID, err)
+	}
+	return result, nil
+}
+
+// DiscardDeadLetter deletes a dead letter without retrying it
+func (wac *WhatsAppClient) DiscardDeadLetter(id int64) (interface{}, error) {
+	if wac.deadLetters == nil {
+		return DeadLetterResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	letters, err := wac.deadLetters.list(id)
+	if err != nil {
+		return DeadLetterResult{Success: false, Message: err.Error()}, err
+	}
+	if ok, err := wac.deadLetters.remove(id); err != nil || !ok {
+		if err == nil {
+			err = fmt.Errorf("no dead letter %d", id)
+		}
+		return DeadLetterResult{Success: false, Message: err.Error()}, err
+	}
+	return DeadLetterResult{
+		Success:     true,
+		Message:     fmt.Sprintf("Dead letter %d discarded", id),
+		DeadLetters: letters,
+	}, nil
+}
+
+// replay runs a recorded operation again
+func (wac *WhatsAppClient) replay(ctx context.Context, op string, args []string) (interface{}, error) {
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+	// list is a comma-separated argument, such as the mentions of a message
+	list := func(i int) []string {
+		if arg(i) == "" {
+			return nil
+		}
+		return strings.Split(arg(i), ",")
+	}
+	switch op {
+	case "send-message":
+		return wac.SendMessage(ctx, arg(0), arg(1), TextOptions{LinkPreview: arg(2) == "true", Mentions: list(3)})
+	case "send-group-message":
+		return wac.SendGroupMessage(ctx, arg(0), arg(1), TextOptions{LinkPreview: arg(3) == "true", Mentions: list(2)})
+	case "send-to-jid":
+		return wac.SendToJID(ctx, arg(0), arg(1))
+	case "reply-message":
+		return wac.ReplyMessage(ctx, arg(0), QuotedMessage{ID: arg(1), Sender: arg(2), Content: arg(3)}, arg(4))
+	case "send-reaction":
+		return wac.SendReaction(ctx, arg(0), arg(1), arg(2), arg(3))
+	case "send-buttons":
+		var buttons InteractiveButtons
+		if err := json.Unmarshal([]byte(arg(1)), &buttons); err != nil {
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+		return wac.SendButtons(ctx, arg(0), buttons)
+	case "send-list":
+		var list InteractiveList
+		if err := json.Unmarshal([]byte(arg(1)), &list); err != nil {
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+		return wac.SendList(ctx, arg(0), list)
+	case "send-status-update":
+		var status StatusUpdate
+		if err := json.Unmarshal([]byte(arg(0)), &status); err != nil {
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+		return wac.SendStatusUpdate(ctx, status)
+	case "post-status-image", "post-status-video":
+		var opts StatusMediaOptions
+		if err := json.Unmarshal([]byte(arg(1)), &opts); err != nil {
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+		if op == "post-status-video" {
+			return wac.PostStatusVideo(ctx, arg(0), opts)
+		}
+		return wac.PostStatusImage(ctx, arg(0), opts)
+	case "edit-message":
+		return wac.EditMessage(ctx, arg(0), arg(1), arg(2))
+	case "delete-message":
+		return wac.DeleteMessage(ctx, arg(0), arg(1), arg(2))
+	case "forward-message":
+		var opts ForwardOptions
+		if err := json.Unmarshal([]byte(arg(2)), &opts); err != nil {
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+		return wac.ForwardMessage(ctx, arg(0), arg(1), opts)
+	case "upload":
+		return wac.Upload(ctx, arg(0), arg(1))
+	case "send-uploaded-image", "send-uploaded-video", "send-uploaded-document":
+		var media MediaInfo
+		if err := json.Unmarshal([]byte(arg(1)), &media); err != nil {
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+		switch op {
+		case "send-uploaded-video":
+			return wac.SendUploadedVideo(ctx, arg(0), media, arg(2))
+		case "send-uploaded-document":
+			return wac.SendUploadedDocument(ctx, arg(0), media, arg(2))
+		}
+		return wac.SendUploadedImage(ctx, arg(0), media, arg(2))
+	case "send-image", "send-video":
+		var opts MediaSendOptions
+		if arg(3) != "" {
+			if err := json.Unmarshal([]byte(arg(3)), &opts); err != nil {
+				return SendResult{Success: false, Message: err.Error()}, err
+			}
+		}
+		if op == "send-video" {
+			return wac.SendVideo(ctx, arg(0), arg(1), arg(2), opts)
+		}
+		return wac.SendImage(ctx, arg(0), arg(1), arg(2), opts)
+	case "send-document":
+		return wac.SendDocument(ctx, arg(0), arg(1), arg(2))
+	case "send-audio":
+		return wac.SendAudio(ctx, arg(0), arg(1))
+	case "send-voice-note":
+		var opts VoiceNoteOptions
+		if err := json.Unmarshal([]byte(arg(2)), &opts); err != nil {
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+		return wac.SendVoiceNote(ctx, arg(0), arg(1), opts)
+	}
+	wac.replayers.mu.Lock()
+	fn, ok := wac.replayers.ops[op]
+	wac.replayers.mu.Unlock()
+	if ok {
+		return fn(ctx, args)
+	}
+	return DeadLetterResult{Success: false, Message: "Cannot retry " + op}, fmt.Errorf("cannot retry %s", op)
+}
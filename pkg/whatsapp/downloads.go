@@ -0,0 +1,262 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// retentionInterval is how often the download directory and the message
+// history are checked against their retention policies
+const retentionInterval = time.Hour
+
+// DownloadInfo is the data of a media-downloaded event
+type DownloadInfo struct {
+	MessageID string `json:"message_id"`
+	ChatID    string `json:"chat_id"`
+	Path      string `json:"path"`
+	Size      int    `json:"size"`
+	MimeType  string `json:"mime_type,omitempty"`
+}
+
+// PurgeResult is returned by PurgeDownloads
+type PurgeResult struct {
+	Success      bool   `json:"success"`
+	Message      string `json:"message,omitempty"`
+	FilesRemoved int    `json:"files_removed"`
+	BytesFreed   int64  `json:"bytes_freed"`
+}
+
+// incomingMedia returns the downloadable part of a message, its MIME type
+// and the file name the sender gave it, if any
+func incomingMedia(msg *waProto.Message) (whatsmeow.DownloadableMessage, string, string) {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage(), msg.GetImageMessage().GetMimetype(), ""
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage(), msg.GetVideoMessage().GetMimetype(), ""
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage(), msg.GetAudioMessage().GetMimetype(), ""
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage(), msg.GetDocumentMessage().GetMimetype(), msg.GetDocumentMessage().GetFileName()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage(), msg.GetStickerMessage().GetMimetype(), ""
+	}
+	return nil, "", ""
+}
+
+// safeName makes a JID or sender-supplied file name usable as one path element
+var safeName = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "..", "_").Replace
+
+// downloadPath returns where an incoming media message is saved:
+// <dir>[/<chat>]/<message id>[_<file name>]<ext>
+func downloadPath(opts Options, msg *events.Message, mimeType, fileName string) string {
+	dir := opts.DownloadDir
+	if opts.DownloadPerChat {
+		dir = filepath.Join(dir, safeName(msg.Info.Chat.String()))
+	}
+	name := safeName(msg.Info.ID)
+	if fileName != "" {
+		return filepath.Join(dir, name+"_"+safeName(filepath.Base(fileName)))
+	}
+	return filepath.Join(dir, name+mediaExtension(mimeType))
+}
+
+// commonExtensions overrides mime's choice for the types WhatsApp sends most,
+// where the alphabetically first extension is an unusual one (.jfif, .oga)
+var commonExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"audio/ogg":  ".ogg",
+	"audio/mpeg": ".mp3",
+	"video/mp4":  ".mp4",
+}
+
+// mediaExtension returns a file extension for a MIME type, or ""
+func mediaExtension(mimeType string) string {
+	mimeType = strings.TrimSpace(strings.Split(mimeType, ";")[0])
+	if ext, ok := commonExtensions[mimeType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// autoDownload streams the media of an incoming message to the download
+// directory and publishes a media-downloaded event
+func (wac *WhatsAppClient) autoDownload(msg *events.Message) {
+	defer LogPanic(logger, "downloading incoming media")
+	opts := wac.Options()
+	media, mimeType, fileName := incomingMedia(msg.Message)
+	if opts.DownloadDir == "" || media == nil {
+		return
+	}
+
+	path := downloadPath(opts, msg, mimeType, fileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		logger.Errorf("Creating download directory: %v", err)
+		return
+	}
+	ctx, cancel := wac.withCallTimeout(context.Background())
+	defer cancel()
+	size, err := wac.downloadToFile(ctx, media, path, TransferInfo{ID: msg.Info.ID, ChatID: msg.Info.Chat.String()})
+	if err = timeoutError("download media", err); err != nil {
+		logger.Errorf("Downloading media of message %s: %v", msg.Info.ID, err)
+		return
+	}
+	logger.Infof("Saved media of message %s to %s (%d bytes)", msg.Info.ID, path, size)
+	wac.publish("media-downloaded", DownloadInfo{
+		MessageID: msg.Info.ID,
+		ChatID:    msg.Info.Chat.String(),
+		Path:      path,
+		Size:      int(size),
+		MimeType:  mimeType,
+	})
+
+	wac.enforceRetention()
+}
+
+// retentionLoop applies the download and message history retention
+// policies periodically until stop closes
+func (wac *WhatsAppClient) retentionLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wac.retentionPass()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// retentionPass runs one tick of retentionLoop
+func (wac *WhatsAppClient) retentionPass() {
+	defer LogPanic(logger, "the retention loop")
+	wac.enforceRetention()
+	wac.enforceHistoryRetention()
+}
+
+// enforceRetention deletes downloads older than DownloadMaxAge, then the
+// oldest ones until the directory fits in DownloadMaxBytes
+func (wac *WhatsAppClient) enforceRetention() {
+	opts := wac.Options()
+	if opts.DownloadDir == "" || (opts.DownloadMaxAge <= 0 && opts.DownloadMaxBytes <= 0) {
+		return
+	}
+	files, bytes, err := wac.pruneDownloads(opts.DownloadDir, opts.DownloadMaxAge, opts.DownloadMaxBytes)
+	if err != nil {
+		logger.Warnf("Applying download retention: %v", err)
+	}
+	if files > 0 {
+		logger.Infof("Download retention removed %d files (%d bytes)", files, bytes)
+	}
+}
+
+// PurgeDownloads deletes downloaded media older than olderThan, or all of it
+// when olderThan is 0
+func (wac *WhatsAppClient) PurgeDownloads(olderThan time.Duration) (interface{}, error) {
+	dir := wac.Options().DownloadDir
+	if dir == "" {
+		return PurgeResult{Success: false, Message: "No download directory configured"}, fmt.Errorf("no download directory configured")
+	}
+	if olderThan < 0 {
+		return PurgeResult{Success: false, Message: "Age must not be negative"}, fmt.Errorf("age must not be negative")
+	}
+	maxAge := olderThan
+	if maxAge == 0 {
+		maxAge = -1 // everything is older than that
+	}
+	files, bytes, err := wac.pruneDownloads(dir, maxAge, 0)
+	if err != nil {
+		return PurgeResult{Success: false, Message: err.Error(), FilesRemoved: files, BytesFreed: bytes}, err
+	}
+	return PurgeResult{
+		Success:      true,
+		Message:      fmt.Sprintf("Removed %d files from %s", files, dir),
+		FilesRemoved: files,
+		BytesFreed:   bytes,
+	}, nil
+}
+
+// pruneDownloads removes files under dir older than maxAge (ignored when
+// 0), then the oldest remaining ones while the total exceeds maxBytes
+// (ignored when 0). Per-chat directories left empty are removed too.
+func (wac *WhatsAppClient) pruneDownloads(dir string, maxAge time.Duration, maxBytes int64) (int, int64, error) {
+	wac.downloadMutex.Lock()
+	defer wac.downloadMutex.Unlock()
+
+	type download struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var all []download
+	var subdirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir // nothing downloaded yet
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != dir {
+				subdirs = append(subdirs, path)
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && !strings.HasSuffix(path, partialSuffix) {
+			all = append(all, download{path, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].modTime.Before(all[j].modTime) })
+
+	var total int64
+	for _, f := range all {
+		total += f.size
+	}
+	var files int
+	var freed int64
+	cutoff := time.Now().Add(-maxAge)
+	for _, f := range all {
+		expired := maxAge != 0 && f.modTime.Before(cutoff)
+		oversize := maxBytes > 0 && total > maxBytes
+		if !expired && !oversize {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			return files, freed, err
+		}
+		files++
+		freed += f.size
+		total -= f.size
+	}
+
+	// Deepest first, so nested directories empty out before their parents
+	sort.Sort(sort.Reverse(sort.StringSlice(subdirs)))
+	for _, sub := range subdirs {
+		os.Remove(sub) // fails harmlessly unless empty
+	}
+	return files, freed, nil
+}
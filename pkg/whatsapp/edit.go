@@ -0,0 +1,79 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// EditMessage replaces the text of a message we sent to a chat.
+// WhatsApp only accepts edits within 15 minutes of the original message.
+func (wac *WhatsAppClient) EditMessage(ctx context.Context, chatJID, messageID, text string) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+
+	chat, err := ParseRecipientJID(chatJID)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	if messageID == "" {
+		return SendResult{Success: false, Message: "The message ID is required"}, fmt.Errorf("message ID is required")
+	}
+
+	msg := wac.Client.BuildEdit(chat, messageID, &waProto.Message{Conversation: &text})
+	resp, err := wac.sendMessage(ctx, chat, msg)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}),
+			wac.deadLetter(ctx, err, "edit-message", chatJID, messageID, text)
+	}
+
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: "Edited message " + messageID,
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// DeleteMessage revokes a message for everyone in the chat. sender
+// is "" for our own messages; group admins can revoke the messages of
+// others by passing their sender.
+func (wac *WhatsAppClient) DeleteMessage(ctx context.Context, chatJID, messageID, sender string) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+
+	chat, err := ParseRecipientJID(chatJID)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	if messageID == "" {
+		return SendResult{Success: false, Message: "The message ID is required"}, fmt.Errorf("message ID is required")
+	}
+	senderJID := types.EmptyJID
+	if sender != "" {
+		if senderJID, err = types.ParseJID(sender); err != nil {
+			err = fmt.Errorf("invalid sender %q: %w", sender, err)
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+	}
+
+	msg := wac.Client.BuildRevoke(chat, senderJID, messageID)
+	resp, err := wac.sendMessage(ctx, chat, msg)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}),
+			wac.deadLetter(ctx, err, "delete-message", chatJID, messageID, sender)
+	}
+
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: "Deleted message " + messageID + " for everyone",
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
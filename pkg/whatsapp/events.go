@@ -0,0 +1,124 @@
+package whatsapp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kbosompem/bb-whatsapp-pod/pkg/metrics"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Event is one item of the client's event stream, shared by every consumer
+// (HTTP, WebSocket, pod listeners)
+type Event struct {
+	Type      string      `json:"type"` // message, receipt, presence, chat-presence, media-downloaded, transfer-progress, group-details, dead-letter, upgrade-required, connected, disconnected, logged-out
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// ReceiptInfo is the data of a receipt event
+type ReceiptInfo struct {
+	ChatID     string   `json:"chat_id"`
+	Sender     string   `json:"sender"`
+	MessageIDs []string `json:"message_ids"`
+	Type       string   `json:"type"` // delivered, read, played, ...
+	Timestamp  int64    `json:"timestamp"`
+}
+
+// PresenceEventInfo is the data of a presence event
+type PresenceEventInfo struct {
+	JID         string `json:"jid"`
+	Unavailable bool   `json:"unavailable"`
+	LastSeen    int64  `json:"last_seen,omitempty"`
+}
+
+// ChatPresenceInfo is the data of a chat-presence (typing/recording) event
+type ChatPresenceInfo struct {
+	ChatID string `json:"chat_id"`
+	Sender string `json:"sender"`
+	State  string `json:"state"`           // composing or paused
+	Media  string `json:"media,omitempty"` // audio while recording a voice note
+}
+
+var metricEventsDropped = metrics.NewCounter("whatsapp_events_dropped_total", "Events dropped because a subscriber was not keeping up.")
+
+// eventBus fans events out to subscribers. A slow subscriber loses events
+// instead of blocking whatsmeow's event handler.
+type eventBus struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]chan Event
+}
+
+// Subscribe returns a channel receiving every event published from now on,
+// and a func that ends the subscription and closes the channel
+func (wac *WhatsAppClient) Subscribe(buffer int) (<-chan Event, func()) {
+	bus := &wac.events
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.subs == nil {
+		bus.subs = map[int]chan Event{}
+	}
+	id := bus.nextID
+	bus.nextID++
+	ch := make(chan Event, buffer)
+	bus.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			bus.mu.Lock()
+			delete(bus.subs, id)
+			bus.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish delivers evt to every subscriber without blocking
+func (wac *WhatsAppClient) publish(eventType string, data interface{}) {
+	evt := Event{Type: eventType, Timestamp: time.Now().Unix(), Data: data}
+	bus := &wac.events
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for id, ch := range bus.subs {
+		select {
+		case ch <- evt:
+		default:
+			metricEventsDropped.Inc()
+			logger.Warnf("Subscriber %d is full, dropped %s event", id, eventType)
+		}
+	}
+}
+
+// subscriberDepths is reported with the queue depths
+func (wac *WhatsAppClient) subscriberDepths() map[string]int {
+	bus := &wac.events
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	depths := map[string]int{}
+	for id, ch := range bus.subs {
+		depths[fmt.Sprintf("subscriber_%d", id)] = len(ch)
+	}
+	return depths
+}
+
+// publishReceipt turns a whatsmeow receipt into a receipt event
+func (wac *WhatsAppClient) publishReceipt(v *events.Receipt) {
+	receiptType := string(v.Type)
+	if receiptType == "" {
+		receiptType = "delivered"
+	}
+	ids := make([]string, len(v.MessageIDs))
+	for i, id := range v.MessageIDs {
+		ids[i] = string(id)
+	}
+	wac.publish("receipt", ReceiptInfo{
+		ChatID:     v.Chat.String(),
+		Sender:     v.Sender.String(),
+		MessageIDs: ids,
+		Type:       receiptType,
+		Timestamp:  v.Timestamp.Unix(),
+	})
+}
@@ -0,0 +1,819 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	waCommon "go.mau.fi/whatsmeow/proto/waCommon"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	waHistorySync "go.mau.fi/whatsmeow/proto/waHistorySync"
+	waWeb "go.mau.fi/whatsmeow/proto/waWeb"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Fake is an in-memory Messenger that never touches the network. Sends and
+// uploads are recorded and succeed with synthetic IDs unless SendErr or
+// UploadErr is set. Set the exported fields before handing it to
+// NewClientWithMessenger; read the recordings with Sent and Uploads.
+type Fake struct {
+	ID        *types.JID // paired device; nil makes Connect emit a QR code
+	PushName  string
+	Groups    []*types.GroupInfo
+	Contacts  map[types.JID]types.ContactInfo
+	Unknown   map[string]bool // phone numbers IsOnWhatsApp reports as not registered
+	SendErr   error
+	UploadErr error
+	DropAcks  int // the next sends are lost: they time out waiting for the server ack
+	// History holds past messages per chat, oldest first, with which the
+	// phone answers on-demand history sync requests
+	History map[types.JID][]*waWeb.WebMessageInfo
+	// JoinRequests are the pending requests to join each group
+	JoinRequests map[types.JID][]types.GroupParticipantRequest
+
+	mu        sync.Mutex
+	loggedIn  bool
+	handlers  []whatsmeow.EventHandler
+	sent      []FakeSent
+	uploads   [][]byte
+	patches   []appstate.PatchInfo
+	appStates map[appstate.WAPatchName]uint64
+	pictures  map[types.JID]string // profile picture IDs, by user or group
+	invites   map[types.JID]string // invite codes of groups whose link was reset
+	nextID    int
+}
+
+// FakeSent is one message recorded by Fake.SendMessage
+type FakeSent struct {
+	To      types.JID
+	Message *waProto.Message
+	ID      types.MessageID
+}
+
+// NewFake returns a Fake that is already paired as jid
+func NewFake(jid types.JID) *Fake {
+	return &Fake{ID: &jid, Contacts: map[types.JID]types.ContactInfo{}}
+}
+
+// Dispatch delivers evt to the registered event handlers, as whatsmeow
+// does for events from the server
+func (f *Fake) Dispatch(evt interface{}) {
+	f.mu.Lock()
+	handlers := append([]whatsmeow.EventHandler(nil), f.handlers...)
+	f.mu.Unlock()
+	for _, h := range handlers {
+		h(evt)
+	}
+}
+
+// Sent returns the messages sent so far
+func (f *Fake) Sent() []FakeSent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeSent(nil), f.sent...)
+}
+
+// Patches returns the app state patches sent so far
+func (f *Fake) Patches() []appstate.PatchInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]appstate.PatchInfo(nil), f.patches...)
+}
+
+// Uploads returns the plaintext of every upload so far
+func (f *Fake) Uploads() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]byte(nil), f.uploads...)
+}
+
+func (f *Fake) newID() string {
+	f.nextID++
+	return fmt.Sprintf("FAKE%012X", f.nextID)
+}
+
+func (f *Fake) GenerateMessageID() types.MessageID {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.newID()
+}
+
+// BuildMessageKey follows whatsmeow: a message is ours unless the sender is
+// another user, and group keys name that sender
+func (f *Fake) BuildMessageKey(chat, sender types.JID, id types.MessageID) *waCommon.MessageKey {
+	key := &waCommon.MessageKey{FromMe: proto.Bool(true), ID: proto.String(id), RemoteJID: proto.String(chat.String())}
+	f.mu.Lock()
+	own := f.ID
+	f.mu.Unlock()
+	if !sender.IsEmpty() && (own == nil || sender.User != own.User) {
+		key.FromMe = proto.Bool(false)
+		if chat.Server != types.DefaultUserServer {
+			key.Participant = proto.String(sender.ToNonAD().String())
+		}
+	}
+	return key
+}
+
+func (f *Fake) BuildReaction(chat, sender types.JID, id types.MessageID, reaction string) *waProto.Message {
+	return &waProto.Message{ReactionMessage: &waProto.ReactionMessage{
+		Key:               f.BuildMessageKey(chat, sender, id),
+		Text:              proto.String(reaction),
+		SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
+	}}
+}
+
+func (f *Fake) BuildRevoke(chat, sender types.JID, id types.MessageID) *waProto.Message {
+	return &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{
+		Type: waProto.ProtocolMessage_REVOKE.Enum(),
+		Key:  f.BuildMessageKey(chat, sender, id),
+	}}
+}
+
+func (f *Fake) BuildEdit(chat types.JID, id types.MessageID, newContent *waProto.Message) *waProto.Message {
+	return &waProto.Message{EditedMessage: &waProto.FutureProofMessage{Message: &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Key:           &waCommon.MessageKey{FromMe: proto.Bool(true), ID: proto.String(id), RemoteJID: proto.String(chat.String())},
+			Type:          waProto.ProtocolMessage_MESSAGE_EDIT.Enum(),
+			EditedMessage: newContent,
+			TimestampMS:   proto.Int64(time.Now().UnixMilli()),
+		},
+	}}}
+}
+
+func (f *Fake) Connect() error {
+	f.mu.Lock()
+	paired := f.ID != nil
+	f.loggedIn = paired
+	f.mu.Unlock()
+	if paired {
+		f.Dispatch(&events.Connected{})
+	} else {
+		f.Dispatch(&events.QR{Codes: []string{"fake-qr-code"}})
+	}
+	return nil
+}
+
+func (f *Fake) Disconnect() {
+	f.mu.Lock()
+	f.loggedIn = false
+	f.mu.Unlock()
+}
+
+func (f *Fake) IsLoggedIn() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.loggedIn
+}
+
+func (f *Fake) Logout() error {
+	f.mu.Lock()
+	f.loggedIn = false
+	f.ID = nil
+	f.mu.Unlock()
+	f.Dispatch(&events.LoggedOut{})
+	return nil
+}
+
+func (f *Fake) AddEventHandler(handler whatsmeow.EventHandler) uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers = append(f.handlers, handler)
+	return uint32(len(f.handlers))
+}
+
+// PairPhone answers with a fixed code; the fake stays unpaired until a test
+// dispatches events.PairSuccess
+func (f *Fake) PairPhone(phone string, showPushNotification bool, clientType whatsmeow.PairClientType, clientDisplayName string) (string, error) {
+	return "FAKE-CODE", nil
+}
+
+func (f *Fake) SetProxyAddress(addr string, opts ...whatsmeow.SetProxyOptions) error { return nil }
+
+func (f *Fake) SetAutoReconnect(enabled bool) {}
+
+func (f *Fake) DeviceID() *types.JID {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ID
+}
+
+func (f *Fake) StoreDevice() StoreDevice {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := StoreDevice{ID: f.ID, PushName: f.PushName}
+	if f.ID != nil {
+		d.Platform = "fake"
+	}
+	return d
+}
+
+func (f *Fake) SendMessage(ctx context.Context, to types.JID, message *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.SendErr != nil {
+		return whatsmeow.SendResponse{}, f.SendErr
+	}
+	if f.DropAcks > 0 {
+		f.DropAcks--
+		return whatsmeow.SendResponse{}, whatsmeow.ErrMessageTimedOut
+	}
+	id := f.newID()
+	if len(extra) > 0 && extra[0].ID != "" {
+		id = extra[0].ID
+	}
+	f.sent = append(f.sent, FakeSent{To: to, Message: message, ID: id})
+	if req := message.GetProtocolMessage().GetPeerDataOperationRequestMessage().GetHistorySyncOnDemandRequest(); req != nil {
+		go f.answerHistorySync(req)
+	}
+	return whatsmeow.SendResponse{Timestamp: time.Now(), ID: id}, nil
+}
+
+// answerHistorySync sends the messages of History before the oldest one the
+// request names, as the phone does
+func (f *Fake) answerHistorySync(req *waProto.PeerDataOperationRequestMessage_HistorySyncOnDemandRequest) {
+	chat, err := types.ParseJID(req.GetChatJID())
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	var older []*waHistorySync.HistorySyncMsg
+	for _, m := range f.History[chat] {
+		if int64(m.GetMessageTimestamp())*1000 < req.GetOldestMsgTimestampMS() && m.GetKey().GetID() != req.GetOldestMsgID() {
+			older = append(older, &waHistorySync.HistorySyncMsg{Message: m})
+		}
+	}
+	f.mu.Unlock()
+	if n := int(req.GetOnDemandMsgCount()); len(older) > n {
+		older = older[len(older)-n:]
+	}
+	f.Dispatch(&events.HistorySync{Data: &waHistorySync.HistorySync{
+		SyncType:      waHistorySync.HistorySync_ON_DEMAND.Enum(),
+		Conversations: []*waHistorySync.Conversation{{ID: proto.String(chat.String()), Messages: older}},
+	}})
+}
+
+func (f *Fake) Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.UploadErr != nil {
+		return whatsmeow.UploadResponse{}, f.UploadErr
+	}
+	f.uploads = append(f.uploads, plaintext)
+	id := f.newID()
+	sum := sha256.Sum256(plaintext)
+	encSum := sha256.Sum256(sum[:])
+	return whatsmeow.UploadResponse{
+		URL:           "https://mmg.fake.invalid/" + id,
+		DirectPath:    "/fake/" + id,
+		MediaKey:      sum[:],
+		FileEncSHA256: encSum[:],
+		FileSHA256:    sum[:],
+		FileLength:    uint64(len(plaintext)),
+	}, nil
+}
+
+// UploadReader reads the plaintext and uploads it like Upload
+func (f *Fake) UploadReader(ctx context.Context, plaintext io.Reader, tempFile io.ReadWriteSeeker, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	data, err := io.ReadAll(plaintext)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	return f.Upload(ctx, data, appInfo)
+}
+
+// Download returns the plaintext of an earlier upload with the same hash,
+// so media sent through the Fake can be dispatched back as incoming
+func (f *Fake) Download(msg whatsmeow.DownloadableMessage) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, data := range f.uploads {
+		if sum := sha256.Sum256(data); bytes.Equal(sum[:], msg.GetFileSHA256()) {
+			return data, nil
+		}
+	}
+	return nil, whatsmeow.ErrMediaDownloadFailedWith404
+}
+
+// DownloadToFile writes what Download returns to file in small chunks, so
+// progress reporting sees more than one write
+func (f *Fake) DownloadToFile(msg whatsmeow.DownloadableMessage, file whatsmeow.File) error {
+	data, err := f.Download(msg)
+	if err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := min(len(data), 32*1024)
+		if _, err := file.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// SendMediaRetryReceipt is accepted but never answered, like a phone that
+// is offline
+func (f *Fake) SendMediaRetryReceipt(message *types.MessageInfo, mediaKey []byte) error {
+	return nil
+}
+
+func (f *Fake) MarkRead(ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error {
+	return nil
+}
+
+func (f *Fake) GetJoinedGroups() ([]*types.GroupInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*types.GroupInfo(nil), f.Groups...), nil
+}
+
+func (f *Fake) GetGroupInfo(jid types.JID) (*types.GroupInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, g := range f.Groups {
+		if g.JID == jid {
+			group := *g
+			return &group, nil
+		}
+	}
+	return nil, whatsmeow.ErrNotInGroup
+}
+
+// GetGroupInfoFromLink finds the group whose invite link has code
+func (f *Fake) GetGroupInfoFromLink(code string) (*types.GroupInfo, error) {
+	code = strings.TrimPrefix(code, whatsmeow.InviteLinkPrefix)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, g := range f.Groups {
+		current, reset := f.invites[g.JID]
+		if code == current || !reset && code == g.JID.User {
+			group := *g
+			return &group, nil
+		}
+	}
+	return nil, whatsmeow.ErrInviteLinkInvalid
+}
+
+func (f *Fake) CreateGroup(req whatsmeow.ReqCreateGroup) (*types.GroupInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group := &types.GroupInfo{JID: types.NewJID(f.newID(), types.GroupServer)}
+	group.Name = req.Name
+	group.GroupParent = req.GroupParent
+	group.GroupLinkedParent = req.GroupLinkedParent
+	for _, p := range req.Participants {
+		group.Participants = append(group.Participants, types.GroupParticipant{JID: p})
+	}
+	f.Groups = append(f.Groups, group)
+	if req.IsParent {
+		// WhatsApp creates a community's announcement group with it
+		announce := &types.GroupInfo{JID: types.NewJID(f.newID(), types.GroupServer)}
+		announce.Name = req.Name
+		announce.IsAnnounce = true
+		announce.LinkedParentJID = group.JID
+		announce.IsDefaultSubGroup = true
+		f.Groups = append(f.Groups, announce)
+	}
+	return group, nil
+}
+
+func (f *Fake) LeaveGroup(jid types.JID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, g := range f.Groups {
+		if g.JID == jid {
+			f.Groups = append(f.Groups[:i], f.Groups[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("not a member of %s", jid)
+}
+
+func (f *Fake) GetGroupInviteLink(jid types.JID, reset bool) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if reset {
+		if _, err := f.group(jid); err != nil {
+			return "", err
+		}
+		if f.invites == nil {
+			f.invites = map[types.JID]string{}
+		}
+		f.invites[jid] = f.newID()
+	}
+	code, ok := f.invites[jid]
+	if !ok {
+		code = jid.User
+	}
+	return "https://chat.whatsapp.com/" + code, nil
+}
+
+func (f *Fake) JoinGroupWithLink(code string) (types.JID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group := &types.GroupInfo{JID: types.NewJID(f.newID(), types.GroupServer)}
+	f.Groups = append(f.Groups, group)
+	return group.JID, nil
+}
+
+func (f *Fake) SetGroupName(jid types.JID, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, g := range f.Groups {
+		if g.JID == jid {
+			g.Name = name
+			return nil
+		}
+	}
+	return fmt.Errorf("not a member of %s", jid)
+}
+
+// BuildPollCreation builds the poll like whatsmeow, with a random secret
+func (f *Fake) BuildPollCreation(name string, optionNames []string, selectableOptionCount int) *waProto.Message {
+	options := make([]*waProto.PollCreationMessage_Option, len(optionNames))
+	for i, o := range optionNames {
+		options[i] = &waProto.PollCreationMessage_Option{OptionName: proto.String(o)}
+	}
+	if selectableOptionCount < 0 || selectableOptionCount > len(optionNames) {
+		selectableOptionCount = 0
+	}
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return &waProto.Message{
+		PollCreationMessage: &waProto.PollCreationMessage{
+			Name:                   proto.String(name),
+			Options:                options,
+			SelectableOptionsCount: proto.Uint32(uint32(selectableOptionCount)),
+		},
+		MessageContextInfo: &waProto.MessageContextInfo{MessageSecret: secret},
+	}
+}
+
+// DecryptPollVote treats the encrypted payload as a marshaled
+// PollVoteMessage, so tests can dispatch votes without the poll's secret
+func (f *Fake) BuildHistorySyncRequest(lastKnownMessageInfo *types.MessageInfo, count int) *waProto.Message {
+	return &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{
+		Type: waProto.ProtocolMessage_PEER_DATA_OPERATION_REQUEST_MESSAGE.Enum(),
+		PeerDataOperationRequestMessage: &waProto.PeerDataOperationRequestMessage{
+			PeerDataOperationRequestType: waProto.PeerDataOperationRequestType_HISTORY_SYNC_ON_DEMAND.Enum(),
+			HistorySyncOnDemandRequest: &waProto.PeerDataOperationRequestMessage_HistorySyncOnDemandRequest{
+				ChatJID:              proto.String(lastKnownMessageInfo.Chat.String()),
+				OldestMsgID:          proto.String(lastKnownMessageInfo.ID),
+				OldestMsgFromMe:      proto.Bool(lastKnownMessageInfo.IsFromMe),
+				OnDemandMsgCount:     proto.Int32(int32(count)),
+				OldestMsgTimestampMS: proto.Int64(lastKnownMessageInfo.Timestamp.UnixMilli()),
+			},
+		},
+	}}
+}
+
+// ParseWebMessage is whatsmeow's, minus edits and unwrapping
+func (f *Fake) ParseWebMessage(chatJID types.JID, webMsg *waWeb.WebMessageInfo) (*events.Message, error) {
+	info := types.MessageInfo{
+		MessageSource: types.MessageSource{
+			Chat:     chatJID,
+			IsFromMe: webMsg.GetKey().GetFromMe(),
+			IsGroup:  chatJID.Server == types.GroupServer,
+		},
+		ID:        webMsg.GetKey().GetID(),
+		PushName:  webMsg.GetPushName(),
+		Timestamp: time.Unix(int64(webMsg.GetMessageTimestamp()), 0),
+	}
+	f.mu.Lock()
+	own := f.ID
+	f.mu.Unlock()
+	switch {
+	case info.IsFromMe && own == nil:
+		return nil, whatsmeow.ErrNotLoggedIn
+	case info.IsFromMe:
+		info.Sender = own.ToNonAD()
+	case chatJID.Server == types.DefaultUserServer:
+		info.Sender = chatJID
+	default:
+		sender, err := types.ParseJID(webMsg.GetKey().GetParticipant())
+		if err != nil {
+			return nil, fmt.Errorf("couldn't find sender of message %s", info.ID)
+		}
+		info.Sender = sender
+	}
+	return &events.Message{Info: info, Message: webMsg.GetMessage(), RawMessage: webMsg.GetMessage(), SourceWebMsg: webMsg}, nil
+}
+
+// SetDisappearingTimer sends the setting to a user, as whatsmeow does, and
+// changes a group's directly
+func (f *Fake) SetDisappearingTimer(chat types.JID, timer time.Duration) error {
+	switch chat.Server {
+	case types.DefaultUserServer:
+		_, err := f.SendMessage(context.Background(), chat, &waProto.Message{
+			ProtocolMessage: &waProto.ProtocolMessage{
+				Type:                waProto.ProtocolMessage_EPHEMERAL_SETTING.Enum(),
+				EphemeralExpiration: proto.Uint32(uint32(timer.Seconds())),
+			},
+		})
+		return err
+	case types.GroupServer:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		g, err := f.group(chat)
+		if err != nil {
+			return err
+		}
+		g.IsEphemeral = timer > 0
+		g.DisappearingTimer = uint32(timer.Seconds())
+		return nil
+	}
+	return fmt.Errorf("can't set disappearing time in a %s chat", chat.Server)
+}
+
+func (f *Fake) DecryptPollVote(vote *events.Message) (*waProto.PollVoteMessage, error) {
+	update := vote.Message.GetPollUpdateMessage()
+	if update == nil {
+		return nil, whatsmeow.ErrNotPollUpdateMessage
+	}
+	var msg waProto.PollVoteMessage
+	if err := proto.Unmarshal(update.GetVote().GetEncPayload(), &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// group returns the joined group with jid; f.mu must be held
+func (f *Fake) group(jid types.JID) (*types.GroupInfo, error) {
+	for _, g := range f.Groups {
+		if g.JID == jid {
+			return g, nil
+		}
+	}
+	return nil, whatsmeow.ErrNotInGroup
+}
+
+func (f *Fake) SetGroupTopic(jid types.JID, previousID, newID, topic string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, err := f.group(jid)
+	if err != nil {
+		return err
+	}
+	g.Topic, g.TopicDeleted = topic, topic == ""
+	return nil
+}
+
+// SetGroupPhoto sets a group's picture, or the own profile picture when jid
+// is empty
+func (f *Fake) SetGroupPhoto(jid types.JID, avatar []byte) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if jid.IsEmpty() {
+		if f.ID == nil {
+			return "", whatsmeow.ErrNotLoggedIn
+		}
+		jid = f.ID.ToNonAD()
+	} else if _, err := f.group(jid); err != nil {
+		return "", err
+	}
+	if f.pictures == nil {
+		f.pictures = map[types.JID]string{}
+	}
+	if avatar == nil {
+		delete(f.pictures, jid)
+		return "remove", nil
+	}
+	f.pictures[jid] = f.newID()
+	return f.pictures[jid], nil
+}
+
+func (f *Fake) SetGroupAnnounce(jid types.JID, announce bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, err := f.group(jid)
+	if err != nil {
+		return err
+	}
+	g.IsAnnounce = announce
+	return nil
+}
+
+func (f *Fake) SetGroupLocked(jid types.JID, locked bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, err := f.group(jid)
+	if err != nil {
+		return err
+	}
+	g.IsLocked = locked
+	return nil
+}
+
+// UpdateGroupParticipants answers like WhatsApp: 409 for adding a member,
+// 404 for changing someone who is not one
+func (f *Fake) UpdateGroupParticipants(jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantChange) ([]types.GroupParticipant, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group, err := f.group(jid)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]types.GroupParticipant, 0, len(participantChanges))
+	for _, p := range participantChanges {
+		idx := -1
+		for i, member := range group.Participants {
+			if member.JID == p {
+				idx = i
+			}
+		}
+		result := types.GroupParticipant{JID: p}
+		switch {
+		case action == whatsmeow.ParticipantChangeAdd && idx >= 0:
+			result.Error = 409
+		case action == whatsmeow.ParticipantChangeAdd:
+			group.Participants = append(group.Participants, result)
+		case idx < 0:
+			result.Error = 404
+		case action == whatsmeow.ParticipantChangeRemove:
+			group.Participants = append(group.Participants[:idx], group.Participants[idx+1:]...)
+		default:
+			group.Participants[idx].IsAdmin = action == whatsmeow.ParticipantChangePromote
+			result.IsAdmin = group.Participants[idx].IsAdmin
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (f *Fake) GetGroupRequestParticipants(jid types.JID) ([]types.GroupParticipantRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.group(jid); err != nil {
+		return nil, err
+	}
+	return append([]types.GroupParticipantRequest(nil), f.JoinRequests[jid]...), nil
+}
+
+// UpdateGroupRequestParticipants approves or rejects pending requests;
+// approved requesters become members
+func (f *Fake) UpdateGroupRequestParticipants(jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantRequestChange) ([]types.GroupParticipant, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group, err := f.group(jid)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]types.GroupParticipant, 0, len(participantChanges))
+	for _, p := range participantChanges {
+		result := types.GroupParticipant{JID: p, Error: 404}
+		pending := f.JoinRequests[jid]
+		for i, r := range pending {
+			if r.JID != p {
+				continue
+			}
+			f.JoinRequests[jid] = append(pending[:i:i], pending[i+1:]...)
+			result.Error = 0
+			if action == whatsmeow.ParticipantChangeApprove {
+				group.Participants = append(group.Participants, types.GroupParticipant{JID: p})
+			}
+			break
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// GetSubGroups lists the joined groups linked to community
+func (f *Fake) GetSubGroups(community types.JID) ([]*types.GroupLinkTarget, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.group(community); err != nil {
+		return nil, err
+	}
+	var subgroups []*types.GroupLinkTarget
+	for _, g := range f.Groups {
+		if g.LinkedParentJID == community {
+			subgroups = append(subgroups, &types.GroupLinkTarget{JID: g.JID, GroupName: g.GroupName, GroupIsDefaultSub: g.GroupIsDefaultSub})
+		}
+	}
+	return subgroups, nil
+}
+
+func (f *Fake) LinkGroup(parent, child types.JID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, err := f.group(parent)
+	if err != nil {
+		return err
+	}
+	if !p.IsParent {
+		return fmt.Errorf("%s is not a community", parent)
+	}
+	g, err := f.group(child)
+	if err != nil {
+		return err
+	}
+	g.LinkedParentJID = parent
+	return nil
+}
+
+func (f *Fake) GetContact(jid types.JID) (types.ContactInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Contacts[jid], nil
+}
+
+// IsOnWhatsApp reports every number registered except those in Unknown
+func (f *Fake) IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	results := make([]types.IsOnWhatsAppResponse, 0, len(phones))
+	for _, phone := range phones {
+		user := strings.TrimPrefix(phone, "+")
+		results = append(results, types.IsOnWhatsAppResponse{
+			Query: phone,
+			JID:   types.NewJID(user, types.DefaultUserServer),
+			IsIn:  !f.Unknown[user],
+		})
+	}
+	return results, nil
+}
+
+func (f *Fake) GetAllContacts() (map[types.JID]types.ContactInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	contacts := make(map[types.JID]types.ContactInfo, len(f.Contacts))
+	for jid, c := range f.Contacts {
+		contacts[jid] = c
+	}
+	return contacts, nil
+}
+
+// IdentityKey derives a stable key from the JID, so security codes are
+// deterministic in tests
+func (f *Fake) IdentityKey(jid types.JID) ([32]byte, error) {
+	return sha256.Sum256([]byte("fake identity " + jid.String())), nil
+}
+
+// GetProfilePictureInfo returns the pictures set with SetGroupPhoto; their
+// URLs do not resolve
+func (f *Fake) GetProfilePictureInfo(jid types.JID, params *whatsmeow.GetProfilePictureParams) (*types.ProfilePictureInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, ok := f.pictures[jid.ToNonAD()]
+	if !ok || (params != nil && params.ExistingID == id) {
+		return nil, nil
+	}
+	return &types.ProfilePictureInfo{ID: id, Type: "image", URL: "https://pps.fake.invalid/" + id, DirectPath: "/fake/" + id}, nil
+}
+
+func (f *Fake) SetStatusMessage(msg string) error { return nil }
+
+func (f *Fake) SendPresence(state types.Presence) error { return nil }
+
+func (f *Fake) SendChatPresence(jid types.JID, state types.ChatPresence, media types.ChatPresenceMedia) error {
+	return nil
+}
+
+func (f *Fake) SubscribePresence(jid types.JID) error { return nil }
+
+// SendAppState records the patch and, like whatsmeow's resync after a
+// send, dispatches an AppState event for each mutation
+func (f *Fake) SendAppState(patch appstate.PatchInfo) error {
+	f.mu.Lock()
+	if f.SendErr != nil {
+		f.mu.Unlock()
+		return f.SendErr
+	}
+	f.patches = append(f.patches, patch)
+	if f.appStates == nil {
+		f.appStates = map[appstate.WAPatchName]uint64{}
+	}
+	f.appStates[patch.Type]++
+	f.mu.Unlock()
+	for _, m := range patch.Mutations {
+		f.Dispatch(&events.AppState{Index: m.Index, SyncActionValue: m.Value})
+	}
+	return nil
+}
+
+func (f *Fake) FetchAppState(name appstate.WAPatchName, fullSync, onlyIfNotSynced bool) error {
+	return nil
+}
+
+func (f *Fake) AppStateVersion(name appstate.WAPatchName) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.appStates[name], nil
+}
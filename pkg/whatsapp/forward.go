@@ -0,0 +1,161 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// A forwarded message is the original message with IsForwarded set in its
+// ContextInfo. Media keeps its keys, so recipients download the file the
+// original points at and nothing is uploaded again.
+
+// ForwardOptions are the options of ForwardMessage
+type ForwardOptions struct {
+	Chat     string `json:"chat"`     // the chat of the message, when its ID is not unique
+	Reupload bool   `json:"reupload"` // download and upload the media again, for media WhatsApp no longer serves
+}
+
+// ForwardMessage forwards a stored text or media message, sent or
+// received, to any chat. WhatsApp shows it as forwarded, and as forwarded
+// many times once it has been forwarded on often enough.
+func (wac *WhatsAppClient) ForwardMessage(ctx context.Context, messageID, toJID string, opts ForwardOptions) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+	if wac.messages == nil {
+		return SendResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	to, err := ParseRecipientJID(toJID)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	spec, _ := json.Marshal(opts)
+
+	msg, err := wac.forwardable(ctx, messageID, opts)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+
+	ts := time.Now()
+	resp, err := wac.sendMessage(ctx, to, msg)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "forward-message", messageID, toJID, string(spec))
+	}
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: fmt.Sprintf("Message forwarded to %s (server timestamp: %v)", to, ts),
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// forwardable builds the forwarded copy of a stored message
+func (wac *WhatsAppClient) forwardable(ctx context.Context, messageID string, opts ForwardOptions) (*waProto.Message, error) {
+	stored, err := wac.messages.media(ctx, messageID, opts.Chat)
+	if err == nil {
+		msg := proto.Clone(stored.message).(*waProto.Message)
+		media, _, _ := incomingMedia(msg)
+		if viewOnce(msg) {
+			return nil, fmt.Errorf("message %s is view once and cannot be forwarded", messageID)
+		}
+		if opts.Reupload {
+			if err := wac.reupload(ctx, stored, media); err != nil {
+				return nil, err
+			}
+		}
+		markForwarded(msg)
+		return msg, nil
+	}
+
+	messageType, content, err := wac.messages.typeAndContent(ctx, opts.Chat, messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no stored message %s", messageID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch messageType {
+	case "text":
+		msg := &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String(content)}}
+		markForwarded(msg)
+		return msg, nil
+	case "image", "video", "audio", "document", "sticker":
+		return nil, fmt.Errorf("the media of message %s is not stored", messageID)
+	}
+	return nil, fmt.Errorf("cannot forward %s message %s: only text and media can be forwarded", messageType, messageID)
+}
+
+// reupload downloads the media of a stored message, asking the phone for it
+// again if WhatsApp no longer serves it, uploads it afresh and points media
+// at the new upload
+func (wac *WhatsAppClient) reupload(ctx context.Context, stored *storedMedia, media whatsmeow.DownloadableMessage) error {
+	result, err := wac.fetchMedia(ctx, media, &stored.info, MediaDownloadResult{MessageID: stored.info.ID}, "")
+	if err != nil {
+		return err
+	}
+	data := result.(*BinaryResult).Data
+	uploaded, err := wac.upload(ctx, data, whatsmeow.GetMediaType(media))
+	if err != nil {
+		return err
+	}
+	length := uint64(len(data))
+	switch m := media.(type) {
+	case *waProto.ImageMessage:
+		m.URL, m.DirectPath, m.MediaKey = &uploaded.URL, &uploaded.DirectPath, uploaded.MediaKey
+		m.FileEncSHA256, m.FileSHA256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, &length
+	case *waProto.VideoMessage:
+		m.URL, m.DirectPath, m.MediaKey = &uploaded.URL, &uploaded.DirectPath, uploaded.MediaKey
+		m.FileEncSHA256, m.FileSHA256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, &length
+	case *waProto.AudioMessage:
+		m.URL, m.DirectPath, m.MediaKey = &uploaded.URL, &uploaded.DirectPath, uploaded.MediaKey
+		m.FileEncSHA256, m.FileSHA256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, &length
+	case *waProto.DocumentMessage:
+		m.URL, m.DirectPath, m.MediaKey = &uploaded.URL, &uploaded.DirectPath, uploaded.MediaKey
+		m.FileEncSHA256, m.FileSHA256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, &length
+	case *waProto.StickerMessage:
+		m.URL, m.DirectPath, m.MediaKey = &uploaded.URL, &uploaded.DirectPath, uploaded.MediaKey
+		m.FileEncSHA256, m.FileSHA256, m.FileLength = uploaded.FileEncSHA256, uploaded.FileSHA256, &length
+	}
+	return nil
+}
+
+// markForwarded replaces the context of a text or media message (the quote
+// and mentions belong to the original chat) with the forwarded flag
+func markForwarded(msg *waProto.Message) {
+	forwarded := &waProto.ContextInfo{
+		IsForwarded:     proto.Bool(true),
+		ForwardingScore: proto.Uint32(contextInfo(msg).GetForwardingScore() + 1),
+	}
+	switch {
+	case msg.ExtendedTextMessage != nil:
+		msg.ExtendedTextMessage.ContextInfo = forwarded
+	case msg.ImageMessage != nil:
+		msg.ImageMessage.ContextInfo = forwarded
+	case msg.VideoMessage != nil:
+		msg.VideoMessage.ContextInfo = forwarded
+	case msg.AudioMessage != nil:
+		msg.AudioMessage.ContextInfo = forwarded
+	case msg.DocumentMessage != nil:
+		msg.DocumentMessage.ContextInfo = forwarded
+	case msg.StickerMessage != nil:
+		msg.StickerMessage.ContextInfo = forwarded
+	}
+}
+
+// typeAndContent returns the type and content of a stored message, in chat
+// when one is given, otherwise the most recent one with that ID
+func (s *messageStore) typeAndContent(ctx context.Context, chat, id string) (messageType, content string, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT message_type, content FROM pod_messages
+		WHERE id = ? AND (? = '' OR chat_jid = ?) ORDER BY timestamp DESC LIMIT 1`, id, chat, chat).
+		Scan(&messageType, &content)
+	return messageType, content, err
+}
@@ -0,0 +1,230 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// GroupDetails is the full info of one group, as fetched by GetGroupDetails
+type GroupDetails struct {
+	JID               string   `json:"jid"`
+	Name              string   `json:"name"`
+	Topic             string   `json:"topic,omitempty"`
+	Owner             string   `json:"owner,omitempty"`
+	Participants      []string `json:"participants"`
+	Admins            []string `json:"admins"`
+	SuperAdmins       []string `json:"super_admins"`       // the admins no other admin can demote, such as the creator
+	Announce          bool     `json:"announce"`           // only admins can send
+	Locked            bool     `json:"locked"`             // only admins can edit the group info
+	JoinApproval      bool     `json:"join_approval"`      // admins approve who joins with the invite link
+	DisappearingTimer uint32   `json:"disappearing_timer"` // seconds, 0 when off
+	IsCommunity       bool     `json:"is_community,omitempty"`
+	Community         string   `json:"community,omitempty"` // the community the group is linked to
+	CreatedAt         int64    `json:"created_at,omitempty"`
+}
+
+// GroupFailure is a group whose info could not be fetched
+type GroupFailure struct {
+	JID   string `json:"jid"`
+	Error string `json:"error"`
+}
+
+// GroupDetailsResult is returned by GetGroupDetails. Groups that failed are
+// listed in Failed while the others are still returned.
+type GroupDetailsResult struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message,omitempty"`
+	Groups  []GroupDetails `json:"groups"`
+	Failed  []GroupFailure `json:"failed,omitempty"`
+}
+
+// GroupInfoResult is returned by GetGroupInfo and GetGroupInfoFromLink
+type GroupInfoResult struct {
+	Success bool          `json:"success"`
+	Message string        `json:"message,omitempty"`
+	Group   *GroupDetails `json:"group,omitempty"`
+}
+
+// GetGroupDetails fetches the full info (admins, settings) of the
+// given groups, or of every joined group when groupJIDs is empty. Up to
+// Options.GroupConcurrency requests run at once, and a group-details
+// event is published as each group arrives. The call only fails as a whole
+// when no group could be fetched.
+func (wac *WhatsAppClient) GetGroupDetails(ctx context.Context, groupJIDs []string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return GroupDetailsResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+
+	var jids []types.JID
+	for _, s := range groupJIDs {
+		jid, err := types.ParseJID(s)
+		if err != nil {
+			return GroupDetailsResult{Success: false, Message: err.Error()}, err
+		}
+		jids = append(jids, jid)
+	}
+	if len(groupJIDs) == 0 {
+		groups, err := callContext(wac, ctx, "fetching groups", wac.Client.GetJoinedGroups)
+		if err != nil {
+			return GroupDetailsResult{Success: false, Message: err.Error()}, err
+		}
+		for _, group := range groups {
+			jids = append(jids, group.JID)
+		}
+	}
+
+	details, failed := wac.fetchGroups(ctx, jids)
+	if len(jids) > 0 && len(details) == 0 {
+		err := fmt.Errorf("fetching group info: %s", failed[0].Error)
+		return GroupDetailsResult{Success: false, Message: err.Error(), Groups: details, Failed: failed}, err
+	}
+	if len(failed) > 0 {
+		logger.For(ctx).Warnf("Fetched %d of %d groups, %d failed", len(details), len(jids), len(failed))
+		return GroupDetailsResult{
+			Success: false,
+			Message: fmt.Sprintf("Fetched %d of %d groups", len(details), len(jids)),
+			Groups:  details,
+			Failed:  failed,
+		}, nil
+	}
+	return GroupDetailsResult{
+		Success: true,
+		Message: fmt.Sprintf("Fetched %d groups", len(details)),
+		Groups:  details,
+	}, nil
+}
+
+// GetGroupInfo fetches the full info of one group the account is in:
+// its settings, owner, creation time and who the admins are
+func (wac *WhatsAppClient) GetGroupInfo(ctx context.Context, groupJID string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return GroupInfoResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	jid, err := parseGroupJID(groupJID)
+	if err != nil {
+		return GroupInfoResult{Success: false, Message: err.Error()}, err
+	}
+
+	group, err := callContext(wac, ctx, "fetching group info", func() (*types.GroupInfo, error) {
+		return wac.Client.GetGroupInfo(jid)
+	})
+	if err != nil {
+		return GroupInfoResult{Success: false, Message: err.Error()}, err
+	}
+	details := groupDetails(group)
+	return GroupInfoResult{Success: true, Message: fmt.Sprintf("%d participants", len(details.Participants)), Group: &details}, nil
+}
+
+// GetGroupInfoFromLink fetches the info of the group behind an invite
+// link, or its bare code, without joining it. WhatsApp may leave out some
+// of the participants of a group the account is not in.
+func (wac *WhatsAppClient) GetGroupInfoFromLink(ctx context.Context, link string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return GroupInfoResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	code := strings.TrimPrefix(strings.TrimSpace(link), whatsmeow.InviteLinkPrefix)
+	if code == "" || strings.Contains(code, "/") {
+		err := fmt.Errorf("invalid invite link %q", link)
+		return GroupInfoResult{Success: false, Message: err.Error()}, err
+	}
+
+	group, err := callContext(wac, ctx, "fetching invite link info", func() (*types.GroupInfo, error) {
+		return wac.Client.GetGroupInfoFromLink(code)
+	})
+	if err != nil {
+		return GroupInfoResult{Success: false, Message: err.Error()}, err
+	}
+	details := groupDetails(group)
+	return GroupInfoResult{Success: true, Message: fmt.Sprintf("%d participants", len(details.Participants)), Group: &details}, nil
+}
+
+// fetchGroups runs GetGroupInfo for jids on a bounded pool of workers. The
+// results keep the order of jids; once ctx ends, the groups not yet fetched
+// are reported as failed.
+func (wac *WhatsAppClient) fetchGroups(ctx context.Context, jids []types.JID) ([]GroupDetails, []GroupFailure) {
+	workers := min(max(wac.Options().GroupConcurrency, 1), len(jids))
+	fetched := make([]*GroupDetails, len(jids))
+	errs := make([]error, len(jids))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				group, err := callContext(wac, ctx, "fetching group "+jids[i].String(), func() (*types.GroupInfo, error) {
+					return wac.Client.GetGroupInfo(jids[i])
+				})
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				details := groupDetails(group)
+				fetched[i] = &details
+				wac.publish("group-details", details)
+			}
+		}()
+	}
+	for i := range jids {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	details := make([]GroupDetails, 0, len(jids))
+	var failed []GroupFailure
+	for i, jid := range jids {
+		if fetched[i] != nil {
+			details = append(details, *fetched[i])
+		} else {
+			failed = append(failed, GroupFailure{JID: jid.String(), Error: errs[i].Error()})
+		}
+	}
+	return details, failed
+}
+
+// groupDetails converts whatsmeow's group info
+func groupDetails(group *types.GroupInfo) GroupDetails {
+	details := GroupDetails{
+		JID:               group.JID.String(),
+		Name:              group.Name,
+		Topic:             group.Topic,
+		Participants:      make([]string, 0, len(group.Participants)),
+		Admins:            []string{},
+		SuperAdmins:       []string{},
+		Announce:          group.IsAnnounce,
+		Locked:            group.IsLocked,
+		JoinApproval:      group.IsJoinApprovalRequired,
+		DisappearingTimer: group.DisappearingTimer,
+		IsCommunity:       group.IsParent,
+	}
+	if !group.LinkedParentJID.IsEmpty() {
+		details.Community = group.LinkedParentJID.String()
+	}
+	if !group.OwnerJID.IsEmpty() {
+		details.Owner = group.OwnerJID.String()
+	}
+	if !group.GroupCreated.IsZero() {
+		details.CreatedAt = group.GroupCreated.Unix()
+	}
+	for _, p := range group.Participants {
+		details.Participants = append(details.Participants, p.JID.String())
+		if p.IsAdmin || p.IsSuperAdmin {
+			details.Admins = append(details.Admins, p.JID.String())
+		}
+		if p.IsSuperAdmin {
+			details.SuperAdmins = append(details.SuperAdmins, p.JID.String())
+		}
+	}
+	return details
+}
@@ -0,0 +1,227 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ParticipantResult is the outcome of a participant change for one JID
+type ParticipantResult struct {
+	JID        string `json:"jid"`
+	Success    bool   `json:"success"`
+	Error      int    `json:"error,omitempty"`       // the code WhatsApp answered with
+	Reason     string `json:"reason,omitempty"`      // what Error means
+	InviteCode string `json:"invite_code,omitempty"` // when the user's privacy settings only allow an invite
+	IsAdmin    bool   `json:"is_admin,omitempty"`
+}
+
+// ParticipantsResult is returned by the group participant changes. Success
+// is only set when every participant changed; Participants tells which did.
+type ParticipantsResult struct {
+	Success      bool                `json:"success"`
+	Message      string              `json:"message,omitempty"`
+	Action       string              `json:"action,omitempty"`
+	Participants []ParticipantResult `json:"participants,omitempty"`
+}
+
+// participantErrors are the codes WhatsApp answers participant changes with
+var participantErrors = map[int]string{
+	400: "bad request",
+	401: "not authorized, admin rights are required",
+	403: "the user's privacy settings do not allow being added; send them the invite",
+	404: "not a member of the group, or not on WhatsApp",
+	406: "the user cannot be added",
+	408: "the user recently left the group and cannot be re-added yet",
+	409: "already a member of the group",
+	500: "the group is full",
+}
+
+// JoinRequest is a pending request to join a group that needs admin approval
+type JoinRequest struct {
+	JID         string `json:"jid"`
+	RequestedAt int64  `json:"requested_at,omitempty"` // Unix seconds
+}
+
+// JoinRequestsResult is returned by GetGroupJoinRequests
+type JoinRequestsResult struct {
+	Success  bool          `json:"success"`
+	Message  string        `json:"message,omitempty"`
+	Requests []JoinRequest `json:"requests"`
+}
+
+// AddGroupParticipants adds participants to a group
+func (wac *WhatsAppClient) AddGroupParticipants(ctx context.Context, groupJID string, participants []string) (interface{}, error) {
+	return wac.updateGroupParticipants(ctx, groupJID, participants, whatsmeow.ParticipantChangeAdd)
+}
+
+// RemoveGroupParticipants removes participants from a group
+func (wac *WhatsAppClient) RemoveGroupParticipants(ctx context.Context, groupJID string, participants []string) (interface{}, error) {
+	return wac.updateGroupParticipants(ctx, groupJID, participants, whatsmeow.ParticipantChangeRemove)
+}
+
+// PromoteGroupParticipants promotes participants to admin status
+func (wac *WhatsAppClient) PromoteGroupParticipants(ctx context.Context, groupJID string, participants []string) (interface{}, error) {
+	return wac.updateGroupParticipants(ctx, groupJID, participants, whatsmeow.ParticipantChangePromote)
+}
+
+// DemoteGroupParticipants demotes admins to regular participants
+func (wac *WhatsAppClient) DemoteGroupParticipants(ctx context.Context, groupJID string, participants []string) (interface{}, error) {
+	return wac.updateGroupParticipants(ctx, groupJID, participants, whatsmeow.ParticipantChangeDemote)
+}
+
+// updateGroupParticipants adds, removes, promotes or demotes group members.
+// Participants are JIDs or phone numbers. The error is only set when the
+// whole change failed; participants WhatsApp refused are reported one by
+// one.
+func (wac *WhatsAppClient) updateGroupParticipants(ctx context.Context, groupJID string, participants []string, action whatsmeow.ParticipantChange) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return ParticipantsResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+
+	group, err := parseGroupJID(groupJID)
+	if err != nil {
+		return ParticipantsResult{Success: false, Message: err.Error()}, err
+	}
+	jids, err := participantJIDs(participants)
+	if err != nil {
+		return ParticipantsResult{Success: false, Message: err.Error()}, err
+	}
+
+	changed, err := callContext(wac, ctx, string(action)+" group participants", func() ([]types.GroupParticipant, error) {
+		return wac.Client.UpdateGroupParticipants(group, jids, action)
+	})
+	if err != nil {
+		logger.For(ctx).Errorf("Error updating participants of %s (%s): %v", group, action, err)
+		return ParticipantsResult{Success: false, Message: err.Error(), Action: string(action)}, err
+	}
+	result := participantsResult(changed, len(jids), string(action))
+	logger.For(ctx).Infof("Updated participants of %s: %s", group, result.Message)
+	return result, nil
+}
+
+// participantsResult reports a participant change WhatsApp answered, out of
+// the requested participants
+func participantsResult(changed []types.GroupParticipant, requested int, action string) ParticipantsResult {
+	result := ParticipantsResult{Action: action, Participants: make([]ParticipantResult, 0, len(changed))}
+	ok := 0
+	for _, p := range changed {
+		r := ParticipantResult{JID: p.JID.String(), Success: p.Error == 0, Error: p.Error, IsAdmin: p.IsAdmin}
+		if p.Error != 0 {
+			r.Reason = participantErrors[p.Error]
+			if r.Reason == "" {
+				r.Reason = "unknown error"
+			}
+		} else {
+			ok++
+		}
+		if p.AddRequest != nil {
+			r.InviteCode = p.AddRequest.Code
+		}
+		result.Participants = append(result.Participants, r)
+	}
+	result.Success = ok == requested
+	result.Message = fmt.Sprintf("%d of %d participants changed (%s)", ok, requested, action)
+	return result
+}
+
+// participantJIDs parses the participants of a change, of which there must
+// be at least one
+func participantJIDs(participants []string) ([]types.JID, error) {
+	if len(participants) == 0 {
+		return nil, fmt.Errorf("no participants given")
+	}
+	jids := make([]types.JID, len(participants))
+	for i, p := range participants {
+		var err error
+		if jids[i], err = participantJID(p); err != nil {
+			return nil, err
+		}
+	}
+	return jids, nil
+}
+
+// participantJID parses a participant given as a JID or a phone number
+func participantJID(p string) (types.JID, error) {
+	if strings.Contains(p, "@") {
+		jid, err := types.ParseJID(p)
+		if err != nil {
+			return types.JID{}, fmt.Errorf("invalid participant JID %q: %w", p, err)
+		}
+		return jid.ToNonAD(), nil
+	}
+	digits, err := NormalizePhone(p)
+	if err != nil {
+		return types.JID{}, fmt.Errorf("invalid participant %q: %w", p, err)
+	}
+	return types.NewJID(digits, types.DefaultUserServer), nil
+}
+
+// GetGroupJoinRequests lists the requests to join a group whose
+// admins approve new members, oldest first. Only admins can see them.
+func (wac *WhatsAppClient) GetGroupJoinRequests(ctx context.Context, groupJID string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return JoinRequestsResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	group, err := parseGroupJID(groupJID)
+	if err != nil {
+		return JoinRequestsResult{Success: false, Message: err.Error()}, err
+	}
+
+	pending, err := callContext(wac, ctx, "fetching join requests", func() ([]types.GroupParticipantRequest, error) {
+		return wac.Client.GetGroupRequestParticipants(group)
+	})
+	if err != nil {
+		return JoinRequestsResult{Success: false, Message: err.Error()}, err
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].RequestedAt.Before(pending[j].RequestedAt) })
+	requests := make([]JoinRequest, len(pending))
+	for i, r := range pending {
+		requests[i] = JoinRequest{JID: r.JID.String()}
+		if !r.RequestedAt.IsZero() {
+			requests[i].RequestedAt = r.RequestedAt.Unix()
+		}
+	}
+	return JoinRequestsResult{Success: true, Message: fmt.Sprintf("%d pending requests", len(requests)), Requests: requests}, nil
+}
+
+// ApproveGroupJoinRequests lets the requesters into a group
+func (wac *WhatsAppClient) ApproveGroupJoinRequests(ctx context.Context, groupJID string, participants []string) (interface{}, error) {
+	return wac.updateGroupJoinRequests(ctx, groupJID, participants, whatsmeow.ParticipantChangeApprove)
+}
+
+// RejectGroupJoinRequests turns the requesters away
+func (wac *WhatsAppClient) RejectGroupJoinRequests(ctx context.Context, groupJID string, participants []string) (interface{}, error) {
+	return wac.updateGroupJoinRequests(ctx, groupJID, participants, whatsmeow.ParticipantChangeReject)
+}
+
+// updateGroupJoinRequests approves or rejects join requests, reported per
+// participant like the other participant changes
+func (wac *WhatsAppClient) updateGroupJoinRequests(ctx context.Context, groupJID string, participants []string, action whatsmeow.ParticipantRequestChange) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return ParticipantsResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	group, err := parseGroupJID(groupJID)
+	if err != nil {
+		return ParticipantsResult{Success: false, Message: err.Error()}, err
+	}
+	jids, err := participantJIDs(participants)
+	if err != nil {
+		return ParticipantsResult{Success: false, Message: err.Error()}, err
+	}
+
+	changed, err := callContext(wac, ctx, string(action)+" join requests", func() ([]types.GroupParticipant, error) {
+		return wac.Client.UpdateGroupRequestParticipants(group, jids, action)
+	})
+	if err != nil {
+		logger.For(ctx).Errorf("Error updating join requests of %s (%s): %v", group, action, err)
+		return ParticipantsResult{Success: false, Message: err.Error(), Action: string(action)}, err
+	}
+	result := participantsResult(changed, len(jids), string(action))
+	logger.For(ctx).Infof("Updated join requests of %s: %s", group, result.Message)
+	return result, nil
+}
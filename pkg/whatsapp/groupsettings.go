@@ -0,0 +1,140 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// GroupPhotoResult is returned by SetGroupPhoto
+type GroupPhotoResult struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message,omitempty"`
+	PictureID string `json:"picture_id,omitempty"` // empty when the photo was removed
+}
+
+// parseGroupJID parses the JID of a group
+func parseGroupJID(groupJID string) (types.JID, error) {
+	jid, err := types.ParseJID(groupJID)
+	if err != nil {
+		return types.JID{}, err
+	}
+	if jid.Server != types.GroupServer {
+		return types.JID{}, fmt.Errorf("%s is not a group JID", groupJID)
+	}
+	return jid, nil
+}
+
+// readAvatar reads the JPEG at path for a group or profile picture; "" is
+// no picture
+func readAvatar(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	avatar, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// WhatsApp rejects anything else with a bare 406
+	if mime := http.DetectContentType(avatar); mime != "image/jpeg" {
+		return nil, fmt.Errorf("pictures must be JPEG, %s is %s", path, mime)
+	}
+	return avatar, nil
+}
+
+// SetGroupTopic changes a group's description; an empty topic
+// removes it
+func (wac *WhatsAppClient) SetGroupTopic(ctx context.Context, groupJID string, topic string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	jid, err := parseGroupJID(groupJID)
+	if err != nil {
+		return GroupResult{Success: false, Message: err.Error()}, err
+	}
+
+	// whatsmeow looks up the current topic ID and generates the new one
+	err = callContextErr(wac, ctx, "setting group topic", func() error {
+		return wac.Client.SetGroupTopic(jid, "", "", topic)
+	})
+	if err != nil {
+		return GroupResult{Success: false, Message: err.Error()}, err
+	}
+	return GroupResult{Success: true, Message: "Group topic updated successfully"}, nil
+}
+
+// SetGroupPhoto sets a group's picture to the JPEG at path, or
+// removes it when path is ""
+func (wac *WhatsAppClient) SetGroupPhoto(ctx context.Context, groupJID string, path string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return GroupPhotoResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	jid, err := parseGroupJID(groupJID)
+	if err != nil {
+		return GroupPhotoResult{Success: false, Message: err.Error()}, err
+	}
+
+	avatar, err := readAvatar(path)
+	if err != nil {
+		return GroupPhotoResult{Success: false, Message: err.Error()}, err
+	}
+
+	id, err := callContext(wac, ctx, "setting group photo", func() (string, error) {
+		return wac.Client.SetGroupPhoto(jid, avatar)
+	})
+	if err != nil {
+		logger.For(ctx).Errorf("Error setting the photo of %s: %v", jid, err)
+		return GroupPhotoResult{Success: false, Message: err.Error()}, err
+	}
+	if avatar == nil {
+		return GroupPhotoResult{Success: true, Message: "Group photo removed"}, nil
+	}
+	return GroupPhotoResult{Success: true, Message: "Group photo updated", PictureID: id}, nil
+}
+
+// SetGroupAnnounce sets whether only admins can send messages to a group
+func (wac *WhatsAppClient) SetGroupAnnounce(ctx context.Context, groupJID string, announce bool) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	jid, err := parseGroupJID(groupJID)
+	if err != nil {
+		return GroupResult{Success: false, Message: err.Error()}, err
+	}
+
+	err = callContextErr(wac, ctx, "setting group announce mode", func() error {
+		return wac.Client.SetGroupAnnounce(jid, announce)
+	})
+	if err != nil {
+		return GroupResult{Success: false, Message: err.Error()}, err
+	}
+	if announce {
+		return GroupResult{Success: true, Message: "Only admins can send messages"}, nil
+	}
+	return GroupResult{Success: true, Message: "All participants can send messages"}, nil
+}
+
+// SetGroupLocked sets whether only admins can edit a group's info
+func (wac *WhatsAppClient) SetGroupLocked(ctx context.Context, groupJID string, locked bool) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	jid, err := parseGroupJID(groupJID)
+	if err != nil {
+		return GroupResult{Success: false, Message: err.Error()}, err
+	}
+
+	err = callContextErr(wac, ctx, "setting group locked mode", func() error {
+		return wac.Client.SetGroupLocked(jid, locked)
+	})
+	if err != nil {
+		return GroupResult{Success: false, Message: err.Error()}, err
+	}
+	if locked {
+		return GroupResult{Success: true, Message: "Only admins can edit the group info"}, nil
+	}
+	return GroupResult{Success: true, Message: "All participants can edit the group info"}, nil
+}
@@ -0,0 +1,164 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waHistorySync "go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	// historyFetchMax is the most messages one on-demand request asks the
+	// phone for, the count WhatsApp recommends
+	historyFetchMax = 50
+	// historyFetchWait is how long get-chat-history waits for the phone to
+	// answer, unless the invoke has a shorter deadline
+	historyFetchWait = 30 * time.Second
+)
+
+// historyFetches are the get-chat-history calls waiting for the phone to
+// answer an on-demand history sync, by chat
+type historyFetches struct {
+	mu      sync.Mutex
+	waiting map[string][]chan int
+}
+
+// wait registers a waiter for chat; it receives the number of messages the
+// answer stored. stop unregisters it.
+func (h *historyFetches) wait(chat string) (answered <-chan int, stop func()) {
+	ch := make(chan int, 1)
+	h.mu.Lock()
+	if h.waiting == nil {
+		h.waiting = map[string][]chan int{}
+	}
+	h.waiting[chat] = append(h.waiting[chat], ch)
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for i, c := range h.waiting[chat] {
+			if c == ch {
+				h.waiting[chat] = append(h.waiting[chat][:i], h.waiting[chat][i+1:]...)
+				break
+			}
+		}
+		if len(h.waiting[chat]) == 0 {
+			delete(h.waiting, chat)
+		}
+	}
+}
+
+// done wakes every waiter of chat
+func (h *historyFetches) done(chat string, stored int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ch := range h.waiting[chat] {
+		select {
+		case ch <- stored:
+		default:
+		}
+	}
+}
+
+// handleHistorySync stores the conversations of a history sync in the
+// archive: the initial ones after pairing, later ones the phone pushes, and
+// on-demand answers to fetchHistory
+func (wac *WhatsAppClient) handleHistorySync(evt *events.HistorySync) {
+	if evt.Data == nil {
+		return
+	}
+	info := HistorySyncInfo{
+		Type:     strings.ToLower(evt.Data.GetSyncType().String()),
+		Progress: int(evt.Data.GetProgress()),
+	}
+	if evt.Data.Progress != nil {
+		eventLogger.Infof("History sync progress: %d%%", info.Progress)
+	}
+	onDemand := evt.Data.GetSyncType() == waHistorySync.HistorySync_ON_DEMAND
+	for _, conv := range evt.Data.GetConversations() {
+		chat, err := types.ParseJID(conv.GetID())
+		if err != nil {
+			logger.Warnf("Skipping history of chat %q: %v", conv.GetID(), err)
+			continue
+		}
+		if wac.messages != nil && !onDemand {
+			wac.messages.saveChat(conv, chat)
+		}
+		stored := 0
+		for _, hm := range conv.GetMessages() {
+			msg, err := wac.Client.ParseWebMessage(chat, hm.GetMessage())
+			if err != nil {
+				logger.Debugf("Skipping a history message of %s: %v", chat, err)
+				continue
+			}
+			wac.storeMessage(messageInfoOf(msg))
+			if wac.messages != nil {
+				wac.messages.saveMedia(msg)
+			}
+			stored++
+		}
+		info.Chats++
+		info.Messages += stored
+		if onDemand {
+			wac.history.done(chat.String(), stored)
+		}
+	}
+	if info.Chats > 0 {
+		logger.Infof("Stored %d past messages of %d chats from a %s history sync", info.Messages, info.Chats, info.Type)
+		wac.publish("history-sync", info)
+	}
+}
+
+// fetchHistory asks the phone for up to count messages of chat older than
+// the oldest one stored, and waits for them to be stored. It returns how
+// many arrived; 0 means the phone has none older, or the stored messages
+// already reach back to since (Unix seconds, 0 for no bound).
+func (wac *WhatsAppClient) fetchHistory(ctx context.Context, chat types.JID, count int, since int64) (int, error) {
+	if !wac.Client.IsLoggedIn() {
+		return 0, fmt.Errorf("not logged in")
+	}
+	own := wac.Client.DeviceID()
+	if own == nil {
+		return 0, fmt.Errorf("not paired")
+	}
+	oldest, ok, err := wac.messages.oldest(ctx, chat.String())
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		// The phone pages back from a message it knows; without one there
+		// is nothing to anchor the request to
+		return 0, fmt.Errorf("no stored message of %s to fetch older ones before", chat)
+	}
+	if since > 0 && oldest.Timestamp < since {
+		return 0, nil
+	}
+	if count > historyFetchMax {
+		count = historyFetchMax
+	}
+
+	answered, stop := wac.history.wait(chat.String())
+	defer stop()
+	req := wac.Client.BuildHistorySyncRequest(&types.MessageInfo{
+		MessageSource: types.MessageSource{Chat: chat, IsFromMe: oldest.IsFromMe},
+		ID:            oldest.ID,
+		Timestamp:     time.Unix(oldest.Timestamp, 0),
+	}, count)
+	ctx, cancel := context.WithTimeout(ctx, historyFetchWait)
+	defer cancel()
+	if _, err := wac.Client.SendMessage(ctx, own.ToNonAD(), req, whatsmeow.SendRequestExtra{Peer: true}); err != nil {
+		return 0, timeoutError("requesting history", err)
+	}
+	select {
+	case n := <-answered:
+		return n, nil
+	case <-ctx.Done():
+		return 0, timeoutError("waiting for the phone to send history", ctx.Err())
+	}
+}
@@ -0,0 +1,212 @@
+package whatsapp
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/libsignal/fingerprint"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ErrNoIdentity is returned when no Signal session with a contact exists
+// yet, so their identity key is unknown. Exchanging a message creates one.
+var ErrNoIdentity = errors.New("no identity key known yet, exchange a message first")
+
+// fingerprintIterations is the SHA-512 rounds of Signal's numeric
+// fingerprint, which WhatsApp's security codes use
+const fingerprintIterations = 5200
+
+// SecurityCodeResult is returned by GetSecurityCode and VerifyIdentity
+type SecurityCodeResult struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	JID        string `json:"jid,omitempty"`
+	Code       string `json:"code,omitempty"`      // 60 digits
+	Formatted  string `json:"formatted,omitempty"` // the code in 12 groups of 5, as WhatsApp shows it
+	Match      *bool  `json:"match,omitempty"`     // VerifyIdentity only: whether the expected code matched
+	Verified   bool   `json:"verified"`            // the contact's current key was verified
+	VerifiedAt int64  `json:"verified_at,omitempty"`
+}
+
+// verifiedStore remembers which identity key was verified for each contact.
+// A contact stays verified only while their key is unchanged, e.g. until
+// they reinstall WhatsApp.
+type verifiedStore struct {
+	db *sql.DB
+}
+
+func newVerifiedStore(db *sql.DB) (*verifiedStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS pod_verified_identities (
+		jid         TEXT PRIMARY KEY,
+		identity    BLOB NOT NULL,
+		verified_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedStore{db: db}, nil
+}
+
+// get returns the time key was verified for jid, zero when it was not
+func (s *verifiedStore) get(jid types.JID, key [32]byte) time.Time {
+	var identity []byte
+	var at int64
+	err := s.db.QueryRow(`SELECT identity, verified_at FROM pod_verified_identities WHERE jid = ?`, jid.String()).Scan(&identity, &at)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.Warnf("Reading verified identity of %s: %v", jid, err)
+		}
+		return time.Time{}
+	}
+	if !bytes.Equal(identity, key[:]) {
+		return time.Time{} // verified a key they no longer use
+	}
+	return time.Unix(at, 0)
+}
+
+func (s *verifiedStore) put(jid types.JID, key [32]byte, at time.Time) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO pod_verified_identities (jid, identity, verified_at) VALUES (?, ?, ?)`,
+		jid.String(), key[:], at.Unix())
+	return err
+}
+
+func (s *verifiedStore) remove(jid types.JID) error {
+	_, err := s.db.Exec(`DELETE FROM pod_verified_identities WHERE jid = ?`, jid.String())
+	return err
+}
+
+// fingerprintHalf is one side of Signal's numeric fingerprint: SHA-512
+// iterated over the version, the serialized public key and the account's
+// phone number
+func fingerprintHalf(phone string, key [32]byte) []byte {
+	public := append([]byte{0x05}, key[:]...) // DJB key type prefix
+	hash := append(append([]byte{0, 0}, public...), phone...)
+	for range fingerprintIterations {
+		sum := sha512.Sum512(append(hash, public...))
+		hash = sum[:]
+	}
+	return hash
+}
+
+// securityCode returns the 60-digit code of our account and a contact, and
+// the contact's identity key. Both halves use the primary device (device 0)
+// of each account, whose keys WhatsApp's "Verify security code" screen shows.
+func (wac *WhatsAppClient) securityCode(contact types.JID) (string, [32]byte, error) {
+	own := wac.Client.DeviceID()
+	if own == nil {
+		return "", [32]byte{}, fmt.Errorf("not paired")
+	}
+	ownKey, err := wac.Client.IdentityKey(types.NewJID(own.User, types.DefaultUserServer))
+	if err != nil {
+		return "", [32]byte{}, fmt.Errorf("own identity key: %w", err)
+	}
+	theirKey, err := wac.Client.IdentityKey(types.NewJID(contact.User, types.DefaultUserServer))
+	if err != nil {
+		return "", theirKey, fmt.Errorf("identity key of %s: %w", contact.User, err)
+	}
+	display := fingerprint.NewDisplay(fingerprintHalf(own.User, ownKey), fingerprintHalf(contact.User, theirKey))
+	return display.DisplayText(), theirKey, nil
+}
+
+// formatCode splits a security code into groups of five digits
+func formatCode(code string) string {
+	var groups []string
+	for i := 0; i < len(code); i += 5 {
+		groups = append(groups, code[i:min(i+5, len(code))])
+	}
+	return strings.Join(groups, " ")
+}
+
+// contactJID parses a contact for the identity vars, which only apply to
+// individual accounts
+func contactJID(jid string) (types.JID, error) {
+	contact, err := types.ParseJID(jid)
+	if err != nil {
+		return contact, err
+	}
+	if contact.Server != types.DefaultUserServer {
+		return contact, fmt.Errorf("%s is not a contact; security codes exist for @%s JIDs only", jid, types.DefaultUserServer)
+	}
+	return contact.ToNonAD(), nil
+}
+
+// GetSecurityCode returns the security code of a contact, to compare with
+// the one they see in WhatsApp or read out of band
+func (wac *WhatsAppClient) GetSecurityCode(jid string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return SecurityCodeResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	contact, err := contactJID(jid)
+	if err != nil {
+		return SecurityCodeResult{Success: false, Message: err.Error()}, err
+	}
+	code, key, err := wac.securityCode(contact)
+	if err != nil {
+		return SecurityCodeResult{Success: false, Message: err.Error(), JID: contact.String()}, err
+	}
+
+	result := SecurityCodeResult{Success: true, JID: contact.String(), Code: code, Formatted: formatCode(code)}
+	if wac.verified != nil {
+		if at := wac.verified.get(contact, key); !at.IsZero() {
+			result.Verified, result.VerifiedAt = true, at.Unix()
+		}
+	}
+	return result, nil
+}
+
+// VerifyIdentity compares a contact's security code with an expected one
+// (spaces are ignored). A match marks the contact's current identity key as
+// verified; a mismatch clears any earlier verification.
+func (wac *WhatsAppClient) VerifyIdentity(jid string, expected string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return SecurityCodeResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	if wac.verified == nil {
+		return SecurityCodeResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	contact, err := contactJID(jid)
+	if err != nil {
+		return SecurityCodeResult{Success: false, Message: err.Error()}, err
+	}
+	code, key, err := wac.securityCode(contact)
+	if err != nil {
+		return SecurityCodeResult{Success: false, Message: err.Error(), JID: contact.String()}, err
+	}
+
+	match := strings.Join(strings.Fields(expected), "") == code
+	result := SecurityCodeResult{Success: true, JID: contact.String(), Match: &match}
+	if !match {
+		logger.Warnf("Security code of %s does not match the expected one", contact)
+		if err := wac.verified.remove(contact); err != nil {
+			return SecurityCodeResult{Success: false, Message: err.Error()}, err
+		}
+		result.Message = "Security code does not match"
+		return result, nil
+	}
+
+	now := time.Now()
+	if err := wac.verified.put(contact, key, now); err != nil {
+		return SecurityCodeResult{Success: false, Message: err.Error()}, err
+	}
+	logger.Infof("Identity of %s verified", contact)
+	result.Message = "Identity verified"
+	result.Verified, result.VerifiedAt = true, now.Unix()
+	return result, nil
+}
+
+// isVerified reports whether a contact's current identity key was verified
+func (wac *WhatsAppClient) isVerified(contact types.JID) bool {
+	if wac.verified == nil || contact.Server != types.DefaultUserServer {
+		return false
+	}
+	key, err := wac.Client.IdentityKey(contact.ToNonAD())
+	if err != nil {
+		return false
+	}
+	return !wac.verified.get(contact.ToNonAD(), key).IsZero()
+}
@@ -0,0 +1,170 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+)
+
+// inbox keeps incoming messages until PollMessages drains them. It is bounded
+// by Options.MessageQueueSize; when full, the oldest message makes room.
+type inbox struct {
+	mu      sync.Mutex
+	msgs    []*MessageInfo
+	dropped int // since the last poll, reported in the log
+}
+
+// queueMessage adds an incoming message to the inbox
+func (wac *WhatsAppClient) queueMessage(msg *MessageInfo) {
+	limit := wac.Options().MessageQueueSize
+	if limit <= 0 {
+		return
+	}
+	in := &wac.inbox
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if over := len(in.msgs) + 1 - limit; over > 0 {
+		if over > len(in.msgs) {
+			over = len(in.msgs)
+		}
+		in.msgs = append(in.msgs[:0], in.msgs[over:]...)
+		in.dropped += over
+		metricInboxDropped.Add(uint64(over))
+	}
+	in.msgs = append(in.msgs, msg)
+}
+
+// PollMessages removes and returns up to max queued incoming messages,
+// oldest first; max 0 returns all of them
+func (wac *WhatsAppClient) PollMessages(max int) []*MessageInfo {
+	in := &wac.inbox
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.dropped > 0 {
+		logger.Warnf("%d incoming messages were dropped from the full queue since the last poll", in.dropped)
+		in.dropped = 0
+	}
+	n := len(in.msgs)
+	if max > 0 && max < n {
+		n = max
+	}
+	out := make([]*MessageInfo, n)
+	copy(out, in.msgs[:n])
+	in.msgs = append(in.msgs[:0], in.msgs[n:]...)
+	return out
+}
+
+// inboxDepth is reported with the queue depths
+func (wac *WhatsAppClient) inboxDepth() int {
+	wac.inbox.mu.Lock()
+	defer wac.inbox.mu.Unlock()
+	return len(wac.inbox.msgs)
+}
+
+// inboxStore keeps the messages still queued at shutdown in the session
+// database, so a restart hands them to the next poll instead of losing them
+type inboxStore struct {
+	db *sql.DB
+}
+
+func newInboxStore(db *sql.DB) (*inboxStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS pod_inbox (
+		seq     INTEGER PRIMARY KEY AUTOINCREMENT,
+		message TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &inboxStore{db: db}, nil
+}
+
+// save appends msgs after any saved earlier
+func (s *inboxStore) save(msgs []*MessageInfo) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, msg := range msgs {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO pod_inbox (message) VALUES (?)`, string(data)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// take removes and returns the saved messages, oldest first
+func (s *inboxStore) take() ([]*MessageInfo, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT seq, message FROM pod_inbox ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*MessageInfo
+	for rows.Next() {
+		var seq int64
+		var data string
+		if err := rows.Scan(&seq, &data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		msg := &MessageInfo{}
+		if err := json.Unmarshal([]byte(data), msg); err != nil {
+			logger.Warnf("Skipping unreadable saved message %d: %v", seq, err)
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM pod_inbox`); err != nil {
+		return nil, err
+	}
+	return msgs, tx.Commit()
+}
+
+// saveInbox moves the queued messages to the inbox store. Disconnect calls
+// it once no more messages can arrive.
+func (wac *WhatsAppClient) saveInbox() {
+	if wac.inboxStore == nil {
+		return
+	}
+	in := &wac.inbox
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.msgs) == 0 {
+		return
+	}
+	if err := wac.inboxStore.save(in.msgs); err != nil {
+		logger.Errorf("Saving %d queued messages: %v", len(in.msgs), err)
+		return
+	}
+	logger.Infof("Saved %d queued messages for the next start", len(in.msgs))
+	in.msgs = nil
+}
+
+// restoreInbox queues the messages saved at the last shutdown
+func (wac *WhatsAppClient) restoreInbox() {
+	msgs, err := wac.inboxStore.take()
+	if err != nil {
+		logger.Errorf("Restoring saved queued messages: %v", err)
+		return
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	for _, msg := range msgs {
+		wac.queueMessage(msg)
+	}
+	logger.Infof("Restored %d queued messages saved at the last shutdown", len(msgs))
+}
@@ -0,0 +1,187 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	maxButtons  = 3  // WhatsApp shows no more reply buttons
+	maxListRows = 10 // across all sections of a list
+)
+
+// Button is a tappable reply button. ID comes back in the button-reply
+// message when it is tapped.
+type Button struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// InteractiveButtons is a text with up to three reply buttons under it
+type InteractiveButtons struct {
+	Text    string   `json:"text"`
+	Footer  string   `json:"footer"`
+	Buttons []Button `json:"buttons"`
+}
+
+// ListRow is one option of a list. ID comes back in the list-reply message
+// when it is picked.
+type ListRow struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// ListSection is a titled group of list rows
+type ListSection struct {
+	Title string    `json:"title"`
+	Rows  []ListRow `json:"rows"`
+}
+
+// InteractiveList is a text with a button that opens a list of options
+type InteractiveList struct {
+	Title      string        `json:"title"`
+	Text       string        `json:"text"`
+	Footer     string        `json:"footer"`
+	ButtonText string        `json:"button-text"` // the label of the button that opens the list
+	Sections   []ListSection `json:"sections"`
+}
+
+// SendButtons sends a message with reply buttons
+func (wac *WhatsAppClient) SendButtons(ctx context.Context, chatJID string, buttons InteractiveButtons) (interface{}, error) {
+	if err := validateButtons(buttons); err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	msg := &waProto.ButtonsMessage{
+		ContentText: proto.String(buttons.Text),
+		HeaderType:  waProto.ButtonsMessage_EMPTY.Enum(),
+	}
+	if buttons.Footer != "" {
+		msg.FooterText = proto.String(buttons.Footer)
+	}
+	for _, b := range buttons.Buttons {
+		msg.Buttons = append(msg.Buttons, &waProto.ButtonsMessage_Button{
+			ButtonID:   proto.String(b.ID),
+			ButtonText: &waProto.ButtonsMessage_Button_ButtonText{DisplayText: proto.String(b.Text)},
+			Type:       waProto.ButtonsMessage_Button_RESPONSE.Enum(),
+		})
+	}
+	spec, _ := json.Marshal(buttons)
+	return wac.sendInteractive(ctx, chatJID, &waProto.Message{ButtonsMessage: msg}, "send-buttons", string(spec))
+}
+
+// SendList sends a message whose button opens a list of options
+func (wac *WhatsAppClient) SendList(ctx context.Context, chatJID string, list InteractiveList) (interface{}, error) {
+	if err := validateList(list); err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	msg := &waProto.ListMessage{
+		Title:       proto.String(list.Title),
+		Description: proto.String(list.Text),
+		ButtonText:  proto.String(list.ButtonText),
+		ListType:    waProto.ListMessage_SINGLE_SELECT.Enum(),
+	}
+	if list.Footer != "" {
+		msg.FooterText = proto.String(list.Footer)
+	}
+	for _, s := range list.Sections {
+		section := &waProto.ListMessage_Section{Title: proto.String(s.Title)}
+		for _, r := range s.Rows {
+			row := &waProto.ListMessage_Row{RowID: proto.String(r.ID), Title: proto.String(r.Title)}
+			if r.Description != "" {
+				row.Description = proto.String(r.Description)
+			}
+			section.Rows = append(section.Rows, row)
+		}
+		msg.Sections = append(msg.Sections, section)
+	}
+	spec, _ := json.Marshal(list)
+	return wac.sendInteractive(ctx, chatJID, &waProto.Message{ListMessage: msg}, "send-list", string(spec))
+}
+
+// sendInteractive sends buttons or a list. Phones only render them wrapped
+// in a view-once message with device list metadata, the way WhatsApp
+// Business clients send them. spec is the JSON the dead letter replays.
+func (wac *WhatsAppClient) sendInteractive(ctx context.Context, chatJID string, msg *waProto.Message, op, spec string) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+
+	chat, err := ParseRecipientJID(chatJID)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+
+	msg.MessageContextInfo = &waProto.MessageContextInfo{
+		DeviceListMetadata:        &waProto.DeviceListMetadata{},
+		DeviceListMetadataVersion: proto.Int32(2),
+	}
+	wrapped := &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{Message: msg}}
+	resp, err := wac.sendMessage(ctx, chat, wrapped)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, op, chatJID, spec)
+	}
+
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: fmt.Sprintf("Interactive message sent to %s", chat),
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// validateButtons checks buttons before they are sent; WhatsApp drops
+// messages its apps cannot show without an error
+func validateButtons(b InteractiveButtons) error {
+	if b.Text == "" {
+		return fmt.Errorf("the buttons text is required")
+	}
+	if len(b.Buttons) == 0 || len(b.Buttons) > maxButtons {
+		return fmt.Errorf("a message needs 1 to %d buttons, got %d", maxButtons, len(b.Buttons))
+	}
+	ids := map[string]bool{}
+	for _, btn := range b.Buttons {
+		if btn.ID == "" || btn.Text == "" {
+			return fmt.Errorf("every button needs an id and a text")
+		}
+		if ids[btn.ID] {
+			return fmt.Errorf("duplicate button id %q", btn.ID)
+		}
+		ids[btn.ID] = true
+	}
+	return nil
+}
+
+// validateList checks a list before it is sent
+func validateList(l InteractiveList) error {
+	if l.Text == "" || l.ButtonText == "" {
+		return fmt.Errorf("the list text and button-text are required")
+	}
+	if len(l.Sections) == 0 {
+		return fmt.Errorf("a list needs at least one section")
+	}
+	ids := map[string]bool{}
+	for _, s := range l.Sections {
+		if len(s.Rows) == 0 {
+			return fmt.Errorf("list section %q has no rows", s.Title)
+		}
+		for _, r := range s.Rows {
+			if r.ID == "" || r.Title == "" {
+				return fmt.Errorf("every list row needs an id and a title")
+			}
+			if ids[r.ID] {
+				return fmt.Errorf("duplicate list row id %q", r.ID)
+			}
+			ids[r.ID] = true
+		}
+	}
+	if len(ids) > maxListRows {
+		return fmt.Errorf("a list holds at most %d rows, got %d", maxListRows, len(ids))
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif" // decoders for preview images
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	linkPreviewTimeout  = 10 * time.Second
+	linkPreviewMaxPage  = 512 << 10 // the metadata is in the head, the rest is not read
+	linkPreviewMaxImage = 5 << 20
+	linkThumbnailSize   = 160 // the longest side of the thumbnail, as phones make them
+)
+
+var (
+	linkURL   = regexp.MustCompile(`https?://[^\s<>"]+`)
+	htmlMeta  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	htmlAttr  = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	htmlTitle = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// linkTrailers end a sentence rather than the link before them
+const linkTrailers = ".,;:!?)]}'\""
+
+// linkPreview is what WhatsApp shows under a link
+type linkPreview struct {
+	URL         string
+	Title       string
+	Description string
+	Thumbnail   []byte // JPEG, nil when the page has no usable image
+	Width       int
+	Height      int
+}
+
+// firstLink returns the first http(s) URL in text, without the punctuation
+// that usually follows a link in a sentence
+func firstLink(text string) string {
+	return strings.TrimRight(linkURL.FindString(text), linkTrailers)
+}
+
+// textMessage builds a text message, with a preview of its first link when
+// preview is set. A page that cannot be previewed is sent as plain text,
+// like the phone does.
+func (wac *WhatsAppClient) textMessage(ctx context.Context, text string, preview bool) *waProto.Message {
+	link := firstLink(text)
+	if !preview || link == "" {
+		return &waProto.Message{Conversation: proto.String(text)}
+	}
+	p, err := wac.fetchLinkPreview(ctx, link)
+	if err != nil {
+		logger.For(ctx).Warnf("No link preview for %s: %v", link, err)
+		return &waProto.Message{Conversation: proto.String(text)}
+	}
+	m := &waProto.ExtendedTextMessage{
+		Text:        proto.String(text),
+		MatchedText: proto.String(link),
+		Title:       proto.String(p.Title),
+		Description: proto.String(p.Description),
+		PreviewType: waProto.ExtendedTextMessage_NONE.Enum(),
+	}
+	if p.Thumbnail != nil {
+		m.JPEGThumbnail = p.Thumbnail
+		m.ThumbnailWidth = proto.Uint32(uint32(p.Width))
+		m.ThumbnailHeight = proto.Uint32(uint32(p.Height))
+	}
+	return &waProto.Message{ExtendedTextMessage: m}
+}
+
+// fetchLinkPreview reads the title, description and image of a page from
+// its Open Graph tags, falling back to <title> and the meta description
+func (wac *WhatsAppClient) fetchLinkPreview(ctx context.Context, link string) (*linkPreview, error) {
+	ctx, cancel := context.WithTimeout(ctx, linkPreviewTimeout)
+	defer cancel()
+	transport, err := wac.proxyTransport()
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{Transport: transport}
+
+	page, mimetype, final, err := fetchLimited(ctx, httpClient, link, linkPreviewMaxPage)
+	if err != nil {
+		return nil, err
+	}
+	if mimetype != "text/html" && mimetype != "application/xhtml+xml" {
+		return nil, fmt.Errorf("not a web page (%s)", mimetype)
+	}
+
+	meta := pageMeta(page)
+	p := &linkPreview{URL: link, Title: meta["og:title"], Description: meta["og:description"]}
+	if p.Title == "" {
+		if m := htmlTitle.FindSubmatch(page); m != nil {
+			p.Title = strings.TrimSpace(html.UnescapeString(string(m[1])))
+		}
+	}
+	if p.Title == "" {
+		return nil, fmt.Errorf("the page has no title")
+	}
+	if p.Description == "" {
+		p.Description = meta["description"]
+	}
+
+	imageURL := meta["og:image"]
+	if imageURL == "" {
+		imageURL = meta["twitter:image"]
+	}
+	if imageURL != "" {
+		if u, err := final.Parse(imageURL); err == nil {
+			if p.Thumbnail, p.Width, p.Height, err = fetchThumbnail(ctx, httpClient, u.String()); err != nil {
+				logger.For(ctx).Warnf("No link preview image for %s: %v", link, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// fetchLimited GETs a URL and reads at most limit bytes of the body. It
+// returns the media type and the URL after redirects.
+func fetchLimited(ctx context.Context, httpClient *http.Client, link string, limit int64) ([]byte, string, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// Sites serve their preview tags to link expanders
+	req.Header.Set("User-Agent", "WhatsApp/2.23.20.0")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", nil, fmt.Errorf("GET %s: %s", link, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	mimetype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return body, mimetype, resp.Request.URL, nil
+}
+
+// pageMeta returns the content of the page's meta tags by property or name,
+// the first of each
+func pageMeta(page []byte) map[string]string {
+	meta := map[string]string{}
+	for _, tag := range htmlMeta.FindAll(page, -1) {
+		attrs := map[string]string{}
+		for _, a := range htmlAttr.FindAllSubmatch(tag, -1) {
+			attrs[strings.ToLower(string(a[1]))] = string(a[2]) + string(a[3]) + string(a[4])
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = strings.TrimSpace(html.UnescapeString(attrs["content"]))
+		}
+	}
+	return meta
+}
+
+// fetchThumbnail downloads a JPEG, PNG or GIF and scales it to a JPEG
+// thumbnail
+func fetchThumbnail(ctx context.Context, httpClient *http.Client, link string) ([]byte, int, int, error) {
+	data, _, _, err := fetchLimited(ctx, httpClient, link, linkPreviewMaxImage)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	thumb := scaleDown(src, linkThumbnailSize)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, 0, 0, err
+	}
+	b := thumb.Bounds()
+	return buf.Bytes(), b.Dx(), b.Dy(), nil
+}
+
+// scaleDown fits img in a size×size square, averaging the source pixels
+// that fall on each thumbnail pixel. Smaller images are left as they are.
+func scaleDown(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	tw, th = max(tw, 1), max(th, 1)
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+max((x+1)*w/tw, x*w/tw+1)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n>>8), uint8(g/n>>8), uint8(bl/n>>8), uint8(a/n>>8)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,204 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// LogLevel orders log lines by severity
+type LogLevel int32
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "info"
+}
+
+// ParseLogLevel accepts debug, info, warn or error
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", s)
+}
+
+// logLevel is the process-wide minimum level, shared by the standard log
+// filter and the whatsmeow loggers
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(int32(LevelInfo))
+}
+
+// SetLogLevel changes the minimum level of all pod and whatsmeow logging
+func SetLogLevel(level LogLevel) {
+	logLevel.Store(int32(level))
+}
+
+// CurrentLogLevel returns the minimum level being logged
+func CurrentLogLevel() LogLevel {
+	return LogLevel(logLevel.Load())
+}
+
+// levelMarker is the "LEVEL: " a Logger writes after the prefixes, the
+// first thing LineLevel looks for
+var levelMarker = regexp.MustCompile(`\b(DEBUG|INFO|WARN|ERROR): `)
+
+// LineLevel returns the level of a standard log line: the marker a Logger
+// wrote, or for lines logged some other way (by a library, say) a guess
+// from the words "ERROR", "WARN" and "DEBUG" in it; anything else is info
+func LineLevel(line []byte) LogLevel {
+	if m := levelMarker.FindSubmatch(line); m != nil {
+		level, _ := ParseLogLevel(string(m[1]))
+		return level
+	}
+	switch {
+	case bytes.Contains(line, []byte("ERROR")), bytes.Contains(line, []byte("FATAL")), bytes.Contains(line, []byte("PANIC")):
+		return LevelError
+	case bytes.Contains(line, []byte("WARN")):
+		return LevelWarn
+	case bytes.Contains(line, []byte("DEBUG")):
+		return LevelDebug
+	}
+	return LevelInfo
+}
+
+// levelFilter drops log lines below the current level. The log package
+// calls Write once per line, so each call can be judged on its own.
+type levelFilter struct {
+	out io.Writer
+}
+
+// LevelFilter wraps out so it only receives lines at or above the current level
+func LevelFilter(out io.Writer) io.Writer {
+	return levelFilter{out: out}
+}
+
+func (f levelFilter) Write(p []byte) (int, error) {
+	if LineLevel(p) < CurrentLogLevel() {
+		return len(p), nil
+	}
+	return f.out.Write(p)
+}
+
+// Logger writes leveled lines to the standard log output as
+// "[component] LEVEL: message", so the level survives into text and JSON
+// log lines. Lines below the current level are not formatted at all.
+type Logger struct {
+	prefix    string // before the component, such as an invoke's "[id=1 req=ab12cd34] "
+	component string
+}
+
+// NewLogger returns a Logger for one part of the pod, e.g. "HTTP"
+func NewLogger(component string) Logger {
+	return Logger{component: component}
+}
+
+// WithPrefix returns a Logger that starts each line with prefix
+func (l Logger) WithPrefix(prefix string) Logger {
+	l.prefix = prefix
+	return l
+}
+
+// logPrefixKey is the context key of WithLogPrefix
+type logPrefixKey struct{}
+
+// WithLogPrefix returns a context whose calls into a client log with
+// prefix, so lines logged while serving an invoke carry its id
+func WithLogPrefix(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, logPrefixKey{}, prefix)
+}
+
+// For returns l with the log prefix of ctx, if it has one
+func (l Logger) For(ctx context.Context) Logger {
+	if prefix, ok := ctx.Value(logPrefixKey{}).(string); ok {
+		l.prefix = prefix
+	}
+	return l
+}
+
+// Prefix returns the prefix l starts each line with
+func (l Logger) Prefix() string {
+	return l.prefix
+}
+
+func (l Logger) Debugf(format string, args ...interface{}) { l.output(LevelDebug, format, args...) }
+func (l Logger) Infof(format string, args ...interface{})  { l.output(LevelInfo, format, args...) }
+func (l Logger) Warnf(format string, args ...interface{})  { l.output(LevelWarn, format, args...) }
+func (l Logger) Errorf(format string, args ...interface{}) { l.output(LevelError, format, args...) }
+
+// LogPanic, deferred at the top of a goroutine or callback, logs a panic
+// with its stack and lets the goroutine end, so a bug in background work
+// does not take the whole pod down
+func LogPanic(l Logger, what string) {
+	if r := recover(); r != nil {
+		logPanic(l, what, r)
+	}
+}
+
+// logPanic logs a recovered panic r and returns it as an error
+func logPanic(l Logger, what string, r interface{}) error {
+	metricPanics.Inc()
+	l.Errorf("PANIC in %s: %v\n%s", what, r, debug.Stack())
+	return fmt.Errorf("internal error in %s: %v", what, r)
+}
+
+func (l Logger) output(level LogLevel, format string, args ...interface{}) {
+	if level < CurrentLogLevel() {
+		return
+	}
+	// Skip output and the level method, so Lshortfile names the caller
+	log.Output(3, fmt.Sprintf("%s[%s] %s: %s", l.prefix, l.component, strings.ToUpper(level.String()), fmt.Sprintf(format, args...)))
+}
+
+// The loggers of this package
+var (
+	logger      = NewLogger("whatsapp")
+	eventLogger = NewLogger("EventHandler")
+	loginLogger = NewLogger("Login")
+)
+
+// waLogger routes whatsmeow's logging into the standard log output, as
+// component "whatsmeow/<module>". Nothing may go to stdout, which carries
+// the pod protocol.
+type waLogger struct {
+	Logger
+	module string
+}
+
+func newWALogger(module string) waLog.Logger {
+	return waLogger{Logger: NewLogger("whatsmeow/" + module), module: module}
+}
+
+func (l waLogger) Sub(module string) waLog.Logger {
+	return newWALogger(l.module + "/" + module)
+}
@@ -0,0 +1,145 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TableStats describes one table of the session database
+type TableStats struct {
+	Name  string `json:"name"`
+	Rows  int64  `json:"rows"`
+	Bytes int64  `json:"bytes"` // pages used by the table and its indexes
+	Owner string `json:"owner"` // "whatsmeow" or "pod"
+}
+
+// MaintenanceResult is returned by Maintenance
+type MaintenanceResult struct {
+	Success    bool         `json:"success"`
+	Message    string       `json:"message,omitempty"`
+	Integrity  []string     `json:"integrity"` // ["ok"] when the check passes
+	Vacuumed   bool         `json:"vacuumed"`
+	SizeBefore int64        `json:"size_before"`
+	SizeAfter  int64        `json:"size_after"`
+	DurationMs int64        `json:"duration_ms"`
+	Tables     []TableStats `json:"tables"`
+}
+
+// podTablePrefix marks the tables the pod adds to the session database
+const podTablePrefix = "pod_"
+
+// Maintenance checks the integrity of the session database, optionally
+// compacts it with VACUUM, and reports the size of every table. VACUUM
+// rewrites the whole file, so it is skipped when the integrity check fails.
+func (wac *WhatsAppClient) Maintenance(ctx context.Context, vacuum bool) (interface{}, error) {
+	if wac.db == nil {
+		return MaintenanceResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	start := time.Now()
+	result := MaintenanceResult{Integrity: []string{}, Tables: []TableStats{}}
+
+	var err error
+	if result.SizeBefore, err = wac.databaseSize(ctx); err != nil {
+		return wac.maintenanceFailed(result, "reading database size", err)
+	}
+
+	rows, err := wac.db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return wac.maintenanceFailed(result, "checking integrity", err)
+	}
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			rows.Close()
+			return wac.maintenanceFailed(result, "checking integrity", err)
+		}
+		result.Integrity = append(result.Integrity, line)
+	}
+	rows.Close()
+	healthy := len(result.Integrity) == 1 && result.Integrity[0] == "ok"
+	if !healthy {
+		logger.For(ctx).Errorf("Session database failed its integrity check: %s", strings.Join(result.Integrity, "; "))
+	}
+
+	if vacuum && healthy {
+		logger.For(ctx).Infof("Vacuuming session database...")
+		if _, err := wac.db.ExecContext(ctx, "VACUUM"); err != nil {
+			return wac.maintenanceFailed(result, "vacuuming", err)
+		}
+		result.Vacuumed = true
+	}
+	if result.SizeAfter, err = wac.databaseSize(ctx); err != nil {
+		return wac.maintenanceFailed(result, "reading database size", err)
+	}
+	if result.Tables, err = wac.tableStats(ctx); err != nil {
+		return wac.maintenanceFailed(result, "reading table sizes", err)
+	}
+
+	result.DurationMs = time.Since(start).Milliseconds()
+	if !healthy {
+		result.Message = "Integrity check failed; restore from a backup or log in again"
+		return result, fmt.Errorf("integrity check failed: %s", strings.Join(result.Integrity, "; "))
+	}
+	result.Success = true
+	result.Message = fmt.Sprintf("Database healthy, %d bytes (was %d)", result.SizeAfter, result.SizeBefore)
+	logger.For(ctx).Infof("Database maintenance done in %dms: %s", result.DurationMs, result.Message)
+	return result, nil
+}
+
+func (wac *WhatsAppClient) maintenanceFailed(result MaintenanceResult, step string, err error) (interface{}, error) {
+	logger.Errorf("Database maintenance failed %s: %v", step, err)
+	result.Message = fmt.Sprintf("Failed %s: %v", step, err)
+	return result, fmt.Errorf("%s: %w", step, err)
+}
+
+// databaseSize returns the size of the database file in bytes
+func (wac *WhatsAppClient) databaseSize(ctx context.Context) (int64, error) {
+	var pages, pageSize int64
+	if err := wac.db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := wac.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
+}
+
+// tableStats counts the rows and bytes of every table, attributing index
+// pages to the table they belong to
+func (wac *WhatsAppClient) tableStats(ctx context.Context) ([]TableStats, error) {
+	rows, err := wac.db.QueryContext(ctx, `SELECT m.tbl_name, SUM(s.pgsize)
+		FROM sqlite_master m JOIN dbstat s ON s.name = m.name
+		WHERE m.tbl_name IN (SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%')
+		GROUP BY m.tbl_name ORDER BY m.tbl_name`)
+	if err != nil {
+		return nil, err
+	}
+	var tables []TableStats
+	for rows.Next() {
+		var t TableStats
+		if err := rows.Scan(&t.Name, &t.Bytes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.Owner = "whatsmeow"
+		if strings.HasPrefix(t.Name, podTablePrefix) {
+			t.Owner = "pod"
+		}
+		tables = append(tables, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range tables {
+		// Names come from sqlite_master, quoting only guards odd characters
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, strings.ReplaceAll(tables[i].Name, `"`, `""`))
+		if err := wac.db.QueryRowContext(ctx, query).Scan(&tables[i].Rows); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
@@ -0,0 +1,291 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/proto/waMmsRetry"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// mediaRetryTimeout bounds the wait for the phone to re-upload expired media
+const mediaRetryTimeout = 30 * time.Second
+
+// MediaKeys identify an attachment without a stored message, e.g. one seen
+// by another program. The byte fields are base64 in JSON.
+type MediaKeys struct {
+	MediaType     string `json:"media-type"` // image, video, audio, document or sticker
+	DirectPath    string `json:"direct-path"`
+	MediaKey      []byte `json:"media-key"`
+	FileEncSHA256 []byte `json:"file-enc-sha256"`
+	FileSHA256    []byte `json:"file-sha256"`
+	FileLength    uint64 `json:"file-length"`
+	MimeType      string `json:"mime-type"`
+}
+
+// MediaDownloadResult is returned by DownloadMedia for a download to a file
+type MediaDownloadResult struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Size      int64  `json:"size"`
+	MimeType  string `json:"mime_type,omitempty"`
+	FileName  string `json:"file_name,omitempty"`
+	Retried   bool   `json:"retried,omitempty"` // the media had expired and the phone uploaded it again
+}
+
+// storedMedia is a media message kept for DownloadMedia and ForwardMessage
+type storedMedia struct {
+	info    types.MessageInfo
+	message *waProto.Message
+}
+
+// saveMedia keeps the media part of a message, so its attachment can be
+// downloaded or forwarded later
+func (s *messageStore) saveMedia(msg *events.Message) {
+	media, _, _ := incomingMedia(msg.Message)
+	if media == nil {
+		return
+	}
+	data, err := proto.Marshal(msg.Message)
+	if err != nil {
+		logger.Warnf("Encoding media of message %s: %v", msg.Info.ID, err)
+		return
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO pod_media (chat_jid, id, sender, is_from_me, message, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		msg.Info.Chat.String(), msg.Info.ID, msg.Info.Sender.String(), msg.Info.IsFromMe, data, msg.Info.Timestamp.Unix())
+	if err != nil {
+		logger.Warnf("Storing media of message %s: %v", msg.Info.ID, err)
+	}
+}
+
+// media finds a stored media message by ID, in chat when one is given,
+// otherwise the most recent one with that ID
+func (s *messageStore) media(ctx context.Context, id, chat string) (*storedMedia, error) {
+	var chatJID, sender string
+	var fromMe bool
+	var data []byte
+	var ts int64
+	err := s.db.QueryRowContext(ctx, `SELECT chat_jid, sender, is_from_me, message, timestamp FROM pod_media
+		WHERE id = ? AND (? = '' OR chat_jid = ?) ORDER BY timestamp DESC LIMIT 1`, id, chat, chat).
+		Scan(&chatJID, &sender, &fromMe, &data, &ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no stored media message %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &storedMedia{message: &waProto.Message{}}
+	if err := proto.Unmarshal(data, m.message); err != nil {
+		return nil, fmt.Errorf("stored media message %s: %w", id, err)
+	}
+	m.info.ID = id
+	m.info.Timestamp = time.Unix(ts, 0)
+	m.info.IsFromMe = fromMe
+	if m.info.Chat, err = types.ParseJID(chatJID); err != nil {
+		return nil, err
+	}
+	if m.info.Sender, err = types.ParseJID(sender); err != nil {
+		return nil, err
+	}
+	m.info.IsGroup = m.info.Chat.Server == types.GroupServer
+	return m, nil
+}
+
+// mediaFromKeys builds the downloadable sub-message the keys describe
+func mediaFromKeys(k MediaKeys) (whatsmeow.DownloadableMessage, error) {
+	if k.DirectPath == "" || len(k.MediaKey) == 0 || len(k.FileEncSHA256) == 0 || len(k.FileSHA256) == 0 {
+		return nil, fmt.Errorf("direct-path, media-key, file-enc-sha256 and file-sha256 are required")
+	}
+	switch k.MediaType {
+	case "image":
+		return &waProto.ImageMessage{DirectPath: &k.DirectPath, MediaKey: k.MediaKey, FileEncSHA256: k.FileEncSHA256,
+			FileSHA256: k.FileSHA256, FileLength: &k.FileLength, Mimetype: &k.MimeType}, nil
+	case "video":
+		return &waProto.VideoMessage{DirectPath: &k.DirectPath, MediaKey: k.MediaKey, FileEncSHA256: k.FileEncSHA256,
+			FileSHA256: k.FileSHA256, FileLength: &k.FileLength, Mimetype: &k.MimeType}, nil
+	case "audio":
+		return &waProto.AudioMessage{DirectPath: &k.DirectPath, MediaKey: k.MediaKey, FileEncSHA256: k.FileEncSHA256,
+			FileSHA256: k.FileSHA256, FileLength: &k.FileLength, Mimetype: &k.MimeType}, nil
+	case "document":
+		return &waProto.DocumentMessage{DirectPath: &k.DirectPath, MediaKey: k.MediaKey, FileEncSHA256: k.FileEncSHA256,
+			FileSHA256: k.FileSHA256, FileLength: &k.FileLength, Mimetype: &k.MimeType}, nil
+	case "sticker":
+		return &waProto.StickerMessage{DirectPath: &k.DirectPath, MediaKey: k.MediaKey, FileEncSHA256: k.FileEncSHA256,
+			FileSHA256: k.FileSHA256, FileLength: &k.FileLength, Mimetype: &k.MimeType}, nil
+	}
+	return nil, fmt.Errorf("unknown media-type %q, use image, video, audio, document or sticker", k.MediaType)
+}
+
+// setDirectPath points media at the location a media retry returned
+func setDirectPath(media whatsmeow.DownloadableMessage, path string) {
+	switch m := media.(type) {
+	case *waProto.ImageMessage:
+		m.DirectPath = &path
+	case *waProto.VideoMessage:
+		m.DirectPath = &path
+	case *waProto.AudioMessage:
+		m.DirectPath = &path
+	case *waProto.DocumentMessage:
+		m.DirectPath = &path
+	case *waProto.StickerMessage:
+		m.DirectPath = &path
+	}
+}
+
+// DownloadMedia downloads and decrypts the attachment of an incoming
+// message to path, or returns it as a *BinaryResult when path is "". chat
+// may be "" when the message ID is unique. Media WhatsApp no longer serves is requested from
+// the phone again once.
+func (wac *WhatsAppClient) DownloadMedia(ctx context.Context, messageID, chat, path string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return MediaDownloadResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	if wac.messages == nil {
+		return MediaDownloadResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	stored, err := wac.messages.media(ctx, messageID, chat)
+	if err != nil {
+		return MediaDownloadResult{Success: false, Message: err.Error()}, err
+	}
+	media, mimeType, fileName := incomingMedia(stored.message)
+	return wac.fetchMedia(ctx, media, &stored.info, MediaDownloadResult{MessageID: messageID, MimeType: mimeType, FileName: fileName}, path)
+}
+
+// DownloadMediaKeys downloads and decrypts the attachment the keys
+// describe, to path or as a *BinaryResult. Without the message it came with, expired media cannot be
+// requested again.
+func (wac *WhatsAppClient) DownloadMediaKeys(ctx context.Context, keys MediaKeys, path string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return MediaDownloadResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	media, err := mediaFromKeys(keys)
+	if err != nil {
+		return MediaDownloadResult{Success: false, Message: err.Error()}, err
+	}
+	return wac.fetchMedia(ctx, media, nil, MediaDownloadResult{MimeType: keys.MimeType}, path)
+}
+
+// fetchMedia downloads media to path or into memory, asking the phone to
+// upload it again (when info is known) if the media servers lost it
+func (wac *WhatsAppClient) fetchMedia(ctx context.Context, media whatsmeow.DownloadableMessage, info *types.MessageInfo, result MediaDownloadResult, path string) (interface{}, error) {
+	var data []byte
+	download := func() error {
+		if path != "" {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			callCtx, cancel := wac.withCallTimeout(ctx)
+			defer cancel()
+			size, err := wac.downloadToFile(callCtx, media, path, TransferInfo{ID: result.MessageID})
+			result.Path, result.Size = path, size
+			return timeoutError("download media", err)
+		}
+		var err error
+		data, err = callContext(wac, ctx, "download media", func() ([]byte, error) {
+			return wac.Client.Download(media)
+		})
+		return err
+	}
+
+	err := download()
+	if info != nil && mediaExpired(err) {
+		logger.For(ctx).Infof("Media of message %s expired, asking the phone to upload it again", info.ID)
+		if err = wac.requestMediaRetry(ctx, info, media); err == nil {
+			result.Retried = true
+			err = download()
+		}
+	}
+	if err != nil {
+		logger.For(ctx).Errorf("Downloading media %s: %v", result.MessageID, err)
+		return MediaDownloadResult{Success: false, Message: err.Error(), MessageID: result.MessageID}, err
+	}
+	if path == "" {
+		return &BinaryResult{Mimetype: result.MimeType, FileName: result.FileName, Data: data}, nil
+	}
+	result.Success = true
+	result.Message = fmt.Sprintf("Downloaded %d bytes", result.Size)
+	return result, nil
+}
+
+// mediaExpired reports whether a download failed because the media servers
+// no longer have the file
+func mediaExpired(err error) bool {
+	return errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith403) ||
+		errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) ||
+		errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410)
+}
+
+// mediaRetries routes media retry notifications to the downloads waiting
+// for them
+type mediaRetries struct {
+	mu      sync.Mutex
+	waiting map[types.MessageID]chan *events.MediaRetry
+}
+
+// requestMediaRetry asks the phone to upload expired media again and
+// points media at the new location
+func (wac *WhatsAppClient) requestMediaRetry(ctx context.Context, info *types.MessageInfo, media whatsmeow.DownloadableMessage) error {
+	ch := make(chan *events.MediaRetry, 1)
+	retries := &wac.mediaRetries
+	retries.mu.Lock()
+	if retries.waiting == nil {
+		retries.waiting = map[types.MessageID]chan *events.MediaRetry{}
+	}
+	retries.waiting[info.ID] = ch
+	retries.mu.Unlock()
+	defer func() {
+		retries.mu.Lock()
+		delete(retries.waiting, info.ID)
+		retries.mu.Unlock()
+	}()
+
+	if err := wac.Client.SendMediaRetryReceipt(info, media.GetMediaKey()); err != nil {
+		return fmt.Errorf("requesting media retry: %w", err)
+	}
+	var evt *events.MediaRetry
+	select {
+	case evt = <-ch:
+	case <-time.After(mediaRetryTimeout):
+		return fmt.Errorf("the phone did not upload the media again within %v", mediaRetryTimeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	notif, err := whatsmeow.DecryptMediaRetryNotification(evt, media.GetMediaKey())
+	if err != nil {
+		return fmt.Errorf("media retry: %w", err)
+	}
+	if notif.GetResult() != waMmsRetry.MediaRetryNotification_SUCCESS {
+		return fmt.Errorf("media retry failed: %s", notif.GetResult())
+	}
+	setDirectPath(media, notif.GetDirectPath())
+	return nil
+}
+
+// handleMediaRetry hands a media retry notification to its waiting download
+func (wac *WhatsAppClient) handleMediaRetry(evt *events.MediaRetry) {
+	retries := &wac.mediaRetries
+	retries.mu.Lock()
+	ch := retries.waiting[evt.MessageID]
+	retries.mu.Unlock()
+	if ch == nil {
+		logger.Infof("Media retry for %s arrived with no download waiting", evt.MessageID)
+		return
+	}
+	select {
+	case ch <- evt:
+	default:
+	}
+}
@@ -0,0 +1,139 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"mime"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Recipients' phones only render the image and video formats WhatsApp
+// supports, and show the JPEG thumbnail carried in the message until the
+// media is downloaded. Sends detect the format from the file's content, not
+// its name, and make the thumbnail themselves.
+
+const (
+	mediaThumbnailSize = 100 // the longest side of a media thumbnail
+	// ffmpegTimeout bounds one run of ffmpeg, such as extracting the first
+	// frame of a video or transcoding a voice note
+	ffmpegTimeout = 2 * time.Minute
+)
+
+// imageMimeTypes are the image formats WhatsApp shows inline
+var imageMimeTypes = []string{"image/jpeg", "image/png"}
+
+// videoMimeTypes are the video formats WhatsApp plays inline
+var videoMimeTypes = []string{"video/mp4", "video/3gpp"}
+
+// detectMimeType returns the MIME type of data from its content
+func detectMimeType(data []byte) string {
+	// http.DetectContentType only knows MP4 by its brands, so 3GP, what
+	// older phones record, is recognized here
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" && strings.HasPrefix(string(data[8:12]), "3g") {
+		return "video/3gpp"
+	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	return mimeType
+}
+
+// imageMimeType is the MIME type of the image in data, or an error when
+// WhatsApp cannot send it as an image
+func imageMimeType(path string, data []byte) (string, error) {
+	return supportedMimeType(path, data, "an image", imageMimeTypes)
+}
+
+// videoMimeType is the MIME type of the video in data, or an error when
+// WhatsApp cannot send it as a video
+func videoMimeType(path string, data []byte) (string, error) {
+	return supportedMimeType(path, data, "a video", videoMimeTypes)
+}
+
+// supportedMimeType is the MIME type of data when it is one of supported
+func supportedMimeType(path string, data []byte, what string, supported []string) (string, error) {
+	mimeType := detectMimeType(data)
+	for _, s := range supported {
+		if mimeType == s {
+			return mimeType, nil
+		}
+	}
+	return "", fmt.Errorf("%s is %s, which WhatsApp cannot send as %s: use %s, or send it as a document",
+		path, mimeType, what, strings.Join(supported, " or "))
+}
+
+// documentMimeType is the MIME type of a document, from its extension when
+// it has a known one (office files all look like ZIP archives), otherwise
+// from its content
+func documentMimeType(path string, data []byte) string {
+	if byExt := mime.TypeByExtension(filepath.Ext(path)); byExt != "" {
+		mimeType, _, _ := strings.Cut(byExt, ";")
+		return mimeType
+	}
+	return detectMimeType(data)
+}
+
+// imageThumbnail scales the image in data down to a JPEG thumbnail, and
+// returns it with the image's size. The thumbnail is nil when the image
+// cannot be decoded; the image is sent without one.
+func imageThumbnail(data []byte) (thumb []byte, width, height int) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		logger.Debugf("No thumbnail: decoding image: %v", err)
+		return nil, 0, 0
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(img, mediaThumbnailSize), &jpeg.Options{Quality: 75}); err != nil {
+		logger.Debugf("No thumbnail: encoding JPEG: %v", err)
+		return nil, 0, 0
+	}
+	b := img.Bounds()
+	return buf.Bytes(), b.Dx(), b.Dy()
+}
+
+// videoThumbnail makes a JPEG thumbnail of the first frame of the video at
+// path, and returns it with the video's size. It needs ffmpeg on the PATH;
+// without it, or when ffmpeg fails, the thumbnail is nil and the video is
+// sent without one.
+func videoThumbnail(ctx context.Context, path string) (thumb []byte, width, height int) {
+	frame, err := runFFmpeg(ctx, nil, "-i", path, "-frames:v", "1", "-f", "image2pipe", "-vcodec", "mjpeg", "-")
+	if err != nil {
+		logger.For(ctx).Debugf("No thumbnail for %s: %v", path, err)
+		return nil, 0, 0
+	}
+	return imageThumbnail(frame)
+}
+
+// errNoFFmpeg is returned by runFFmpeg when ffmpeg is not on the PATH
+var errNoFFmpeg = errors.New("ffmpeg is not installed")
+
+// runFFmpeg runs ffmpeg with args, feeding it stdin when it is not nil, and
+// returns what it writes to stdout
+func runFFmpeg(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, errNoFFmpeg
+	}
+	ctx, cancel := context.WithTimeout(ctx, ffmpegTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffmpeg, append([]string{"-loglevel", "error"}, args...)...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ffmpeg: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package whatsapp
+
+import (
+	"regexp"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// A mention is an @user token in the text plus the user's JID in the
+// message's ContextInfo; WhatsApp only highlights (and notifies) users that
+// are in both.
+
+// mentionToken matches @ followed by a phone number, as people type it
+var mentionToken = regexp.MustCompile(`@\+?[0-9][0-9 \-]*[0-9]|@\+?[0-9]`)
+
+// parseMentions parses the users to mention, given as JIDs or phone numbers
+func parseMentions(mentions []string) ([]types.JID, error) {
+	jids := make([]types.JID, 0, len(mentions))
+	seen := map[types.JID]bool{}
+	for _, m := range mentions {
+		jid, err := participantJID(m)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[jid] {
+			seen[jid] = true
+			jids = append(jids, jid)
+		}
+	}
+	return jids, nil
+}
+
+// mentionText rewrites the @number tokens of the mentioned users to the
+// @user form WhatsApp expects ("@+1 555-0100" becomes "@15550100"), and
+// appends a token for each mentioned user the text does not name
+func mentionText(text string, mentions []types.JID) string {
+	users := map[string]bool{}
+	for _, jid := range mentions {
+		users[jid.User] = true
+	}
+	named := map[string]bool{}
+	text = mentionToken.ReplaceAllStringFunc(text, func(token string) string {
+		digits := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, token)
+		if !users[digits] {
+			return token
+		}
+		named[digits] = true
+		return "@" + digits
+	})
+	for _, jid := range mentions {
+		if !named[jid.User] && !strings.Contains(text, "@"+jid.User) {
+			text += " @" + jid.User
+		}
+	}
+	return text
+}
+
+// withMentions makes a text message mention the given users, turning a plain
+// conversation message into an extended one, which can carry them
+func withMentions(msg *waProto.Message, mentions []types.JID) *waProto.Message {
+	if len(mentions) == 0 {
+		return msg
+	}
+	if msg.ExtendedTextMessage == nil {
+		msg = &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String(msg.GetConversation())}}
+	}
+	text := msg.ExtendedTextMessage
+	text.Text = proto.String(mentionText(text.GetText(), mentions))
+	if text.ContextInfo == nil {
+		text.ContextInfo = &waProto.ContextInfo{}
+	}
+	for _, jid := range mentions {
+		text.ContextInfo.MentionedJID = append(text.ContextInfo.MentionedJID, jid.String())
+	}
+	return msg
+}
+
+// withMentionsArg appends the mentions of a send to the arguments a dead
+// letter replays, when there are any
+func withMentionsArg(args []string, mentions []string) []string {
+	if len(mentions) == 0 {
+		return args
+	}
+	return append(args, strings.Join(mentions, ","))
+}
+
+// groupDeadLetterArgs lays out the arguments a send-group-message dead
+// letter replays. The link preview flag follows the mentions, so letters
+// written before it existed still replay without one.
+func groupDeadLetterArgs(groupJID, message string, opts TextOptions) []string {
+	args := []string{groupJID, message}
+	if !opts.LinkPreview {
+		return withMentionsArg(args, opts.Mentions)
+	}
+	return append(args, strings.Join(opts.Mentions, ","), "true")
+}
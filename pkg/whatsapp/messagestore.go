@@ -0,0 +1,282 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// messageStore keeps every incoming and outgoing message in the session
+// database. Without limits it grows with the account, so Options can cap it
+// by age (HistoryMaxAge) and by row count (HistoryMaxRows).
+type messageStore struct {
+	db *sql.DB
+}
+
+// PruneResult is returned by PruneHistory
+type PruneResult struct {
+	Success     bool   `json:"success"`
+	Message     string `json:"message,omitempty"`
+	RowsRemoved int64  `json:"rows_removed"`
+	RowsLeft    int64  `json:"rows_left"`
+}
+
+// HistoryQuery pages through a chat's stored messages, newest first. Since
+// is in Unix seconds, 0 for no bound.
+type HistoryQuery struct {
+	Limit  int   `json:"-"` // positional in get-chat-history; 0 uses defaultHistoryLimit
+	Offset int   `json:"offset"`
+	Since  int64 `json:"since"`
+	Fetch  bool  `json:"fetch"` // ask the phone for older messages when the stored ones do not fill the page
+}
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 1000
+)
+
+func newMessageStore(db *sql.DB) (*messageStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS pod_messages (
+		chat_jid     TEXT NOT NULL,
+		id           TEXT NOT NULL,
+		sender       TEXT NOT NULL,
+		is_from_me   BOOLEAN NOT NULL,
+		message_type TEXT NOT NULL,
+		content      TEXT NOT NULL,
+		timestamp    INTEGER NOT NULL,
+		PRIMARY KEY (chat_jid, id)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS pod_messages_timestamp ON pod_messages (timestamp)`); err != nil {
+		return nil, err
+	}
+	// The media part of incoming messages, for download-media; pruned with
+	// the messages it belongs to
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS pod_media (
+		chat_jid   TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		sender     TEXT NOT NULL,
+		is_from_me BOOLEAN NOT NULL,
+		message    BLOB NOT NULL,
+		timestamp  INTEGER NOT NULL,
+		PRIMARY KEY (chat_jid, id)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	// Receipts of the messages we sent, one row per recipient
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS pod_receipts (
+		chat_jid     TEXT NOT NULL,
+		message_id   TEXT NOT NULL,
+		recipient    TEXT NOT NULL,
+		delivered_at INTEGER NOT NULL DEFAULT 0,
+		read_at      INTEGER NOT NULL DEFAULT 0,
+		played_at    INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (chat_jid, message_id, recipient)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	// What history syncs tell about each chat, for get-archived-chats
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS pod_chats (
+		jid                TEXT PRIMARY KEY,
+		name               TEXT NOT NULL DEFAULT '',
+		last_message_at    INTEGER NOT NULL DEFAULT 0,
+		unread_count       INTEGER NOT NULL DEFAULT 0,
+		archived           BOOLEAN NOT NULL DEFAULT 0,
+		pinned             BOOLEAN NOT NULL DEFAULT 0,
+		muted_until        INTEGER NOT NULL DEFAULT 0,
+		disappearing_timer INTEGER NOT NULL DEFAULT 0
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	if err := addColumn(db, "pod_chats", "disappearing_timer", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	return &messageStore{db: db}, nil
+}
+
+// addColumn adds a column to a table an older version of the pod created
+// without it
+func addColumn(db *sql.DB, table, column, definition string) error {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
+
+// save records a message; a redelivered message replaces the stored copy
+func (s *messageStore) save(m *MessageInfo) {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO pod_messages
+		(chat_jid, id, sender, is_from_me, message_type, content, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		m.ChatID, m.ID, m.Sender, m.IsFromMe, m.MessageType, m.Content, m.Timestamp)
+	if err != nil {
+		logger.Warnf("Storing message %s: %v", m.ID, err)
+	}
+}
+
+// history returns the messages of one chat matching q, newest first
+func (s *messageStore) history(ctx context.Context, chat string, q HistoryQuery) ([]MessageHistoryInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, chat_jid, content, sender, is_from_me, message_type, timestamp
+		FROM pod_messages WHERE chat_jid = ? AND timestamp >= ?
+		ORDER BY timestamp DESC, rowid DESC LIMIT ? OFFSET ?`, chat, q.Since, q.Limit, q.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs := []MessageHistoryInfo{}
+	for rows.Next() {
+		var m MessageHistoryInfo
+		if err := rows.Scan(&m.ID, &m.ChatID, &m.Content, &m.Sender, &m.IsFromMe, &m.MessageType, &m.Timestamp); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// oldest returns the earliest stored message of a chat, ok false if none is
+func (s *messageStore) oldest(ctx context.Context, chat string) (m MessageHistoryInfo, ok bool, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT id, is_from_me, timestamp FROM pod_messages
+		WHERE chat_jid = ? ORDER BY timestamp, rowid LIMIT 1`, chat).Scan(&m.ID, &m.IsFromMe, &m.Timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m, false, nil
+	}
+	return m, err == nil, err
+}
+
+// prune deletes messages older than maxAge, then the oldest ones beyond
+// maxRows; 0 disables either limit
+func (s *messageStore) prune(maxAge time.Duration, maxRows int64) (removed, left int64, err error) {
+	if maxAge > 0 {
+		res, err := s.db.Exec(`DELETE FROM pod_messages WHERE timestamp < ?`, time.Now().Add(-maxAge).Unix())
+		if err != nil {
+			return removed, 0, err
+		}
+		n, _ := res.RowsAffected()
+		removed += n
+	}
+	if maxRows > 0 {
+		res, err := s.db.Exec(`DELETE FROM pod_messages WHERE rowid IN (
+			SELECT rowid FROM pod_messages ORDER BY timestamp DESC, rowid DESC LIMIT -1 OFFSET ?)`, maxRows)
+		if err != nil {
+			return removed, 0, err
+		}
+		n, _ := res.RowsAffected()
+		removed += n
+	}
+	if removed > 0 {
+		_, err := s.db.Exec(`DELETE FROM pod_media WHERE NOT EXISTS (
+			SELECT 1 FROM pod_messages m WHERE m.chat_jid = pod_media.chat_jid AND m.id = pod_media.id)`)
+		if err != nil {
+			return removed, 0, err
+		}
+		_, err = s.db.Exec(`DELETE FROM pod_receipts WHERE NOT EXISTS (
+			SELECT 1 FROM pod_messages m WHERE m.chat_jid = pod_receipts.chat_jid AND m.id = pod_receipts.message_id)`)
+		if err != nil {
+			return removed, 0, err
+		}
+	}
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM pod_messages`).Scan(&left)
+	return removed, left, err
+}
+
+// storeMessage records a message when the client has a session database
+func (wac *WhatsAppClient) storeMessage(m *MessageInfo) {
+	if wac.messages != nil {
+		wac.messages.save(m)
+	}
+}
+
+// GetChatHistory returns the stored messages of a chat, newest first.
+// Only messages sent or received while the pod was running are stored, and
+// retention may have pruned older ones. With q.Fetch, a page the stored
+// messages do not fill is completed with older messages from the phone.
+func (wac *WhatsAppClient) GetChatHistory(ctx context.Context, jid string, q HistoryQuery) (interface{}, error) {
+	if wac.messages == nil {
+		return MessageHistoryResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	chat, err := types.ParseJID(jid)
+	if err != nil {
+		return MessageHistoryResult{Success: false, Message: err.Error()}, err
+	}
+	if q.Limit < 0 || q.Offset < 0 || q.Since < 0 {
+		return MessageHistoryResult{Success: false, Message: "Limit, offset and since must not be negative"}, fmt.Errorf("limit, offset and since must not be negative")
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultHistoryLimit
+	}
+	if q.Limit > maxHistoryLimit {
+		q.Limit = maxHistoryLimit
+	}
+
+	msgs, err := wac.messages.history(ctx, chat.String(), q)
+	if err != nil {
+		return MessageHistoryResult{Success: false, Message: err.Error()}, err
+	}
+	fetched := 0
+	if q.Fetch && len(msgs) < q.Limit {
+		// The missing messages are older than every stored one
+		if fetched, err = wac.fetchHistory(ctx, chat, q.Limit-len(msgs), q.Since); err != nil {
+			return MessageHistoryResult{Success: false, Message: err.Error(), Messages: msgs}, err
+		}
+		if fetched > 0 {
+			if msgs, err = wac.messages.history(ctx, chat.String(), q); err != nil {
+				return MessageHistoryResult{Success: false, Message: err.Error()}, err
+			}
+		}
+	}
+	return MessageHistoryResult{
+		Success:  true,
+		Message:  fmt.Sprintf("%d messages", len(msgs)),
+		Messages: msgs,
+		Fetched:  fetched,
+	}, nil
+}
+
+// enforceHistoryRetention applies HistoryMaxAge and HistoryMaxRows
+func (wac *WhatsAppClient) enforceHistoryRetention() {
+	opts := wac.Options()
+	if wac.messages == nil || (opts.HistoryMaxAge <= 0 && opts.HistoryMaxRows <= 0) {
+		return
+	}
+	removed, _, err := wac.messages.prune(opts.HistoryMaxAge, opts.HistoryMaxRows)
+	if err != nil {
+		logger.Warnf("Applying message history retention: %v", err)
+	}
+	if removed > 0 {
+		logger.Infof("Message history retention removed %d messages", removed)
+	}
+}
+
+// PruneHistory deletes stored messages older than maxAge and the oldest
+// beyond maxRows now; 0 disables either limit
+func (wac *WhatsAppClient) PruneHistory(maxAge time.Duration, maxRows int64) (interface{}, error) {
+	if wac.messages == nil {
+		return PruneResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	if maxAge < 0 || maxRows < 0 {
+		return PruneResult{Success: false, Message: "Limits must not be negative"}, fmt.Errorf("limits must not be negative")
+	}
+	removed, left, err := wac.messages.prune(maxAge, maxRows)
+	if err != nil {
+		return PruneResult{Success: false, Message: err.Error(), RowsRemoved: removed}, err
+	}
+	return PruneResult{
+		Success:     true,
+		Message:     fmt.Sprintf("Removed %d messages, %d left", removed, left),
+		RowsRemoved: removed,
+		RowsLeft:    left,
+	}, nil
+}
@@ -0,0 +1,198 @@
+package whatsapp
+
+import (
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+// LocationInfo is the place of a location or live location message
+type LocationInfo struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+	URL       string  `json:"url,omitempty"`
+	Live      bool    `json:"live,omitempty"`
+}
+
+// ContactCard is one contact of a contact-card message
+type ContactCard struct {
+	DisplayName string `json:"display_name"`
+	VCard       string `json:"vcard"`
+}
+
+// PollInfo is the question and options of a poll
+type PollInfo struct {
+	Name            string   `json:"name"`
+	Options         []string `json:"options"`
+	SelectableCount uint32   `json:"selectable_count,omitempty"` // 0 allows any number
+}
+
+// decodeMessage fills the type, content and per-type fields of info from
+// an (unwrapped) message. Content is the text, caption, reaction emoji,
+// poll question or location name, whatever the message type carries.
+func decodeMessage(msg *waProto.Message, info *MessageInfo) {
+	if edit := msg.GetEditedMessage().GetMessage(); edit != nil {
+		// How edits we send are wrapped; incoming ones arrive unwrapped
+		msg = edit
+	}
+	msg, once := unwrapViewOnce(msg)
+	if once {
+		info.ViewOnce = true
+	}
+	info.MessageType = "unknown"
+	switch {
+	case msg.GetConversation() != "":
+		info.MessageType = "text"
+		info.Content = msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		info.MessageType = "text"
+		info.Content = msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		m := msg.GetImageMessage()
+		info.MessageType = "image"
+		info.Content, info.Caption = m.GetCaption(), m.GetCaption()
+		info.Mimetype, info.FileLength = m.GetMimetype(), m.GetFileLength()
+	case msg.GetVideoMessage() != nil:
+		m := msg.GetVideoMessage()
+		info.MessageType = "video"
+		info.Content, info.Caption = m.GetCaption(), m.GetCaption()
+		info.Mimetype, info.FileLength, info.Duration = m.GetMimetype(), m.GetFileLength(), m.GetSeconds()
+		info.GifPlayback = m.GetGifPlayback()
+	case msg.GetAudioMessage() != nil:
+		m := msg.GetAudioMessage()
+		info.MessageType = "audio"
+		info.Mimetype, info.FileLength, info.Duration = m.GetMimetype(), m.GetFileLength(), m.GetSeconds()
+		info.VoiceNote = m.GetPTT()
+	case msg.GetStickerMessage() != nil:
+		m := msg.GetStickerMessage()
+		info.MessageType = "sticker"
+		info.Mimetype, info.FileLength = m.GetMimetype(), m.GetFileLength()
+	case msg.GetDocumentMessage() != nil:
+		m := msg.GetDocumentMessage()
+		info.MessageType = "document"
+		info.Content, info.Caption = m.GetCaption(), m.GetCaption()
+		info.Mimetype, info.FileLength, info.FileName = m.GetMimetype(), m.GetFileLength(), m.GetFileName()
+	case msg.GetLocationMessage() != nil:
+		m := msg.GetLocationMessage()
+		info.MessageType = "location"
+		info.Content = m.GetName()
+		info.Location = &LocationInfo{
+			Latitude:  m.GetDegreesLatitude(),
+			Longitude: m.GetDegreesLongitude(),
+			Name:      m.GetName(),
+			Address:   m.GetAddress(),
+			URL:       m.GetURL(),
+		}
+	case msg.GetLiveLocationMessage() != nil:
+		m := msg.GetLiveLocationMessage()
+		info.MessageType = "location"
+		info.Content, info.Caption = m.GetCaption(), m.GetCaption()
+		info.Location = &LocationInfo{Latitude: m.GetDegreesLatitude(), Longitude: m.GetDegreesLongitude(), Live: true}
+	case msg.GetContactMessage() != nil:
+		m := msg.GetContactMessage()
+		info.MessageType = "contact"
+		info.Content = m.GetDisplayName()
+		info.Contacts = []ContactCard{{DisplayName: m.GetDisplayName(), VCard: m.GetVcard()}}
+	case msg.GetContactsArrayMessage() != nil:
+		m := msg.GetContactsArrayMessage()
+		info.MessageType = "contact"
+		info.Content = m.GetDisplayName()
+		for _, c := range m.GetContacts() {
+			info.Contacts = append(info.Contacts, ContactCard{DisplayName: c.GetDisplayName(), VCard: c.GetVcard()})
+		}
+	case msg.GetReactionMessage() != nil:
+		// An empty emoji removes the sender's earlier reaction
+		m := msg.GetReactionMessage()
+		info.MessageType = "reaction"
+		info.Content = m.GetText()
+		info.TargetID = m.GetKey().GetID()
+	case pollCreation(msg) != nil:
+		m := pollCreation(msg)
+		info.MessageType = "poll"
+		info.Content = m.GetName()
+		info.Poll = &PollInfo{Name: m.GetName(), Options: []string{}, SelectableCount: m.GetSelectableOptionsCount()}
+		for _, o := range m.GetOptions() {
+			info.Poll.Options = append(info.Poll.Options, o.GetOptionName())
+		}
+	case msg.GetPollUpdateMessage() != nil:
+		// The vote itself is encrypted with the poll's key
+		info.MessageType = "poll-vote"
+		info.TargetID = msg.GetPollUpdateMessage().GetPollCreationMessageKey().GetID()
+	case msg.GetButtonsMessage() != nil:
+		info.MessageType = "buttons"
+		info.Content = msg.GetButtonsMessage().GetContentText()
+	case msg.GetListMessage() != nil:
+		info.MessageType = "list"
+		info.Content = msg.GetListMessage().GetDescription()
+	case msg.GetButtonsResponseMessage() != nil:
+		m := msg.GetButtonsResponseMessage()
+		info.MessageType = "button-reply"
+		info.Content, info.SelectedID = m.GetSelectedDisplayText(), m.GetSelectedButtonID()
+	case msg.GetTemplateButtonReplyMessage() != nil:
+		m := msg.GetTemplateButtonReplyMessage()
+		info.MessageType = "button-reply"
+		info.Content, info.SelectedID = m.GetSelectedDisplayText(), m.GetSelectedID()
+	case msg.GetListResponseMessage() != nil:
+		m := msg.GetListResponseMessage()
+		info.MessageType = "list-reply"
+		info.Content, info.SelectedID = m.GetTitle(), m.GetSingleSelectReply().GetSelectedRowID()
+	case msg.GetProtocolMessage() != nil:
+		m := msg.GetProtocolMessage()
+		switch m.GetType() {
+		case waProto.ProtocolMessage_MESSAGE_EDIT:
+			decodeMessage(m.GetEditedMessage(), info)
+			info.MessageType = "edit"
+			info.TargetID = m.GetKey().GetID()
+			return
+		case waProto.ProtocolMessage_REVOKE:
+			info.MessageType = "revoke"
+			info.TargetID = m.GetKey().GetID()
+		}
+	}
+
+	if ctx := contextInfo(msg); ctx != nil {
+		info.QuotedID = ctx.GetStanzaID()
+		info.QuotedSender = ctx.GetParticipant()
+		info.Mentions = ctx.GetMentionedJID()
+	}
+}
+
+// pollCreation returns the poll of any of the poll message versions
+func pollCreation(msg *waProto.Message) *waProto.PollCreationMessage {
+	if m := msg.GetPollCreationMessage(); m != nil {
+		return m
+	}
+	if m := msg.GetPollCreationMessageV2(); m != nil {
+		return m
+	}
+	return msg.GetPollCreationMessageV3()
+}
+
+// contextInfo returns the quote and mention context of whichever message
+// type msg carries
+func contextInfo(msg *waProto.Message) *waProto.ContextInfo {
+	type withContext interface {
+		GetContextInfo() *waProto.ContextInfo
+	}
+	for _, m := range []withContext{
+		msg.GetExtendedTextMessage(),
+		msg.GetImageMessage(),
+		msg.GetVideoMessage(),
+		msg.GetAudioMessage(),
+		msg.GetStickerMessage(),
+		msg.GetDocumentMessage(),
+		msg.GetLocationMessage(),
+		msg.GetLiveLocationMessage(),
+		msg.GetContactMessage(),
+		msg.GetContactsArrayMessage(),
+		pollCreation(msg),
+		msg.GetButtonsResponseMessage(),
+		msg.GetTemplateButtonReplyMessage(),
+		msg.GetListResponseMessage(),
+	} {
+		if ctx := m.GetContextInfo(); ctx != nil {
+			return ctx
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	waCommon "go.mau.fi/whatsmeow/proto/waCommon"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	waWeb "go.mau.fi/whatsmeow/proto/waWeb"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Messenger is everything WhatsAppClient needs from whatsmeow. The live
+// implementation wraps *whatsmeow.Client; Fake implements it in memory so
+// the invoke layer and result marshaling can run without an account.
+type Messenger interface {
+	// Connection and session
+	Connect() error
+	Disconnect()
+	IsLoggedIn() bool
+	Logout() error
+	AddEventHandler(handler whatsmeow.EventHandler) uint32
+	SetProxyAddress(addr string, opts ...whatsmeow.SetProxyOptions) error
+	SetAutoReconnect(enabled bool)
+	DeviceID() *types.JID // nil until the device is paired
+	StoreDevice() StoreDevice
+	PairPhone(phone string, showPushNotification bool, clientType whatsmeow.PairClientType, clientDisplayName string) (string, error)
+
+	// Messages and media
+	GenerateMessageID() types.MessageID
+	BuildMessageKey(chat, sender types.JID, id types.MessageID) *waCommon.MessageKey
+	BuildReaction(chat, sender types.JID, id types.MessageID, reaction string) *waProto.Message
+	BuildRevoke(chat, sender types.JID, id types.MessageID) *waProto.Message
+	BuildEdit(chat types.JID, id types.MessageID, newContent *waProto.Message) *waProto.Message
+	BuildPollCreation(name string, optionNames []string, selectableOptionCount int) *waProto.Message
+	DecryptPollVote(vote *events.Message) (*waProto.PollVoteMessage, error)
+	SendMessage(ctx context.Context, to types.JID, message *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error)
+	Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error)
+	UploadReader(ctx context.Context, plaintext io.Reader, tempFile io.ReadWriteSeeker, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error)
+	Download(msg whatsmeow.DownloadableMessage) ([]byte, error)
+	DownloadToFile(msg whatsmeow.DownloadableMessage, file whatsmeow.File) error
+	SendMediaRetryReceipt(message *types.MessageInfo, mediaKey []byte) error
+	MarkRead(ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error
+	BuildHistorySyncRequest(lastKnownMessageInfo *types.MessageInfo, count int) *waProto.Message
+	ParseWebMessage(chatJID types.JID, webMsg *waWeb.WebMessageInfo) (*events.Message, error)
+	SetDisappearingTimer(chat types.JID, timer time.Duration) error
+
+	// Groups
+	GetJoinedGroups() ([]*types.GroupInfo, error)
+	GetGroupInfo(jid types.JID) (*types.GroupInfo, error)
+	GetGroupInfoFromLink(code string) (*types.GroupInfo, error)
+	CreateGroup(req whatsmeow.ReqCreateGroup) (*types.GroupInfo, error)
+	LeaveGroup(jid types.JID) error
+	GetGroupInviteLink(jid types.JID, reset bool) (string, error)
+	JoinGroupWithLink(code string) (types.JID, error)
+	SetGroupName(jid types.JID, name string) error
+	SetGroupTopic(jid types.JID, previousID, newID, topic string) error
+	SetGroupPhoto(jid types.JID, avatar []byte) (string, error)
+	SetGroupAnnounce(jid types.JID, announce bool) error
+	SetGroupLocked(jid types.JID, locked bool) error
+	UpdateGroupParticipants(jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantChange) ([]types.GroupParticipant, error)
+	GetGroupRequestParticipants(jid types.JID) ([]types.GroupParticipantRequest, error)
+	UpdateGroupRequestParticipants(jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantRequestChange) ([]types.GroupParticipant, error)
+	GetSubGroups(community types.JID) ([]*types.GroupLinkTarget, error)
+	LinkGroup(parent, child types.JID) error
+
+	// Contacts, status and presence
+	GetContact(jid types.JID) (types.ContactInfo, error)
+	GetAllContacts() (map[types.JID]types.ContactInfo, error)
+	IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse, error)
+	IdentityKey(jid types.JID) ([32]byte, error) // the Signal identity key of one device, ErrNoIdentity until a session exists
+	GetProfilePictureInfo(jid types.JID, params *whatsmeow.GetProfilePictureParams) (*types.ProfilePictureInfo, error)
+	SetStatusMessage(msg string) error
+	SendPresence(state types.Presence) error
+	SendChatPresence(jid types.JID, state types.ChatPresence, media types.ChatPresenceMedia) error
+	SubscribePresence(jid types.JID) error
+
+	// App state (chat settings synced between devices)
+	SendAppState(patch appstate.PatchInfo) error
+	FetchAppState(name appstate.WAPatchName, fullSync, onlyIfNotSynced bool) error
+	AppStateVersion(name appstate.WAPatchName) (uint64, error)
+}
+
+// StoreDevice is what whatsmeow's store records about the linked device
+type StoreDevice struct {
+	ID             *types.JID // nil until the device is paired
+	LID            types.JID
+	PushName       string
+	BusinessName   string
+	Platform       string // of the phone the device is linked to, e.g. android or smbi
+	RegistrationID uint32
+}
+
+// whatsmeowMessenger adapts *whatsmeow.Client to Messenger, turning the
+// store fields the client reads into methods
+type whatsmeowMessenger struct {
+	*whatsmeow.Client
+	db *sql.DB // the session database, for store data whatsmeow has no getter for
+}
+
+func (m whatsmeowMessenger) SetAutoReconnect(enabled bool) {
+	m.EnableAutoReconnect = enabled
+}
+
+func (m whatsmeowMessenger) DeviceID() *types.JID {
+	return m.Store.ID
+}
+
+func (m whatsmeowMessenger) StoreDevice() StoreDevice {
+	return StoreDevice{
+		ID:             m.Store.ID,
+		LID:            m.Store.LID,
+		PushName:       m.Store.PushName,
+		BusinessName:   m.Store.BusinessName,
+		Platform:       m.Store.Platform,
+		RegistrationID: m.Store.RegistrationID,
+	}
+}
+
+func (m whatsmeowMessenger) GetContact(jid types.JID) (types.ContactInfo, error) {
+	return m.Store.Contacts.GetContact(jid)
+}
+
+func (m whatsmeowMessenger) GetAllContacts() (map[types.JID]types.ContactInfo, error) {
+	return m.Store.Contacts.GetAllContacts()
+}
+
+func (m whatsmeowMessenger) AppStateVersion(name appstate.WAPatchName) (uint64, error) {
+	version, _, err := m.Store.AppState.GetAppStateVersion(string(name))
+	return version, err
+}
+
+// IdentityKey reads whatsmeow's identity table directly, since its
+// IdentityStore can only check a key, not return one
+func (m whatsmeowMessenger) IdentityKey(jid types.JID) ([32]byte, error) {
+	var key [32]byte
+	if m.Store.ID == nil {
+		return key, fmt.Errorf("not paired")
+	}
+	if jid == *m.Store.ID {
+		return *m.Store.IdentityKey.Pub, nil
+	}
+	var identity []byte
+	err := m.db.QueryRow(`SELECT identity FROM whatsmeow_identity_keys WHERE our_jid = ? AND their_id = ?`,
+		m.Store.ID.String(), jid.SignalAddress().String()).Scan(&identity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrNoIdentity
+	} else if err != nil {
+		return key, err
+	} else if len(identity) != len(key) {
+		return key, fmt.Errorf("identity key of %s has %d bytes", jid, len(identity))
+	}
+	copy(key[:], identity)
+	return key, nil
+}
@@ -0,0 +1,43 @@
+package whatsapp
+
+import "github.com/kbosompem/bb-whatsapp-pod/pkg/metrics"
+
+// Client metrics, exported on the pod's /metrics endpoint when it is enabled
+var (
+	metricMessagesSent     = metrics.NewCounter("whatsapp_messages_sent_total", "Messages sent successfully.")
+	metricSendErrors       = metrics.NewCounter("whatsapp_send_errors_total", "Sends that returned an error.")
+	metricMessagesReceived = metrics.NewCounter("whatsapp_messages_received_total", "Messages received from WhatsApp.")
+	metricSendLatency      = metrics.NewHistogram("whatsapp_send_duration_seconds", "Time spent in whatsmeow SendMessage.", metrics.DefBuckets)
+	metricReconnects       = metrics.NewCounter("whatsapp_reconnects_total", "Connections established after the first one.")
+	metricUploadBytes      = metrics.NewCounter("whatsapp_media_upload_bytes_total", "Bytes of media uploaded.")
+	metricUploadErrors     = metrics.NewCounter("whatsapp_media_upload_errors_total", "Media uploads that returned an error.")
+	metricUploadCacheHits  = metrics.NewCounter("whatsapp_upload_cache_hits_total", "Media uploads answered from the upload cache.")
+	metricThrottled        = metrics.NewCounter("whatsapp_throttled_total", "Sends and uploads WhatsApp answered with a rate limit.")
+	metricDeadLetters      = metrics.NewCounter("whatsapp_dead_letters_total", "Sends and uploads kept as dead letters after failing for good.")
+	metricAckResends       = metrics.NewCounter("whatsapp_ack_resends_total", "Messages resent because the server ack did not arrive in time.")
+	metricInboxDropped     = metrics.NewCounter("whatsapp_inbox_dropped_total", "Incoming messages dropped from the full poll-messages queue.")
+	metricPanics           = metrics.NewCounter("whatsapp_recovered_panics_total", "Panics recovered in background goroutines and event handlers.")
+)
+
+// Totals are the client counters since the process started
+type Totals struct {
+	MessagesSent     uint64 `json:"messages_sent"`
+	SendErrors       uint64 `json:"send_errors"`
+	MessagesReceived uint64 `json:"messages_received"`
+	Reconnects       uint64 `json:"reconnects"`
+	UploadBytes      uint64 `json:"upload_bytes"`
+	UploadErrors     uint64 `json:"upload_errors"`
+}
+
+// CurrentTotals reads the client counters. They are process-wide, so they
+// can be read before any client exists.
+func CurrentTotals() Totals {
+	return Totals{
+		MessagesSent:     metricMessagesSent.Value(),
+		SendErrors:       metricSendErrors.Value(),
+		MessagesReceived: metricMessagesReceived.Value(),
+		Reconnects:       metricReconnects.Value(),
+		UploadBytes:      metricUploadBytes.Value(),
+		UploadErrors:     metricUploadErrors.Value(),
+	}
+}
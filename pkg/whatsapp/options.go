@@ -0,0 +1,240 @@
+package whatsapp
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Options are the runtime settings of a WhatsAppClient. They can be changed
+// after the client is created with SetOptions.
+type Options struct {
+	LoginTimeout      time.Duration // how long Login waits for a QR code or pairing event
+	AutoReconnect     bool          // reconnect after the connection drops, a stream is replaced or a ban expires
+	ReconnectBackoff  time.Duration // first wait before reconnecting, doubled per failed attempt
+	SendInterval      time.Duration // minimum gap between two outgoing messages (0 disables)
+	Proxy             string        // http(s):// or socks5:// proxy for the WhatsApp connection, "" for none
+	DryRun            bool          // sends and uploads are logged and answered with synthetic IDs, never sent
+	UploadCacheTTL    time.Duration // how long an upload is reused for identical content (0 disables the cache)
+	AutoUpdateVersion bool          // switch to the latest WhatsApp web version when the server rejects ours
+	ThrottleRetries   int           // retries of a send or upload WhatsApp rate limits (0 fails at once)
+	ThrottleBackoff   time.Duration // first wait after a rate limit, doubled per retry
+	UploadConcurrency int           // media uploads allowed to run at once
+	MaxUploadSize     int64         // largest file, in bytes, a send or upload accepts (0 is unlimited)
+	CallTimeout       time.Duration // bound on each WhatsApp request without a deadline of its own (0 disables)
+	GroupConcurrency  int           // group info requests GetGroupDetails runs at once
+	AckTimeout        time.Duration // how long a send waits for the server ack before resending (0 uses whatsmeow's 75s)
+	AckRetries        int           // resends of a message whose ack did not arrive (0 fails at once)
+	MessageQueueSize  int           // incoming messages kept for PollMessages, the oldest dropped beyond it (0 disables)
+
+	// The linked device is registered with these when it is paired, so they
+	// only affect the next pairing. DeviceName is shown under Linked Devices
+	// on the phone ("" keeps whatsmeow's); HistorySync asks the phone for the
+	// full chat history instead of only the recent messages.
+	DeviceName  string
+	HistorySync bool
+
+	// The session database is opened with these pragmas, so they only take
+	// effect when the client is created. A busy timeout lets a write wait for
+	// another connection's lock instead of failing with "database is locked".
+	DBJournalMode string        // wal, delete, truncate, persist, memory or off ("" keeps SQLite's default)
+	DBBusyTimeout time.Duration // 0 fails at once on a locked database
+	DBSynchronous string        // off, normal, full or extra ("" keeps SQLite's default)
+
+	// Incoming media is saved under DownloadDir ("" disables auto-download),
+	// in one subdirectory per chat with DownloadPerChat. Files older than
+	// DownloadMaxAge are deleted, then the oldest while the directory holds
+	// more than DownloadMaxBytes; 0 disables either limit.
+	DownloadDir      string
+	DownloadPerChat  bool
+	DownloadMaxAge   time.Duration
+	DownloadMaxBytes int64
+
+	// Stored message history older than HistoryMaxAge, and the oldest
+	// messages beyond HistoryMaxRows, are pruned; 0 keeps everything
+	HistoryMaxAge  time.Duration
+	HistoryMaxRows int64
+}
+
+// DefaultOptions returns the settings used when nothing is configured
+func DefaultOptions() Options {
+	return Options{
+		LoginTimeout:      65 * time.Second,
+		AutoReconnect:     true,
+		ReconnectBackoff:  2 * time.Second,
+		UploadCacheTTL:    7 * 24 * time.Hour,
+		AutoUpdateVersion: true,
+		ThrottleRetries:   4,
+		ThrottleBackoff:   2 * time.Second,
+		UploadConcurrency: 4,
+		MaxUploadSize:     2 << 30, // WhatsApp's limit for documents
+		CallTimeout:       2 * time.Minute,
+		GroupConcurrency:  8,
+		AckTimeout:        20 * time.Second,
+		AckRetries:        2,
+		MessageQueueSize:  1000,
+		DBJournalMode:     "wal",
+		DBBusyTimeout:     5 * time.Second,
+		DBSynchronous:     "normal",
+	}
+}
+
+// SetOptions applies new runtime settings to the client
+func (wac *WhatsAppClient) SetOptions(opts Options) {
+	wac.optionsMutex.Lock()
+	proxyChanged := opts.Proxy != wac.options.Proxy
+	wac.options = opts
+	wac.optionsMutex.Unlock()
+
+	wac.uploadPool.setLimit(opts.UploadConcurrency)
+	wac.Client.SetAutoReconnect(false) // reconnect.go does it, with backoff
+	applyDeviceProps(opts)
+	if proxyChanged {
+		// Takes effect on the next connect
+		if err := wac.Client.SetProxyAddress(opts.Proxy); err != nil {
+			logger.Errorf("Invalid proxy %q: %v", RedactURL(opts.Proxy), err)
+		}
+	}
+	logged := opts
+	logged.Proxy = RedactURL(opts.Proxy)
+	logger.Infof("Options applied: %+v", logged)
+}
+
+// RedactURL hides the password of each URL in a comma-separated list, as
+// proxy and NATS URLs may carry user:pass@ credentials
+func RedactURL(s string) string {
+	urls := strings.Split(s, ",")
+	for i, raw := range urls {
+		if u, err := url.Parse(strings.TrimSpace(raw)); err == nil && u.User != nil {
+			urls[i] = u.Redacted()
+		}
+	}
+	return strings.Join(urls, ",")
+}
+
+// applyDeviceProps sets what whatsmeow sends when pairing a new device.
+// whatsmeow keeps these in package globals, read at pairing time.
+func applyDeviceProps(opts Options) {
+	if opts.DeviceName != "" && opts.DeviceName != store.DeviceProps.GetOs() {
+		v := store.DeviceProps.GetVersion()
+		store.SetOSInfo(opts.DeviceName, [3]uint32{v.GetPrimary(), v.GetSecondary(), v.GetTertiary()})
+	}
+	store.DeviceProps.RequireFullSync = proto.Bool(opts.HistorySync)
+}
+
+// Options returns the client's current runtime settings
+func (wac *WhatsAppClient) Options() Options {
+	wac.optionsMutex.Lock()
+	defer wac.optionsMutex.Unlock()
+	return wac.options
+}
+
+// sendMessage is the single path every outgoing message takes. It waits for
+// the message's turn in its chat and enforces the configured send interval
+// before handing the message to whatsmeow, and registers the send so Drain
+// can wait for it.
+func (wac *WhatsAppClient) sendMessage(ctx context.Context, to types.JID, msg *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	done, err := wac.beginWork()
+	if err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+	defer done()
+
+	turn := wac.turnFor(ctx, to)
+	defer turn.release()
+	if err := turn.wait(ctx); err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+
+	interval := wac.Options().SendInterval
+	if interval > 0 {
+		wac.sendMutex.Lock()
+		wait := time.Until(wac.lastSend.Add(interval))
+		if wait > 0 {
+			logger.For(ctx).Infof("Rate limit: waiting %v before sending to %s", wait, to)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				wac.sendMutex.Unlock()
+				return whatsmeow.SendResponse{}, ctx.Err()
+			}
+		}
+		wac.lastSend = time.Now()
+		wac.sendMutex.Unlock()
+	}
+	if wac.Options().DryRun {
+		return dryRunSend(ctx, to, msg), nil
+	}
+	resp, err := wac.sendAcked(ctx, to, msg, extra...)
+	err = timeoutError("send to "+to.String(), err)
+	if err != nil {
+		metricSendErrors.Inc()
+	} else {
+		metricMessagesSent.Inc()
+		own := wac.session.JID().ToNonAD()
+		sent := &MessageInfo{
+			ID:        resp.ID,
+			ChatID:    to.String(),
+			Sender:    own.String(),
+			IsFromMe:  true,
+			Timestamp: resp.Timestamp.Unix(),
+		}
+		decodeMessage(msg, sent)
+		wac.storeMessage(sent)
+		if wac.messages != nil {
+			// so media sent by the pod can be downloaded and forwarded, too
+			wac.messages.saveMedia(&events.Message{Info: types.MessageInfo{
+				MessageSource: types.MessageSource{Chat: to, Sender: own, IsFromMe: true},
+				ID:            resp.ID,
+				Timestamp:     resp.Timestamp,
+			}, Message: msg})
+		}
+	}
+	return resp, err
+}
+
+// upload is the single path every media upload takes, so uploads are
+// tracked alongside sends while the client drains and share the upload pool
+func (wac *WhatsAppClient) upload(ctx context.Context, data []byte, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	done, err := wac.beginWork()
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	defer done()
+
+	sum := sha256.Sum256(data)
+	if wac.Options().DryRun {
+		return dryRunUpload(ctx, sum[:], int64(len(data))), nil
+	}
+	return wac.cachedUpload(ctx, func() (whatsmeow.UploadResponse, error) {
+		release, err := wac.uploadPool.acquire(ctx)
+		if err != nil {
+			return whatsmeow.UploadResponse{}, err
+		}
+		defer release()
+		var resp whatsmeow.UploadResponse
+		err = wac.withThrottleRetry(ctx, "media upload", func() error {
+			callCtx, cancel := wac.withCallTimeout(ctx)
+			defer cancel()
+			var err error
+			resp, err = wac.Client.Upload(callCtx, data, mediaType)
+			return err
+		})
+		err = timeoutError("media upload", err)
+		if err != nil {
+			metricUploadErrors.Inc()
+		} else {
+			metricUploadBytes.Add(uint64(len(data)))
+		}
+		return resp, err
+	}, sum[:], int64(len(data)), mediaType)
+}
@@ -0,0 +1,55 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// pairClientDisplay is how the device asks to be shown while pairing by
+// code; WhatsApp only accepts "Browser (OS)" with common names
+const pairClientDisplay = "Chrome (Linux)"
+
+// PairResult is returned by PairPhone
+type PairResult struct {
+	Status  string `json:"status"` // code-pending, or logged-in when the session was already paired
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// PairPhone connects like Login, then asks WhatsApp for an
+// 8-character code to enter on the phone under Linked Devices > Link with
+// phone number. The code is valid while the login would show QR codes,
+// about 160 seconds; the session is logged in once it has been entered.
+func (wac *WhatsAppClient) PairPhone(ctx context.Context, phone string) (interface{}, error) {
+	digits, err := NormalizePhone(phone)
+	if err != nil {
+		return PairResult{Status: "login-failed", Message: err.Error()}, err
+	}
+
+	// Pairing needs the login websocket, which is up once the first QR
+	// code arrives
+	res, err := wac.login(ctx)
+	if err != nil {
+		return res, err
+	}
+	login := res.(LoginResult)
+	if login.Status == "logged-in" {
+		return PairResult{Status: "logged-in", Message: "Already logged in"}, nil
+	}
+
+	code, err := callContext(wac, ctx, "pair phone", func() (string, error) {
+		return wac.Client.PairPhone(digits, true, whatsmeow.PairClientChrome, pairClientDisplay)
+	})
+	if err != nil {
+		logger.For(ctx).Errorf("Requesting a pairing code for %s: %v", digits, err)
+		return PairResult{Status: "login-failed", Message: err.Error()}, fmt.Errorf("pair phone: %w", err)
+	}
+	logger.For(ctx).Infof("Pairing code issued for %s", digits)
+	return PairResult{
+		Status:  "code-pending",
+		Code:    code,
+		Message: "Enter the code on the phone under Linked Devices > Link with phone number",
+	}, nil
+}
@@ -0,0 +1,305 @@
+package whatsapp
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// maxPollOptions is the most options WhatsApp shows in a poll
+const maxPollOptions = 12
+
+// PollOptionResult is the tally of one poll option
+type PollOptionResult struct {
+	Name   string   `json:"name"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters"`
+}
+
+// PollResultsResult is returned by GetPollResults
+type PollResultsResult struct {
+	Success bool               `json:"success"`
+	Message string             `json:"message,omitempty"`
+	PollID  string             `json:"poll_id,omitempty"`
+	ChatID  string             `json:"chat_id,omitempty"`
+	Name    string             `json:"name,omitempty"`
+	Options []PollOptionResult `json:"options"`
+	Voters  int                `json:"voters"` // who currently has at least one option selected
+}
+
+// PollVoteInfo is the data of a poll-vote event
+type PollVoteInfo struct {
+	PollID    string   `json:"poll_id"`
+	ChatID    string   `json:"chat_id"`
+	Voter     string   `json:"voter"`
+	Options   []string `json:"options"` // empty when the vote was withdrawn
+	Timestamp int64    `json:"timestamp"`
+}
+
+// pollStore keeps the polls seen in chats and each voter's latest vote.
+// Votes only carry hashes of the option names, so they are tallied against
+// the stored poll.
+type pollStore struct {
+	db *sql.DB
+}
+
+func newPollStore(db *sql.DB) (*pollStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS pod_polls (
+		chat_jid   TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		name       TEXT NOT NULL,
+		options    TEXT NOT NULL,
+		selectable INTEGER NOT NULL,
+		timestamp  INTEGER NOT NULL,
+		PRIMARY KEY (chat_jid, id)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS pod_poll_votes (
+		chat_jid  TEXT NOT NULL,
+		poll_id   TEXT NOT NULL,
+		voter     TEXT NOT NULL,
+		options   TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		PRIMARY KEY (chat_jid, poll_id, voter)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &pollStore{db: db}, nil
+}
+
+// savePoll records a poll so its votes can be tallied
+func (s *pollStore) savePoll(chat, id string, poll *waProto.PollCreationMessage, ts time.Time) {
+	names := make([]string, 0, len(poll.GetOptions()))
+	for _, o := range poll.GetOptions() {
+		names = append(names, o.GetOptionName())
+	}
+	options, _ := json.Marshal(names)
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO pod_polls (chat_jid, id, name, options, selectable, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?)`, chat, id, poll.GetName(), string(options), poll.GetSelectableOptionsCount(), ts.Unix())
+	if err != nil {
+		logger.Warnf("Storing poll %s: %v", id, err)
+	}
+}
+
+// saveVote replaces a voter's earlier vote unless it is newer
+func (s *pollStore) saveVote(chat, pollID, voter string, hashes [][]byte, ts time.Time) {
+	hexes := make([]string, len(hashes))
+	for i, h := range hashes {
+		hexes[i] = hex.EncodeToString(h)
+	}
+	options, _ := json.Marshal(hexes)
+	_, err := s.db.Exec(`INSERT INTO pod_poll_votes (chat_jid, poll_id, voter, options, timestamp) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (chat_jid, poll_id, voter) DO UPDATE SET options = excluded.options, timestamp = excluded.timestamp
+		WHERE excluded.timestamp >= timestamp`, chat, pollID, voter, string(options), ts.UnixMilli())
+	if err != nil {
+		logger.Warnf("Storing vote on poll %s: %v", pollID, err)
+	}
+}
+
+// poll returns a stored poll's chat, question and option names. The chat is
+// looked up when it is "".
+func (s *pollStore) poll(ctx context.Context, id, chat string) (string, string, []string, error) {
+	var name, options string
+	err := s.db.QueryRowContext(ctx, `SELECT chat_jid, name, options FROM pod_polls
+		WHERE id = ? AND (? = '' OR chat_jid = ?) ORDER BY timestamp DESC LIMIT 1`, id, chat, chat).
+		Scan(&chat, &name, &options)
+	if err != nil {
+		return "", "", nil, err
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(options), &names); err != nil {
+		return "", "", nil, fmt.Errorf("stored poll %s: %w", id, err)
+	}
+	return chat, name, names, nil
+}
+
+// votes returns each voter's latest selection as option hashes in hex
+func (s *pollStore) votes(ctx context.Context, chat, pollID string) (map[string][]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT voter, options FROM pod_poll_votes
+		WHERE chat_jid = ? AND poll_id = ? ORDER BY voter`, chat, pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	votes := map[string][]string{}
+	for rows.Next() {
+		var voter, options string
+		if err := rows.Scan(&voter, &options); err != nil {
+			return nil, err
+		}
+		var hexes []string
+		if err := json.Unmarshal([]byte(options), &hexes); err != nil {
+			return nil, err
+		}
+		votes[voter] = hexes
+	}
+	return votes, rows.Err()
+}
+
+// optionHash is how votes name a poll option
+func optionHash(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreatePoll sends a poll with 2 to 12 distinct options. selectable
+// is how many options a voter may pick, 0 for any number.
+func (wac *WhatsAppClient) CreatePoll(ctx context.Context, chatJID, question string, options []string, selectable int) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+
+	chat, err := ParseRecipientJID(chatJID)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	if err := validatePoll(question, options, selectable); err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+
+	msg := wac.Client.BuildPollCreation(question, options, selectable)
+	resp, err := wac.sendMessage(ctx, chat, msg)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), err
+	}
+	if wac.polls != nil {
+		wac.polls.savePoll(chat.String(), resp.ID, msg.GetPollCreationMessage(), resp.Timestamp)
+	}
+
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: fmt.Sprintf("Poll sent to %s", chat),
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// validatePoll checks a poll before it is sent, since WhatsApp accepts
+// polls its apps cannot show
+func validatePoll(question string, options []string, selectable int) error {
+	if question == "" {
+		return fmt.Errorf("the poll question is required")
+	}
+	if len(options) < 2 || len(options) > maxPollOptions {
+		return fmt.Errorf("a poll needs 2 to %d options, got %d", maxPollOptions, len(options))
+	}
+	seen := map[string]bool{}
+	for _, o := range options {
+		if o == "" {
+			return fmt.Errorf("poll options must not be empty")
+		}
+		if seen[o] {
+			return fmt.Errorf("duplicate poll option %q", o)
+		}
+		seen[o] = true
+	}
+	if selectable < 0 || selectable > len(options) {
+		return fmt.Errorf("selectable must be between 0 (any number) and %d, got %d", len(options), selectable)
+	}
+	return nil
+}
+
+// recordPoll stores an incoming poll, or decrypts and stores a vote
+func (wac *WhatsAppClient) recordPoll(msg *events.Message) {
+	if wac.polls == nil {
+		return
+	}
+	chat := msg.Info.Chat.String()
+	if poll := pollCreation(msg.Message); poll != nil {
+		wac.polls.savePoll(chat, msg.Info.ID, poll, msg.Info.Timestamp)
+		return
+	}
+	update := msg.Message.GetPollUpdateMessage()
+	if update == nil {
+		return
+	}
+	vote, err := wac.Client.DecryptPollVote(msg)
+	if err != nil {
+		// Votes on polls sent before this device was linked cannot be read
+		logger.Warnf("Decrypting poll vote %s: %v", msg.Info.ID, err)
+		return
+	}
+	pollID := update.GetPollCreationMessageKey().GetID()
+	voter := msg.Info.Sender.ToNonAD().String()
+	wac.polls.saveVote(chat, pollID, voter, vote.GetSelectedOptions(), msg.Info.Timestamp)
+
+	info := PollVoteInfo{PollID: pollID, ChatID: chat, Voter: voter, Options: []string{}, Timestamp: msg.Info.Timestamp.Unix()}
+	if _, _, names, err := wac.polls.poll(context.Background(), pollID, chat); err == nil {
+		byHash := map[string]string{}
+		for _, n := range names {
+			byHash[optionHash(n)] = n
+		}
+		for _, h := range vote.GetSelectedOptions() {
+			if n, ok := byHash[hex.EncodeToString(h)]; ok {
+				info.Options = append(info.Options, n)
+			}
+		}
+	}
+	wac.publish("poll-vote", info)
+}
+
+// GetPollResults tallies the latest vote of every voter on a poll. chat
+// may be "" when the poll ID is unique. Only polls and votes seen while the
+// pod was running are known.
+func (wac *WhatsAppClient) GetPollResults(ctx context.Context, pollID, chat string) (interface{}, error) {
+	if wac.polls == nil {
+		return PollResultsResult{Success: false, Message: "No session database", Options: []PollOptionResult{}}, fmt.Errorf("no session database")
+	}
+	if chat != "" {
+		jid, err := types.ParseJID(chat)
+		if err != nil {
+			return PollResultsResult{Success: false, Message: err.Error(), Options: []PollOptionResult{}}, err
+		}
+		chat = jid.ToNonAD().String()
+	}
+
+	chat, name, names, err := wac.polls.poll(ctx, pollID, chat)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("no stored poll %s", pollID)
+	}
+	if err != nil {
+		return PollResultsResult{Success: false, Message: err.Error(), Options: []PollOptionResult{}}, err
+	}
+	votes, err := wac.polls.votes(ctx, chat, pollID)
+	if err != nil {
+		return PollResultsResult{Success: false, Message: err.Error(), Options: []PollOptionResult{}}, err
+	}
+
+	result := PollResultsResult{Success: true, PollID: pollID, ChatID: chat, Name: name, Options: make([]PollOptionResult, len(names))}
+	index := map[string]int{}
+	for i, n := range names {
+		result.Options[i] = PollOptionResult{Name: n, Voters: []string{}}
+		index[optionHash(n)] = i
+	}
+	for voter, hashes := range votes {
+		counted := false
+		for _, h := range hashes {
+			if i, ok := index[h]; ok {
+				result.Options[i].Votes++
+				result.Options[i].Voters = append(result.Options[i].Voters, voter)
+				counted = true
+			}
+		}
+		if counted {
+			result.Voters++
+		}
+	}
+	for _, o := range result.Options {
+		sort.Strings(o.Voters)
+	}
+	return result, nil
+}
@@ -0,0 +1,113 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ChatPresenceResult is returned by SendChatPresence
+type ChatPresenceResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	ChatID  string `json:"chat_id,omitempty"`
+	State   string `json:"state,omitempty"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+}
+
+// chatPresenceStates maps the states send-chat-presence accepts to what
+// WhatsApp sends: recording is composing with audio media
+var chatPresenceStates = map[string]struct {
+	state types.ChatPresence
+	media types.ChatPresenceMedia
+}{
+	"composing": {types.ChatPresenceComposing, types.ChatPresenceMediaText},
+	"recording": {types.ChatPresenceComposing, types.ChatPresenceMediaAudio},
+	"paused":    {types.ChatPresencePaused, types.ChatPresenceMediaText},
+}
+
+// SendChatPresence shows "typing…" (composing), "recording audio…"
+// (recording) or nothing (paused) to the other members of a chat. Phones
+// clear the indicator by themselves after about 25 seconds, so a bot that
+// takes longer should send composing again.
+func (wac *WhatsAppClient) SendChatPresence(ctx context.Context, chatJID, state string) (interface{}, error) {
+	s, ok := chatPresenceStates[state]
+	if !ok {
+		err := fmt.Errorf("invalid chat presence %q: use composing, recording or paused", state)
+		return ChatPresenceResult{Success: false, Message: err.Error()}, err
+	}
+	if err := wac.sendReady(); err != nil {
+		return ChatPresenceResult{Success: false, Message: "Not logged in"}, err
+	}
+	chat, err := ParseRecipientJID(chatJID)
+	if err != nil {
+		return ChatPresenceResult{Success: false, Message: err.Error()}, err
+	}
+
+	result := ChatPresenceResult{Success: true, ChatID: chat.String(), State: state, DryRun: wac.Options().DryRun}
+	if result.DryRun {
+		logger.For(ctx).Infof("DRY RUN: would send chat presence %s to %s", state, chat)
+		result.Message = fmt.Sprintf("Would show %s in %s", state, chat)
+		return result, nil
+	}
+	err = callContextErr(wac, ctx, "sending chat presence", func() error {
+		return wac.Client.SendChatPresence(chat, s.state, s.media)
+	})
+	if err != nil {
+		return ChatPresenceResult{Success: false, Message: err.Error()}, err
+	}
+	result.Message = fmt.Sprintf("Showing %s in %s", state, chat)
+	return result, nil
+}
+
+// presenceCache keeps the latest presence of each contact, as received in
+// presence events. Contacts only send them once subscribed to, and only
+// while the pod is online.
+type presenceCache struct {
+	mu    sync.Mutex
+	byJID map[types.JID]PresenceInfo
+}
+
+// update records a presence event. A contact coming online keeps the last
+// seen time it went offline with.
+func (c *presenceCache) update(jid types.JID, online bool, lastSeen time.Time) {
+	jid = jid.ToNonAD()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byJID == nil {
+		c.byJID = make(map[types.JID]PresenceInfo)
+	}
+	info := c.byJID[jid]
+	info.JID, info.IsOnline, info.UpdatedAt = jid.String(), online, time.Now().Unix()
+	if !lastSeen.IsZero() {
+		info.LastSeen = lastSeen.Unix()
+	}
+	c.byJID[jid] = info
+}
+
+// get returns the latest presence of a contact, if any was received
+func (c *presenceCache) get(jid types.JID) (PresenceInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, ok := c.byJID[jid.ToNonAD()]
+	return info, ok
+}
+
+// GetPresence returns the latest presence received from a contact: whether
+// it is online and, if it shares it, when it was last seen. Call
+// SubscribePresence first; until a presence event arrives the result holds
+// no presence.
+func (wac *WhatsAppClient) GetPresence(jid string) (interface{}, error) {
+	contactJID, err := types.ParseJID(jid)
+	if err != nil {
+		return PresenceResult{Success: false, Message: err.Error()}, err
+	}
+	info, ok := wac.presence.get(contactJID)
+	if !ok {
+		return PresenceResult{Success: true, Message: fmt.Sprintf("No presence received from %s; subscribe to it first", contactJID)}, nil
+	}
+	return PresenceResult{Success: true, Presence: &info}, nil
+}
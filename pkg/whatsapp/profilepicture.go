@@ -0,0 +1,85 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// profilePictureMaxSize bounds a profile picture download; WhatsApp serves
+// them at 640x640
+const profilePictureMaxSize = 5 << 20
+
+// ProfilePictureResult is returned by SetProfilePicture and by
+// DownloadProfilePicture for a download to a file
+type ProfilePictureResult struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message,omitempty"`
+	JID       string `json:"jid,omitempty"`
+	PictureID string `json:"picture_id,omitempty"` // empty when the picture was removed
+	Path      string `json:"path,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+}
+
+// DownloadProfilePicture downloads the profile picture of a user or
+// group to path, or returns it as a *BinaryResult when path is "". It
+// fails when the picture is not set or hidden by the owner's privacy
+// settings.
+func (wac *WhatsAppClient) DownloadProfilePicture(ctx context.Context, jid, path string) (interface{}, error) {
+	if !wac.Client.IsLoggedIn() {
+		return ProfilePictureResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	target, err := types.ParseJID(jid)
+	if err != nil {
+		return ProfilePictureResult{Success: false, Message: err.Error()}, err
+	}
+
+	pic, err := callContext(wac, ctx, "fetching profile picture", func() (*types.ProfilePictureInfo, error) {
+		return wac.Client.GetProfilePictureInfo(target, &whatsmeow.GetProfilePictureParams{})
+	})
+	if err != nil {
+		return ProfilePictureResult{Success: false, Message: err.Error()}, err
+	}
+	if pic == nil {
+		err := fmt.Errorf("%s has no profile picture", target)
+		return ProfilePictureResult{Success: false, Message: err.Error()}, err
+	}
+
+	transport, err := wac.proxyTransport()
+	if err != nil {
+		return ProfilePictureResult{Success: false, Message: err.Error()}, err
+	}
+	callCtx, cancel := wac.withCallTimeout(ctx)
+	defer cancel()
+	data, mimetype, _, err := fetchLimited(callCtx, &http.Client{Transport: transport}, pic.URL, profilePictureMaxSize)
+	if err != nil {
+		err = timeoutError("downloading profile picture", err)
+		return ProfilePictureResult{Success: false, Message: err.Error()}, err
+	}
+	if mimetype == "" {
+		mimetype = http.DetectContentType(data)
+	}
+	if path == "" {
+		return &BinaryResult{Mimetype: mimetype, FileName: pic.ID + ".jpg", Data: data}, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return ProfilePictureResult{Success: false, Message: err.Error()}, err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return ProfilePictureResult{Success: false, Message: err.Error()}, err
+	}
+	return ProfilePictureResult{
+		Success:   true,
+		Message:   fmt.Sprintf("Downloaded %d bytes", len(data)),
+		JID:       target.String(),
+		PictureID: pic.ID,
+		Path:      path,
+		Size:      int64(len(data)),
+	}, nil
+}
@@ -0,0 +1,105 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QR formats a login can render its QR code in, besides the raw string
+const (
+	QRFormatRaw      = "raw"
+	QRFormatTerminal = "terminal"   // Unicode half blocks, for dark terminals
+	QRFormatPNG      = "png-base64" // a base64-encoded PNG
+)
+
+// qrPNGSize is the edge of a rendered PNG in pixels
+const qrPNGSize = 256
+
+// LoginOptions change what Login returns
+type LoginOptions struct {
+	QRFormat string `json:"qr-format"` // raw (default), terminal or png-base64
+}
+
+// Login connects the client and waits for the first QR code or login
+// event. While a QR code is pending it is also rendered as opts asks.
+func (wac *WhatsAppClient) Login(ctx context.Context, opts LoginOptions) (interface{}, error) {
+	switch opts.QRFormat {
+	case "", QRFormatRaw, QRFormatTerminal, QRFormatPNG:
+	default:
+		err := fmt.Errorf("unknown QR format %q, use %q, %q or %q", opts.QRFormat, QRFormatRaw, QRFormatTerminal, QRFormatPNG)
+		return LoginResult{Status: "login-failed", Message: err.Error()}, err
+	}
+
+	res, err := wac.login(ctx)
+	if err != nil {
+		return res, err
+	}
+	result := res.(LoginResult)
+	if result.QrCode == "" {
+		return result, nil
+	}
+	switch opts.QRFormat {
+	case QRFormatTerminal:
+		result.QrTerminal, err = renderQRTerminal(result.QrCode)
+	case QRFormatPNG:
+		result.QrPNG, err = renderQRPNG(result.QrCode)
+	}
+	if err != nil {
+		// The raw code still works, so rendering failures are not fatal
+		result.Message = fmt.Sprintf("%s (rendering the QR code failed: %v)", result.Message, err)
+	}
+	return result, nil
+}
+
+// QRResult is returned by EncodeQR
+type QRResult struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	QrTerminal string `json:"qr_terminal,omitempty"`
+	QrPNG      string `json:"qr_png_base64,omitempty"`
+}
+
+// EncodeQR renders any string as a QR code, in the terminal (default) or
+// png-base64 format, e.g. a QR code a login returned earlier in raw form
+func EncodeQR(code, format string) (interface{}, error) {
+	if code == "" {
+		return QRResult{Success: false, Message: "The code is required"}, fmt.Errorf("code is required")
+	}
+	var result QRResult
+	var err error
+	switch format {
+	case "", QRFormatTerminal:
+		result.QrTerminal, err = renderQRTerminal(code)
+	case QRFormatPNG:
+		result.QrPNG, err = renderQRPNG(code)
+	default:
+		err = fmt.Errorf("unknown QR format %q, use %q or %q", format, QRFormatTerminal, QRFormatPNG)
+	}
+	if err != nil {
+		return QRResult{Success: false, Message: err.Error()}, err
+	}
+	result.Success = true
+	return result, nil
+}
+
+// renderQRTerminal draws the code with two modules per character row.
+// Light modules are printed, so it reads correctly on a dark background.
+func renderQRTerminal(code string) (string, error) {
+	qr, err := qrcode.New(code, qrcode.Low)
+	if err != nil {
+		return "", err
+	}
+	return qr.ToSmallString(false), nil
+}
+
+// renderQRPNG encodes the code as a base64 PNG
+func renderQRPNG(code string) (string, error) {
+	png, err := qrcode.Encode(code, qrcode.Medium, qrPNGSize)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(png), nil
+}
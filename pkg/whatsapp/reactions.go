@@ -0,0 +1,73 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// SendReaction reacts to a message of a chat with an emoji; an
+// empty emoji removes our reaction. sender is who sent the message, and may
+// be "" for a stored message.
+func (wac *WhatsAppClient) SendReaction(ctx context.Context, chatJID, messageID, emoji, sender string) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+
+	chat, err := ParseRecipientJID(chatJID)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	senderJID, err := wac.messageSender(ctx, chat, messageID, sender)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+
+	msg := wac.Client.BuildReaction(chat, senderJID, messageID, emoji)
+	resp, err := wac.sendMessage(ctx, chat, msg)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}),
+			wac.deadLetter(ctx, err, "send-reaction", chatJID, messageID, emoji, senderJID.String())
+	}
+
+	message := fmt.Sprintf("Reacted %s to %s", emoji, messageID)
+	if emoji == "" {
+		message = "Removed the reaction to " + messageID
+	}
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: message,
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// messageSender parses the sender of a message the caller named, or looks
+// it up in the message store when sender is ""
+func (wac *WhatsAppClient) messageSender(ctx context.Context, chat types.JID, messageID, sender string) (types.JID, error) {
+	if messageID == "" {
+		return types.JID{}, fmt.Errorf("message ID is required")
+	}
+	if sender == "" {
+		if wac.messages == nil {
+			return types.JID{}, fmt.Errorf("the message sender is required without a session database")
+		}
+		var err error
+		sender, _, err = wac.messages.senderAndContent(ctx, chat.String(), messageID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return types.JID{}, fmt.Errorf("no stored message %s in %s; pass its sender", messageID, chat)
+		}
+		if err != nil {
+			return types.JID{}, err
+		}
+	}
+	jid, err := types.ParseJID(sender)
+	if err != nil {
+		return types.JID{}, fmt.Errorf("invalid sender %q: %w", sender, err)
+	}
+	return jid, nil
+}
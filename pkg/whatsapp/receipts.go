@@ -0,0 +1,144 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// RecipientReceipt is how far one recipient got with a sent message. The
+// times are Unix seconds, 0 until that receipt arrived.
+type RecipientReceipt struct {
+	JID         string `json:"jid"`
+	DeliveredAt int64  `json:"delivered_at,omitempty"`
+	ReadAt      int64  `json:"read_at,omitempty"`
+	PlayedAt    int64  `json:"played_at,omitempty"` // voice notes and videos
+}
+
+// MessageReceiptsResult is returned by GetMessageReceipts
+type MessageReceiptsResult struct {
+	Success    bool               `json:"success"`
+	Message    string             `json:"message,omitempty"`
+	MessageID  string             `json:"message_id,omitempty"`
+	ChatID     string             `json:"chat_id,omitempty"`
+	Status     string             `json:"status,omitempty"` // sent, delivered, read or played, as far as every recipient got
+	Recipients []RecipientReceipt `json:"recipients"`
+}
+
+// receiptColumns are the pod_receipts columns of the receipt types that are
+// tracked; the others (retries, our own devices' receipts) are not
+var receiptColumns = map[types.ReceiptType]string{
+	types.ReceiptTypeDelivered: "delivered_at",
+	types.ReceiptTypeRead:      "read_at",
+	types.ReceiptTypePlayed:    "played_at",
+}
+
+// saveReceipt records a receipt for messages we sent. Each recipient keeps
+// the time of its first receipt of each type; device receipts of the same
+// user count as one.
+func (s *messageStore) saveReceipt(v *events.Receipt) {
+	column, ok := receiptColumns[v.Type]
+	if !ok || v.IsFromMe {
+		return
+	}
+	recipient := v.Sender.ToNonAD().String()
+	for _, id := range v.MessageIDs {
+		_, err := s.db.Exec(`INSERT INTO pod_receipts (chat_jid, message_id, recipient, `+column+`)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (chat_jid, message_id, recipient) DO UPDATE SET `+column+` = excluded.`+column+`
+			WHERE `+column+` = 0`,
+			v.Chat.String(), id, recipient, v.Timestamp.Unix())
+		if err != nil {
+			logger.Warnf("Storing %s receipt for %s: %v", column, id, err)
+		}
+	}
+}
+
+// receipts returns the recipients of a message with their receipts. The
+// chat is looked up when it is "".
+func (s *messageStore) receipts(ctx context.Context, id, chat string) (string, []RecipientReceipt, error) {
+	if chat == "" {
+		err := s.db.QueryRowContext(ctx, `SELECT chat_jid FROM pod_receipts WHERE message_id = ?
+			UNION SELECT chat_jid FROM pod_messages WHERE id = ? AND is_from_me LIMIT 1`, id, id).Scan(&chat)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, fmt.Errorf("no receipts or sent message %s", id)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT recipient, delivered_at, read_at, played_at FROM pod_receipts
+		WHERE chat_jid = ? AND message_id = ? ORDER BY recipient`, chat, id)
+	if err != nil {
+		return chat, nil, err
+	}
+	defer rows.Close()
+	recipients := []RecipientReceipt{}
+	for rows.Next() {
+		var r RecipientReceipt
+		if err := rows.Scan(&r.JID, &r.DeliveredAt, &r.ReadAt, &r.PlayedAt); err != nil {
+			return chat, nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return chat, recipients, rows.Err()
+}
+
+// receiptStatus is how far every recipient got. A read or played message
+// was also delivered, even when that receipt was skipped.
+func receiptStatus(recipients []RecipientReceipt) string {
+	if len(recipients) == 0 {
+		return "sent"
+	}
+	status := "played"
+	for _, r := range recipients {
+		switch {
+		case r.PlayedAt > 0:
+		case r.ReadAt > 0:
+			if status == "played" {
+				status = "read"
+			}
+		case r.DeliveredAt > 0:
+			status = "delivered"
+		default:
+			return "sent"
+		}
+	}
+	return status
+}
+
+// GetMessageReceipts returns the delivery and read receipts of a sent
+// message, per recipient. chat may be "" when the message ID is unique.
+// Only receipts that arrived while the pod was running are known; in a
+// group, the status is only as far as the recipients heard from so far.
+func (wac *WhatsAppClient) GetMessageReceipts(ctx context.Context, messageID, chat string) (interface{}, error) {
+	if wac.messages == nil {
+		return MessageReceiptsResult{Success: false, Message: "No session database"}, fmt.Errorf("no session database")
+	}
+	if messageID == "" {
+		return MessageReceiptsResult{Success: false, Message: "The message ID is required"}, fmt.Errorf("message ID is required")
+	}
+	if chat != "" {
+		jid, err := types.ParseJID(chat)
+		if err != nil {
+			return MessageReceiptsResult{Success: false, Message: err.Error()}, err
+		}
+		chat = jid.ToNonAD().String()
+	}
+
+	chat, recipients, err := wac.messages.receipts(ctx, messageID, chat)
+	if err != nil {
+		return MessageReceiptsResult{Success: false, Message: err.Error(), Recipients: []RecipientReceipt{}}, err
+	}
+	return MessageReceiptsResult{
+		Success:    true,
+		MessageID:  messageID,
+		ChatID:     chat,
+		Status:     receiptStatus(recipients),
+		Recipients: recipients,
+	}, nil
+}
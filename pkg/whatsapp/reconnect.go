@@ -0,0 +1,159 @@
+package whatsapp
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// maxReconnectBackoff caps the wait between two reconnect attempts
+const maxReconnectBackoff = 5 * time.Minute
+
+// ReconnectInfo reports an automatic reconnect in progress, in status
+type ReconnectInfo struct {
+	State         string `json:"state"`                     // waiting or reconnecting
+	Reason        string `json:"reason"`                    // disconnected, stream-replaced, temporary-ban, keepalive-timeout or connect-failure
+	Attempts      int    `json:"attempts"`                  // attempts made since the connection dropped
+	NextAttemptAt int64  `json:"next_attempt_at,omitempty"` // while waiting
+	LastError     string `json:"last_error,omitempty"`
+}
+
+// reconnectState replaces whatsmeow's own auto-reconnect, which retries at
+// a fixed, linearly growing pace and gives up on replaced streams and bans.
+// It is idle while the connection is up, or when it was dropped on purpose.
+type reconnectState struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	gen      int    // bumped on every schedule and cancel, so a stale timer does nothing
+	state    string // "", waiting or reconnecting
+	reason   string
+	attempts int
+	nextAt   time.Time
+	lastErr  string
+}
+
+// reconnectBackoff returns the wait before reconnect attempt n (0-based):
+// exponential from base, capped, and jittered so that many pods dropped by
+// the same outage do not all come back at once
+func reconnectBackoff(base time.Duration, n int) time.Duration {
+	d := base << min(n, 16)
+	if d <= 0 || d > maxReconnectBackoff {
+		d = maxReconnectBackoff
+	}
+	return d/2 + randomDuration(d/2)
+}
+
+// scheduleReconnect plans a reconnect after wait, or after the backoff of
+// the next attempt when wait is 0. A later plan replaces a pending one.
+func (wac *WhatsAppClient) scheduleReconnect(reason string, wait time.Duration) {
+	opts := wac.Options()
+	if !opts.AutoReconnect || !wac.HasSession() || !wac.reconnectable() {
+		return
+	}
+	rs := &wac.reconnect
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if wait <= 0 {
+		wait = reconnectBackoff(opts.ReconnectBackoff, rs.attempts)
+	}
+	if rs.timer != nil {
+		rs.timer.Stop()
+	}
+	rs.gen++
+	gen := rs.gen
+	rs.state, rs.reason, rs.nextAt = "waiting", reason, time.Now().Add(wait)
+	rs.timer = time.AfterFunc(wait, func() { wac.reconnectNow(gen) })
+	logger.Infof("Reconnecting in %v (%s, attempt %d)", wait.Round(time.Millisecond), reason, rs.attempts+1)
+}
+
+// reconnectNow runs a scheduled attempt. A failed connect schedules the
+// next one; a connect that gets through is finished by the Connected event,
+// or by the disconnect or connect failure that follows it.
+func (wac *WhatsAppClient) reconnectNow(gen int) {
+	defer LogPanic(logger, "the reconnect loop")
+	rs := &wac.reconnect
+	rs.mu.Lock()
+	if gen != rs.gen {
+		rs.mu.Unlock()
+		return
+	}
+	rs.state = "reconnecting"
+	rs.timer = nil
+	rs.attempts++
+	rs.mu.Unlock()
+
+	if !wac.Options().AutoReconnect || !wac.reconnectable() {
+		wac.cancelReconnect()
+		return
+	}
+	err := wac.Client.Connect()
+	if err == nil || errors.Is(err, whatsmeow.ErrAlreadyConnected) {
+		return
+	}
+	logger.Warnf("Reconnect failed: %v", err)
+	wac.noteConnError("reconnect: %v", err)
+	rs.mu.Lock()
+	if gen != rs.gen {
+		rs.mu.Unlock()
+		return
+	}
+	rs.lastErr = err.Error()
+	reason := rs.reason
+	rs.mu.Unlock()
+	wac.scheduleReconnect(reason, 0)
+}
+
+// cancelReconnect drops a pending reconnect. The attempts are kept for
+// status until the connection is up again.
+func (wac *WhatsAppClient) cancelReconnect() {
+	rs := &wac.reconnect
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.timer != nil {
+		rs.timer.Stop()
+		rs.timer = nil
+	}
+	rs.gen++
+	rs.state, rs.nextAt = "", time.Time{}
+}
+
+// reconnected resets the manager once the connection is up
+func (wac *WhatsAppClient) reconnected() {
+	wac.cancelReconnect()
+	rs := &wac.reconnect
+	rs.mu.Lock()
+	rs.attempts, rs.reason, rs.lastErr = 0, "", ""
+	rs.mu.Unlock()
+}
+
+// reconnectable reports whether reconnecting could help: not after a
+// logout, a client the server rejected as outdated, or a shutdown
+func (wac *WhatsAppClient) reconnectable() bool {
+	switch wac.session.loginStatus() {
+	case "logged-out", "upgrade-required":
+		return false
+	}
+	select {
+	case <-wac.stopRetention: // closed by Disconnect
+		return false
+	default:
+	}
+	return true
+}
+
+// reconnectInfo reports the manager's state for status, nil while idle
+func (wac *WhatsAppClient) reconnectInfo() *ReconnectInfo {
+	rs := &wac.reconnect
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.state == "" {
+		return nil
+	}
+	info := &ReconnectInfo{State: rs.state, Reason: rs.reason, Attempts: rs.attempts, LastError: rs.lastErr}
+	if rs.state == "waiting" {
+		info.NextAttemptAt = rs.nextAt.Unix()
+	}
+	return info
+}
@@ -0,0 +1,103 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// QuotedMessage is the message a reply quotes. Sender and Content may be
+// left empty for a stored message; they are then looked up by ID.
+type QuotedMessage struct {
+	ID      string `json:"id"`
+	Sender  string `json:"sender"`
+	Content string `json:"content"`
+}
+
+// ReplyMessage sends text to a chat with the quoted message attached,
+// so WhatsApp shows it as a reply
+func (wac *WhatsAppClient) ReplyMessage(ctx context.Context, chatJID string, quoted QuotedMessage, text string) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+
+	chat, err := ParseRecipientJID(chatJID)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	if quoted.ID == "" {
+		return SendResult{Success: false, Message: "The quoted message ID is required"}, fmt.Errorf("quoted message ID is required")
+	}
+	if err := wac.completeQuoted(ctx, chat, &quoted); err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	sender, err := types.ParseJID(quoted.Sender)
+	if err != nil {
+		err = fmt.Errorf("invalid quoted sender %q: %w", quoted.Sender, err)
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+
+	msg := &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text: &text,
+			ContextInfo: &waProto.ContextInfo{
+				StanzaID:      &quoted.ID,
+				Participant:   proto.String(sender.ToNonAD().String()),
+				QuotedMessage: &waProto.Message{Conversation: &quoted.Content},
+			},
+		},
+	}
+
+	ts := time.Now()
+	resp, err := wac.sendMessage(ctx, chat, msg)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}),
+			wac.deadLetter(ctx, err, "reply-message", chatJID, quoted.ID, quoted.Sender, quoted.Content, text)
+	}
+
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: fmt.Sprintf("Reply sent to %s (server timestamp: %v)", chat, ts),
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// completeQuoted fills the sender and content of a quoted message the
+// caller left out from the message store
+func (wac *WhatsAppClient) completeQuoted(ctx context.Context, chat types.JID, quoted *QuotedMessage) error {
+	if quoted.Sender != "" && quoted.Content != "" {
+		return nil
+	}
+	if wac.messages == nil {
+		return fmt.Errorf("quoted sender and content are required without a session database")
+	}
+	sender, content, err := wac.messages.senderAndContent(ctx, chat.String(), quoted.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no stored message %s in %s; pass the quoted sender and content", quoted.ID, chat)
+	}
+	if err != nil {
+		return err
+	}
+	if quoted.Sender == "" {
+		quoted.Sender = sender
+	}
+	if quoted.Content == "" {
+		quoted.Content = content
+	}
+	return nil
+}
+
+// senderAndContent returns who sent a stored message and its content
+func (s *messageStore) senderAndContent(ctx context.Context, chat, id string) (sender, content string, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT sender, content FROM pod_messages WHERE chat_jid = ? AND id = ?`, chat, id).
+		Scan(&sender, &content)
+	return sender, content, err
+}
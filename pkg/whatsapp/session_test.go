@@ -0,0 +1,49 @@
+package whatsapp
+
+import (
+	"sync"
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// TestSessionStateRace delivers login events while invokes read the session,
+// for go test -race to check
+func TestSessionStateRace(t *testing.T) {
+	jid := types.NewJID("15550001111", types.DefaultUserServer)
+	fake := NewFake(jid)
+	wac := NewClientWithMessenger(fake, Options{})
+
+	evts := []interface{}{
+		&events.Connected{},
+		&events.Disconnected{},
+		&events.QR{Codes: []string{"code"}},
+		&events.PairSuccess{ID: jid},
+		&events.StreamReplaced{},
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			fake.Dispatch(evts[i%len(evts)])
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			wac.Connection()
+			if _, err := wac.Status(); err != nil {
+				t.Errorf("Status: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	fake.Dispatch(&events.PairSuccess{ID: jid})
+	if got := wac.Connection(); got.Status != "logged-in" || got.JID != jid.String() {
+		t.Errorf("after PairSuccess: status %q, JID %q; want logged-in, %s", got.Status, got.JID, jid)
+	}
+}
@@ -0,0 +1,44 @@
+package whatsapp
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrShuttingDown is returned for work submitted after Drain has started
+var ErrShuttingDown = errors.New("pod is shutting down")
+
+// beginWork registers an in-flight send or upload. The returned func must be
+// called when the operation finishes.
+func (wac *WhatsAppClient) beginWork() (func(), error) {
+	wac.drainMutex.Lock()
+	defer wac.drainMutex.Unlock()
+	if wac.draining {
+		return nil, ErrShuttingDown
+	}
+	wac.inFlight.Add(1)
+	return wac.inFlight.Done, nil
+}
+
+// Drain stops accepting new sends and uploads and waits up to grace for the
+// ones already running to finish. It reports whether everything completed.
+func (wac *WhatsAppClient) Drain(grace time.Duration) bool {
+	wac.drainMutex.Lock()
+	wac.draining = true
+	wac.drainMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wac.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logger.Infof("Drain complete, no operations in flight.")
+		return true
+	case <-time.After(grace):
+		logger.Warnf("Drain grace period of %v expired with operations still in flight.", grace)
+		return false
+	}
+}
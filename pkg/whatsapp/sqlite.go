@@ -0,0 +1,51 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// sqliteDSN returns the connection string of the session database. The
+// pragmas are run on every pooled connection as it is opened, busy_timeout
+// first so switching the journal mode waits out other connections' locks.
+func sqliteDSN(path string, opts Options) string {
+	pragmas := []string{"foreign_keys(ON)"}
+	if opts.DBBusyTimeout > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("busy_timeout(%d)", opts.DBBusyTimeout.Milliseconds()))
+	}
+	if opts.DBJournalMode != "" {
+		pragmas = append(pragmas, fmt.Sprintf("journal_mode(%s)", strings.ToUpper(opts.DBJournalMode)))
+	}
+	if opts.DBSynchronous != "" {
+		pragmas = append(pragmas, fmt.Sprintf("synchronous(%s)", strings.ToUpper(opts.DBSynchronous)))
+	}
+	return fileURI(path, url.Values{"_pragma": pragmas})
+}
+
+// fileURI returns the sqlite URI of the database at path, escaping
+// characters such as ? and # that would otherwise end the file name
+func fileURI(path string, query url.Values) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path), RawQuery: query.Encode()}
+	return u.String()
+}
+
+// checkJournalMode warns when SQLite kept a different journal mode than the
+// one asked for, e.g. WAL on a file system without shared memory support
+func checkJournalMode(db *sql.DB, want string) {
+	if want == "" {
+		return
+	}
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		logger.Warnf("Reading the database journal mode: %v", err)
+		return
+	}
+	if !strings.EqualFold(mode, want) {
+		logger.Warnf("Database journal mode is %s, not %s", mode, want)
+		return
+	}
+	logger.Infof("Database journal mode: %s", mode)
+}
@@ -0,0 +1,157 @@
+package whatsapp
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// Media sent from a file is never read into memory whole: whatsmeow
+// encrypts it as a stream into a temporary file and uploads from there, so
+// a video of several gigabytes costs no more memory than a photo. The upload
+// of the encrypted copy is tracked as a transfer and reported in
+// transfer-progress events, like downloads.
+
+// checkUploadSize returns the size of the file at path, or an error when it
+// is larger than Options.MaxUploadSize
+func (wac *WhatsAppClient) checkUploadSize(path string) (int64, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if limit := wac.Options().MaxUploadSize; limit > 0 && stat.Size() > limit {
+		return 0, fmt.Errorf("%s is %d bytes, over the upload limit of %d bytes (max-upload-size-mb)", path, stat.Size(), limit)
+	}
+	return stat.Size(), nil
+}
+
+// readFileHeader returns the first 512 bytes of a file, all that is needed
+// to detect its type
+func readFileHeader(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// hashFile returns the SHA-256 of a file, read as a stream
+func hashFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// uploadFile is upload for a file on disk, streamed instead of read into
+// memory. chat is the chat the media is sent to, "" for a bare upload; it is
+// reported with the transfer.
+func (wac *WhatsAppClient) uploadFile(ctx context.Context, path string, mediaType whatsmeow.MediaType, chat string) (whatsmeow.UploadResponse, error) {
+	size, err := wac.checkUploadSize(path)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	done, err := wac.beginWork()
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	defer done()
+
+	// The hash is only needed to look the file up in the cache
+	var sum []byte
+	if wac.Options().DryRun || wac.cachingUploads() {
+		if sum, err = hashFile(path); err != nil {
+			return whatsmeow.UploadResponse{}, err
+		}
+	}
+	if wac.Options().DryRun {
+		return dryRunUpload(ctx, sum, size), nil
+	}
+	return wac.cachedUpload(ctx, func() (whatsmeow.UploadResponse, error) {
+		release, err := wac.uploadPool.acquire(ctx)
+		if err != nil {
+			return whatsmeow.UploadResponse{}, err
+		}
+		defer release()
+		t := wac.startTransfer(TransferInfo{
+			ID:     "upload-" + string(wac.Client.GenerateMessageID()),
+			Kind:   "upload",
+			ChatID: chat,
+			Path:   path,
+			Total:  size,
+		})
+		var resp whatsmeow.UploadResponse
+		err = wac.withThrottleRetry(ctx, "media upload", func() error {
+			callCtx, cancel := wac.withCallTimeout(ctx)
+			defer cancel()
+			var err error
+			resp, err = wac.streamUpload(callCtx, path, mediaType, t)
+			return err
+		})
+		err = timeoutError("media upload", err)
+		t.finish(size, err)
+		if err != nil {
+			metricUploadErrors.Inc()
+		} else {
+			metricUploadBytes.Add(uint64(size))
+		}
+		return resp, err
+	}, sum, size, mediaType)
+}
+
+// streamUpload makes one attempt at uploading the file at path
+func (wac *WhatsAppClient) streamUpload(ctx context.Context, path string, mediaType whatsmeow.MediaType, t *transfer) (whatsmeow.UploadResponse, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	defer file.Close()
+	temp, err := os.CreateTemp("", "bb-whatsapp-upload-*")
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+	return wac.Client.UploadReader(ctx, file, &uploadTempFile{File: temp, t: t}, mediaType)
+}
+
+// uploadTempFile is the temporary file whatsmeow encrypts the media into
+// and then uploads from. It counts what is read back for progress
+// reporting: the reads are the upload.
+type uploadTempFile struct {
+	*os.File
+	t   *transfer
+	pos int64
+}
+
+func (f *uploadTempFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.pos += int64(n)
+	f.t.progress(f.pos)
+	return n, err
+}
+
+func (f *uploadTempFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+	return pos, err
+}
@@ -0,0 +1,161 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// maxThrottleBackoff caps the wait between two throttled attempts
+const maxThrottleBackoff = time.Minute
+
+// ThrottleInfo reports how WhatsApp has been rate limiting the client
+type ThrottleInfo struct {
+	Active       bool   `json:"active"`                   // sends are paused until the backoff ends
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"` // remaining backoff while active
+	Count        int    `json:"count"`                    // throttled responses since the client started
+	LastError    string `json:"last_error,omitempty"`
+	LastAt       int64  `json:"last_at,omitempty"`
+}
+
+// throttleState is shared by all sends and uploads: once WhatsApp throttles
+// one of them, the others wait out the same backoff instead of piling on
+type throttleState struct {
+	mu      sync.Mutex
+	until   time.Time
+	count   int
+	lastErr string
+	lastAt  time.Time
+}
+
+// RetryStats counts the repeated attempts of one operation
+type RetryStats struct {
+	Retries    int
+	Throttled  bool
+	AckRetries int // resends after the server ack did not arrive
+}
+
+// sendResult reports the retries in a send result
+func (s *RetryStats) sendResult(r SendResult) SendResult {
+	r.Retries = s.Retries
+	r.Throttled = s.Throttled
+	r.AckRetries = s.AckRetries
+	return r
+}
+
+type retryStatsKey struct{}
+
+// withRetryStats returns a context that collects the retries of the sends
+// and uploads made with it
+func withRetryStats(ctx context.Context) (context.Context, *RetryStats) {
+	stats := &RetryStats{}
+	return context.WithValue(ctx, retryStatsKey{}, stats), stats
+}
+
+func retryStatsFrom(ctx context.Context) *RetryStats {
+	if stats, ok := ctx.Value(retryStatsKey{}).(*RetryStats); ok {
+		return stats
+	}
+	return &RetryStats{}
+}
+
+// isThrottled recognizes WhatsApp's rate limit answers: 429 rate-overlimit
+// and 419 resource-limit from info queries, error 429 in a message ack, and
+// 429 or 503 from the media servers
+func isThrottled(err error) bool {
+	if errors.Is(err, whatsmeow.ErrIQRateOverLimit) || errors.Is(err, whatsmeow.ErrIQResourceLimit) {
+		return true
+	}
+	msg := err.Error()
+	if errors.Is(err, whatsmeow.ErrServerReturnedError) {
+		return strings.HasSuffix(msg, " 429")
+	}
+	return strings.Contains(msg, "status code 429") || strings.Contains(msg, "status code 503")
+}
+
+// throttleBackoff returns the wait before retry attempt n (0-based):
+// exponential from base, capped, with jitter so clients don't retry in step
+func throttleBackoff(base time.Duration, n int) time.Duration {
+	d := base << n
+	if d <= 0 || d > maxThrottleBackoff {
+		d = maxThrottleBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// withThrottleRetry runs fn, retrying it with backoff while WhatsApp
+// reports it as throttled, up to Options.ThrottleRetries times
+func (wac *WhatsAppClient) withThrottleRetry(ctx context.Context, what string, fn func() error) error {
+	opts := wac.Options()
+	stats := retryStatsFrom(ctx)
+	for attempt := 0; ; attempt++ {
+		if err := wac.waitThrottle(ctx); err != nil {
+			return err
+		}
+		err := fn()
+		if err == nil || !isThrottled(err) {
+			return err
+		}
+		stats.Throttled = true
+		metricThrottled.Inc()
+		delay := throttleBackoff(opts.ThrottleBackoff, attempt)
+		wac.noteThrottle(err, delay)
+		if attempt >= opts.ThrottleRetries {
+			logger.For(ctx).Errorf("%s still throttled after %d retries: %v", what, attempt, err)
+			return err
+		}
+		stats.Retries++
+		logger.For(ctx).Warnf("%s throttled by WhatsApp (%v), retrying in %v", what, err, delay.Round(time.Millisecond))
+	}
+}
+
+// noteThrottle records a throttled response and extends the shared backoff
+func (wac *WhatsAppClient) noteThrottle(err error, delay time.Duration) {
+	t := &wac.throttle
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count++
+	t.lastErr = err.Error()
+	t.lastAt = time.Now()
+	if until := t.lastAt.Add(delay); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// waitThrottle blocks until the shared backoff is over or ctx is done
+func (wac *WhatsAppClient) waitThrottle(ctx context.Context) error {
+	wac.throttle.mu.Lock()
+	wait := time.Until(wac.throttle.until)
+	wac.throttle.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttleInfo reports the throttle state, or nil if WhatsApp never
+// throttled this client
+func (wac *WhatsAppClient) throttleInfo() *ThrottleInfo {
+	t := &wac.throttle
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.count == 0 {
+		return nil
+	}
+	info := &ThrottleInfo{Count: t.count, LastError: t.lastErr, LastAt: t.lastAt.Unix()}
+	if wait := time.Until(t.until); wait > 0 {
+		info.Active = true
+		info.RetryAfterMs = wait.Milliseconds()
+	}
+	return info
+}
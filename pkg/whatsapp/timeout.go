@@ -0,0 +1,68 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrTimeout is returned when a WhatsApp call runs past its deadline, either
+// Options.CallTimeout or a deadline already set on the caller's context
+var ErrTimeout = errors.New("timed out")
+
+// withCallTimeout bounds one whatsmeow call by Options.CallTimeout, unless
+// the caller already set a deadline of its own
+func (wac *WhatsAppClient) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := wac.Options().CallTimeout
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeoutError reports a deadline expiry as ErrTimeout, naming the call
+func timeoutError(what string, err error) error {
+	if err != nil && errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrTimeout) {
+		return fmt.Errorf("%s %w", what, ErrTimeout)
+	}
+	return err
+}
+
+// callContext runs a whatsmeow call that takes no context under ctx and the
+// call timeout. If ctx ends first it returns at once; the call itself
+// finishes in the background, bounded by whatsmeow's own request timeout.
+func callContext[T any](wac *WhatsAppClient, ctx context.Context, what string, call func() (T, error)) (T, error) {
+	ctx, cancel := wac.withCallTimeout(ctx)
+	defer cancel()
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() {
+			if p := recover(); p != nil {
+				r.err = logPanic(logger.For(ctx), what, p)
+			}
+			done <- r
+		}()
+		r.value, r.err = call()
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, timeoutError(what, ctx.Err())
+	}
+}
+
+// callContextErr is callContext for calls that only return an error
+func callContextErr(wac *WhatsAppClient, ctx context.Context, what string, call func() error) error {
+	_, err := callContext(wac, ctx, what, func() (struct{}, error) {
+		return struct{}{}, call()
+	})
+	return err
+}
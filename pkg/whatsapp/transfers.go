@@ -0,0 +1,234 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+const (
+	// progressInterval is the minimum time between transfer-progress events
+	// for one transfer
+	progressInterval = 500 * time.Millisecond
+	// keepTransfers is how many finished transfers stay visible to
+	// GetTransferStatus
+	keepTransfers = 50
+	// partialSuffix marks a download still being written; retention skips
+	// these files
+	partialSuffix = ".partial"
+)
+
+// TransferInfo describes a media transfer. It is the data of a
+// transfer-progress event and an entry of GetTransferStatus.
+type TransferInfo struct {
+	ID         string `json:"id"` // the message ID of a download, upload-... for an upload
+	Kind       string `json:"kind"`
+	ChatID     string `json:"chat_id,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Bytes      int64  `json:"bytes"`
+	Total      int64  `json:"total"` // 0 when the sender did not say
+	State      string `json:"state"` // running, done, failed
+	Error      string `json:"error,omitempty"`
+	StartedAt  int64  `json:"started_at"`
+	FinishedAt int64  `json:"finished_at,omitempty"`
+}
+
+// TransferResult is returned by GetTransferStatus
+type TransferResult struct {
+	Success   bool           `json:"success"`
+	Message   string         `json:"message,omitempty"`
+	Transfers []TransferInfo `json:"transfers"`
+}
+
+// transferTracker keeps running transfers and the most recent finished ones
+type transferTracker struct {
+	mu       sync.Mutex
+	running  map[string]*transfer
+	finished []TransferInfo // oldest first
+}
+
+// transfer is one running transfer; info is guarded by the tracker's mutex
+type transfer struct {
+	wac          *WhatsAppClient
+	info         TransferInfo
+	lastProgress time.Time
+}
+
+// startTransfer registers a running transfer and publishes its first
+// progress event
+func (wac *WhatsAppClient) startTransfer(info TransferInfo) *transfer {
+	info.State = "running"
+	info.StartedAt = time.Now().Unix()
+	t := &transfer{wac: wac, info: info, lastProgress: time.Now()}
+
+	tt := &wac.transfers
+	tt.mu.Lock()
+	if tt.running == nil {
+		tt.running = map[string]*transfer{}
+	}
+	tt.running[info.ID] = t
+	tt.mu.Unlock()
+	wac.publish("transfer-progress", info)
+	return t
+}
+
+// progress records the bytes written so far, publishing an event at most
+// every progressInterval
+func (t *transfer) progress(written int64) {
+	tt := &t.wac.transfers
+	tt.mu.Lock()
+	if written <= t.info.Bytes {
+		tt.mu.Unlock()
+		return // whatsmeow rewrites the file from the start on a retry
+	}
+	t.info.Bytes = written
+	if t.info.Total > 0 && t.info.Bytes > t.info.Total {
+		t.info.Bytes = t.info.Total // the encrypted stream is slightly longer
+	}
+	if time.Since(t.lastProgress) < progressInterval {
+		tt.mu.Unlock()
+		return
+	}
+	t.lastProgress = time.Now()
+	info := t.info
+	tt.mu.Unlock()
+	t.wac.publish("transfer-progress", info)
+}
+
+// finish moves the transfer to the finished list and publishes its final
+// state
+func (t *transfer) finish(size int64, err error) {
+	tt := &t.wac.transfers
+	tt.mu.Lock()
+	t.info.FinishedAt = time.Now().Unix()
+	if err != nil {
+		t.info.State = "failed"
+		t.info.Error = err.Error()
+	} else {
+		t.info.State = "done"
+		t.info.Bytes = size
+		t.info.Total = size
+	}
+	info := t.info
+	delete(tt.running, info.ID)
+	tt.finished = append(tt.finished, info)
+	if len(tt.finished) > keepTransfers {
+		tt.finished = tt.finished[len(tt.finished)-keepTransfers:]
+	}
+	tt.mu.Unlock()
+	t.wac.publish("transfer-progress", info)
+}
+
+// GetTransferStatus returns the transfer of one message, or every running
+// and recently finished transfer when id is empty
+func (wac *WhatsAppClient) GetTransferStatus(id string) (interface{}, error) {
+	tt := &wac.transfers
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	transfers := make([]TransferInfo, 0, len(tt.running)+len(tt.finished))
+	for _, t := range tt.running {
+		if id == "" || t.info.ID == id {
+			transfers = append(transfers, t.info)
+		}
+	}
+	for i := len(tt.finished) - 1; i >= 0; i-- {
+		if info := tt.finished[i]; id == "" || info.ID == id {
+			transfers = append(transfers, info)
+			if id != "" {
+				break // only the latest attempt
+			}
+		}
+	}
+	sort.SliceStable(transfers, func(i, j int) bool { return transfers[i].StartedAt > transfers[j].StartedAt })
+
+	if id != "" && len(transfers) == 0 {
+		return TransferResult{Success: false, Message: "No transfer for message " + id, Transfers: transfers},
+			fmt.Errorf("no transfer for message %s", id)
+	}
+	return TransferResult{
+		Success:   true,
+		Message:   fmt.Sprintf("%d transfer(s)", len(transfers)),
+		Transfers: transfers,
+	}, nil
+}
+
+// progressFile is the file whatsmeow streams a download into. It counts
+// what is written for progress reporting and fails writes once ctx ends, so
+// a cancelled download stops without buffering the rest.
+type progressFile struct {
+	*os.File
+	ctx context.Context
+	t   *transfer
+	pos int64
+}
+
+func (f *progressFile) Write(p []byte) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := f.File.Write(p)
+	f.pos += int64(n)
+	f.t.progress(f.pos)
+	return n, err
+}
+
+func (f *progressFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+	return pos, err
+}
+
+// downloadToFile streams the decrypted media to path through a .partial
+// file, renamed into place once complete, and returns its size. Progress is
+// tracked under info.ID; unlike in-memory calls it is not bounded by
+// CallTimeout, since large media can legitimately take longer.
+func (wac *WhatsAppClient) downloadToFile(ctx context.Context, media whatsmeow.DownloadableMessage, path string, info TransferInfo) (int64, error) {
+	info.Kind = "download"
+	info.Path = path
+	if sized, ok := media.(interface{ GetFileLength() uint64 }); ok {
+		info.Total = int64(sized.GetFileLength())
+	}
+	t := wac.startTransfer(info)
+
+	size, err := wac.streamDownload(ctx, media, path, t)
+	t.finish(size, err)
+	return size, err
+}
+
+func (wac *WhatsAppClient) streamDownload(ctx context.Context, media whatsmeow.DownloadableMessage, path string, t *transfer) (int64, error) {
+	partial := path + partialSuffix
+	file, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	err = wac.Client.DownloadToFile(media, &progressFile{File: file, ctx: ctx, t: t})
+	if ctx.Err() != nil {
+		err = ctx.Err() // whatever the write failure was wrapped in
+	}
+	var size int64
+	if err == nil {
+		var stat os.FileInfo
+		if stat, err = file.Stat(); err == nil {
+			size = stat.Size()
+		}
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partial, path)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return 0, err
+	}
+	return size, nil
+}
@@ -0,0 +1,102 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// uploadCache remembers upload results by content hash in the session
+// database, so a file sent repeatedly (a logo, a standard PDF) is uploaded
+// once. Entries older than Options.UploadCacheTTL are treated as expired,
+// since WhatsApp stops serving old media.
+type uploadCache struct {
+	db *sql.DB
+}
+
+func newUploadCache(db *sql.DB) (*uploadCache, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS pod_upload_cache (
+		file_sha256     BLOB NOT NULL,
+		media_type      TEXT NOT NULL,
+		url             TEXT NOT NULL,
+		direct_path     TEXT NOT NULL,
+		media_key       BLOB NOT NULL,
+		file_enc_sha256 BLOB NOT NULL,
+		file_length     INTEGER NOT NULL,
+		uploaded_at     INTEGER NOT NULL,
+		PRIMARY KEY (file_sha256, media_type)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadCache{db: db}, nil
+}
+
+// get returns the cached upload of a file with this hash, unless it is
+// older than ttl, in which case the entry is dropped
+func (c *uploadCache) get(sum []byte, mediaType whatsmeow.MediaType, ttl time.Duration) (whatsmeow.UploadResponse, bool) {
+	resp := whatsmeow.UploadResponse{FileSHA256: sum}
+	var uploadedAt int64
+	err := c.db.QueryRow(`SELECT url, direct_path, media_key, file_enc_sha256, file_length, uploaded_at
+		FROM pod_upload_cache WHERE file_sha256 = ? AND media_type = ?`, sum, string(mediaType)).
+		Scan(&resp.URL, &resp.DirectPath, &resp.MediaKey, &resp.FileEncSHA256, &resp.FileLength, &uploadedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.Warnf("Reading upload cache: %v", err)
+		}
+		return whatsmeow.UploadResponse{}, false
+	}
+	if age := time.Since(time.Unix(uploadedAt, 0)); age > ttl {
+		logger.Infof("Upload cache entry %x expired (%v old), re-uploading", sum[:8], age.Round(time.Second))
+		c.delete(sum, mediaType)
+		return whatsmeow.UploadResponse{}, false
+	}
+	return resp, true
+}
+
+// put stores a fresh upload
+func (c *uploadCache) put(mediaType whatsmeow.MediaType, resp whatsmeow.UploadResponse) {
+	_, err := c.db.Exec(`INSERT OR REPLACE INTO pod_upload_cache
+		(file_sha256, media_type, url, direct_path, media_key, file_enc_sha256, file_length, uploaded_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		resp.FileSHA256, string(mediaType), resp.URL, resp.DirectPath, resp.MediaKey, resp.FileEncSHA256, resp.FileLength, time.Now().Unix())
+	if err != nil {
+		logger.Warnf("Writing upload cache: %v", err)
+	}
+}
+
+func (c *uploadCache) delete(sum []byte, mediaType whatsmeow.MediaType) {
+	if _, err := c.db.Exec(`DELETE FROM pod_upload_cache WHERE file_sha256 = ? AND media_type = ?`, sum, string(mediaType)); err != nil {
+		logger.Warnf("Deleting upload cache entry: %v", err)
+	}
+}
+
+// cachingUploads reports whether uploads go through the cache
+func (wac *WhatsAppClient) cachingUploads() bool {
+	return wac.uploads != nil && wac.Options().UploadCacheTTL > 0
+}
+
+// cachedUpload uploads content with the SHA-256 sum and size through the
+// cache when it is enabled; sum may be nil when it is not
+func (wac *WhatsAppClient) cachedUpload(ctx context.Context, upload func() (whatsmeow.UploadResponse, error), sum []byte, size int64, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	if !wac.cachingUploads() {
+		return upload()
+	}
+	if resp, ok := wac.uploads.get(sum, mediaType, wac.Options().UploadCacheTTL); ok {
+		metricUploadCacheHits.Inc()
+		logger.For(ctx).Infof("Reusing cached upload of %x (%d bytes)", sum[:8], size)
+		return resp, nil
+	}
+	resp, err := upload()
+	if err != nil {
+		return resp, err
+	}
+	if len(resp.FileSHA256) == 0 {
+		return resp, fmt.Errorf("upload returned no file hash")
+	}
+	wac.uploads.put(mediaType, resp)
+	return resp, nil
+}
@@ -0,0 +1,148 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// An upload is encrypted with keys derived for its media type, so the
+// MediaInfo Upload returns can be sent again, to any number of chats, in a
+// message of that type without transferring the file again.
+
+// mediaTypeNames names the media types an upload can be encrypted as
+var mediaTypeNames = map[whatsmeow.MediaType]string{
+	whatsmeow.MediaImage:    "image",
+	whatsmeow.MediaVideo:    "video",
+	whatsmeow.MediaAudio:    "audio",
+	whatsmeow.MediaDocument: "document",
+}
+
+// uploadMediaType is the media type a file of mimeType is uploaded as;
+// anything not an image, video or audio is sent as a document
+func uploadMediaType(mimeType string) whatsmeow.MediaType {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return whatsmeow.MediaImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return whatsmeow.MediaVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return whatsmeow.MediaAudio
+	}
+	return whatsmeow.MediaDocument
+}
+
+// SendUploadedImage sends an image uploaded earlier with Upload
+func (wac *WhatsAppClient) SendUploadedImage(ctx context.Context, recipient string, media MediaInfo, caption string) (interface{}, error) {
+	return wac.sendUploaded(ctx, "send-uploaded-image", recipient, media, caption, whatsmeow.MediaImage, func() *waProto.Message {
+		return &waProto.Message{ImageMessage: &waProto.ImageMessage{
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectURL),
+			Mimetype:      proto.String(mimeTypeOr(media.Mimetype, "image/jpeg")),
+			Caption:       proto.String(caption),
+			FileSHA256:    media.FileSHA256,
+			FileEncSHA256: media.FileEncSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			MediaKey:      media.MediaKey,
+		}}
+	})
+}
+
+// SendUploadedVideo sends a video uploaded earlier with Upload
+func (wac *WhatsAppClient) SendUploadedVideo(ctx context.Context, recipient string, media MediaInfo, caption string) (interface{}, error) {
+	return wac.sendUploaded(ctx, "send-uploaded-video", recipient, media, caption, whatsmeow.MediaVideo, func() *waProto.Message {
+		return &waProto.Message{VideoMessage: &waProto.VideoMessage{
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectURL),
+			Mimetype:      proto.String(mimeTypeOr(media.Mimetype, "video/mp4")),
+			Caption:       proto.String(caption),
+			FileSHA256:    media.FileSHA256,
+			FileEncSHA256: media.FileEncSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			MediaKey:      media.MediaKey,
+		}}
+	})
+}
+
+// SendUploadedDocument sends a document uploaded earlier with Upload
+func (wac *WhatsAppClient) SendUploadedDocument(ctx context.Context, recipient string, media MediaInfo, caption string) (interface{}, error) {
+	return wac.sendUploaded(ctx, "send-uploaded-document", recipient, media, caption, whatsmeow.MediaDocument, func() *waProto.Message {
+		return &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectURL),
+			Mimetype:      proto.String(mimeTypeOr(media.Mimetype, "application/octet-stream")),
+			FileName:      proto.String(media.FileName),
+			Caption:       proto.String(caption),
+			FileSHA256:    media.FileSHA256,
+			FileEncSHA256: media.FileEncSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			MediaKey:      media.MediaKey,
+		}}
+	})
+}
+
+// sendUploaded checks that media was uploaded as mediaType and sends the
+// message build makes of it to recipient
+func (wac *WhatsAppClient) sendUploaded(ctx context.Context, op, recipient string, media MediaInfo, caption string, mediaType whatsmeow.MediaType, build func() *waProto.Message) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+	to, err := ParseRecipientJID(recipient)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	if err := wac.checkUploaded(media, mediaType); err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	spec, _ := json.Marshal(media)
+
+	ts := time.Now()
+	resp, err := wac.sendMessage(ctx, to, build())
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, op, recipient, string(spec), caption)
+	}
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: fmt.Sprintf("Uploaded %s sent to %s (server timestamp: %v)", mediaTypeNames[mediaType], to, ts),
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// checkUploaded rejects media that is incomplete or was uploaded as another
+// media type, which recipients would fail to decrypt
+func (wac *WhatsAppClient) checkUploaded(media MediaInfo, mediaType whatsmeow.MediaType) error {
+	want := mediaTypeNames[mediaType]
+	if media.MediaType == "" && media.Mimetype != "" {
+		media.MediaType = mediaTypeNames[uploadMediaType(media.Mimetype)]
+	}
+	if media.MediaType == "" {
+		return fmt.Errorf("the media has no media_type or mimetype: pass the :media of an upload result")
+	}
+	if media.MediaType != want {
+		return fmt.Errorf("the media was uploaded as %s and cannot be sent as %s", media.MediaType, want)
+	}
+	if media.DirectURL == "" || len(media.FileSHA256) == 0 {
+		return fmt.Errorf("the media has no direct_url or file_sha256: pass the :media of an upload result")
+	}
+	// Dry-run uploads have no keys
+	if !wac.Options().DryRun && (len(media.MediaKey) == 0 || len(media.FileEncSHA256) == 0) {
+		return fmt.Errorf("the media has no media_key or file_enc_sha256: pass the :media of an upload result")
+	}
+	return nil
+}
+
+// mimeTypeOr returns mimeType, or fallback when it is empty
+func mimeTypeOr(mimeType, fallback string) string {
+	if mimeType == "" {
+		return fallback
+	}
+	return mimeType
+}
@@ -0,0 +1,119 @@
+package whatsapp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// uploadPool bounds how many media uploads run at once, so bulk media jobs
+// overlap their uploads without opening a connection per file
+type uploadPool struct {
+	mu      sync.Mutex
+	slots   chan struct{}
+	waiting atomic.Int64
+}
+
+// setLimit resizes the pool. Uploads already running release their slot to
+// the previous pool, so the new limit applies to uploads started afterwards.
+func (p *uploadPool) setLimit(n int) {
+	if n < 1 {
+		n = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.slots == nil || cap(p.slots) != n {
+		p.slots = make(chan struct{}, n)
+	}
+}
+
+// acquire waits for a free upload slot; release must be called when the
+// upload is over
+func (p *uploadPool) acquire(ctx context.Context) (release func(), err error) {
+	p.mu.Lock()
+	slots := p.slots
+	p.mu.Unlock()
+
+	p.waiting.Add(1)
+	defer p.waiting.Add(-1)
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// chatOrder keeps messages to one chat in the order their sends were
+// requested, even when a later media message finishes uploading first.
+// Each send takes a turn; a turn waits for the previous turn in its chat.
+type chatOrder struct {
+	mu    sync.Mutex
+	tails map[types.JID]chan struct{}
+}
+
+type chatTurn struct {
+	order *chatOrder
+	chat  types.JID
+	prev  <-chan struct{} // closed when the previous turn is over, nil if none
+	mine  chan struct{}
+	once  sync.Once
+}
+
+// take queues a turn behind the last one taken for chat
+func (o *chatOrder) take(chat types.JID) *chatTurn {
+	chat = chat.ToNonAD()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.tails == nil {
+		o.tails = make(map[types.JID]chan struct{})
+	}
+	t := &chatTurn{order: o, chat: chat, prev: o.tails[chat], mine: make(chan struct{})}
+	o.tails[chat] = t.mine
+	return t
+}
+
+// wait blocks until every earlier turn in the chat is over
+func (t *chatTurn) wait(ctx context.Context) error {
+	if t.prev == nil {
+		return nil
+	}
+	select {
+	case <-t.prev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release ends the turn; it is safe to call more than once
+func (t *chatTurn) release() {
+	t.once.Do(func() {
+		t.order.mu.Lock()
+		if t.order.tails[t.chat] == t.mine {
+			delete(t.order.tails, t.chat)
+		}
+		t.order.mu.Unlock()
+		close(t.mine)
+	})
+}
+
+type chatTurnKey struct{}
+
+// takeTurn reserves the next send slot in a chat before slow preparation such
+// as an upload, so the message keeps its place. sendMessage waits for the turn
+// carried by the returned context; release frees it if the send never happens.
+func (wac *WhatsAppClient) takeTurn(ctx context.Context, chat types.JID) (context.Context, func()) {
+	t := wac.chatOrder.take(chat)
+	return context.WithValue(ctx, chatTurnKey{}, t), t.release
+}
+
+// turnFor returns the turn reserved in ctx for chat, or takes one now
+func (wac *WhatsAppClient) turnFor(ctx context.Context, chat types.JID) *chatTurn {
+	if t, ok := ctx.Value(chatTurnKey{}).(*chatTurn); ok && t.chat == chat.ToNonAD() {
+		return t
+	}
+	return wac.chatOrder.take(chat)
+}
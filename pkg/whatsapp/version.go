@@ -0,0 +1,177 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+)
+
+// versionCheckTimeout bounds the request for the current WhatsApp web version
+const versionCheckTimeout = 15 * time.Second
+
+// VersionInfo compares the WhatsApp web version the pod presents with the
+// one WhatsApp currently serves
+type VersionInfo struct {
+	Current  string `json:"current"`
+	Latest   string `json:"latest,omitempty"`  // "" when it could not be fetched
+	Outdated bool   `json:"outdated"`          // the server rejected Current, or Latest is newer
+	Applied  bool   `json:"applied,omitempty"` // Latest is now used for new connections
+	Error    string `json:"error,omitempty"`   // why Latest could not be fetched
+}
+
+// VersionResult is returned by CheckVersion and SetVersion
+type VersionResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	VersionInfo
+}
+
+// applyWAVersion makes new connections present v. SetWAVersion alone leaves
+// the user agent in the client payload at the old version.
+func applyWAVersion(v store.WAVersionContainer) {
+	store.SetWAVersion(v)
+	store.BaseClientPayload.UserAgent.AppVersion = v.ProtoAppVersion()
+}
+
+// latestWAVersion asks web.whatsapp.com for the version it currently serves,
+// through the configured proxy
+func (wac *WhatsAppClient) latestWAVersion(ctx context.Context) (store.WAVersionContainer, error) {
+	ctx, cancel := context.WithTimeout(ctx, versionCheckTimeout)
+	defer cancel()
+	transport, err := wac.proxyTransport()
+	if err != nil {
+		return store.WAVersionContainer{}, err
+	}
+	httpClient := &http.Client{Transport: ctxTransport{ctx, transport}}
+	latest, err := whatsmeow.GetLatestVersion(httpClient)
+	if err != nil {
+		return store.WAVersionContainer{}, err
+	}
+	return *latest, nil
+}
+
+// proxyTransport is an HTTP transport through the configured proxy
+func (wac *WhatsAppClient) proxyTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy := wac.Options().Proxy; proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return transport, nil
+}
+
+// ctxTransport attaches ctx to requests made by code that builds its own
+type ctxTransport struct {
+	ctx context.Context
+	rt  http.RoundTripper
+}
+
+func (t ctxTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.rt.RoundTrip(req.WithContext(t.ctx))
+}
+
+// checkVersion compares the current version with the latest one, switching
+// to the latest when apply is set and it is newer
+func (wac *WhatsAppClient) checkVersion(ctx context.Context, apply bool) VersionInfo {
+	current := store.GetWAVersion()
+	info := VersionInfo{Current: current.String()}
+	latest, err := wac.latestWAVersion(ctx)
+	if err != nil {
+		logger.For(ctx).Warnf("Fetching the current WhatsApp web version: %v", err)
+		info.Error = err.Error()
+		return info
+	}
+	info.Latest = latest.String()
+	info.Outdated = current.LessThan(latest)
+	if apply && info.Outdated {
+		applyWAVersion(latest)
+		info.Applied = true
+		logger.For(ctx).Infof("Now presenting WhatsApp web version %s (was %s)", latest, current)
+	}
+	return info
+}
+
+// handleClientOutdated runs when the server rejects the pod's version. It
+// looks up the version WhatsApp now requires and, with AutoUpdateVersion,
+// switches to it so the next login can succeed, then fails the pending login
+// with a structured upgrade-required status.
+func (wac *WhatsAppClient) handleClientOutdated() {
+	defer LogPanic(logger, "the version check")
+	info := wac.checkVersion(context.Background(), wac.Options().AutoUpdateVersion)
+	info.Outdated = true // the server said so, whatever the web page reports
+
+	wac.versionMutex.Lock()
+	wac.upgrade = &info
+	wac.versionMutex.Unlock()
+	wac.session.setStatus("upgrade-required")
+
+	switch {
+	case info.Applied:
+		eventLogger.Errorf("Client version %s rejected as outdated; switched to %s, log in again", info.Current, info.Latest)
+	case info.Latest != "":
+		eventLogger.Errorf("Client version %s rejected as outdated (latest %s); update the pod or use set-wa-version", info.Current, info.Latest)
+	default:
+		eventLogger.Errorf("Client version %s rejected as outdated; update the pod", info.Current)
+	}
+	wac.publish("upgrade-required", info)
+	select {
+	case wac.qrChan <- "upgrade-required":
+	default:
+	}
+}
+
+// upgradeInfo returns the version report of the last ClientOutdated event
+func (wac *WhatsAppClient) upgradeInfo() *VersionInfo {
+	wac.versionMutex.Lock()
+	defer wac.versionMutex.Unlock()
+	return wac.upgrade
+}
+
+// CheckVersion reports the WhatsApp web version in use and the latest one
+func (wac *WhatsAppClient) CheckVersion(ctx context.Context) (interface{}, error) {
+	info := wac.checkVersion(ctx, false)
+	if info.Error != "" {
+		return VersionResult{Success: false, Message: "Could not fetch the latest version", VersionInfo: info}, fmt.Errorf("fetching latest version: %s", info.Error)
+	}
+	msg := "Up to date"
+	if info.Outdated {
+		msg = "A newer WhatsApp web version is available"
+	}
+	return VersionResult{Success: true, Message: msg, VersionInfo: info}, nil
+}
+
+// SetVersion switches the WhatsApp web version presented on the next
+// connect, to a dotted version string or "latest"
+func (wac *WhatsAppClient) SetVersion(ctx context.Context, version string) (interface{}, error) {
+	if version == "latest" {
+		info := wac.checkVersion(ctx, true)
+		if info.Error != "" {
+			return VersionResult{Success: false, Message: "Could not fetch the latest version", VersionInfo: info}, fmt.Errorf("fetching latest version: %s", info.Error)
+		}
+		return VersionResult{Success: true, Message: "Using version " + store.GetWAVersion().String(), VersionInfo: info}, nil
+	}
+
+	v, err := store.ParseVersion(version)
+	if err != nil || v.IsZero() {
+		if err == nil {
+			err = fmt.Errorf("version must not be 0.0.0")
+		}
+		return VersionResult{Success: false, Message: err.Error()}, err
+	}
+	previous := store.GetWAVersion()
+	applyWAVersion(v)
+	logger.For(ctx).Infof("Now presenting WhatsApp web version %s (was %s)", v, previous)
+	return VersionResult{
+		Success:     true,
+		Message:     "Using version " + v.String() + " from the next connect",
+		VersionInfo: VersionInfo{Current: v.String(), Applied: true},
+	}, nil
+}
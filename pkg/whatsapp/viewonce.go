@@ -0,0 +1,68 @@
+package whatsapp
+
+import (
+	"encoding/json"
+
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// A view-once image or video has ViewOnce set and is wrapped in a
+// ViewOnceMessage, which phones open once and then discard. A GIF is an MP4
+// video with GifPlayback set: it loops silently instead of playing once.
+
+// MediaSendOptions are the options of SendImage and SendVideo
+type MediaSendOptions struct {
+	ViewOnce    bool `json:"view-once"`    // the recipient can open it once
+	GifPlayback bool `json:"gif-playback"` // videos only: loop silently, like a GIF
+}
+
+// withMediaOptions applies opts to an image or video message
+func withMediaOptions(msg *waProto.Message, opts MediaSendOptions) *waProto.Message {
+	if opts.GifPlayback && msg.VideoMessage != nil {
+		msg.VideoMessage.GifPlayback = proto.Bool(true)
+		msg.VideoMessage.GifAttribution = waProto.VideoMessage_NONE.Enum()
+	}
+	if !opts.ViewOnce {
+		return msg
+	}
+	switch {
+	case msg.ImageMessage != nil:
+		msg.ImageMessage.ViewOnce = proto.Bool(true)
+	case msg.VideoMessage != nil:
+		msg.VideoMessage.ViewOnce = proto.Bool(true)
+	}
+	return &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{Message: msg}}
+}
+
+// withMediaOptionsArg appends the options of a media send to the arguments
+// a dead letter replays, when any are set
+func withMediaOptionsArg(args []string, opts MediaSendOptions) []string {
+	if opts == (MediaSendOptions{}) {
+		return args
+	}
+	spec, _ := json.Marshal(opts)
+	return append(args, string(spec))
+}
+
+// viewOnce reports whether a media message may only be viewed once
+func viewOnce(msg *waProto.Message) bool {
+	return msg.GetImageMessage().GetViewOnce() || msg.GetVideoMessage().GetViewOnce() || msg.GetAudioMessage().GetViewOnce()
+}
+
+// unwrapViewOnce returns the message inside any of the view-once wrappers,
+// and whether it is view once. The buttons and lists we send are wrapped in
+// a ViewOnceMessage too, so only the flag of the media inside or the newer
+// wrappers, which carry nothing else, make a message view once.
+func unwrapViewOnce(msg *waProto.Message) (*waProto.Message, bool) {
+	if inner := msg.GetViewOnceMessageV2Extension().GetMessage(); inner != nil {
+		return inner, true
+	}
+	if inner := msg.GetViewOnceMessageV2().GetMessage(); inner != nil {
+		return inner, true
+	}
+	if inner := msg.GetViewOnceMessage().GetMessage(); inner != nil {
+		msg = inner
+	}
+	return msg, viewOnce(msg)
+}
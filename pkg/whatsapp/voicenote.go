@@ -0,0 +1,189 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// A voice note is an audio message flagged PTT ("push to talk"). Phones play
+// it inline with a waveform, but only when it is Opus in an Ogg container,
+// so other audio is transcoded with ffmpeg first.
+
+const (
+	voiceNoteMimeType = "audio/ogg; codecs=opus"
+	waveformSamples   = 64   // the bars phones draw, each 0 to 100
+	waveformRate      = 8000 // the sample rate the waveform is measured at
+	opusGranuleRate   = 48000
+)
+
+// VoiceNoteOptions are the options of SendVoiceNote
+type VoiceNoteOptions struct {
+	Transcode bool `json:"transcode"` // transcode OGG/Opus input too, e.g. to shrink a high-bitrate recording
+}
+
+// SendVoiceNote sends an audio file as a voice note, with its
+// duration and waveform. Audio that is not OGG/Opus needs ffmpeg on the
+// PATH, and without ffmpeg the note has no waveform.
+func (wac *WhatsAppClient) SendVoiceNote(ctx context.Context, recipient, filePath string, opts VoiceNoteOptions) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+	to, err := ParseRecipientJID(recipient)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	spec, _ := json.Marshal(opts)
+	// Keep this message's place in the chat while the media uploads
+	ctx, release := wac.takeTurn(ctx, to)
+	defer release()
+
+	if _, err := wac.checkUploadSize(filePath); err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	if opts.Transcode || !isOggOpus(data) {
+		if data, err = transcodeVoiceNote(ctx, filePath); err != nil {
+			err = fmt.Errorf("transcoding %s to OGG/Opus: %w", filePath, err)
+			return SendResult{Success: false, Message: err.Error()}, err
+		}
+	}
+	seconds, err := oggOpusDuration(data)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", filePath, err)
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	waveform, err := voiceWaveform(ctx, data)
+	if err != nil {
+		logger.For(ctx).Debugf("No waveform for %s: %v", filePath, err)
+	}
+
+	uploaded, err := wac.upload(ctx, data, whatsmeow.MediaAudio)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-voice-note", recipient, filePath, string(spec))
+	}
+	msg := &waProto.Message{
+		AudioMessage: &waProto.AudioMessage{
+			URL:           &uploaded.URL,
+			DirectPath:    proto.String(uploaded.DirectPath),
+			Mimetype:      proto.String(voiceNoteMimeType),
+			FileSHA256:    uploaded.FileSHA256,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+			MediaKey:      uploaded.MediaKey,
+			PTT:           proto.Bool(true),
+			Seconds:       proto.Uint32(seconds),
+			Waveform:      waveform,
+		},
+	}
+
+	ts := time.Now()
+	resp, err := wac.sendMessage(ctx, to, msg)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-voice-note", recipient, filePath, string(spec))
+	}
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: fmt.Sprintf("Voice note of %ds sent to %s (server timestamp: %v)", seconds, to, ts),
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// isOggOpus reports whether data is Opus in an Ogg container; the first
+// page of such a stream holds just the OpusHead header
+func isOggOpus(data []byte) bool {
+	return len(data) >= 36 && string(data[:4]) == "OggS" && string(data[28:36]) == "OpusHead"
+}
+
+// transcodeVoiceNote transcodes the audio at path to mono OGG/Opus, as
+// phones record voice notes
+func transcodeVoiceNote(ctx context.Context, path string) ([]byte, error) {
+	return runFFmpeg(ctx, nil, "-i", path, "-vn", "-ac", "1", "-ar", "48000",
+		"-c:a", "libopus", "-b:a", "32k", "-application", "voip", "-f", "ogg", "-")
+}
+
+// oggOpusDuration is the length of an OGG/Opus stream in whole seconds
+// (at least 1), from the granule position of its last page, which counts
+// 48 kHz samples including the pre-skip the OpusHead declares
+func oggOpusDuration(data []byte) (uint32, error) {
+	if !isOggOpus(data) || len(data) < 40 {
+		return 0, errors.New("not an OGG/Opus stream")
+	}
+	preSkip := uint64(binary.LittleEndian.Uint16(data[38:40]))
+	var granule uint64
+	for page := 0; page < len(data); {
+		if page+27 > len(data) || string(data[page:page+4]) != "OggS" {
+			return 0, fmt.Errorf("corrupt OGG page at byte %d", page)
+		}
+		segments := int(data[page+26])
+		if page+27+segments > len(data) {
+			return 0, errors.New("truncated OGG stream")
+		}
+		size := 27 + segments
+		for _, n := range data[page+27 : page+27+segments] {
+			size += int(n)
+		}
+		// -1 marks a page no packet ends on
+		if g := binary.LittleEndian.Uint64(data[page+6 : page+14]); g != ^uint64(0) {
+			granule = g
+		}
+		page += size
+	}
+	if granule <= preSkip {
+		return 1, nil
+	}
+	return uint32((granule - preSkip + opusGranuleRate - 1) / opusGranuleRate), nil
+}
+
+// voiceWaveform measures the loudness of an OGG/Opus stream in
+// waveformSamples equal slices, scaled so the loudest is 100. Decoding
+// needs ffmpeg; without it there is no waveform.
+func voiceWaveform(ctx context.Context, data []byte) ([]byte, error) {
+	pcm, err := runFFmpeg(ctx, data, "-i", "pipe:0", "-ac", "1", "-ar", fmt.Sprint(waveformRate), "-f", "s16le", "-")
+	if err != nil {
+		return nil, err
+	}
+	samples := len(pcm) / 2
+	if samples == 0 {
+		return nil, errors.New("no audio")
+	}
+	levels := make([]float64, waveformSamples)
+	var loudest float64
+	for i := range levels {
+		from, to := i*samples/waveformSamples, (i+1)*samples/waveformSamples
+		if to <= from {
+			continue
+		}
+		var sum float64
+		for s := from; s < to; s++ {
+			v := float64(int16(binary.LittleEndian.Uint16(pcm[2*s:])))
+			if v < 0 {
+				v = -v
+			}
+			sum += v
+		}
+		levels[i] = sum / float64(to-from)
+		loudest = max(loudest, levels[i])
+	}
+	waveform := make([]byte, waveformSamples)
+	if loudest == 0 {
+		return waveform, nil
+	}
+	for i, level := range levels {
+		waveform[i] = byte(level / loudest * 100)
+	}
+	return waveform, nil
+}
@@ -2,70 +2,155 @@ package whatsapp
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
-	"log" // Import standard log package
 	"os"
-	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	_ "modernc.org/sqlite"
 
 	"go.mau.fi/whatsmeow"
 	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
-	waLog "go.mau.fi/whatsmeow/util/log"
 	"google.golang.org/protobuf/proto"
 )
 
-// WhatsAppClient wraps the whatsmeow client and related state
+// WhatsAppClient wraps the whatsmeow client and related state. Methods
+// that talk to WhatsApp take a context first, and take their optional
+// settings as one options struct last.
 type WhatsAppClient struct {
-	Client       *whatsmeow.Client
-	dbContainer  *sqlstore.Container
-	jid          types.JID
-	loginStatus  string      // "not-logged-in", "qr-pending", "logged-in", "login-failed", "connecting"
-	qrCodeStr    string      // Stores the QR code string when received
-	qrChan       chan string // Channel to signal QR code availability
-	loginMutex   sync.Mutex  // Protect concurrent login attempts
-	lastMessage  *MessageInfo
-	messageMutex sync.Mutex
+	Client        Messenger // *whatsmeow.Client in production, Fake offline
+	dbContainer   *sqlstore.Container
+	db            *sql.DB          // the session database, shared by whatsmeow and the pod's tables
+	uploads       *uploadCache     // nil without a session database
+	messages      *messageStore    // nil without a session database
+	deadLetters   *deadLetterStore // nil without a session database
+	verified      *verifiedStore   // nil without a session database
+	appState      *appStateStore   // nil without a session database
+	audit         *auditStore      // nil without a session database
+	polls         *pollStore       // nil without a session database
+	inboxStore    *inboxStore      // nil without a session database
+	session       sessionState
+	qrChan        chan string // Channel to signal QR code availability
+	loginMutex    sync.Mutex  // Protect concurrent login attempts
+	lastMessage   *MessageInfo
+	messageMutex  sync.Mutex
+	options       Options
+	optionsMutex  sync.Mutex
+	sendMutex     sync.Mutex // serializes sends when a send interval is configured
+	lastSend      time.Time
+	inFlight      sync.WaitGroup // sends and uploads that Drain waits for
+	drainMutex    sync.Mutex
+	draining      bool
+	connectedOnce atomic.Bool // set on the first Connected event, later ones are reconnects
+	downloadMutex sync.Mutex  // serializes retention passes over the download directory
+	stopRetention chan struct{}
+	stopOnce      sync.Once
+	upgrade       *VersionInfo // set when the server rejected the client version
+	throttle      throttleState
+	conn          connState
+	uploadPool    uploadPool
+	chatOrder     chatOrder
+	transfers     transferTracker
+	versionMutex  sync.Mutex
+	events        eventBus
+	inbox         inbox
+	mediaRetries  mediaRetries
+	presence      presenceCache
+	reconnect     reconnectState
+	replayers     replayers
+	history       historyFetches
 }
 
 // Result types for pod responses
 type StatusResult struct {
-	Status      string       `json:"status"`
-	LastMessage *MessageInfo `json:"last_message,omitempty"`
+	Status           string         `json:"status"`
+	JID              string         `json:"jid,omitempty"`
+	PushName         string         `json:"push_name,omitempty"`
+	Platform         string         `json:"platform,omitempty"` // of the phone the session is linked to
+	Connected        bool           `json:"connected"`
+	LoggedIn         bool           `json:"logged_in"`
+	UptimeMs         int64          `json:"uptime_ms"`                 // time connected, 0 while disconnected
+	QueuedMessages   int            `json:"queued_messages"`           // incoming messages waiting for poll-messages
+	LastDisconnect   string         `json:"last_disconnect,omitempty"` // why the connection last dropped
+	LastDisconnectAt int64          `json:"last_disconnect_at,omitempty"`
+	Device           *DeviceInfo    `json:"device,omitempty"` // set once paired
+	LastMessage      *MessageInfo   `json:"last_message,omitempty"`
+	Version          *VersionInfo   `json:"version,omitempty"`   // set while the status is upgrade-required
+	Throttle         *ThrottleInfo  `json:"throttle,omitempty"`  // set once WhatsApp has rate limited the client
+	Reconnect        *ReconnectInfo `json:"reconnect,omitempty"` // set while reconnecting after the connection dropped
+}
+
+// DeviceInfo is the store's record of the linked device, in status
+type DeviceInfo struct {
+	LID            string `json:"lid,omitempty"`
+	BusinessName   string `json:"business_name,omitempty"`
+	RegistrationID uint32 `json:"registration_id"`
+	DeviceName     string `json:"device_name,omitempty"` // shown under Linked Devices on the phone
+	WebVersion     string `json:"web_version"`           // the WhatsApp web version the client announces
 }
 
 type LoginResult struct {
-	Status  string `json:"status"`
-	QrCode  string `json:"qr_code,omitempty"` // Changed: Now returns the actual QR code string
-	Message string `json:"message,omitempty"`
+	Status     string       `json:"status"`
+	QrCode     string       `json:"qr_code,omitempty"`       // Changed: Now returns the actual QR code string
+	QrTerminal string       `json:"qr_terminal,omitempty"`   // the QR code drawn for a terminal, with qr-format terminal
+	QrPNG      string       `json:"qr_png_base64,omitempty"` // the QR code as a base64 PNG, with qr-format png-base64
+	Message    string       `json:"message,omitempty"`
+	Version    *VersionInfo `json:"version,omitempty"` // set when the status is upgrade-required
 }
 
 type SendResult struct {
-	Success bool   `json:"success"`
-	Message string `json:"message,omitempty"`
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	ID         string `json:"id,omitempty"`          // message ID, synthetic in dry-run mode
+	DryRun     bool   `json:"dry_run,omitempty"`     // nothing was actually sent
+	Retries    int    `json:"retries,omitempty"`     // attempts repeated because WhatsApp rate limited them
+	Throttled  bool   `json:"throttled,omitempty"`   // WhatsApp rate limited the send at least once
+	AckRetries int    `json:"ack_retries,omitempty"` // resends with the same ID because the server ack did not arrive
 }
 
 type MessageInfo struct {
+	ID          string `json:"id,omitempty"`
 	ChatID      string `json:"chat_id"`
 	Content     string `json:"content"`
 	Sender      string `json:"sender"`
 	IsFromMe    bool   `json:"is_from_me"`
-	MessageType string `json:"message_type"`
+	MessageType string `json:"message_type"` // text, image, video, audio, sticker, document, location, contact, reaction, poll, poll-vote, buttons, list, button-reply, list-reply, edit, revoke or unknown
 	Timestamp   int64  `json:"timestamp"`
+
+	// Per-type fields, set when the message type has them
+	Caption      string        `json:"caption,omitempty"`
+	Mimetype     string        `json:"mimetype,omitempty"`
+	FileName     string        `json:"file_name,omitempty"`
+	FileLength   uint64        `json:"file_length,omitempty"`
+	Duration     uint32        `json:"duration,omitempty"` // seconds, for audio and video
+	VoiceNote    bool          `json:"voice_note,omitempty"`
+	ViewOnce     bool          `json:"view_once,omitempty"`
+	GifPlayback  bool          `json:"gif_playback,omitempty"` // a video that loops like a GIF
+	Location     *LocationInfo `json:"location,omitempty"`
+	Contacts     []ContactCard `json:"contacts,omitempty"`
+	Poll         *PollInfo     `json:"poll,omitempty"`
+	TargetID     string        `json:"target_id,omitempty"`   // the message a reaction, poll vote, edit or revoke refers to
+	SelectedID   string        `json:"selected_id,omitempty"` // the button or list row a reply picked
+	QuotedID     string        `json:"quoted_id,omitempty"`
+	QuotedSender string        `json:"quoted_sender,omitempty"`
+	Mentions     []string      `json:"mentions,omitempty"`
 }
 
 // GroupInfo represents information about a WhatsApp group
 type GroupInfo struct {
-	JID          string   `json:"jid"`
-	Name         string   `json:"name"`
-	Participants []string `json:"participants"`
+	JID               string   `json:"jid"`
+	Name              string   `json:"name"`
+	Participants      []string `json:"participants"`
+	DisappearingTimer uint32   `json:"disappearing_timer,omitempty"` // seconds, 0 when off
 }
 
 // GroupResult represents the result of group operations
@@ -75,14 +160,18 @@ type GroupResult struct {
 	Groups  []GroupInfo `json:"groups,omitempty"`
 }
 
-// MediaInfo represents information about uploaded media
+// MediaInfo represents information about uploaded media. Upload returns
+// it, and the send-uploaded vars take it back to send the file again.
 type MediaInfo struct {
-	URL        string `json:"url"`
-	DirectURL  string `json:"direct_url"`
-	Mimetype   string `json:"mimetype"`
-	FileSHA256 []byte `json:"file_sha256"`
-	FileLength uint64 `json:"file_length"`
-	MediaKey   []byte `json:"media_key"`
+	URL           string `json:"url"`
+	DirectURL     string `json:"direct_url"`
+	Mimetype      string `json:"mimetype"`
+	MediaType     string `json:"media_type,omitempty"` // image, video, audio or document: what the upload was encrypted as
+	FileName      string `json:"file_name,omitempty"`
+	FileSHA256    []byte `json:"file_sha256"`
+	FileEncSHA256 []byte `json:"file_enc_sha256,omitempty"`
+	FileLength    uint64 `json:"file_length"`
+	MediaKey      []byte `json:"media_key"`
 }
 
 // UploadResult represents the result of media upload operations
@@ -90,6 +179,50 @@ type UploadResult struct {
 	Success bool       `json:"success"`
 	Message string     `json:"message,omitempty"`
 	Media   *MediaInfo `json:"media,omitempty"`
+	DryRun  bool       `json:"dry_run,omitempty"` // nothing was actually uploaded
+}
+
+// BinaryResult carries raw bytes (e.g. downloaded media) back to the pod.
+// Instead of being marshaled as one base64 JSON string, it is streamed to
+// the babashka client in chunks, so only async vars should return it.
+type BinaryResult struct {
+	Mimetype string `json:"mimetype"`
+	FileName string `json:"file_name,omitempty"`
+	Data     []byte `json:"-"`
+}
+
+// BinaryHeader is the first value streamed for a BinaryResult
+type BinaryHeader struct {
+	Mimetype  string `json:"mimetype"`
+	FileName  string `json:"file_name,omitempty"`
+	Size      int    `json:"size"`
+	ChunkSize int    `json:"chunk_size"`
+	Chunks    int    `json:"chunks"`
+	Encoding  string `json:"encoding"` // "base64" for BinaryChunk values, "raw" for bare byte strings
+}
+
+// BinaryChunk is one slice of a streamed BinaryResult, as a value in the
+// pod's format. Clients that asked for raw chunks get the slice itself as
+// the value instead.
+type BinaryChunk struct {
+	Index int    `json:"index"`
+	Data  []byte `json:"data"` // encoding/json emits []byte as base64
+}
+
+// Chunks splits the payload into pieces of at most size bytes
+func (b *BinaryResult) Chunks(size int) [][]byte {
+	if size <= 0 {
+		size = len(b.Data)
+	}
+	var chunks [][]byte
+	for start := 0; start < len(b.Data); start += size {
+		end := start + size
+		if end > len(b.Data) {
+			end = len(b.Data)
+		}
+		chunks = append(chunks, b.Data[start:end])
+	}
+	return chunks
 }
 
 // ContactInfo represents information about a WhatsApp contact
@@ -101,6 +234,7 @@ type ContactInfo struct {
 	LastSeen     int64  `json:"last_seen,omitempty"`
 	IsOnline     bool   `json:"is_online,omitempty"`
 	ProfilePicID string `json:"profile_pic_id,omitempty"`
+	Verified     bool   `json:"verified"` // the security code was verified for the contact's current key
 }
 
 // ContactResult represents the result of contact operations
@@ -125,9 +259,10 @@ type StatusUpdateResult struct {
 
 // PresenceInfo represents information about a contact's presence
 type PresenceInfo struct {
-	JID      string `json:"jid"`
-	IsOnline bool   `json:"is_online"`
-	LastSeen int64  `json:"last_seen,omitempty"`
+	JID       string `json:"jid"`
+	IsOnline  bool   `json:"is_online"`
+	LastSeen  int64  `json:"last_seen,omitempty"`  // hidden by contacts who do not share it
+	UpdatedAt int64  `json:"updated_at,omitempty"` // when the pod last heard of the contact's presence
 }
 
 // PresenceResult represents the result of presence operations
@@ -154,6 +289,7 @@ type MessageHistoryResult struct {
 	Success  bool                 `json:"success"`
 	Message  string               `json:"message,omitempty"`
 	Messages []MessageHistoryInfo `json:"messages,omitempty"`
+	Fetched  int                  `json:"fetched,omitempty"` // messages the phone sent for this page
 }
 
 // GroupCreateInfo represents information needed to create a group
@@ -170,166 +306,316 @@ type GroupCreateResult struct {
 }
 
 // NewClient initializes the whatsmeow client
-func NewClient(dbPath string) (*WhatsAppClient, error) {
-	// Configure whatsmeow components to use Noop logger
-	dbLogger := waLog.Noop
-	clientLogger := waLog.Noop
-
-	log.Printf("[whatsapp] Initializing DB with path: %s", dbPath) // Use standard log
-	container, err := sqlstore.New("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(ON)", dbPath), dbLogger)
+func NewClient(dbPath string, opts Options) (*WhatsAppClient, error) {
+	// Route whatsmeow logging through the standard log, filtered by level
+	dbLogger := newWALogger("Database")
+	clientLogger := newWALogger("Client")
+
+	logger.Infof("Initializing DB with path: %s", dbPath)
+	// Open the handle ourselves so the pod's own tables share the session database
+	db, err := sql.Open("sqlite", sqliteDSN(dbPath, opts))
 	if err != nil {
-		log.Printf("[whatsapp] Error connecting database: %v", err) // Use standard log
+		logger.Errorf("Error connecting database: %v", err)
 		return nil, fmt.Errorf("failed to connect database: %w", err)
 	}
-	log.Println("[whatsapp] Database container created.")
+	checkJournalMode(db, opts.DBJournalMode)
+	container := sqlstore.NewWithDB(db, "sqlite", dbLogger)
+	if err := container.Upgrade(); err != nil {
+		db.Close()
+		logger.Errorf("Error upgrading database: %v", err)
+		return nil, fmt.Errorf("failed to upgrade database: %w", err)
+	}
+	logger.Infof("Database container created.")
+
+	var (
+		uploads     *uploadCache
+		messages    *messageStore
+		deadLetters *deadLetterStore
+		verified    *verifiedStore
+		appState    *appStateStore
+		audit       *auditStore
+		polls       *pollStore
+		inbox       *inboxStore
+	)
+	for _, store := range []podStore{
+		newPodStore("upload cache", &uploads, newUploadCache),
+		newPodStore("message store", &messages, newMessageStore),
+		newPodStore("dead letter store", &deadLetters, newDeadLetterStore),
+		newPodStore("verified identity store", &verified, newVerifiedStore),
+		newPodStore("app state store", &appState, newAppStateStore),
+		newPodStore("audit store", &audit, newAuditStore),
+		newPodStore("poll store", &polls, newPollStore),
+		newPodStore("inbox store", &inbox, newInboxStore),
+	} {
+		if err := store.open(db); err != nil {
+			db.Close()
+			logger.Errorf("Error creating %s: %v", store.name, err)
+			return nil, fmt.Errorf("failed to create %s: %w", store.name, err)
+		}
+	}
 
 	deviceStore, err := container.GetFirstDevice()
 	if err != nil {
-		log.Printf("[whatsapp] Error getting device store: %v", err) // Use standard log
+		db.Close()
+		logger.Errorf("Error getting device store: %v", err)
 		return nil, fmt.Errorf("failed to get device: %w", err)
 	}
-	log.Println("[whatsapp] Device store retrieved.")
+	logger.Infof("Device store retrieved.")
 
 	client := whatsmeow.NewClient(deviceStore, clientLogger)
-	log.Println("[whatsapp] Whatsmeow client created.")
+	client.EmitAppStateEventsOnFullSync = true // so the app state store also sees full syncs
+	logger.Infof("Whatsmeow client created.")
+
+	wac := NewClientWithMessenger(whatsmeowMessenger{client, db}, opts)
+	wac.dbContainer = container
+	wac.db = db
+	wac.uploads = uploads
+	wac.messages = messages
+	wac.deadLetters = deadLetters
+	wac.verified = verified
+	wac.appState = appState
+	wac.audit = audit
+	wac.polls = polls
+	wac.inboxStore = inbox
+	wac.restoreInbox()
+	return wac, nil
+}
+
+// podStore creates one of the pod's own tables in the session database
+type podStore struct {
+	name string
+	open func(db *sql.DB) error
+}
+
+// newPodStore is a podStore that keeps the store create makes in *dst
+func newPodStore[T any](name string, dst **T, create func(*sql.DB) (*T, error)) podStore {
+	return podStore{name: name, open: func(db *sql.DB) (err error) {
+		*dst, err = create(db)
+		return err
+	}}
+}
 
+// NewClientWithMessenger builds a client around any Messenger, e.g. a Fake
+// for tests. It has no session database to close.
+func NewClientWithMessenger(m Messenger, opts Options) *WhatsAppClient {
 	wac := &WhatsAppClient{
-		Client:      client,
-		dbContainer: container,
-		loginStatus: "not-logged-in",
-		qrChan:      make(chan string, 1), // Buffered channel for QR code
+		Client:        m,
+		session:       sessionState{status: "not-logged-in"},
+		qrChan:        make(chan string, 1), // Buffered channel for QR code
+		stopRetention: make(chan struct{}),
 	}
 
+	wac.SetOptions(opts)
+
 	wac.Client.AddEventHandler(wac.eventHandler)
-	log.Println("[whatsapp] Event handler added.")
+	logger.Infof("Event handler added.")
 
-	return wac, nil
+	go wac.retentionLoop(wac.stopRetention)
+
+	return wac
 }
 
 // eventHandler handles incoming events from whatsmeow client
 func (wac *WhatsAppClient) eventHandler(evt interface{}) {
-	log.Printf("[EventHandler] Received event: %T", evt)
+	defer LogPanic(eventLogger, "the event handler")
+	eventLogger.Debugf("Received event: %T", evt)
 	switch v := evt.(type) {
 	case *events.Message:
 		wac.handleMessage(v)
+	case *events.MediaRetry:
+		wac.handleMediaRetry(v)
 	case *events.Connected:
-		log.Println("[EventHandler] Connected event")
-		if wac.Client.Store.ID != nil {
-			wac.jid = *wac.Client.Store.ID
-			log.Printf("[EventHandler] Already logged in with JID: %s", wac.jid)
-			wac.loginStatus = "logged-in"
+		eventLogger.Infof("Connected event")
+		wac.noteConnected(true)
+		wac.reconnected()
+		if wac.connectedOnce.Swap(true) {
+			metricReconnects.Inc()
+		}
+		wac.publish("connected", nil)
+		if id := wac.Client.DeviceID(); id != nil {
+			wac.session.loggedIn(*id)
+			eventLogger.Infof("Already logged in with JID: %s", *id)
+			wac.versionMutex.Lock()
+			wac.upgrade = nil
+			wac.versionMutex.Unlock()
 			select {
 			case wac.qrChan <- "logged-in":
 			default:
 			}
 		} else {
-			log.Println("[EventHandler] Connected, but not logged in yet.")
+			eventLogger.Infof("Connected, but not logged in yet.")
 		}
 	case *events.PushName:
-		log.Printf("[EventHandler] Push name update for %s: %s", v.JID, v.NewPushName)
+		eventLogger.Infof("Push name update for %s: %s", v.JID, v.NewPushName)
 	case *events.StreamReplaced:
-		log.Println("[EventHandler] Stream replaced event received")
-		wac.loginStatus = "not-logged-in"
+		eventLogger.Infof("Stream replaced event received")
+		wac.session.setStatus("not-logged-in")
+		wac.noteDisconnected("stream replaced by another connection")
+		wac.scheduleReconnect("stream-replaced", 0)
 	case *events.Disconnected:
-		log.Println("[EventHandler] Disconnected event")
-		if wac.loginStatus != "logged-out" {
-			wac.loginStatus = "not-logged-in"
+		eventLogger.Infof("Disconnected event")
+		reason := "connection closed"
+		if conn := wac.Connection(); time.Since(conn.LastErrorAt) < 10*time.Second {
+			reason += " after " + conn.LastError // e.g. a stream error the server sent first
 		}
+		wac.noteDisconnected(reason)
+		wac.publish("disconnected", nil)
+		wac.session.setStatusUnless("not-logged-in", "logged-out")
+		wac.scheduleReconnect("disconnected", 0)
 	case *events.QR:
-		log.Println("[EventHandler] QR event")
-		if wac.loginStatus != "logged-in" {
-			wac.loginStatus = "qr-pending"
-		}
+		eventLogger.Infof("QR event")
+		wac.session.setStatusUnless("qr-pending", "logged-in")
 		if len(v.Codes) > 0 {
 			qrCode := v.Codes[0]
-			wac.qrCodeStr = qrCode
-			log.Println("[EventHandler] QR code captured. Sending to login channel.")
+			wac.session.setQR(qrCode)
+			eventLogger.Infof("QR code captured. Sending to login channel.")
 			select {
 			case wac.qrChan <- qrCode:
-				log.Println("[EventHandler] Sent QR code to channel")
+				eventLogger.Infof("Sent QR code to channel")
 			default:
-				log.Println("[EventHandler] QR channel was full/closed.")
+				eventLogger.Infof("QR channel was full/closed.")
 			}
 		} else {
-			log.Println("[EventHandler] QR event with no codes.")
+			eventLogger.Infof("QR event with no codes.")
 		}
 	case *events.PairSuccess:
-		log.Printf("[EventHandler] PairSuccess event! JID: %s, Platform: %s", v.ID, v.Platform)
-		wac.jid = v.ID
-		wac.loginStatus = "logged-in"
+		eventLogger.Infof("PairSuccess event! JID: %s, Platform: %s", v.ID, v.Platform)
+		wac.session.loggedIn(v.ID)
 		select {
 		case wac.qrChan <- "logged-in":
 		default:
 		}
+	case *events.ConnectFailure:
+		eventLogger.Errorf("Connect failure: %v %s", v.Reason, v.Message)
+		wac.noteConnError("connect failure: %v %s", v.Reason, v.Message)
+		wac.scheduleReconnect("connect-failure", 0)
+	case *events.StreamError:
+		eventLogger.Errorf("Stream error: %s", v.Code)
+		wac.noteConnError("stream error: %s", v.Code)
+	case *events.KeepAliveTimeout:
+		eventLogger.Warnf("Keepalive timeout (%d in a row)", v.ErrorCount)
+		wac.noteConnError("keepalive timeout (%d in a row)", v.ErrorCount)
+		if wac.Options().AutoReconnect && time.Since(v.LastSuccess) > whatsmeow.KeepAliveMaxFailTime {
+			// The socket looks open but is dead; whatsmeow no longer drops it for us
+			wac.Client.Disconnect()
+			wac.noteDisconnected("keepalive timeout")
+			wac.scheduleReconnect("keepalive-timeout", 0)
+		}
+	case *events.TemporaryBan:
+		eventLogger.Errorf("%v", v)
+		wac.noteConnError("temporary ban: %v", v.Code)
+		wac.noteDisconnected(fmt.Sprintf("temporary ban: %v", v.Code))
+		// Coming back before the ban ends would only extend it
+		wac.scheduleReconnect("temporary-ban", v.Expire+randomDuration(time.Minute))
 	case *events.ClientOutdated:
-		log.Printf("[EventHandler] ERROR: Client is outdated. Please update the pod.")
-		wac.loginStatus = "login-failed"
-		// Signal login failure via the channel
-		select {
-		case wac.qrChan <- "login-failed":
-		default:
+		eventLogger.Errorf("Client is outdated, checking the current WhatsApp web version...")
+		wac.session.setStatus("upgrade-required")
+		wac.noteDisconnected("client outdated")
+		wac.cancelReconnect()
+		go wac.handleClientOutdated() // fetches over HTTP, keep the event loop free
+	case *events.LoggedOut:
+		eventLogger.Infof("Logged out by server (reason: %v)", v.Reason)
+		wac.session.setStatus("logged-out")
+		wac.noteDisconnected(fmt.Sprintf("logged out: %v", v.Reason))
+		wac.cancelReconnect()
+		wac.publish("logged-out", map[string]string{"reason": v.Reason.String()})
+	case *events.Receipt:
+		if wac.messages != nil {
+			wac.messages.saveReceipt(v)
 		}
-	case *events.OfflineSyncCompleted:
-		log.Println("[EventHandler] Offline sync completed")
-	case *events.HistorySync: // Handle history sync progress
-		if v.Data != nil && v.Data.Progress != nil {
-			log.Printf("[EventHandler] History sync progress: %.2f%%", *v.Data.Progress)
+		wac.publishReceipt(v)
+	case *events.Presence:
+		info := PresenceEventInfo{JID: v.From.String(), Unavailable: v.Unavailable}
+		if !v.LastSeen.IsZero() {
+			info.LastSeen = v.LastSeen.Unix()
 		}
+		wac.presence.update(v.From, !v.Unavailable, v.LastSeen)
+		wac.publish("presence", info)
+	case *events.ChatPresence:
+		wac.publish("chat-presence", ChatPresenceInfo{
+			ChatID: v.Chat.String(),
+			Sender: v.Sender.String(),
+			State:  string(v.State),
+			Media:  string(v.Media),
+		})
+	case *events.AppState:
+		wac.recordAppState(v)
+	case *events.GroupInfo:
+		wac.recordDisappearingTimer(v)
+	case *events.OfflineSyncCompleted:
+		eventLogger.Infof("Offline sync completed")
+	case *events.HistorySync:
+		wac.handleHistorySync(v)
 	}
 }
 
 // handleMessage processes incoming messages
 func (wac *WhatsAppClient) handleMessage(msg *events.Message) {
-	log.Printf("[MessageHandler] Received message from %s", msg.Info.Sender)
+	metricMessagesReceived.Inc()
+	eventLogger.Debugf("Received message from %s", msg.Info.Sender)
 
-	var content string
-	if msg.Message.GetConversation() != "" {
-		content = msg.Message.GetConversation()
-	} else if msg.Message.GetExtendedTextMessage() != nil {
-		content = msg.Message.GetExtendedTextMessage().GetText()
-	} else {
-		content = "[Media or other content type]"
-	}
-
-	messageInfo := &MessageInfo{
-		ChatID:      msg.Info.Chat.String(),
-		Content:     content,
-		Sender:      msg.Info.Sender.String(),
-		IsFromMe:    msg.Info.IsFromMe,
-		MessageType: "text",
-		Timestamp:   msg.Info.Timestamp.Unix(),
-	}
+	messageInfo := messageInfoOf(msg)
 
 	wac.messageMutex.Lock()
 	wac.lastMessage = messageInfo
 	wac.messageMutex.Unlock()
 
-	log.Printf("[MessageHandler] Processed message: %+v", messageInfo)
+	eventLogger.Debugf("Processed message: %+v", messageInfo)
+	wac.storeMessage(messageInfo)
+	if wac.messages != nil {
+		wac.messages.saveMedia(msg)
+	}
+	wac.recordPoll(msg)
+	wac.recordDisappearingTimer(msg)
+	wac.queueMessage(messageInfo)
+	wac.publish("message", messageInfo)
+
+	if wac.Options().DownloadDir != "" {
+		go wac.autoDownload(msg)
+	}
 }
 
-// Login initiates the WhatsApp login process
-func (wac *WhatsAppClient) Login() (interface{}, error) {
+// messageInfoOf decodes a message for results, events and the history
+func messageInfoOf(msg *events.Message) *MessageInfo {
+	info := &MessageInfo{
+		ID:        msg.Info.ID,
+		ChatID:    msg.Info.Chat.String(),
+		Sender:    msg.Info.Sender.String(),
+		IsFromMe:  msg.Info.IsFromMe,
+		Timestamp: msg.Info.Timestamp.Unix(),
+		// whatsmeow has removed the view-once wrapper; a V1 one alone may
+		// have held buttons, so decodeMessage checks the media's own flag
+		ViewOnce: msg.IsViewOnceV2,
+	}
+	decodeMessage(msg.Message, info)
+	return info
+}
+
+// login connects the client and waits for the first QR code or login
+// event. It gives up as soon as ctx is cancelled, e.g. when the process
+// shuts down.
+func (wac *WhatsAppClient) login(ctx context.Context) (interface{}, error) {
 	wac.loginMutex.Lock() // Prevent concurrent login attempts
 	defer wac.loginMutex.Unlock()
 
 	if wac.Client.IsLoggedIn() {
-		wac.loginStatus = "logged-in"
+		wac.session.setStatus("logged-in")
 		return LoginResult{Status: "logged-in", Message: "Already logged in"}, nil
 	}
 
 	// If already connecting or pending QR from a *previous* call, report status
 	// (Mutex prevents true concurrency, but state might persist)
-	if wac.loginStatus == "connecting" || wac.loginStatus == "qr-pending" {
+	if status := wac.session.loginStatus(); status == "connecting" || status == "qr-pending" {
 		// If QR is pending, maybe return the stored QR code?
-		if wac.loginStatus == "qr-pending" && wac.qrCodeStr != "" {
-			return LoginResult{Status: wac.loginStatus, Message: "Login pending, scan QR code", QrCode: wac.qrCodeStr}, nil
+		if qr := wac.session.qr(); status == "qr-pending" && qr != "" {
+			return LoginResult{Status: status, Message: "Login pending, scan QR code", QrCode: qr}, nil
 		}
-		return LoginResult{Status: wac.loginStatus, Message: "Login already in progress"}, nil
+		return LoginResult{Status: status, Message: "Login already in progress"}, nil
 	}
 
 	// Reset state for new login attempt
-	wac.loginStatus = "connecting"
-	wac.qrCodeStr = ""
+	wac.session.setStatus("connecting")
+	wac.session.setQR("")
 	// Clear the channel in case of old data
 	select {
 	case <-wac.qrChan:
@@ -337,12 +623,12 @@ func (wac *WhatsAppClient) Login() (interface{}, error) {
 	}
 
 	go func() {
+		defer LogPanic(loginLogger.For(ctx), "connecting")
 		err := wac.Client.Connect()
 		if err != nil {
 			if !strings.Contains(err.Error(), "disconnect called") {
-				log.Printf("[Login Connect GoRoutine] ERROR: Connection failed: %v", err)
-				if wac.loginStatus != "logged-in" {
-					wac.loginStatus = "login-failed"
+				loginLogger.For(ctx).Errorf("Connection failed: %v", err)
+				if wac.session.setStatusUnless("login-failed", "logged-in") {
 					// Signal failure via channel
 					select {
 					case wac.qrChan <- "login-failed":
@@ -352,128 +638,213 @@ func (wac *WhatsAppClient) Login() (interface{}, error) {
 			}
 			return
 		}
-		log.Println("[Login Connect GoRoutine] Connect() returned successfully, waiting for QR/Login event...")
+		loginLogger.For(ctx).Infof("Connect() returned successfully, waiting for QR/Login event...")
 	}()
 
 	// Wait for QR code, login success, or failure signal from event handler via channel
+	loginTimeout := wac.Options().LoginTimeout
 	select {
 	case resultSignal := <-wac.qrChan:
-		log.Printf("[Login] Received signal from qrChan: %s", resultSignal)
+		loginLogger.For(ctx).Infof("Received signal from qrChan: %s", resultSignal)
 		switch resultSignal {
 		case "logged-in":
-			wac.loginStatus = "logged-in"
+			wac.session.setStatus("logged-in")
 			return LoginResult{Status: "logged-in"}, nil
 		case "login-failed":
-			wac.loginStatus = "login-failed"
+			wac.session.setStatus("login-failed")
 			return LoginResult{Status: "login-failed", Message: "Login process failed"}, fmt.Errorf("login failed")
+		case "upgrade-required":
+			info := wac.upgradeInfo()
+			msg := "WhatsApp rejected the client version"
+			if info.Applied {
+				msg += "; switched to " + info.Latest + ", log in again"
+			}
+			return LoginResult{Status: "upgrade-required", Message: msg, Version: info}, fmt.Errorf("client outdated: %s", msg)
 		default: // Assume it's the QR code string
-			wac.loginStatus = "qr-pending"
-			wac.qrCodeStr = resultSignal // Store it again just in case
+			wac.session.setStatus("qr-pending")
+			wac.session.setQR(resultSignal) // Store it again just in case
 			return LoginResult{Status: "qr-pending", Message: "Scan QR code", QrCode: resultSignal}, nil
 		}
-	case <-time.After(65 * time.Second): // Timeout waiting for event
-		log.Printf("[Login] WARN: Login timed out after 65 seconds waiting for event.")
-		if wac.loginStatus == "connecting" || wac.loginStatus == "qr-pending" {
-			wac.loginStatus = "login-failed"
+	case <-time.After(loginTimeout): // Timeout waiting for event
+		loginLogger.For(ctx).Warnf("Login timed out after %v waiting for event.", loginTimeout)
+		if wac.session.setStatusIf("login-failed", "connecting", "qr-pending") {
 			wac.Client.Disconnect() // Clean up connection attempt
 		}
 		return LoginResult{Status: "timeout", Message: "Login timed out"}, fmt.Errorf("login timed out")
-	case <-wac.interruptForShutdown():
-		log.Println("[Login] WARN: Login interrupted by shutdown signal.")
-		return LoginResult{Status: "interrupted"}, fmt.Errorf("login interrupted")
+	case <-ctx.Done():
+		loginLogger.For(ctx).Warnf("Login cancelled: %v", ctx.Err())
+		if wac.session.setStatusIf("not-logged-in", "connecting", "qr-pending") {
+			wac.Client.Disconnect() // Clean up connection attempt
+		}
+		return LoginResult{Status: "interrupted", Message: "Login cancelled"}, fmt.Errorf("login interrupted")
 	}
 }
 
-// interruptForShutdown creates a channel that closes on SIGINT/SIGTERM
-func (wac *WhatsAppClient) interruptForShutdown() <-chan struct{} {
-	c := make(chan struct{})
-	go func() {
-		signals := make(chan os.Signal, 1)
-		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
-		<-signals
-		log.Println("[Interrupt] Received interrupt signal, shutting down...")
-		close(c)
-	}()
-	return c
-}
-
 // Logout logs the client out
-func (wac *WhatsAppClient) Logout() (interface{}, error) {
-	log.Printf("INFO: Logging out...")
+func (wac *WhatsAppClient) Logout(ctx context.Context) (interface{}, error) {
+	logger.For(ctx).Infof("Logging out...")
 	// Set status first, so disconnect event doesn't reset to not-logged-in
-	wac.loginStatus = "logged-out"
-	err := wac.Client.Logout()
+	wac.session.setStatus("logged-out")
+	wac.cancelReconnect()
+	err := callContextErr(wac, ctx, "logging out", wac.Client.Logout)
 	if err != nil {
-		log.Printf("ERROR: Error logging out: %v", err)
+		logger.For(ctx).Errorf("Error logging out: %v", err)
 		return StatusResult{Status: "logout-failed"}, err
 	}
-	log.Printf("INFO: Logout successful.")
-	wac.jid = types.JID{}
+	logger.For(ctx).Infof("Logout successful.")
+	wac.noteDisconnected("logout")
+	wac.session.setJID(types.JID{})
 	return StatusResult{Status: "logged-out"}, nil
 }
 
-// Status returns the current connection status and last message
+// Status returns the connection status with diagnostics: who the session
+// is, whether and for how long it is connected, why it last dropped, and
+// the last message
 func (wac *WhatsAppClient) Status() (interface{}, error) {
 	wac.messageMutex.Lock()
 	lastMsg := wac.lastMessage
 	wac.messageMutex.Unlock()
 
-	return StatusResult{
-		Status:      wac.loginStatus,
-		LastMessage: lastMsg,
-	}, nil
+	conn := wac.Connection()
+	result := StatusResult{
+		Status:         conn.Status,
+		JID:            conn.JID,
+		Connected:      conn.Connected,
+		LoggedIn:       wac.Client.IsLoggedIn(),
+		QueuedMessages: wac.inboxDepth(),
+		LastDisconnect: conn.Disconnect,
+		LastMessage:    lastMsg,
+		Version:        wac.upgradeInfo(),
+		Throttle:       wac.throttleInfo(),
+		Reconnect:      wac.reconnectInfo(),
+	}
+	if conn.Connected {
+		result.UptimeMs = time.Since(conn.ChangedAt).Milliseconds()
+	}
+	if !conn.DisconnectAt.IsZero() {
+		result.LastDisconnectAt = conn.DisconnectAt.Unix()
+	}
+	if dev := wac.Client.StoreDevice(); dev.ID != nil {
+		if result.JID == "" {
+			result.JID = dev.ID.String()
+		}
+		result.PushName, result.Platform = dev.PushName, dev.Platform
+		result.Device = &DeviceInfo{
+			BusinessName:   dev.BusinessName,
+			RegistrationID: dev.RegistrationID,
+			DeviceName:     store.DeviceProps.GetOs(),
+			WebVersion:     store.GetWAVersion().String(),
+		}
+		if !dev.LID.IsEmpty() {
+			result.Device.LID = dev.LID.String()
+		}
+	}
+	return result, nil
 }
 
-// SendMessage sends a message to the specified phone number
-func (wac *WhatsAppClient) SendMessage(phone string, message string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return SendResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+// HasSession reports whether the store holds a paired device, i.e. whether
+// Login can connect without scanning a QR code
+func (wac *WhatsAppClient) HasSession() bool {
+	return wac.Client.DeviceID() != nil
+}
+
+// NormalizePhone turns a user-typed phone number such as "+1 555-123 4567"
+// into the digits-only user part of a WhatsApp JID
+func NormalizePhone(phone string) (string, error) {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && b.Len() == 0, r == ' ', r == '-', r == '(', r == ')', r == '.':
+			// formatting characters, dropped
+		default:
+			return "", fmt.Errorf("invalid phone number %q: unexpected character %q", phone, r)
+		}
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("invalid phone number %q: no digits", phone)
 	}
+	return b.String(), nil
+}
 
-	recipient := types.JID{
-		User:   phone,
-		Server: "s.whatsapp.net",
+// TextOptions are the optional settings of a text message
+type TextOptions struct {
+	LinkPreview bool     `json:"link-preview"` // attach a preview of the first link in the message
+	Mentions    []string `json:"mentions"`     // users to mention, as JIDs or phone numbers
+}
+
+// SendMessage sends a text message to the specified phone number
+func (wac *WhatsAppClient) SendMessage(ctx context.Context, phone string, message string, opts TextOptions) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
 	}
 
-	msg := &waProto.Message{
-		Conversation: &message,
+	user, err := NormalizePhone(phone)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	recipient := types.JID{
+		User:   user,
+		Server: types.DefaultUserServer,
+	}
+	mentioned, err := parseMentions(opts.Mentions)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
 	}
 
+	msg := withMentions(wac.textMessage(ctx, message, opts.LinkPreview), mentioned)
+
 	ts := time.Now()
-	_, err := wac.Client.SendMessage(context.Background(), recipient, msg)
+	resp, err := wac.sendMessage(ctx, recipient, msg)
 	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-message", withMentionsArg([]string{phone, message, strconv.FormatBool(opts.LinkPreview)}, opts.Mentions)...)
 	}
 
-	return SendResult{
+	return stats.sendResult(SendResult{
 		Success: true,
 		Message: fmt.Sprintf("Message sent (server timestamp: %v)", ts),
-	}, nil
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// QueueDepths reports how many items are waiting in the client's internal channels
+func (wac *WhatsAppClient) QueueDepths() map[string]int {
+	depths := wac.subscriberDepths()
+	depths["qr_signals"] = len(wac.qrChan)
+	depths["uploads_waiting"] = int(wac.uploadPool.waiting.Load())
+	depths["inbox"] = wac.inboxDepth()
+	return depths
 }
 
 // Disconnect cleans up the client connection
 func (wac *WhatsAppClient) Disconnect() {
+	wac.stopOnce.Do(func() { close(wac.stopRetention) })
+	wac.cancelReconnect()
 	if wac.Client != nil {
-		log.Printf("INFO: Disconnecting WhatsApp client...")
+		logger.Infof("Disconnecting WhatsApp client...")
 		wac.Client.Disconnect()
 	}
+	wac.saveInbox()
 	if wac.dbContainer != nil {
-		log.Printf("INFO: Closing database connection...")
+		logger.Infof("Closing database connection...")
 		err := wac.dbContainer.Close()
 		if err != nil {
-			log.Printf("ERROR: Error closing database: %v", err)
+			logger.Errorf("Error closing database: %v", err)
 		}
 	}
-	log.Printf("INFO: Cleanup complete.")
+	logger.Infof("Cleanup complete.")
 }
 
 // GetGroups returns a list of all groups the user is in
-func (wac *WhatsAppClient) GetGroups() (interface{}, error) {
+func (wac *WhatsAppClient) GetGroups(ctx context.Context) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
 
-	groups, err := wac.Client.GetJoinedGroups()
+	groups, err := callContext(wac, ctx, "fetching groups", wac.Client.GetJoinedGroups)
 	if err != nil {
 		return GroupResult{Success: false, Message: err.Error()}, err
 	}
@@ -486,9 +857,10 @@ func (wac *WhatsAppClient) GetGroups() (interface{}, error) {
 		}
 
 		groupInfos[i] = GroupInfo{
-			JID:          group.JID.String(),
-			Name:         group.Name,
-			Participants: participants,
+			JID:               group.JID.String(),
+			Name:              group.Name,
+			Participants:      participants,
+			DisappearingTimer: group.DisappearingTimer,
 		}
 	}
 
@@ -498,114 +870,235 @@ func (wac *WhatsAppClient) GetGroups() (interface{}, error) {
 	}, nil
 }
 
-// SendGroupMessage sends a message to a WhatsApp group
-func (wac *WhatsAppClient) SendGroupMessage(groupJID string, message string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return SendResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+// SendGroupMessage sends a text message to a WhatsApp group. Mentioned
+// members are notified even when they muted the group.
+func (wac *WhatsAppClient) SendGroupMessage(ctx context.Context, groupJID string, message string, opts TextOptions) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
 	}
 
 	recipient, err := types.ParseJID(groupJID)
 	if err != nil {
 		return SendResult{Success: false, Message: err.Error()}, err
 	}
+	mentioned, err := parseMentions(opts.Mentions)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+
+	msg := withMentions(wac.textMessage(ctx, message, opts.LinkPreview), mentioned)
+
+	ts := time.Now()
+	resp, err := wac.sendMessage(ctx, recipient, msg)
+	if err != nil {
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-group-message", groupDeadLetterArgs(groupJID, message, opts)...)
+	}
+
+	return stats.sendResult(SendResult{
+		Success: true,
+		Message: fmt.Sprintf("Message sent to group (server timestamp: %v)", ts),
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
+}
+
+// SendToJID sends a text message to a contact (s.whatsapp.net or
+// lid), group, newsletter or status@broadcast. A device part of the JID is
+// dropped, since messages go to every device of the account.
+func (wac *WhatsAppClient) SendToJID(ctx context.Context, jid string, message string) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
+	}
+
+	recipient, err := ParseRecipientJID(jid)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
 
 	msg := &waProto.Message{
 		Conversation: &message,
 	}
 
 	ts := time.Now()
-	_, err = wac.Client.SendMessage(context.Background(), recipient, msg)
+	resp, err := wac.sendMessage(ctx, recipient, msg)
 	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-to-jid", jid, message)
 	}
 
-	return SendResult{
+	return stats.sendResult(SendResult{
 		Success: true,
-		Message: fmt.Sprintf("Message sent to group (server timestamp: %v)", ts),
-	}, nil
+		Message: fmt.Sprintf("Message sent to %s (server timestamp: %v)", recipient, ts),
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
 }
 
-// Upload uploads a media file to WhatsApp servers
-func (wac *WhatsAppClient) Upload(filePath string, mimeType string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return UploadResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+// ParseRecipientJID parses a chat JID messages can be sent to
+func ParseRecipientJID(jid string) (types.JID, error) {
+	parsed, err := types.ParseJID(jid)
+	if err != nil {
+		return types.JID{}, fmt.Errorf("invalid JID %q: %w", jid, err)
 	}
+	if parsed.User == "" {
+		return types.JID{}, fmt.Errorf("invalid JID %q: no user part", jid)
+	}
+	switch parsed.Server {
+	case types.BroadcastServer:
+		if parsed.User != types.StatusBroadcastJID.User {
+			// Only the phone can send to its broadcast lists
+			return types.JID{}, fmt.Errorf("cannot send to broadcast list %q: use send-broadcast with its recipients", jid)
+		}
+		return parsed.ToNonAD(), nil
+	case types.DefaultUserServer, types.HiddenUserServer, types.GroupServer, types.NewsletterServer:
+		return parsed.ToNonAD(), nil
+	case types.LegacyUserServer:
+		return types.NewJID(parsed.User, types.DefaultUserServer), nil
+	}
+	return types.JID{}, fmt.Errorf("cannot send to %q: unsupported server %q", jid, parsed.Server)
+}
 
-	// Read the file
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return UploadResult{Success: false, Message: err.Error()}, err
+// Upload uploads a media file to WhatsApp servers
+func (wac *WhatsAppClient) Upload(ctx context.Context, filePath string, mimeType string) (interface{}, error) {
+	if err := wac.sendReady(); err != nil {
+		return UploadResult{Success: false, Message: "Not logged in"}, err
 	}
 
-	// Upload the file
-	uploaded, err := wac.Client.Upload(context.Background(), data, whatsmeow.MediaImage)
+	// Upload the file, encrypted for the kind of message it will be sent in
+	mediaType := uploadMediaType(mimeType)
+	uploaded, err := wac.uploadFile(ctx, filePath, mediaType, "")
 	if err != nil {
-		return UploadResult{Success: false, Message: err.Error()}, err
+		return UploadResult{Success: false, Message: err.Error()}, wac.deadLetter(ctx, err, "upload", filePath, mimeType)
 	}
 
 	mediaInfo := &MediaInfo{
-		URL:        uploaded.URL,
-		DirectURL:  uploaded.DirectPath,
-		Mimetype:   mimeType,
-		FileSHA256: uploaded.FileSHA256,
-		FileLength: uploaded.FileLength,
-		MediaKey:   uploaded.MediaKey,
+		URL:           uploaded.URL,
+		DirectURL:     uploaded.DirectPath,
+		Mimetype:      mimeType,
+		MediaType:     mediaTypeNames[mediaType],
+		FileName:      filepath.Base(filePath),
+		FileSHA256:    uploaded.FileSHA256,
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileLength:    uploaded.FileLength,
+		MediaKey:      uploaded.MediaKey,
 	}
 
 	return UploadResult{
 		Success: true,
 		Media:   mediaInfo,
+		DryRun:  wac.Options().DryRun,
 	}, nil
 }
 
-// SendImage sends an image to a contact or group
-func (wac *WhatsAppClient) SendImage(recipient string, filePath string, caption string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return SendResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+// UploadData uploads bytes held in memory, as Upload does a
+// file, for a send-uploaded-* send. Nothing is kept to replay it from, so
+// a failure is returned but not dead-lettered.
+func (wac *WhatsAppClient) UploadData(ctx context.Context, data []byte, mimeType string, fileName string) (interface{}, error) {
+	if err := wac.sendReady(); err != nil {
+		return UploadResult{Success: false, Message: "Not logged in"}, err
+	}
+	if limit := wac.Options().MaxUploadSize; limit > 0 && int64(len(data)) > limit {
+		err := fmt.Errorf("the data is %d bytes, over the upload limit of %d bytes (max-upload-size-mb)", len(data), limit)
+		return UploadResult{Success: false, Message: err.Error()}, err
+	}
+
+	mediaType := uploadMediaType(mimeType)
+	uploaded, err := wac.upload(ctx, data, mediaType)
+	if err != nil {
+		return UploadResult{Success: false, Message: err.Error()}, err
+	}
+	return UploadResult{
+		Success: true,
+		Media: &MediaInfo{
+			URL:           uploaded.URL,
+			DirectURL:     uploaded.DirectPath,
+			Mimetype:      mimeType,
+			MediaType:     mediaTypeNames[mediaType],
+			FileName:      fileName,
+			FileSHA256:    uploaded.FileSHA256,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileLength:    uploaded.FileLength,
+			MediaKey:      uploaded.MediaKey,
+		},
+		DryRun: wac.Options().DryRun,
+	}, nil
+}
+
+// SendImage sends an image to a contact or group, optionally one that can
+// only be viewed once
+func (wac *WhatsAppClient) SendImage(ctx context.Context, recipient string, filePath string, caption string, opts MediaSendOptions) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
 	}
+	if opts.GifPlayback {
+		err := fmt.Errorf("gif-playback only applies to videos")
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	args := withMediaOptionsArg([]string{recipient, filePath, caption}, opts)
 
 	// Parse recipient JID
 	recipientJID, err := types.ParseJID(recipient)
 	if err != nil {
 		return SendResult{Success: false, Message: err.Error()}, err
 	}
+	// Keep this message's place in the chat while the media uploads
+	ctx, release := wac.takeTurn(ctx, recipientJID)
+	defer release()
 
-	// Read the image file
+	// Read the image file, which is decoded for its thumbnail anyway
+	if _, err := wac.checkUploadSize(filePath); err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return SendResult{Success: false, Message: err.Error()}, err
 	}
+	mimeType, err := imageMimeType(filePath, data)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	thumb, width, height := imageThumbnail(data)
 
 	// Upload the image
-	uploaded, err := wac.Client.Upload(context.Background(), data, whatsmeow.MediaImage)
+	uploaded, err := wac.upload(ctx, data, whatsmeow.MediaImage)
 	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-image", args...)
 	}
 
 	// Create the image message
 	msg := &waProto.Message{
 		ImageMessage: &waProto.ImageMessage{
-			URL:        &uploaded.URL,
-			Mimetype:   proto.String("image/jpeg"),
-			Caption:    proto.String(caption),
-			FileSHA256: uploaded.FileSHA256,
-			FileLength: proto.Uint64(uploaded.FileLength),
-			MediaKey:   uploaded.MediaKey,
-			DirectPath: proto.String(uploaded.DirectPath),
+			URL:           &uploaded.URL,
+			Mimetype:      proto.String(mimeType),
+			Caption:       proto.String(caption),
+			FileSHA256:    uploaded.FileSHA256,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+			MediaKey:      uploaded.MediaKey,
+			DirectPath:    proto.String(uploaded.DirectPath),
+			JPEGThumbnail: thumb,
 		},
 	}
+	if thumb != nil {
+		msg.ImageMessage.Width, msg.ImageMessage.Height = proto.Uint32(uint32(width)), proto.Uint32(uint32(height))
+	}
+	msg = withMediaOptions(msg, opts)
 
 	// Send the message
 	ts := time.Now()
-	_, err = wac.Client.SendMessage(context.Background(), recipientJID, msg)
+	resp, err := wac.sendMessage(ctx, recipientJID, msg)
 	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-image", args...)
 	}
 
-	return SendResult{
+	return stats.sendResult(SendResult{
 		Success: true,
 		Message: fmt.Sprintf("Image sent (server timestamp: %v)", ts),
-	}, nil
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
 }
 
 // GetContactInfo retrieves information about a contact
@@ -620,7 +1113,7 @@ func (wac *WhatsAppClient) GetContactInfo(jid string) (interface{}, error) {
 	}
 
 	// Get contact info from the store
-	contact, err := wac.Client.Store.Contacts.GetContact(contactJID)
+	contact, err := wac.Client.GetContact(contactJID)
 	if err != nil {
 		return ContactResult{Success: false, Message: err.Error()}, err
 	}
@@ -633,6 +1126,7 @@ func (wac *WhatsAppClient) GetContactInfo(jid string) (interface{}, error) {
 		LastSeen:     0,     // Not available in current API
 		IsOnline:     false, // Not available in current API
 		ProfilePicID: "",    // Not available in current API
+		Verified:     wac.isVerified(contactJID),
 	}
 
 	return ContactResult{
@@ -642,7 +1136,7 @@ func (wac *WhatsAppClient) GetContactInfo(jid string) (interface{}, error) {
 }
 
 // GetProfilePicture retrieves a contact's profile picture
-func (wac *WhatsAppClient) GetProfilePicture(jid string) (interface{}, error) {
+func (wac *WhatsAppClient) GetProfilePicture(ctx context.Context, jid string) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return UploadResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
@@ -652,7 +1146,9 @@ func (wac *WhatsAppClient) GetProfilePicture(jid string) (interface{}, error) {
 		return UploadResult{Success: false, Message: err.Error()}, err
 	}
 
-	pic, err := wac.Client.GetProfilePictureInfo(contactJID, &whatsmeow.GetProfilePictureParams{})
+	pic, err := callContext(wac, ctx, "fetching profile picture", func() (*types.ProfilePictureInfo, error) {
+		return wac.Client.GetProfilePictureInfo(contactJID, &whatsmeow.GetProfilePictureParams{})
+	})
 	if err != nil {
 		return UploadResult{Success: false, Message: err.Error()}, err
 	}
@@ -676,23 +1172,40 @@ func (wac *WhatsAppClient) GetProfilePicture(jid string) (interface{}, error) {
 	}, nil
 }
 
-// SetProfilePicture sets your own profile picture
-func (wac *WhatsAppClient) SetProfilePicture(filePath string) (interface{}, error) {
+// SetProfilePicture sets your own profile picture to the JPEG at
+// filePath, or removes it when filePath is ""
+func (wac *WhatsAppClient) SetProfilePicture(ctx context.Context, filePath string) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
-		return SendResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+		return ProfilePictureResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+	}
+	avatar, err := readAvatar(filePath)
+	if err != nil {
+		return ProfilePictureResult{Success: false, Message: err.Error()}, err
 	}
 
-	// Note: SetProfilePicture is not available in the current API version
-	return SendResult{Success: false, Message: "Setting profile picture is not supported in the current API version"}, fmt.Errorf("not supported")
+	// whatsmeow's group photo call sets the own picture when it has no target
+	id, err := callContext(wac, ctx, "setting profile picture", func() (string, error) {
+		return wac.Client.SetGroupPhoto(types.EmptyJID, avatar)
+	})
+	if err != nil {
+		logger.For(ctx).Errorf("Error setting the profile picture: %v", err)
+		return ProfilePictureResult{Success: false, Message: err.Error()}, err
+	}
+	if avatar == nil {
+		return ProfilePictureResult{Success: true, Message: "Profile picture removed"}, nil
+	}
+	return ProfilePictureResult{Success: true, Message: "Profile picture updated", PictureID: id}, nil
 }
 
 // SetStatus sets your status message
-func (wac *WhatsAppClient) SetStatus(text string) (interface{}, error) {
+func (wac *WhatsAppClient) SetStatus(ctx context.Context, text string) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return StatusUpdateResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
 
-	err := wac.Client.SetStatusMessage(text)
+	err := callContextErr(wac, ctx, "setting status", func() error {
+		return wac.Client.SetStatusMessage(text)
+	})
 	if err != nil {
 		return StatusUpdateResult{Success: false, Message: err.Error()}, err
 	}
@@ -720,7 +1233,7 @@ func (wac *WhatsAppClient) GetStatus(jid string) (interface{}, error) {
 	}
 
 	// Get contact info from the store
-	_, err = wac.Client.Store.Contacts.GetContact(contactJID)
+	_, err = wac.Client.GetContact(contactJID)
 	if err != nil {
 		return StatusUpdateResult{Success: false, Message: err.Error()}, err
 	}
@@ -737,7 +1250,7 @@ func (wac *WhatsAppClient) GetStatus(jid string) (interface{}, error) {
 }
 
 // SetPresence sets your online/offline status
-func (wac *WhatsAppClient) SetPresence(isOnline bool) (interface{}, error) {
+func (wac *WhatsAppClient) SetPresence(ctx context.Context, isOnline bool) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return PresenceResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
@@ -747,13 +1260,15 @@ func (wac *WhatsAppClient) SetPresence(isOnline bool) (interface{}, error) {
 		presence = types.PresenceAvailable
 	}
 
-	err := wac.Client.SendPresence(presence)
+	err := callContextErr(wac, ctx, "sending presence", func() error {
+		return wac.Client.SendPresence(presence)
+	})
 	if err != nil {
 		return PresenceResult{Success: false, Message: err.Error()}, err
 	}
 
 	presenceInfo := &PresenceInfo{
-		JID:      wac.jid.String(),
+		JID:      wac.session.JID().String(),
 		IsOnline: isOnline,
 		LastSeen: time.Now().Unix(),
 	}
@@ -765,7 +1280,7 @@ func (wac *WhatsAppClient) SetPresence(isOnline bool) (interface{}, error) {
 }
 
 // SubscribePresence subscribes to a contact's presence updates
-func (wac *WhatsAppClient) SubscribePresence(jid string) (interface{}, error) {
+func (wac *WhatsAppClient) SubscribePresence(ctx context.Context, jid string) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return PresenceResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
@@ -775,39 +1290,21 @@ func (wac *WhatsAppClient) SubscribePresence(jid string) (interface{}, error) {
 		return PresenceResult{Success: false, Message: err.Error()}, err
 	}
 
-	err = wac.Client.SubscribePresence(contactJID)
+	err = callContextErr(wac, ctx, "subscribing to presence", func() error {
+		return wac.Client.SubscribePresence(contactJID)
+	})
 	if err != nil {
 		return PresenceResult{Success: false, Message: err.Error()}, err
 	}
 
-	presenceInfo := &PresenceInfo{
-		JID:      contactJID.String(),
-		IsOnline: false, // Initial state
-	}
-
-	return PresenceResult{
-		Success:  true,
-		Presence: presenceInfo,
-	}, nil
-}
-
-// GetChatHistory retrieves chat history with a contact or group
-func (wac *WhatsAppClient) GetChatHistory(jid string, limit int) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return MessageHistoryResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
-	}
-
-	_, err := types.ParseJID(jid)
-	if err != nil {
-		return MessageHistoryResult{Success: false, Message: err.Error()}, err
+	// Updates arrive as presence events; report what is known so far
+	result := PresenceResult{Success: true}
+	if info, ok := wac.presence.get(contactJID); ok {
+		result.Presence = &info
+	} else {
+		result.Message = fmt.Sprintf("Subscribed to %s; no presence received yet", contactJID)
 	}
-
-	// Note: Message history retrieval is not directly available in the current API version
-	// We can only access messages that are received while the client is running
-	return MessageHistoryResult{
-		Success: false,
-		Message: "Message history retrieval is not supported in the current API version",
-	}, fmt.Errorf("not supported")
+	return result, nil
 }
 
 // GetUnreadMessages retrieves all unread messages
@@ -825,7 +1322,7 @@ func (wac *WhatsAppClient) GetUnreadMessages() (interface{}, error) {
 }
 
 // MarkMessageAsRead marks a message as read
-func (wac *WhatsAppClient) MarkMessageAsRead(messageID string, chatJID string) (interface{}, error) {
+func (wac *WhatsAppClient) MarkMessageAsRead(ctx context.Context, messageID string, chatJID string) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return SendResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
@@ -840,7 +1337,9 @@ func (wac *WhatsAppClient) MarkMessageAsRead(messageID string, chatJID string) (
 	parsedMessageID := types.MessageID(messageID)
 
 	// Mark the message as read
-	err = wac.Client.MarkRead([]types.MessageID{parsedMessageID}, time.Now(), parsedChatJID, parsedChatJID, types.ReceiptTypeRead)
+	err = callContextErr(wac, ctx, "marking read", func() error {
+		return wac.Client.MarkRead([]types.MessageID{parsedMessageID}, time.Now(), parsedChatJID, parsedChatJID, types.ReceiptTypeRead)
+	})
 	if err != nil {
 		return SendResult{Success: false, Message: err.Error()}, err
 	}
@@ -851,21 +1350,8 @@ func (wac *WhatsAppClient) MarkMessageAsRead(messageID string, chatJID string) (
 	}, nil
 }
 
-// DeleteMessage deletes a message
-func (wac *WhatsAppClient) DeleteMessage(messageID string, forEveryone bool) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return SendResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
-	}
-
-	// Note: Message deletion is not directly available in the current API version
-	return SendResult{
-		Success: false,
-		Message: "Message deletion is not supported in the current API version",
-	}, fmt.Errorf("not supported")
-}
-
 // CreateGroup creates a new WhatsApp group
-func (wac *WhatsAppClient) CreateGroup(info *GroupCreateInfo) (interface{}, error) {
+func (wac *WhatsAppClient) CreateGroup(ctx context.Context, info *GroupCreateInfo) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return GroupCreateResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
@@ -886,7 +1372,9 @@ func (wac *WhatsAppClient) CreateGroup(info *GroupCreateInfo) (interface{}, erro
 		Participants: participants,
 	}
 
-	group, err := wac.Client.CreateGroup(req)
+	group, err := callContext(wac, ctx, "creating group", func() (*types.GroupInfo, error) {
+		return wac.Client.CreateGroup(req)
+	})
 	if err != nil {
 		return GroupCreateResult{Success: false, Message: err.Error()}, err
 	}
@@ -910,7 +1398,7 @@ func (wac *WhatsAppClient) CreateGroup(info *GroupCreateInfo) (interface{}, erro
 }
 
 // LeaveGroup leaves a WhatsApp group
-func (wac *WhatsAppClient) LeaveGroup(groupJID string) (interface{}, error) {
+func (wac *WhatsAppClient) LeaveGroup(ctx context.Context, groupJID string) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
@@ -920,7 +1408,9 @@ func (wac *WhatsAppClient) LeaveGroup(groupJID string) (interface{}, error) {
 		return GroupResult{Success: false, Message: err.Error()}, err
 	}
 
-	err = wac.Client.LeaveGroup(jid)
+	err = callContextErr(wac, ctx, "leaving group", func() error {
+		return wac.Client.LeaveGroup(jid)
+	})
 	if err != nil {
 		return GroupResult{Success: false, Message: err.Error()}, err
 	}
@@ -929,7 +1419,7 @@ func (wac *WhatsAppClient) LeaveGroup(groupJID string) (interface{}, error) {
 }
 
 // GetGroupInviteLink gets the invite link for a group
-func (wac *WhatsAppClient) GetGroupInviteLink(groupJID string) (interface{}, error) {
+func (wac *WhatsAppClient) GetGroupInviteLink(ctx context.Context, groupJID string) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
@@ -939,7 +1429,9 @@ func (wac *WhatsAppClient) GetGroupInviteLink(groupJID string) (interface{}, err
 		return GroupResult{Success: false, Message: err.Error()}, err
 	}
 
-	link, err := wac.Client.GetGroupInviteLink(jid, false)
+	link, err := callContext(wac, ctx, "fetching invite link", func() (string, error) {
+		return wac.Client.GetGroupInviteLink(jid, false)
+	})
 	if err != nil {
 		return GroupResult{Success: false, Message: err.Error()}, err
 	}
@@ -947,118 +1439,71 @@ func (wac *WhatsAppClient) GetGroupInviteLink(groupJID string) (interface{}, err
 	return GroupResult{Success: true, Message: link}, nil
 }
 
-// JoinGroupWithLink joins a group using an invite link
-func (wac *WhatsAppClient) JoinGroupWithLink(link string) (interface{}, error) {
+// RevokeGroupInviteLink revokes the invite link of a group, so it no
+// longer lets anyone join, and returns the new link in its place
+func (wac *WhatsAppClient) RevokeGroupInviteLink(ctx context.Context, groupJID string) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
 
-	_, err := wac.Client.JoinGroupWithLink(link)
+	jid, err := parseGroupJID(groupJID)
 	if err != nil {
 		return GroupResult{Success: false, Message: err.Error()}, err
 	}
 
-	return GroupResult{Success: true, Message: "Successfully joined the group"}, nil
-}
-
-// SetGroupName changes a group's name
-func (wac *WhatsAppClient) SetGroupName(groupJID string, name string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
-	}
-
-	jid, err := types.ParseJID(groupJID)
-	if err != nil {
-		return GroupResult{Success: false, Message: err.Error()}, err
-	}
-
-	err = wac.Client.SetGroupName(jid, name)
+	link, err := callContext(wac, ctx, "revoking invite link", func() (string, error) {
+		return wac.Client.GetGroupInviteLink(jid, true)
+	})
 	if err != nil {
 		return GroupResult{Success: false, Message: err.Error()}, err
 	}
 
-	return GroupResult{Success: true, Message: "Group name updated successfully"}, nil
-}
-
-// SetGroupTopic changes a group's description/topic
-func (wac *WhatsAppClient) SetGroupTopic(groupJID string, topic string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
-	}
-
-	_, err := types.ParseJID(groupJID)
-	if err != nil {
-		return GroupResult{Success: false, Message: err.Error()}, err
-	}
-
-	// Note: SetGroupTopic is not available in the current API version
-	return GroupResult{Success: false, Message: "Setting group topic is not supported in the current API version"}, fmt.Errorf("not supported")
-}
-
-// AddGroupParticipants adds participants to a group
-func (wac *WhatsAppClient) AddGroupParticipants(groupJID string, participants []string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
-	}
-
-	_, err := types.ParseJID(groupJID)
-	if err != nil {
-		return GroupResult{Success: false, Message: err.Error()}, err
-	}
-
-	// Note: AddGroupParticipants is not available in the current API version
-	return GroupResult{Success: false, Message: "Adding group participants is not supported in the current API version"}, fmt.Errorf("not supported")
+	logger.For(ctx).Infof("Revoked the invite link of %s", jid)
+	return GroupResult{Success: true, Message: link}, nil
 }
 
-// RemoveGroupParticipants removes participants from a group
-func (wac *WhatsAppClient) RemoveGroupParticipants(groupJID string, participants []string) (interface{}, error) {
+// JoinGroupWithLink joins a group using an invite link
+func (wac *WhatsAppClient) JoinGroupWithLink(ctx context.Context, link string) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
 
-	_, err := types.ParseJID(groupJID)
+	_, err := callContext(wac, ctx, "joining group", func() (types.JID, error) {
+		return wac.Client.JoinGroupWithLink(link)
+	})
 	if err != nil {
 		return GroupResult{Success: false, Message: err.Error()}, err
 	}
 
-	// Note: RemoveGroupParticipants is not available in the current API version
-	return GroupResult{Success: false, Message: "Removing group participants is not supported in the current API version"}, fmt.Errorf("not supported")
+	return GroupResult{Success: true, Message: "Successfully joined the group"}, nil
 }
 
-// PromoteGroupParticipants promotes participants to admin status
-func (wac *WhatsAppClient) PromoteGroupParticipants(groupJID string, participants []string) (interface{}, error) {
+// SetGroupName changes a group's name
+func (wac *WhatsAppClient) SetGroupName(ctx context.Context, groupJID string, name string) (interface{}, error) {
 	if !wac.Client.IsLoggedIn() {
 		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
 	}
 
-	_, err := types.ParseJID(groupJID)
+	jid, err := types.ParseJID(groupJID)
 	if err != nil {
 		return GroupResult{Success: false, Message: err.Error()}, err
 	}
 
-	// Note: PromoteGroupParticipants is not available in the current API version
-	return GroupResult{Success: false, Message: "Promoting group participants is not supported in the current API version"}, fmt.Errorf("not supported")
-}
-
-// DemoteGroupParticipants demotes admins to regular participants
-func (wac *WhatsAppClient) DemoteGroupParticipants(groupJID string, participants []string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return GroupResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
-	}
-
-	_, err := types.ParseJID(groupJID)
+	err = callContextErr(wac, ctx, "renaming group", func() error {
+		return wac.Client.SetGroupName(jid, name)
+	})
 	if err != nil {
 		return GroupResult{Success: false, Message: err.Error()}, err
 	}
 
-	// Note: DemoteGroupParticipants is not available in the current API version
-	return GroupResult{Success: false, Message: "Demoting group participants is not supported in the current API version"}, fmt.Errorf("not supported")
+	return GroupResult{Success: true, Message: "Group name updated successfully"}, nil
 }
 
 // SendDocument sends a document to a contact or group
-func (wac *WhatsAppClient) SendDocument(recipient string, filePath string, caption string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return SendResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+func (wac *WhatsAppClient) SendDocument(ctx context.Context, recipient string, filePath string, caption string) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
 	}
 
 	// Parse recipient JID
@@ -1066,9 +1511,12 @@ func (wac *WhatsAppClient) SendDocument(recipient string, filePath string, capti
 	if err != nil {
 		return SendResult{Success: false, Message: err.Error()}, err
 	}
+	// Keep this message's place in the chat while the media uploads
+	ctx, release := wac.takeTurn(ctx, recipientJID)
+	defer release()
 
-	// Read the file
-	data, err := os.ReadFile(filePath)
+	// Detect the document's type; the file itself is streamed to the upload
+	header, err := readFileHeader(filePath)
 	if err != nil {
 		return SendResult{Success: false, Message: err.Error()}, err
 	}
@@ -1080,16 +1528,16 @@ func (wac *WhatsAppClient) SendDocument(recipient string, filePath string, capti
 	}
 
 	// Upload the document
-	uploaded, err := wac.Client.Upload(context.Background(), data, whatsmeow.MediaDocument)
+	uploaded, err := wac.uploadFile(ctx, filePath, whatsmeow.MediaDocument, recipientJID.String())
 	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-document", recipient, filePath, caption)
 	}
 
 	// Create the document message
 	msg := &waProto.Message{
 		DocumentMessage: &waProto.DocumentMessage{
 			URL:        &uploaded.URL,
-			Mimetype:   proto.String("application/octet-stream"),
+			Mimetype:   proto.String(documentMimeType(filePath, header)),
 			FileName:   proto.String(fileInfo.Name()),
 			Caption:    proto.String(caption),
 			FileSHA256: uploaded.FileSHA256,
@@ -1101,71 +1549,93 @@ func (wac *WhatsAppClient) SendDocument(recipient string, filePath string, capti
 
 	// Send the message
 	ts := time.Now()
-	_, err = wac.Client.SendMessage(context.Background(), recipientJID, msg)
+	resp, err := wac.sendMessage(ctx, recipientJID, msg)
 	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-document", recipient, filePath, caption)
 	}
 
-	return SendResult{
+	return stats.sendResult(SendResult{
 		Success: true,
 		Message: fmt.Sprintf("Document sent (server timestamp: %v)", ts),
-	}, nil
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
 }
 
-// SendVideo sends a video to a contact or group
-func (wac *WhatsAppClient) SendVideo(recipient string, filePath string, caption string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return SendResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+// SendVideo sends a video to a contact or group, optionally one that can
+// only be viewed once or that plays as a looping GIF
+func (wac *WhatsAppClient) SendVideo(ctx context.Context, recipient string, filePath string, caption string, opts MediaSendOptions) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
 	}
+	args := withMediaOptionsArg([]string{recipient, filePath, caption}, opts)
 
 	// Parse recipient JID
 	recipientJID, err := types.ParseJID(recipient)
 	if err != nil {
 		return SendResult{Success: false, Message: err.Error()}, err
 	}
+	// Keep this message's place in the chat while the media uploads
+	ctx, release := wac.takeTurn(ctx, recipientJID)
+	defer release()
 
-	// Read the video file
-	data, err := os.ReadFile(filePath)
+	// Detect the video's format; the file itself is streamed to the upload
+	header, err := readFileHeader(filePath)
 	if err != nil {
 		return SendResult{Success: false, Message: err.Error()}, err
 	}
+	mimeType, err := videoMimeType(filePath, header)
+	if err != nil {
+		return SendResult{Success: false, Message: err.Error()}, err
+	}
+	thumb, width, height := videoThumbnail(ctx, filePath)
 
 	// Upload the video
-	uploaded, err := wac.Client.Upload(context.Background(), data, whatsmeow.MediaVideo)
+	uploaded, err := wac.uploadFile(ctx, filePath, whatsmeow.MediaVideo, recipientJID.String())
 	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-video", args...)
 	}
 
 	// Create the video message
 	msg := &waProto.Message{
 		VideoMessage: &waProto.VideoMessage{
-			URL:        &uploaded.URL,
-			Mimetype:   proto.String("video/mp4"),
-			Caption:    proto.String(caption),
-			FileSHA256: uploaded.FileSHA256,
-			FileLength: proto.Uint64(uploaded.FileLength),
-			MediaKey:   uploaded.MediaKey,
-			DirectPath: proto.String(uploaded.DirectPath),
+			URL:           &uploaded.URL,
+			Mimetype:      proto.String(mimeType),
+			Caption:       proto.String(caption),
+			FileSHA256:    uploaded.FileSHA256,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+			MediaKey:      uploaded.MediaKey,
+			DirectPath:    proto.String(uploaded.DirectPath),
+			JPEGThumbnail: thumb,
 		},
 	}
+	if thumb != nil {
+		msg.VideoMessage.Width, msg.VideoMessage.Height = proto.Uint32(uint32(width)), proto.Uint32(uint32(height))
+	}
+	msg = withMediaOptions(msg, opts)
 
 	// Send the message
 	ts := time.Now()
-	_, err = wac.Client.SendMessage(context.Background(), recipientJID, msg)
+	resp, err := wac.sendMessage(ctx, recipientJID, msg)
 	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-video", args...)
 	}
 
-	return SendResult{
+	return stats.sendResult(SendResult{
 		Success: true,
 		Message: fmt.Sprintf("Video sent (server timestamp: %v)", ts),
-	}, nil
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
 }
 
 // SendAudio sends an audio file to a contact or group
-func (wac *WhatsAppClient) SendAudio(recipient string, filePath string) (interface{}, error) {
-	if !wac.Client.IsLoggedIn() {
-		return SendResult{Success: false, Message: "Not logged in"}, fmt.Errorf("not logged in")
+func (wac *WhatsAppClient) SendAudio(ctx context.Context, recipient string, filePath string) (interface{}, error) {
+	ctx, stats := withRetryStats(ctx)
+	if err := wac.sendReady(); err != nil {
+		return SendResult{Success: false, Message: "Not logged in"}, err
 	}
 
 	// Parse recipient JID
@@ -1173,17 +1643,14 @@ func (wac *WhatsAppClient) SendAudio(recipient string, filePath string) (interfa
 	if err != nil {
 		return SendResult{Success: false, Message: err.Error()}, err
 	}
-
-	// Read the audio file
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
-	}
+	// Keep this message's place in the chat while the media uploads
+	ctx, release := wac.takeTurn(ctx, recipientJID)
+	defer release()
 
 	// Upload the audio
-	uploaded, err := wac.Client.Upload(context.Background(), data, whatsmeow.MediaAudio)
+	uploaded, err := wac.uploadFile(ctx, filePath, whatsmeow.MediaAudio, recipientJID.String())
 	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-audio", recipient, filePath)
 	}
 
 	// Create the audio message
@@ -1200,13 +1667,15 @@ func (wac *WhatsAppClient) SendAudio(recipient string, filePath string) (interfa
 
 	// Send the message
 	ts := time.Now()
-	_, err = wac.Client.SendMessage(context.Background(), recipientJID, msg)
+	resp, err := wac.sendMessage(ctx, recipientJID, msg)
 	if err != nil {
-		return SendResult{Success: false, Message: err.Error()}, err
+		return stats.sendResult(SendResult{Success: false, Message: err.Error()}), wac.deadLetter(ctx, err, "send-audio", recipient, filePath)
 	}
 
-	return SendResult{
+	return stats.sendResult(SendResult{
 		Success: true,
 		Message: fmt.Sprintf("Audio sent (server timestamp: %v)", ts),
-	}, nil
+		ID:      resp.ID,
+		DryRun:  wac.Options().DryRun,
+	}), nil
 }